@@ -0,0 +1,135 @@
+// Copyright (c) Microsoft Corporation.
+// Licensed under the MIT License.
+package main
+
+// agentemulator runs an extension the way the Azure Guest Agent does, on a local directory, e.g.
+// agentemulator -extension ./myext -name Publisher.MyExtension -version 1.0 -public '{"a":1}' -operations install,enable,disable,uninstall
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/Azure/azure-extension-platform/pkg/agentemulator"
+	"github.com/Azure/azure-extension-platform/pkg/exithelper"
+	"github.com/Azure/azure-extension-platform/vmextension"
+)
+
+var eh = exithelper.Exiter
+
+func main() {
+	handlerPath := flag.String("extension", "", "path of the extension executable")
+	name := flag.String("name", "", "extension name")
+	version := flag.String("version", "1.0", "extension version")
+	publicSettings := flag.String("public", "", "public settings as JSON")
+	protectedSettings := flag.String("protected", "", "protected settings as JSON, encrypted before they are handed to the extension")
+	operations := flag.String("operations", "install,enable,disable,uninstall",
+		"comma separated operations to run; update upgrades to -update-version")
+	updateHandlerPath := flag.String("update-extension", "", "path of the executable of the version to update to, -extension if empty")
+	updateVersion := flag.String("update-version", "", "version to update to")
+	rootDir := flag.String("root", "", "emulated agent directory, a temporary directory that is removed afterwards if empty")
+	timeout := flag.Duration("timeout", agentemulator.DefaultTimeout, "timeout of each operation")
+	flag.Parse()
+
+	if *handlerPath == "" || *name == "" {
+		flag.Usage()
+		eh.Exit(exithelper.ArgumentError)
+	}
+
+	e, err := agentemulator.New(agentemulator.Options{
+		Name:        *name,
+		Version:     *version,
+		HandlerPath: *handlerPath,
+		RootDir:     *rootDir,
+		Timeout:     *timeout,
+		Output:      os.Stdout,
+	})
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "could not set up the emulated agent: %v\n", err)
+		eh.Exit(exithelper.EnvironmentError)
+	}
+
+	if *updateHandlerPath == "" {
+		*updateHandlerPath = *handlerPath
+	}
+	exitCode := run(e, *publicSettings, *protectedSettings, *operations, *updateHandlerPath, *updateVersion)
+	e.Close()
+	eh.Exit(exitCode)
+}
+
+func run(e *agentemulator.Emulator, publicSettings, protectedSettings, operations, updateHandlerPath, updateVersion string) int {
+	fmt.Printf("emulating the guest agent in %s\n", e.RootDir)
+
+	var public, protected interface{}
+	if err := unmarshalSettings(publicSettings, &public); err != nil {
+		fmt.Fprintf(os.Stderr, "invalid public settings: %v\n", err)
+		return exithelper.ArgumentError
+	}
+	if err := unmarshalSettings(protectedSettings, &protected); err != nil {
+		fmt.Fprintf(os.Stderr, "invalid protected settings: %v\n", err)
+		return exithelper.ArgumentError
+	}
+	if _, err := e.SetSettings(public, protected); err != nil {
+		fmt.Fprintf(os.Stderr, "could not write the settings: %v\n", err)
+		return exithelper.EnvironmentError
+	}
+
+	for _, op := range strings.Split(operations, ",") {
+		var results []agentemulator.Result
+		var err error
+		if op == vmextension.UpdateOperation.ToString() {
+			if updateVersion == "" {
+				fmt.Fprintln(os.Stderr, "-update-version is required to update")
+				return exithelper.ArgumentError
+			}
+			results, err = e.Update(updateHandlerPath, updateVersion)
+		} else {
+			operation, parseErr := vmextension.OperationNameFromString(op)
+			if parseErr != nil {
+				fmt.Fprintf(os.Stderr, "unknown operation '%s'\n", op)
+				return exithelper.ArgumentError
+			}
+			results, err = e.RunSequence(operation)
+		}
+
+		for _, r := range results {
+			fmt.Printf("%s of version %s exited with code %d after %v\n", r.Operation, r.Version, r.ExitCode, r.Duration.Round(time.Millisecond))
+		}
+		printStatus(e)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return exithelper.ExecutionError
+		}
+	}
+
+	events, err := e.Events()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "could not read the events: %v\n", err)
+		return exithelper.FileSystemError
+	}
+	for _, event := range events {
+		fmt.Printf("event %s [%s] %s: %s\n", event.Timestamp, event.EventLevel, event.TaskName, event.Message)
+	}
+	return 0
+}
+
+func unmarshalSettings(s string, v interface{}) error {
+	if s == "" {
+		return nil
+	}
+	return json.Unmarshal([]byte(s), v)
+}
+
+func printStatus(e *agentemulator.Emulator) {
+	seqNo, _ := e.SequenceNumber()
+	r, err := e.Status(seqNo)
+	if err != nil || len(r) == 0 {
+		fmt.Printf("no status reported for sequence number %d\n", seqNo)
+		return
+	}
+	s := r[len(r)-1].Status
+	fmt.Printf("status %d: %s %s: %s\n", seqNo, s.Operation, s.Status, s.FormattedMessage.Message)
+}
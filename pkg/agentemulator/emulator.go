@@ -0,0 +1,304 @@
+// Copyright (c) Microsoft Corporation.
+// Licensed under the MIT License.
+package agentemulator
+
+// this package emulates the Azure Guest Agent for testing extensions end to end on a
+// development machine. It is not intended for use in production code.
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/Azure/azure-extension-platform/pkg/encrypt"
+	"github.com/Azure/azure-extension-platform/pkg/extensionerrors"
+	"github.com/Azure/azure-extension-platform/pkg/handlerenv"
+	"github.com/pkg/errors"
+)
+
+const (
+	// DefaultTimeout is how long a single extension command may run
+	DefaultTimeout = 5 * time.Minute
+
+	handlerEnvFileName = "HandlerEnvironment.json"
+	heartbeatFileName  = "heartbeat.log"
+	mrseqFileName      = "mrseq"
+	settingsFileSuffix = ".settings"
+	statusFileSuffix   = ".status"
+)
+
+// Options describe the extension to emulate the guest agent for
+type Options struct {
+	Name        string // the extension name, for example Microsoft.Azure.Extensions.CustomScript
+	Version     string // the extension version
+	HandlerPath string // the extension executable, which is copied into the handler directory
+
+	// RootDir is the emulated agent directory, /var/lib/waagent on a VM. A temporary directory
+	// that is removed by Close is used if it is empty.
+	RootDir string
+
+	// Timeout limits how long each extension command may run. DefaultTimeout is used if it is 0.
+	Timeout time.Duration
+
+	// Output receives the stdout and stderr of the extension commands, which are always
+	// captured in the Result as well
+	Output io.Writer
+
+	// EncryptOptions customize the certificate and cipher used to encrypt protected settings
+	EncryptOptions []encrypt.Option
+}
+
+// Emulator lays an extension handler out on disk the way the guest agent does, hands it
+// settings, runs its commands and reads back the status files and events it wrote
+type Emulator struct {
+	Name    string
+	Version string
+	RootDir string
+
+	// HandlerDir holds the extension executable, HandlerEnvironment.json and the mrseq file
+	HandlerDir string
+
+	// HandlerEnv has the folders written to HandlerEnvironment.json. The data folder isn't part of
+	// HandlerEnvironment.json and can't be redirected, so DataFolder is left empty.
+	HandlerEnv handlerenv.HandlerEnvironment
+
+	handlerPath string
+	ownsRootDir bool
+	timeout     time.Duration
+	output      io.Writer
+	certHandler encrypt.ICertHandler
+	seqNo       *uint
+}
+
+// handlerEnvironmentFile is the HandlerEnvironment.json format read by handlerenv
+type handlerEnvironmentFile struct {
+	Version            float64 `json:"version"`
+	Name               string  `json:"name"`
+	HandlerEnvironment struct {
+		HeartbeatFile string `json:"heartbeatFile"`
+		StatusFolder  string `json:"statusFolder"`
+		ConfigFolder  string `json:"configFolder"`
+		LogFolder     string `json:"logFolder"`
+		EventsFolder  string `json:"eventsFolder"`
+		DeploymentID  string `json:"deploymentid"`
+		RoleName      string `json:"rolename"`
+		Instance      string `json:"instance"`
+	} `json:"handlerEnvironment"`
+}
+
+// handlerSettingsFile is the {seqNo}.settings format read by settings
+type handlerSettingsFile struct {
+	RuntimeSettings []handlerSettingsContainer `json:"runtimeSettings"`
+}
+
+type handlerSettingsContainer struct {
+	HandlerSettings handlerSettings `json:"handlerSettings"`
+}
+
+type handlerSettings struct {
+	PublicSettings          interface{} `json:"publicSettings"`
+	ProtectedSettingsBase64 string      `json:"protectedSettings,omitempty"`
+	SettingsCertThumbprint  string      `json:"protectedSettingsCertThumbprint,omitempty"`
+}
+
+// New lays out the handler directory and folders of the extension, writes HandlerEnvironment.json
+// and creates the certificate that protected settings are encrypted with. The layout mirrors the
+// agent: the handler lives in {RootDir}/{Name}-{Version} with its config and status folders,
+// logs and events go to {RootDir}/log/{Name} and certificates are kept in RootDir.
+func New(opts Options) (*Emulator, error) {
+	if opts.Name == "" || opts.Version == "" || opts.HandlerPath == "" {
+		return nil, extensionerrors.ErrArgCannotBeNullOrEmpty
+	}
+	if _, err := os.Stat(opts.HandlerPath); err != nil {
+		return nil, errors.Wrap(err, "cannot find the extension executable")
+	}
+
+	e := &Emulator{
+		Name:    opts.Name,
+		RootDir: opts.RootDir,
+		timeout: opts.Timeout,
+		output:  opts.Output,
+	}
+	if e.timeout == 0 {
+		e.timeout = DefaultTimeout
+	}
+
+	if e.RootDir == "" {
+		dir, err := os.MkdirTemp("", "agentemulator")
+		if err != nil {
+			return nil, errors.Wrap(err, "failed to create the emulated agent directory")
+		}
+		e.RootDir = dir
+		e.ownsRootDir = true
+	}
+	rootDir, err := filepath.Abs(e.RootDir)
+	if err != nil {
+		e.Close()
+		return nil, err
+	}
+	e.RootDir = rootDir
+
+	if err := e.installHandler(opts.Version, opts.HandlerPath); err != nil {
+		e.Close()
+		return nil, err
+	}
+
+	// the certificate must be two levels above the config folder, where decrypt looks for it
+	e.certHandler, err = encrypt.New(e.RootDir, opts.EncryptOptions...)
+	if err != nil {
+		e.Close()
+		return nil, errors.Wrap(err, "failed to create the protected settings certificate")
+	}
+	return e, nil
+}
+
+// Close removes the emulated agent directory if New created it
+func (e *Emulator) Close() error {
+	if !e.ownsRootDir {
+		return nil
+	}
+	return os.RemoveAll(e.RootDir)
+}
+
+// SequenceNumber returns the sequence number of the latest settings, or false if no settings were written
+func (e *Emulator) SequenceNumber() (uint, bool) {
+	if e.seqNo == nil {
+		return 0, false
+	}
+	return *e.seqNo, true
+}
+
+// SetSettings writes the next {seqNo}.settings file and returns its sequence number. The public settings
+// are written as JSON, the protected settings are marshaled to JSON and encrypted. Either may be nil.
+func (e *Emulator) SetSettings(publicSettings, protectedSettings interface{}) (uint, error) {
+	hs := handlerSettings{PublicSettings: publicSettings}
+	if protectedSettings != nil {
+		b, err := json.Marshal(protectedSettings)
+		if err != nil {
+			return 0, errors.Wrap(err, "failed to marshal the protected settings")
+		}
+		encrypted, err := e.certHandler.Encrypt(b)
+		if err != nil {
+			return 0, errors.Wrap(err, "failed to encrypt the protected settings")
+		}
+		thumbprint, err := e.certHandler.GetThumbprint()
+		if err != nil {
+			return 0, errors.Wrap(err, "failed to get the certificate thumbprint")
+		}
+		hs.ProtectedSettingsBase64 = base64.StdEncoding.EncodeToString(encrypted)
+		hs.SettingsCertThumbprint = thumbprint
+	}
+
+	b, err := json.Marshal(handlerSettingsFile{RuntimeSettings: []handlerSettingsContainer{{HandlerSettings: hs}}})
+	if err != nil {
+		return 0, errors.Wrap(err, "failed to marshal the settings file")
+	}
+
+	var seqNo uint
+	if e.seqNo != nil {
+		seqNo = *e.seqNo + 1
+	}
+	fileName := filepath.Join(e.HandlerEnv.ConfigFolder, fmt.Sprintf("%d%s", seqNo, settingsFileSuffix))
+	if err := os.WriteFile(fileName, b, 0600); err != nil {
+		return 0, errors.Wrap(err, "failed to write the settings file")
+	}
+	e.seqNo = &seqNo
+	return seqNo, nil
+}
+
+// installHandler lays out the handler directory of version and copies the executable into it
+func (e *Emulator) installHandler(version, handlerPath string) error {
+	handlerDir := filepath.Join(e.RootDir, fmt.Sprintf("%s-%s", e.Name, version))
+	logFolder := filepath.Join(e.RootDir, "log", e.Name)
+	he := handlerenv.HandlerEnvironment{
+		HeartbeatFile: filepath.Join(handlerDir, heartbeatFileName),
+		StatusFolder:  filepath.Join(handlerDir, "status"),
+		ConfigFolder:  filepath.Join(handlerDir, "config"),
+		LogFolder:     logFolder,
+		EventsFolder:  filepath.Join(logFolder, "events"),
+		DeploymentID:  "agentemulator",
+		RoleName:      "agentemulator",
+		Instance:      "agentemulator",
+	}
+
+	for _, dir := range []string{handlerDir, he.StatusFolder, he.ConfigFolder, he.LogFolder, he.EventsFolder} {
+		if err := os.MkdirAll(dir, 0755); err != nil {
+			return errors.Wrapf(err, "failed to create %s", dir)
+		}
+	}
+
+	var f handlerEnvironmentFile
+	f.Version = 1.0
+	f.Name = e.Name
+	f.HandlerEnvironment.HeartbeatFile = he.HeartbeatFile
+	f.HandlerEnvironment.StatusFolder = he.StatusFolder
+	f.HandlerEnvironment.ConfigFolder = he.ConfigFolder
+	f.HandlerEnvironment.LogFolder = he.LogFolder
+	f.HandlerEnvironment.EventsFolder = he.EventsFolder
+	f.HandlerEnvironment.DeploymentID = he.DeploymentID
+	f.HandlerEnvironment.RoleName = he.RoleName
+	f.HandlerEnvironment.Instance = he.Instance
+	b, err := json.Marshal([]handlerEnvironmentFile{f})
+	if err != nil {
+		return errors.Wrap(err, "failed to marshal the handler environment")
+	}
+	if err := os.WriteFile(filepath.Join(handlerDir, handlerEnvFileName), b, 0644); err != nil {
+		return errors.Wrap(err, "failed to write the handler environment")
+	}
+
+	// the extension finds HandlerEnvironment.json and the mrseq file next to its executable
+	handlerCopy := filepath.Join(handlerDir, filepath.Base(handlerPath))
+	if err := copyFile(handlerPath, handlerCopy, 0755); err != nil {
+		return errors.Wrap(err, "failed to copy the extension executable")
+	}
+
+	e.Version = version
+	e.HandlerDir = handlerDir
+	e.HandlerEnv = he
+	e.handlerPath = handlerCopy
+	return nil
+}
+
+// copyFile copies the file src to dst, replacing dst if it exists
+func copyFile(src, dst string, perm os.FileMode) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+
+	out, err := os.OpenFile(dst, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, perm)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(out, in); err != nil {
+		out.Close()
+		return err
+	}
+	return out.Close()
+}
+
+// copyFolderFiles copies the regular files of the folder src into the folder dst
+func copyFolderFiles(src, dst string) error {
+	entries, err := os.ReadDir(src)
+	if err != nil {
+		return err
+	}
+	for _, entry := range entries {
+		if !entry.Type().IsRegular() {
+			continue
+		}
+		info, err := entry.Info()
+		if err != nil {
+			return err
+		}
+		if err := copyFile(filepath.Join(src, entry.Name()), filepath.Join(dst, entry.Name()), info.Mode().Perm()); err != nil {
+			return err
+		}
+	}
+	return nil
+}
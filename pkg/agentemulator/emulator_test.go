@@ -0,0 +1,179 @@
+// Copyright (c) Microsoft Corporation.
+// Licensed under the MIT License.
+package agentemulator
+
+import (
+	"encoding/json"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"testing"
+
+	"github.com/Azure/azure-extension-platform/pkg/status"
+	"github.com/Azure/azure-extension-platform/pkg/utils"
+	"github.com/Azure/azure-extension-platform/vmextension"
+	"github.com/stretchr/testify/require"
+)
+
+const testExtensionName = "AgentEmulator.TestExtension"
+
+// buildTestExtension compiles testdata/testextension. The extension creates its data folder on
+// install, which is outside the emulated agent directory, so the test is skipped if it can't.
+func buildTestExtension(t *testing.T) string {
+	goTool, err := exec.LookPath("go")
+	if err != nil {
+		t.Skip("the go tool is not installed")
+	}
+	dataFolder := utils.GetDataFolder(testExtensionName, "")
+	if err := os.MkdirAll(dataFolder, 0755); err != nil {
+		t.Skipf("cannot create the extension data folder %s: %v", dataFolder, err)
+	}
+	t.Cleanup(func() { os.RemoveAll(dataFolder) })
+
+	handlerPath := filepath.Join(t.TempDir(), "testextension")
+	if runtime.GOOS == "windows" {
+		handlerPath += ".exe"
+	}
+	out, err := exec.Command(goTool, "build", "-o", handlerPath, "./testdata/testextension").CombinedOutput()
+	require.NoError(t, err, string(out))
+	return handlerPath
+}
+
+func newTestEmulator(t *testing.T, handlerPath string) *Emulator {
+	e, err := New(Options{Name: testExtensionName, Version: "1.0", HandlerPath: handlerPath})
+	require.NoError(t, err)
+	t.Cleanup(func() { e.Close() })
+	return e
+}
+
+func Test_newLaysOutHandler(t *testing.T) {
+	handlerPath := filepath.Join(t.TempDir(), "ext")
+	require.NoError(t, os.WriteFile(handlerPath, []byte("yaba"), 0755))
+
+	e := newTestEmulator(t, handlerPath)
+	require.Equal(t, filepath.Join(e.RootDir, testExtensionName+"-1.0"), e.HandlerDir)
+	for _, dir := range []string{e.HandlerEnv.ConfigFolder, e.HandlerEnv.StatusFolder, e.HandlerEnv.LogFolder, e.HandlerEnv.EventsFolder} {
+		require.DirExists(t, dir)
+	}
+	require.FileExists(t, filepath.Join(e.HandlerDir, "ext"))
+
+	b, err := os.ReadFile(filepath.Join(e.HandlerDir, handlerEnvFileName))
+	require.NoError(t, err)
+	var hef []handlerEnvironmentFile
+	require.NoError(t, json.Unmarshal(b, &hef))
+	require.Len(t, hef, 1)
+	require.Equal(t, testExtensionName, hef[0].Name)
+	require.Equal(t, e.HandlerEnv.ConfigFolder, hef[0].HandlerEnvironment.ConfigFolder)
+	require.Equal(t, e.HandlerEnv.EventsFolder, hef[0].HandlerEnvironment.EventsFolder)
+
+	rootDir := e.RootDir
+	require.NoError(t, e.Close())
+	require.NoDirExists(t, rootDir)
+}
+
+func Test_newInvalidArguments(t *testing.T) {
+	_, err := New(Options{Name: testExtensionName, Version: "1.0"})
+	require.Error(t, err)
+
+	_, err = New(Options{Name: testExtensionName, Version: "1.0", HandlerPath: filepath.Join(t.TempDir(), "missing")})
+	require.Error(t, err)
+}
+
+func Test_setSettingsIncrementsSequenceNumber(t *testing.T) {
+	handlerPath := filepath.Join(t.TempDir(), "ext")
+	require.NoError(t, os.WriteFile(handlerPath, []byte("yaba"), 0755))
+	e := newTestEmulator(t, handlerPath)
+
+	_, ok := e.SequenceNumber()
+	require.False(t, ok)
+
+	seqNo, err := e.SetSettings(map[string]string{"a": "b"}, nil)
+	require.NoError(t, err)
+	require.Equal(t, uint(0), seqNo)
+
+	seqNo, err = e.SetSettings(nil, map[string]string{"secret": "yaba"})
+	require.NoError(t, err)
+	require.Equal(t, uint(1), seqNo)
+
+	b, err := os.ReadFile(filepath.Join(e.HandlerEnv.ConfigFolder, "1.settings"))
+	require.NoError(t, err)
+	var f handlerSettingsFile
+	require.NoError(t, json.Unmarshal(b, &f))
+	require.Len(t, f.RuntimeSettings, 1)
+	require.NotEmpty(t, f.RuntimeSettings[0].HandlerSettings.ProtectedSettingsBase64)
+	require.NotEmpty(t, f.RuntimeSettings[0].HandlerSettings.SettingsCertThumbprint)
+	require.NotContains(t, string(b), "yaba")
+}
+
+func Test_commandEnvironmentReplacesGuestAgentVariables(t *testing.T) {
+	env := commandEnvironment(
+		[]string{"PATH=/bin", "VERSION=9.9", "ConfigSequenceNumber=7", "AZURE_GUEST_AGENT_EXTENSION_PATH=/outer"},
+		map[vmextension.GuestAgentEnvVar]string{vmextension.GuestAgentEnvVarConfigSequenceNumber: "2"})
+	require.ElementsMatch(t, []string{"PATH=/bin", "ConfigSequenceNumber=2"}, env)
+}
+
+func Test_emulatorInstallEnableDisableUninstall(t *testing.T) {
+	e := newTestEmulator(t, buildTestExtension(t))
+
+	_, err := e.SetSettings(map[string]string{"message": "hello"}, map[string]string{"secret": "yaba"})
+	require.NoError(t, err)
+
+	results, err := e.RunSequence(vmextension.InstallOperation, vmextension.EnableOperation)
+	require.NoError(t, err)
+	require.Len(t, results, 2)
+	// the protected setting was decrypted, and is kept out of the status file
+	require.NoError(t, e.ExpectStatus(vmextension.EnableOperation, status.StatusSuccess, "message=hello", "secret=[REDACTED]"))
+	require.NoError(t, e.ExpectEvent("enable", "message=hello"))
+
+	_, err = e.RunSequence(vmextension.DisableOperation, vmextension.UninstallOperation)
+	require.NoError(t, err)
+	require.NoError(t, e.ExpectStatus(vmextension.DisableOperation, status.StatusSuccess))
+}
+
+func Test_emulatorEnableFailure(t *testing.T) {
+	e := newTestEmulator(t, buildTestExtension(t))
+
+	_, err := e.SetSettings(map[string]bool{"fail": true}, nil)
+	require.NoError(t, err)
+
+	results, err := e.RunSequence(vmextension.InstallOperation, vmextension.EnableOperation, vmextension.DisableOperation)
+	require.Error(t, err)
+	require.Len(t, results, 2, "the sequence stops at the failing enable")
+	require.False(t, results[1].Succeeded())
+	require.NoError(t, e.ExpectStatus(vmextension.EnableOperation, status.StatusError, "failing as requested"))
+	require.Error(t, e.ExpectStatus(vmextension.EnableOperation, status.StatusSuccess))
+}
+
+func Test_emulatorUpdate(t *testing.T) {
+	handlerPath := buildTestExtension(t)
+	e := newTestEmulator(t, handlerPath)
+
+	_, err := e.SetSettings(map[string]string{"message": "hello"}, nil)
+	require.NoError(t, err)
+	_, err = e.RunSequence(vmextension.InstallOperation, vmextension.EnableOperation)
+	require.NoError(t, err)
+
+	results, err := e.Update(handlerPath, "1.1")
+	require.NoError(t, err)
+
+	var operations []vmextension.OperationName
+	var versions []string
+	for _, r := range results {
+		operations = append(operations, r.Operation)
+		versions = append(versions, r.Version)
+	}
+	require.Equal(t, []vmextension.OperationName{
+		vmextension.DisableOperation,
+		vmextension.UpdateOperation,
+		vmextension.UninstallOperation,
+		vmextension.InstallOperation,
+		vmextension.EnableOperation,
+	}, operations)
+	require.Equal(t, []string{"1.0", "1.1", "1.0", "1.1", "1.1"}, versions)
+
+	require.Equal(t, "1.1", e.Version)
+	require.FileExists(t, filepath.Join(e.HandlerEnv.ConfigFolder, "0.settings"))
+	require.NoError(t, e.ExpectStatus(vmextension.EnableOperation, status.StatusSuccess, "message=hello"))
+	require.NoError(t, e.ExpectEvent("update", "from=1.0 to=1.1 disableExitCode=0"))
+}
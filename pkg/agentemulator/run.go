@@ -0,0 +1,227 @@
+// Copyright (c) Microsoft Corporation.
+// Licensed under the MIT License.
+package agentemulator
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/Azure/azure-extension-platform/vmextension"
+	"github.com/pkg/errors"
+)
+
+// Result is the outcome of running an extension command
+type Result struct {
+	Operation vmextension.OperationName
+	Version   string // the version of the extension that ran the command
+	ExitCode  int
+	Output    string // the combined stdout and stderr of the command
+	Duration  time.Duration
+}
+
+// Succeeded returns true if the command exited with code 0
+func (r Result) Succeeded() bool {
+	return r.ExitCode == 0
+}
+
+// handler is an installed version of the extension
+type handler struct {
+	version string
+	path    string
+}
+
+func (e *Emulator) currentHandler() handler {
+	return handler{version: e.Version, path: e.handlerPath}
+}
+
+// Run runs an extension command for the current version and sequence number. A command that exits
+// with a non zero code is reported in the Result; an error is only returned if the command could not
+// be run or timed out.
+func (e *Emulator) Run(operation vmextension.OperationName) (Result, error) {
+	return e.run(e.currentHandler(), operation, nil)
+}
+
+// RunSequence runs the extension commands in order and stops at the first one that fails,
+// like the guest agent does when, for example, install fails and enable is never run
+func (e *Emulator) RunSequence(operations ...vmextension.OperationName) ([]Result, error) {
+	results := make([]Result, 0, len(operations))
+	for _, operation := range operations {
+		r, err := e.Run(operation)
+		if err != nil {
+			return results, err
+		}
+		results = append(results, r)
+		if !r.Succeeded() {
+			return results, fmt.Errorf("%s exited with code %d", operation, r.ExitCode)
+		}
+	}
+	return results, nil
+}
+
+// Update upgrades the extension to newVersion, using the executable at newHandlerPath, in the
+// order the guest agent does: disable the old version, update the new version, uninstall the old
+// version, install the new version and enable it. The settings, status files and mrseq file are
+// carried over to the new handler directory. The exit codes of disable and uninstall are passed on
+// to update and install. Update stops if update or install of the new version fails.
+func (e *Emulator) Update(newHandlerPath, newVersion string) ([]Result, error) {
+	if newVersion == e.Version {
+		return nil, fmt.Errorf("the extension is already at version %s", newVersion)
+	}
+	oldHandler := e.currentHandler()
+	oldHandlerDir, oldHandlerEnv := e.HandlerDir, e.HandlerEnv
+
+	var results []Result
+	disable, err := e.run(oldHandler, vmextension.DisableOperation, nil)
+	if err != nil {
+		return results, err
+	}
+	results = append(results, disable)
+
+	if err := e.installHandler(newVersion, newHandlerPath); err != nil {
+		return results, err
+	}
+	if err := copyFolderFiles(oldHandlerEnv.ConfigFolder, e.HandlerEnv.ConfigFolder); err != nil {
+		return results, errors.Wrap(err, "failed to copy the settings to the new version")
+	}
+	if err := copyFolderFiles(oldHandlerEnv.StatusFolder, e.HandlerEnv.StatusFolder); err != nil {
+		return results, errors.Wrap(err, "failed to copy the status files to the new version")
+	}
+	mrseq := filepath.Join(oldHandlerDir, mrseqFileName)
+	if _, err := os.Stat(mrseq); err == nil {
+		if err := copyFile(mrseq, filepath.Join(e.HandlerDir, mrseqFileName), 0600); err != nil {
+			return results, errors.Wrap(err, "failed to copy the mrseq file to the new version")
+		}
+	}
+	newHandler := e.currentHandler()
+
+	update, err := e.run(newHandler, vmextension.UpdateOperation, map[vmextension.GuestAgentEnvVar]string{
+		vmextension.GuestAgentEnvVarUpdateToVersion:    newVersion,
+		vmextension.GuestAgentEnvVarUpdateFromVersion:  oldHandler.version,
+		vmextension.GuestAgentEnvVarDisableCmdExitCode: strconv.Itoa(disable.ExitCode),
+	})
+	if err != nil {
+		return results, err
+	}
+	results = append(results, update)
+	if !update.Succeeded() {
+		return results, fmt.Errorf("update of version %s exited with code %d", newVersion, update.ExitCode)
+	}
+
+	uninstall, err := e.run(oldHandler, vmextension.UninstallOperation, nil)
+	if err != nil {
+		return results, err
+	}
+	results = append(results, uninstall)
+
+	install, err := e.run(newHandler, vmextension.InstallOperation, map[vmextension.GuestAgentEnvVar]string{
+		vmextension.GuestAgentEnvVarUninstallCmdExitCode: strconv.Itoa(uninstall.ExitCode),
+	})
+	if err != nil {
+		return results, err
+	}
+	results = append(results, install)
+	if !install.Succeeded() {
+		return results, fmt.Errorf("install of version %s exited with code %d", newVersion, install.ExitCode)
+	}
+
+	enable, err := e.run(newHandler, vmextension.EnableOperation, nil)
+	if err != nil {
+		return results, err
+	}
+	results = append(results, enable)
+	if !enable.Succeeded() {
+		return results, fmt.Errorf("enable of version %s exited with code %d", newVersion, enable.ExitCode)
+	}
+	return results, nil
+}
+
+// run runs the command of the handler with the guest agent environment variables and any extra ones
+func (e *Emulator) run(h handler, operation vmextension.OperationName, extraEnv map[vmextension.GuestAgentEnvVar]string) (Result, error) {
+	env := map[vmextension.GuestAgentEnvVar]string{
+		vmextension.GuestAgentEnvVarExtensionVersion:  h.version,
+		vmextension.GuestAgentEnvVarExtensionFullPath: filepath.Dir(h.path),
+	}
+	if seqNo, ok := e.SequenceNumber(); ok {
+		env[vmextension.GuestAgentEnvVarConfigSequenceNumber] = strconv.FormatUint(uint64(seqNo), 10)
+	}
+	for name, value := range extraEnv {
+		env[name] = value
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), e.timeout)
+	defer cancel()
+
+	var output bytes.Buffer
+	var w io.Writer = &output
+	if e.output != nil {
+		w = io.MultiWriter(&output, e.output)
+	}
+
+	cmd := exec.CommandContext(ctx, h.path, operation.ToString())
+	cmd.Dir = filepath.Dir(h.path)
+	cmd.Env = commandEnvironment(os.Environ(), env)
+	cmd.Stdout = w
+	cmd.Stderr = w
+
+	start := time.Now()
+	err := cmd.Run()
+	r := Result{
+		Operation: operation,
+		Version:   h.version,
+		Output:    output.String(),
+		Duration:  time.Since(start),
+	}
+	if ctx.Err() == context.DeadlineExceeded {
+		return r, fmt.Errorf("%s of version %s timed out after %v", operation, h.version, e.timeout)
+	}
+	if err != nil {
+		var exitErr *exec.ExitError
+		if !errors.As(err, &exitErr) {
+			return r, errors.Wrapf(err, "failed to run %s of version %s", operation, h.version)
+		}
+		r.ExitCode = exitErr.ExitCode()
+	}
+	return r, nil
+}
+
+// commandEnvironment returns the environment of the current process with the guest agent
+// variables replaced, so variables of an outer extension never leak into the emulated one
+func commandEnvironment(environ []string, env map[vmextension.GuestAgentEnvVar]string) []string {
+	guestAgentEnvVars := []vmextension.GuestAgentEnvVar{
+		vmextension.GuestAgentEnvVarExtensionVersion,
+		vmextension.GuestAgentEnvVarExtensionFullPath,
+		vmextension.GuestAgentEnvVarConfigSequenceNumber,
+		vmextension.GuestAgentEnvVarUpdateToVersion,
+		vmextension.GuestAgentEnvVarUpdateFromVersion,
+		vmextension.GuestAgentEnvVarDisableCmdExitCode,
+		vmextension.GuestAgentEnvVarUninstallCmdExitCode,
+		vmextension.GuestAgentEnvVarConfigExtensionName,
+	}
+
+	result := make([]string, 0, len(environ)+len(env))
+	for _, kv := range environ {
+		name := strings.SplitN(kv, "=", 2)[0]
+		isGuestAgentEnvVar := false
+		for _, v := range guestAgentEnvVars {
+			if name == string(v) {
+				isGuestAgentEnvVar = true
+				break
+			}
+		}
+		if !isGuestAgentEnvVar {
+			result = append(result, kv)
+		}
+	}
+	for name, value := range env {
+		result = append(result, fmt.Sprintf("%s=%s", name, value))
+	}
+	return result
+}
@@ -0,0 +1,82 @@
+// Copyright (c) Microsoft Corporation.
+// Licensed under the MIT License.
+package main
+
+// testextension is a minimal extension the agentemulator tests run end to end
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+
+	"github.com/Azure/azure-extension-platform/vmextension"
+)
+
+const extensionName = "AgentEmulator.TestExtension"
+
+type publicSettings struct {
+	Message string `json:"message"`
+	Fail    bool   `json:"fail"`
+}
+
+type protectedSettings struct {
+	Secret string `json:"secret"`
+}
+
+func enable(ext *vmextension.VMExtension) (string, error) {
+	hs, err := ext.GetSettings()
+	if err != nil {
+		return "", err
+	}
+
+	var pub publicSettings
+	if hs.PublicSettings != "" {
+		if err := json.Unmarshal([]byte(hs.PublicSettings), &pub); err != nil {
+			return "", err
+		}
+	}
+	var prot protectedSettings
+	if hs.ProtectedSettings != "" {
+		if err := json.Unmarshal([]byte(hs.ProtectedSettings), &prot); err != nil {
+			return "", err
+		}
+	}
+
+	if pub.Fail {
+		return "", errors.New("failing as requested")
+	}
+	msg := fmt.Sprintf("message=%s secret=%s", pub.Message, prot.Secret)
+	ext.ExtensionEvents.LogInformationalEvent("enable", msg)
+	return msg, nil
+}
+
+func update(ext *vmextension.VMExtension) error {
+	ext.ExtensionEvents.LogInformationalEvent("update", fmt.Sprintf("from=%s to=%s disableExitCode=%s",
+		os.Getenv(string(vmextension.GuestAgentEnvVarUpdateFromVersion)),
+		os.Getenv(string(vmextension.GuestAgentEnvVarUpdateToVersion)),
+		os.Getenv(string(vmextension.GuestAgentEnvVarDisableCmdExitCode))))
+	return nil
+}
+
+func main() {
+	version, err := vmextension.GetGuestAgentEnvironmetVariable(vmextension.GuestAgentEnvVarExtensionVersion)
+	if err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
+
+	ii, err := vmextension.GetInitializationInfo(extensionName, version, false, enable)
+	if err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
+	ii.UpdateCallback = update
+
+	ext, err := vmextension.GetVMExtension(ii)
+	if err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
+	ext.Do()
+}
@@ -0,0 +1,118 @@
+// Copyright (c) Microsoft Corporation.
+// Licensed under the MIT License.
+package agentemulator
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/Azure/azure-extension-platform/pkg/extensionerrors"
+	"github.com/Azure/azure-extension-platform/pkg/status"
+	"github.com/Azure/azure-extension-platform/vmextension"
+	"github.com/pkg/errors"
+)
+
+// Event is an extension event read from the events folder
+type Event struct {
+	Version     string `json:"Version"`
+	Timestamp   string `json:"Timestamp"`
+	TaskName    string `json:"TaskName"`
+	EventLevel  string `json:"EventLevel"`
+	Message     string `json:"Message"`
+	EventPid    string `json:"EventPid"`
+	EventTid    string `json:"EventTid"`
+	OperationID string `json:"OperationId"`
+}
+
+// Status reads the {seqNo}.status file of the current version
+func (e *Emulator) Status(seqNo uint) (status.StatusReport, error) {
+	fileName := filepath.Join(e.HandlerEnv.StatusFolder, fmt.Sprintf("%d%s", seqNo, statusFileSuffix))
+	b, err := os.ReadFile(fileName)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, errors.Wrapf(extensionerrors.ErrNotFound, "no status file for sequence number %d", seqNo)
+		}
+		return nil, errors.Wrap(err, "failed to read the status file")
+	}
+
+	var r status.StatusReport
+	if err := json.Unmarshal(b, &r); err != nil {
+		return nil, errors.Wrapf(err, "failed to parse %s", fileName)
+	}
+	return r, nil
+}
+
+// ExpectStatus returns an error unless the status file of the latest settings reports the operation
+// with the status type and a message that contains each of messageParts
+func (e *Emulator) ExpectStatus(operation vmextension.OperationName, t status.StatusType, messageParts ...string) error {
+	seqNo, ok := e.SequenceNumber()
+	if !ok {
+		return errors.New("no settings were written, so there is no status file")
+	}
+	r, err := e.Status(seqNo)
+	if err != nil {
+		return err
+	}
+	if len(r) == 0 {
+		return fmt.Errorf("the status file for sequence number %d is empty", seqNo)
+	}
+
+	s := r[len(r)-1].Status
+	if s.Operation != operation.ToStatusName() || s.Status != t {
+		return fmt.Errorf("expected status '%s' for operation '%s', got '%s' for operation '%s': %s",
+			t, operation.ToStatusName(), s.Status, s.Operation, s.FormattedMessage.Message)
+	}
+	for _, part := range messageParts {
+		if !strings.Contains(s.FormattedMessage.Message, part) {
+			return fmt.Errorf("expected the status message to contain '%s', got '%s'", part, s.FormattedMessage.Message)
+		}
+	}
+	return nil
+}
+
+// Events reads the events the extension wrote, oldest first
+func (e *Emulator) Events() ([]Event, error) {
+	// the events are named after the time they were written, which Glob returns in order
+	fileNames, err := filepath.Glob(filepath.Join(e.HandlerEnv.EventsFolder, "*.json"))
+	if err != nil {
+		return nil, err
+	}
+
+	events := make([]Event, 0, len(fileNames))
+	for _, fileName := range fileNames {
+		b, err := os.ReadFile(fileName)
+		if err != nil {
+			return nil, errors.Wrap(err, "failed to read the event file")
+		}
+		// a file holds a single event, or a list of events written together
+		var batch []Event
+		if err := json.Unmarshal(b, &batch); err == nil {
+			events = append(events, batch...)
+			continue
+		}
+		var event Event
+		if err := json.Unmarshal(b, &event); err != nil {
+			return nil, errors.Wrapf(err, "failed to parse %s", fileName)
+		}
+		events = append(events, event)
+	}
+	return events, nil
+}
+
+// ExpectEvent returns an error unless the extension wrote an event for the task with a message
+// that contains messagePart
+func (e *Emulator) ExpectEvent(taskName string, messagePart string) error {
+	events, err := e.Events()
+	if err != nil {
+		return err
+	}
+	for _, event := range events {
+		if event.TaskName == taskName && strings.Contains(event.Message, messagePart) {
+			return nil
+		}
+	}
+	return fmt.Errorf("no event for task '%s' with a message containing '%s' among %d events", taskName, messagePart, len(events))
+}
@@ -3,6 +3,7 @@
 package commandhandler
 
 import (
+	"context"
 	"github.com/Azure/azure-extension-platform/pkg/constants"
 	"github.com/Azure/azure-extension-platform/pkg/logging"
 	"github.com/pkg/errors"
@@ -18,9 +19,20 @@ type ICommandHandler interface {
 type ICommandHandlerWithEnvVariables interface {
 	ExecuteWithEnvVariables(command string, workingDir, logDir string, waitForCompletion bool, el logging.ILogger, params *map[string]string) (returnCode int, err error)
 }
+type ICommandHandlerWithEnvironment interface {
+	ExecuteWithEnvironment(command string, workingDir, logDir string, waitForCompletion bool, el logging.ILogger, env *Environment) (returnCode int, err error)
+}
 
+// ExecuteWithEnvVariables runs the command with the params added to the environment of the extension
+// with the CustomAction_ prefix
 func (commandHandler *CommandHandler) ExecuteWithEnvVariables(command string, workingDir, logDir string, waitForCompletion bool, el logging.ILogger, params *map[string]string) (returnCode int, err error) {
-	return execCmdInDirWithAction(command, workingDir, logDir, waitForCompletion, el, params)
+	return execCmdInDirWithAction(command, workingDir, logDir, waitForCompletion, el, customActionEnvironment(params))
+}
+
+// ExecuteWithEnvironment runs the command with the environment described by env, or the environment
+// of the extension if it is nil
+func (commandHandler *CommandHandler) ExecuteWithEnvironment(command string, workingDir, logDir string, waitForCompletion bool, el logging.ILogger, env *Environment) (returnCode int, err error) {
+	return execCmdInDirWithAction(command, workingDir, logDir, waitForCompletion, el, env)
 }
 
 type CommandHandler struct {
@@ -37,52 +49,22 @@ func (commandHandler *CommandHandler) Execute(command string, workingDir, logDir
 var execWaitFunctionToCall func(cmd string, workingDir string, stdout, stderr io.WriteCloser) (int, error) = execWait
 var execDontWaitFunctionToCall func(cmd string, workingDir string) (int, error) = execDontWait
 
-var execWaitFunctionWithParams = execWaitWithEnvVariables
 var execDontWaitFunctionWithParams = execDontWaitWithEnvVariables
 
-func execCmdInDirWithAction(cmd, workingDir, logDir string, waitForCompletion bool, el logging.ILogger, params *map[string]string) (int, error) {
-	var exitCode int
-	var execErr error
+func execCmdInDirWithAction(cmd, workingDir, logDir string, waitForCompletion bool, el logging.ILogger, env *Environment) (int, error) {
+	if waitForCompletion {
+		result, err := executeWithOptions(context.Background(), cmd, ExecuteOptions{WorkingDir: workingDir, LogDir: logDir, Environment: env}, el)
+		return result.ExitCode, err
+	}
+
+	if err := env.validate(); err != nil {
+		return -1, err
+	}
 	err := os.MkdirAll(workingDir, constants.FilePermissions_UserOnly_ReadWriteExecute)
 	if err != nil {
 		return -1, errors.Wrapf(err, "error while creating/accessing directory %s", workingDir)
 	}
-
-	if waitForCompletion {
-		outFileName, errFileName := logPaths(logDir)
-		outF, err := os.OpenFile(outFileName, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, constants.FilePermissions_UserOnly_ReadWrite)
-		if err != nil {
-			return -1, errors.Wrapf(err, "failed to open stdout file")
-		}
-
-		errF, err := os.OpenFile(errFileName, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, constants.FilePermissions_UserOnly_ReadWrite)
-		if err != nil {
-			return -1, errors.Wrapf(err, "failed to open stderr file")
-		}
-
-		exitCode, execErr = execWaitFunctionWithParams(cmd, workingDir, outF, errF, params)
-
-		// add the output of the command to the log file
-		el.Info("command: %s", cmd)
-		stdOutFile, err2 := os.OpenFile(outFileName, os.O_RDONLY, constants.FilePermissions_UserOnly_ReadWrite)
-		if err2 == nil {
-			el.InfoFromStream("stdout:", stdOutFile)
-			stdOutFile.Close()
-		}
-
-		stdErrFile, err3 := os.OpenFile(errFileName, os.O_RDONLY, constants.FilePermissions_UserOnly_ReadWrite)
-		if err3 == nil {
-			el.InfoFromStream("stderr:", stdErrFile)
-			stdErrFile.Close()
-		}
-
-	} else {
-
-		exitCode, execErr = execDontWaitFunctionWithParams(cmd, workingDir, params)
-
-	}
-
-	return exitCode, execErr
+	return execDontWaitFunctionWithParams(cmd, workingDir, env)
 }
 
 // logPaths returns stdout and stderr file paths for the specified output
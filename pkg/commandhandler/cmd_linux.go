@@ -19,14 +19,6 @@ func execWait(cmd, workdir string, stdout, stderr io.WriteCloser) (int, error) {
 	}, nil, cmd)
 }
 
-func execWaitWithEnvVariables(cmd, workdir string, stdout, stderr io.WriteCloser, params *map[string]string) (int, error) {
-	defer stdout.Close()
-	defer stderr.Close()
-	return execCommonWithEnvVariables(workdir, stdout, stderr, func(c *exec.Cmd) error {
-		return c.Run()
-	}, params, cmd)
-}
-
 func execDontWait(cmd, workdir string) (int, error) {
 	// passing '&' as a trailing parameter to /bin/sh in addition (*exec.Command).Start() to will double fork and prevent zombie processes
 	return execCommonWithEnvVariables(workdir, os.Stdout, os.Stderr, func(c *exec.Cmd) error {
@@ -34,22 +26,20 @@ func execDontWait(cmd, workdir string) (int, error) {
 	}, nil, cmd, "&")
 }
 
-func execDontWaitWithEnvVariables(cmd, workdir string, params *map[string]string) (int, error) {
+func execDontWaitWithEnvVariables(cmd, workdir string, env *Environment) (int, error) {
 	return execCommonWithEnvVariables(workdir, os.Stdout, os.Stderr, func(c *exec.Cmd) error {
 		return c.Start()
-	}, params, cmd, "&")
+	}, env, cmd, "&")
 }
 
-func execCommonWithEnvVariables(workdir string, stdout, stderr io.WriteCloser, execMethodToCall func(*exec.Cmd) error, params *map[string]string, args ...string) (int, error) {
+func execCommonWithEnvVariables(workdir string, stdout, stderr io.WriteCloser, execMethodToCall func(*exec.Cmd) error, env *Environment, args ...string) (int, error) {
 
 	args = append([]string{"-c"}, args...)
 	c := exec.Command("/bin/sh", args...)
 	c.Dir = workdir
 	c.Stdout = stdout
 	c.Stderr = stderr
-	c.Env = os.Environ()
-
-	addEnvVariables(params, c)
+	env.apply(c)
 
 	err := execMethodToCall(c)
 	exitErr, ok := err.(*exec.ExitError)
@@ -7,7 +7,9 @@ import (
 	"github.com/stretchr/testify/assert"
 	"io/ioutil"
 	"path"
+	"strconv"
 	"strings"
+	"syscall"
 	"testing"
 )
 
@@ -97,3 +99,31 @@ func TestCommandWithEnvironmentVariableEmpty(t *testing.T) {
 	assert.NoError(t, err, "stdout file should be read")
 	assert.Contains(t, string(fileInfo), "", "stdout message should be as expected")
 }
+
+func TestExecuteWithEnvironment(t *testing.T) {
+	defer cleanupTest()
+	t.Setenv("ENVTEST_DROP", "1")
+	cmd := New()
+	env := &Environment{Remove: []string{"ENVTEST_DROP"}, Variables: map[string]string{"EXACT": "value"}}
+	retCode, err := cmd.ExecuteWithEnvironment("echo $EXACT $ENVTEST_DROP $CustomAction_EXACT.", workingDir, workingDir, true, extensionLogger, env)
+	assert.NoError(t, err)
+	assert.Equal(t, 0, retCode)
+	fileBytes, err := ioutil.ReadFile(path.Join(workingDir, "stdout"))
+	assert.NoError(t, err)
+	assert.Equal(t, "value .\n", string(fileBytes))
+
+	_, err = cmd.ExecuteWithEnvironment("echo", workingDir, workingDir, true, extensionLogger, &Environment{Variables: map[string]string{"A=B": ""}})
+	assert.Error(t, err)
+}
+
+func TestExecuteKeepsProcessGroup(t *testing.T) {
+	defer cleanupTest()
+	cmd := New()
+	// the command is killed along with the extension
+	retCode, err := cmd.Execute("cut -d' ' -f5 /proc/self/stat", workingDir, workingDir, true, extensionLogger)
+	assert.NoError(t, err)
+	assert.Equal(t, 0, retCode)
+	fileBytes, err := ioutil.ReadFile(path.Join(workingDir, "stdout"))
+	assert.NoError(t, err)
+	assert.Equal(t, strconv.Itoa(syscall.Getpgrp()), strings.TrimSpace(string(fileBytes)))
+}
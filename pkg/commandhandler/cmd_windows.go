@@ -6,7 +6,6 @@ import (
 	"fmt"
 	"github.com/pkg/errors"
 	"io"
-	"os"
 	"os/exec"
 	"syscall"
 )
@@ -19,35 +18,25 @@ func execWait(cmd, workdir string, stdout, stderr io.WriteCloser) (int, error) {
 	}, nil)
 }
 
-func execWaitWithEnvVariables(cmd, workdir string, stdout, stderr io.WriteCloser, params *map[string]string) (int, error) {
-	defer stdout.Close()
-	defer stderr.Close()
-	return execCommonWithEnvVariables(cmd, workdir, stdout, stderr, func(c *exec.Cmd) error {
-		return c.Run()
-	}, params)
-}
-
 func execDontWait(cmd, workdir string) (int, error) {
 	return execCommonWithEnvVariables(cmd, workdir, nil, nil, func(c *exec.Cmd) error {
 		return c.Start()
 	}, nil)
 }
 
-func execDontWaitWithEnvVariables(cmd, workdir string, params *map[string]string) (int, error) {
+func execDontWaitWithEnvVariables(cmd, workdir string, env *Environment) (int, error) {
 	return execCommonWithEnvVariables(cmd, workdir, nil, nil, func(c *exec.Cmd) error {
 		return c.Start()
-	}, params)
+	}, env)
 }
 
-func execCommonWithEnvVariables(cmd, workdir string, stdout, stderr io.WriteCloser, execFunctionToCall func(*exec.Cmd) error, params *map[string]string) (int, error) {
+func execCommonWithEnvVariables(cmd, workdir string, stdout, stderr io.WriteCloser, execFunctionToCall func(*exec.Cmd) error, env *Environment) (int, error) {
 
 	c := exec.Command("cmd")
 	c.Dir = workdir
 	c.Stdout = stdout
 	c.Stderr = stderr
-	c.Env = os.Environ()
-
-	addEnvVariables(params, c)
+	env.apply(c)
 
 	// don't pass the args in exec.Command because
 	// On Windows, processes receive the whole command line as a single string
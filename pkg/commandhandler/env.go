@@ -0,0 +1,121 @@
+// Copyright (c) Microsoft Corporation.
+// Licensed under the MIT License.
+package commandhandler
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"sort"
+	"strings"
+
+	"github.com/Azure/azure-extension-platform/pkg/redact"
+)
+
+// Environment describes the environment variables of a command. Without one, a command
+// inherits the environment of the extension.
+type Environment struct {
+	Clean  bool     // Starts from an empty environment instead of the one of the extension
+	Allow  []string // The variables of the extension kept when Clean is set, such as PATH
+	Remove []string // The variables of the extension left out of the environment
+
+	Variables             map[string]string // Added with their exact names
+	CustomActionVariables map[string]string // Added with the CustomAction_ prefix, as ExecuteWithEnvVariables does
+
+	// Secrets are added like Variables. Their values are redacted from the log, events and status
+	// from then on, for the rest of the process, including where the output of the command is copied
+	// to them. The output passed to ExecuteOptions.Stdout, Stderr and OnLine, and kept in the tails of
+	// the result, isn't redacted. Values shorter than redact.MinValueLength are passed through as
+	// they are.
+	Secrets map[string]string
+}
+
+// customActionEnvironment returns the environment ExecuteWithEnvVariables has always used, which
+// inherits the environment of the extension
+func customActionEnvironment(params *map[string]string) *Environment {
+	if params == nil {
+		return nil
+	}
+	return &Environment{CustomActionVariables: *params}
+}
+
+// validate returns an error if a variable has a name that can't be set
+func (e *Environment) validate() error {
+	if e == nil {
+		return nil
+	}
+	for _, variables := range []map[string]string{e.Variables, e.Secrets} {
+		for name := range variables {
+			if name == "" || strings.ContainsAny(name, "=\x00") {
+				return fmt.Errorf("invalid environment variable name '%s'", name)
+			}
+		}
+	}
+	return nil
+}
+
+// apply sets the environment of the command. Variables added later replace those added earlier,
+// so secrets take precedence over variables, which take precedence over inherited ones.
+func (e *Environment) apply(c *exec.Cmd) {
+	c.Env = os.Environ()
+	if e == nil {
+		return
+	}
+
+	inherited := c.Env
+	c.Env = make([]string, 0, len(inherited))
+	for _, v := range inherited {
+		name := envVariableName(v)
+		if (e.Clean && !matchesEnvVariable(e.Allow, name)) || matchesEnvVariable(e.Remove, name) {
+			continue
+		}
+		c.Env = append(c.Env, v)
+	}
+
+	addEnvVariables(&e.CustomActionVariables, c)
+	for _, name := range sortedNames(e.Variables) {
+		c.Env = append(c.Env, name+"="+e.Variables[name])
+	}
+	for _, name := range sortedNames(e.Secrets) {
+		redact.AddValue(e.Secrets[name])
+		c.Env = append(c.Env, name+"="+e.Secrets[name])
+	}
+}
+
+// envVariableName returns the name of a variable in the name=value form. On Windows, names
+// of hidden variables such as =C: start with =.
+func envVariableName(v string) string {
+	if v == "" {
+		return v
+	}
+	if i := strings.IndexByte(v[1:], '='); i >= 0 {
+		return v[:i+1]
+	}
+	return v
+}
+
+// matchesEnvVariable returns true if the name is in the list. Names in the list ending with *
+// match every name starting with the rest of it, such as AZURE_*.
+func matchesEnvVariable(names []string, name string) bool {
+	if !envNamesCaseSensitive {
+		name = strings.ToUpper(name)
+	}
+	for _, n := range names {
+		if !envNamesCaseSensitive {
+			n = strings.ToUpper(n)
+		}
+		if n == name || (strings.HasSuffix(n, "*") && strings.HasPrefix(name, strings.TrimSuffix(n, "*"))) {
+			return true
+		}
+	}
+	return false
+}
+
+func sortedNames(variables map[string]string) []string {
+	names := make([]string, 0, len(variables))
+	for name := range variables {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
@@ -0,0 +1,62 @@
+// Copyright (c) Microsoft Corporation.
+// Licensed under the MIT License.
+package commandhandler
+
+import (
+	"os"
+	"os/exec"
+	"testing"
+
+	"github.com/Azure/azure-extension-platform/pkg/redact"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestEnvironmentApply(t *testing.T) {
+	defer redact.Default().Clear()
+	t.Setenv("ENVTEST_KEEP", "1")
+	t.Setenv("ENVTEST_DROP", "1")
+	t.Setenv("OTHER_ENVTEST", "1")
+
+	c := exec.Command("yaba")
+	env := &Environment{
+		Clean:                 true,
+		Allow:                 []string{"ENVTEST_*", "PATH"},
+		Remove:                []string{"ENVTEST_DROP"},
+		Variables:             map[string]string{"EXACT": "value", "TOKEN": "replaced"},
+		CustomActionVariables: map[string]string{"FOO": "bar"},
+		Secrets:               map[string]string{"TOKEN": "supersecret"},
+	}
+	env.apply(c)
+	assert.Contains(t, c.Env, "ENVTEST_KEEP=1")
+	assert.Contains(t, c.Env, "PATH="+os.Getenv("PATH"))
+	assert.NotContains(t, c.Env, "ENVTEST_DROP=1")
+	assert.NotContains(t, c.Env, "OTHER_ENVTEST=1")
+	assert.Contains(t, c.Env, "EXACT=value")
+	assert.Contains(t, c.Env, "CustomAction_FOO=bar")
+	// the secret is added last, so it replaces the variable
+	assert.Equal(t, "TOKEN=supersecret", c.Env[len(c.Env)-1])
+	assert.Equal(t, "token "+redact.Placeholder, redact.Redact("token supersecret"))
+
+	// without Clean the environment is inherited
+	env = &Environment{Remove: []string{"ENVTEST_DROP"}}
+	env.apply(c)
+	assert.Contains(t, c.Env, "OTHER_ENVTEST=1")
+	assert.NotContains(t, c.Env, "ENVTEST_DROP=1")
+
+	var nilEnv *Environment
+	nilEnv.apply(c)
+	assert.Equal(t, os.Environ(), c.Env)
+}
+
+func TestEnvironmentValidate(t *testing.T) {
+	assert.NoError(t, (*Environment)(nil).validate())
+	assert.NoError(t, (&Environment{Variables: map[string]string{"FOO": "a=b"}}).validate())
+	assert.Error(t, (&Environment{Variables: map[string]string{"FOO=BAR": "yaba"}}).validate())
+	assert.Error(t, (&Environment{Secrets: map[string]string{"": "yaba"}}).validate())
+}
+
+func TestEnvVariableName(t *testing.T) {
+	assert.Equal(t, "FOO", envVariableName("FOO=bar=baz"))
+	assert.Equal(t, "=C:", envVariableName(`=C:=C:\yaba`))
+	assert.Equal(t, "", envVariableName(""))
+}
@@ -0,0 +1,301 @@
+// Copyright (c) Microsoft Corporation.
+// Licensed under the MIT License.
+package commandhandler
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/Azure/azure-extension-platform/pkg/constants"
+	"github.com/Azure/azure-extension-platform/pkg/logging"
+	"github.com/Azure/azure-extension-platform/pkg/status"
+	"github.com/pkg/errors"
+)
+
+const (
+	// TimeoutExitCode is the exit code returned for commands killed because they ran longer than
+	// their timeout, as returned by the timeout utility
+	TimeoutExitCode = 124
+
+	// CanceledExitCode is the exit code returned for commands killed because their context was canceled
+	CanceledExitCode = 130
+
+	// killWaitDelay is how long to wait for the output of a killed command to be closed
+	killWaitDelay = 5 * time.Second
+)
+
+var (
+	// ErrCommandTimedOut is returned, wrapped, when a command runs longer than its timeout
+	ErrCommandTimedOut = errors.New("command timed out")
+
+	// ErrCommandCanceled is returned, wrapped, when the context of a command is canceled before it completes
+	ErrCommandCanceled = errors.New("command canceled")
+)
+
+// ExecuteOptions control how ExecuteWithOptions runs a command
+type ExecuteOptions struct {
+	WorkingDir   string            // The directory the command runs in, which is created if needed
+	LogDir       string            // The directory the stdout and stderr files are written to
+	Timeout      time.Duration     // How long the command may run before it is killed. Zero means no limit.
+	EnvVariables map[string]string // Added to the environment with the CustomAction_ prefix, as ExecuteWithEnvVariables does
+	Environment  *Environment      // The environment of the command, which is that of the extension if nil
+
+	// StreamToLog logs each line of output as the command writes it, instead of copying the stdout
+	// and stderr files to the log once the command completes
+	StreamToLog bool
+	Stdout      io.Writer // Also receives the stdout of the command as it is written
+	Stderr      io.Writer // Also receives the stderr of the command as it is written
+	OnLine      LineFunc  // Called for each line of output as it is written. Calls aren't concurrent.
+	TailSize    int       // Number of bytes at the end of stdout and stderr kept in the result, including the ... replacing earlier output. Zero keeps none.
+
+	Sandbox *SandboxOptions // Limits the privileges and resources of the command, on Linux only
+}
+
+// streams returns true if the output needs more than being written to the stdout and stderr files
+func (o *ExecuteOptions) streams() bool {
+	return o.StreamToLog || o.Stdout != nil || o.Stderr != nil || o.OnLine != nil || o.TailSize > 0
+}
+
+// ExecuteResult describes how a command ended
+type ExecuteResult struct {
+	ExitCode int           // The exit code of the command, TimeoutExitCode or CanceledExitCode
+	Duration time.Duration // How long the command ran
+	TimedOut bool          // True if the command was killed because it ran longer than its timeout
+	Canceled bool          // True if the command was killed because its context was canceled
+
+	StdoutTail string // The end of stdout, if ExecuteOptions.TailSize is set
+	StderrTail string // The end of stderr, if ExecuteOptions.TailSize is set
+}
+
+// Substatuses returns the ends of stdout and stderr as the substatuses {name}StdOut and {name}StdErr,
+// which report success if the command exited with code zero
+func (r *ExecuteResult) Substatuses(name string) []status.Substatus {
+	t := status.StatusSuccess
+	if r.ExitCode != 0 {
+		t = status.StatusError
+	}
+	return []status.Substatus{
+		status.NewSubstatus(name+"StdOut", t, r.ExitCode, r.StdoutTail),
+		status.NewSubstatus(name+"StdErr", t, r.ExitCode, r.StderrTail),
+	}
+}
+
+type ICommandHandlerWithOptions interface {
+	ExecuteWithOptions(ctx context.Context, command string, options ExecuteOptions, el logging.ILogger) (*ExecuteResult, error)
+}
+
+type ICommandHandlerWithArgs interface {
+	ExecuteArgs(ctx context.Context, name string, args []string, options ExecuteOptions, el logging.ILogger) (*ExecuteResult, error)
+}
+
+// ExecuteWithOptions runs the command and waits for it to complete. When the timeout expires or the
+// context is canceled, the command is killed along with every process it started. The result is
+// returned along with any error, which wraps ErrCommandTimedOut or ErrCommandCanceled if the command
+// was killed.
+func (commandHandler *CommandHandler) ExecuteWithOptions(ctx context.Context, command string, options ExecuteOptions, el logging.ILogger) (*ExecuteResult, error) {
+	return executeWithOptions(ctx, command, options, el)
+}
+
+// ExecuteArgs runs the executable with the arguments as ExecuteWithOptions runs a command, but without
+// a shell. The arguments are passed to the executable as they are, so they don't need to be quoted and
+// can't run other commands. The name is looked up in the PATH if it doesn't contain a path separator.
+// On Windows, batch files are run by cmd, which parses their arguments its own way, so use
+// ExecuteWithOptions for them.
+func (commandHandler *CommandHandler) ExecuteArgs(ctx context.Context, name string, args []string, options ExecuteOptions, el logging.ILogger) (*ExecuteResult, error) {
+	return executeArgs(ctx, name, args, options, el)
+}
+
+func executeWithOptions(ctx context.Context, command string, options ExecuteOptions, el logging.ILogger) (*ExecuteResult, error) {
+	return execute(ctx, command, func(ctx context.Context) *exec.Cmd {
+		return newCommandContext(ctx, command, options.WorkingDir)
+	}, options, el)
+}
+
+func executeArgs(ctx context.Context, name string, args []string, options ExecuteOptions, el logging.ILogger) (*ExecuteResult, error) {
+	if name == "" {
+		return &ExecuteResult{ExitCode: -1}, errors.New("the name of the executable is required")
+	}
+	return execute(ctx, commandLine(name, args), func(ctx context.Context) *exec.Cmd {
+		return newArgsCommandContext(ctx, name, args, options.WorkingDir)
+	}, options, el)
+}
+
+// execute runs the command returned by newCmd, which is logged as command
+func execute(ctx context.Context, command string, newCmd func(ctx context.Context) *exec.Cmd, options ExecuteOptions, el logging.ILogger) (*ExecuteResult, error) {
+	result := &ExecuteResult{ExitCode: -1}
+	if err := options.Environment.validate(); err != nil {
+		return result, err
+	}
+	createdDir := firstMissingDir(options.WorkingDir)
+	err := os.MkdirAll(options.WorkingDir, constants.FilePermissions_UserOnly_ReadWriteExecute)
+	if err != nil {
+		return result, errors.Wrapf(err, "error while creating/accessing directory %s", options.WorkingDir)
+	}
+
+	outFileName, errFileName := logPaths(options.LogDir)
+	outF, err := os.OpenFile(outFileName, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, constants.FilePermissions_UserOnly_ReadWrite)
+	if err != nil {
+		return result, errors.Wrapf(err, "failed to open stdout file")
+	}
+	defer outF.Close()
+
+	errF, err := os.OpenFile(errFileName, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, constants.FilePermissions_UserOnly_ReadWrite)
+	if err != nil {
+		return result, errors.Wrapf(err, "failed to open stderr file")
+	}
+	defer errF.Close()
+
+	if options.Timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, options.Timeout)
+		defer cancel()
+	}
+
+	c := newCmd(ctx)
+	c.Stdout = outF
+	c.Stderr = errF
+	options.Environment.apply(c)
+	addEnvVariables(&options.EnvVariables, c)
+
+	var outWriter, errWriter *streamWriter
+	if options.streams() {
+		if options.StreamToLog {
+			el.Info("command: %s", command)
+		}
+		outWriter, errWriter = newStreamWriters(&options, outF, errF, el)
+		c.Stdout = outWriter
+		c.Stderr = errWriter
+	}
+
+	sb, err := newSandbox(c, options.Sandbox, createdDir)
+	if err != nil {
+		return result, errors.Wrapf(err, "failed to sandbox the command")
+	}
+	defer sb.close()
+
+	start := time.Now()
+	runErr := c.Start()
+	if runErr == nil {
+		if err := sb.started(c.Process.Pid); err != nil {
+			c.Process.Kill()
+			c.Wait()
+			return result, errors.Wrapf(err, "failed to sandbox the command")
+		}
+		runErr = c.Wait()
+	}
+	result.Duration = time.Since(start)
+	outF.Close()
+	errF.Close()
+
+	if errors.Is(runErr, exec.ErrWaitDelay) {
+		// the command succeeded, but a process it left running kept the output open
+		el.Warn("stopped reading the output of the command, which is still open after it exited")
+		runErr = nil
+	}
+	execErr := commandResult(ctx, runErr, options.Timeout, result)
+
+	if outWriter != nil {
+		outWriter.flush()
+		errWriter.flush()
+		if options.TailSize > 0 {
+			result.StdoutTail = outWriter.tail.String()
+			result.StderrTail = errWriter.tail.String()
+		}
+	}
+
+	if options.StreamToLog {
+		el.Info("command exited with code %d after %v", result.ExitCode, result.Duration)
+		return result, execErr
+	}
+
+	// add the output of the command to the log file
+	el.Info("command: %s (ran for %v)", command, result.Duration)
+	if stdOutFile, err := os.Open(outFileName); err == nil {
+		el.InfoFromStream("stdout:", stdOutFile)
+		stdOutFile.Close()
+	}
+	if stdErrFile, err := os.Open(errFileName); err == nil {
+		el.InfoFromStream("stderr:", stdErrFile)
+		stdErrFile.Close()
+	}
+
+	return result, execErr
+}
+
+// newStreamWriters returns the writers for stdout and stderr of a command whose output is streamed
+func newStreamWriters(options *ExecuteOptions, outF, errF io.Writer, el logging.ILogger) (outWriter, errWriter *streamWriter) {
+	sink := &outputSink{}
+	if options.StreamToLog {
+		sink.onLines = append(sink.onLines, func(stream OutputStream, line string) {
+			el.Info("%s: %s", stream, line)
+		})
+	}
+	if options.OnLine != nil {
+		sink.onLines = append(sink.onLines, options.OnLine)
+	}
+
+	newWriter := func(stream OutputStream, file io.Writer, w io.Writer) *streamWriter {
+		sw := &streamWriter{sink: sink, stream: stream, file: file, w: w}
+		if options.TailSize > 0 {
+			sw.tail = &tailBuffer{max: options.TailSize}
+		}
+		return sw
+	}
+	return newWriter(Stdout, outF, options.Stdout), newWriter(Stderr, errF, options.Stderr)
+}
+
+// commandResult fills in the result from the outcome of running the command and returns the
+// error for it
+func commandResult(ctx context.Context, runErr error, timeout time.Duration, result *ExecuteResult) error {
+	if runErr == nil {
+		result.ExitCode = 0
+		return nil
+	}
+
+	// the command may have failed because it was killed
+	switch ctx.Err() {
+	case context.DeadlineExceeded:
+		result.TimedOut = true
+		result.ExitCode = TimeoutExitCode
+		if timeout > 0 {
+			return errors.Wrapf(ErrCommandTimedOut, "killed after %v", timeout)
+		}
+		return errors.Wrap(ErrCommandTimedOut, "the deadline of the context expired")
+	case context.Canceled:
+		result.Canceled = true
+		result.ExitCode = CanceledExitCode
+		return errors.Wrapf(ErrCommandCanceled, "killed after %v", result.Duration)
+	}
+
+	if exitErr, ok := runErr.(*exec.ExitError); ok {
+		result.ExitCode = exitErr.ExitCode()
+		return fmt.Errorf("command terminated with exit status=%d", result.ExitCode)
+	}
+	if errors.Is(runErr, exec.ErrNotFound) || errors.Is(runErr, fs.ErrNotExist) {
+		// an executable run without a shell exits with the code the shell returns when it's missing
+		result.ExitCode = commandNotFoundExitCode
+		return errors.Wrapf(runErr, "failed to execute command")
+	}
+	result.ExitCode = 1
+	return errors.Wrapf(runErr, "failed to execute command")
+}
+
+// commandLine returns the executable and its arguments as they are logged, with the arguments
+// that would be split or lost otherwise quoted
+func commandLine(name string, args []string) string {
+	quoted := make([]string, 0, len(args)+1)
+	for _, a := range append([]string{name}, args...) {
+		if a == "" || strings.ContainsAny(a, " \t\n\"'") {
+			a = strconv.Quote(a)
+		}
+		quoted = append(quoted, a)
+	}
+	return strings.Join(quoted, " ")
+}
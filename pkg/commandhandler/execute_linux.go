@@ -0,0 +1,39 @@
+// Copyright (c) Microsoft Corporation.
+// Licensed under the MIT License.
+package commandhandler
+
+import (
+	"context"
+	"os/exec"
+	"syscall"
+)
+
+// commandNotFoundExitCode is the exit code /bin/sh returns for a command that doesn't exist
+const commandNotFoundExitCode = 127
+
+// envNamesCaseSensitive is true because Linux environment variable names are case sensitive
+const envNamesCaseSensitive = true
+
+// newCommandContext returns the command run by /bin/sh, in a process group of its own if the
+// context can be done, so the whole group is killed when it is
+func newCommandContext(ctx context.Context, cmd, workdir string) *exec.Cmd {
+	return newArgsCommandContext(ctx, "/bin/sh", []string{"-c", cmd}, workdir)
+}
+
+// newArgsCommandContext returns the executable run directly, in a process group of its own if the
+// context can be done, so the whole group is killed when it is. A command whose context can't be
+// done stays in the process group of the extension, so it is killed along with the extension.
+func newArgsCommandContext(ctx context.Context, name string, args []string, workdir string) *exec.Cmd {
+	c := exec.CommandContext(ctx, name, args...)
+	c.Dir = workdir
+	c.WaitDelay = killWaitDelay
+	if ctx.Done() == nil {
+		return c
+	}
+	c.SysProcAttr = &syscall.SysProcAttr{Setpgid: true}
+	c.Cancel = func() error {
+		// a negative pid signals the process group, which has the pid of its leader
+		return syscall.Kill(-c.Process.Pid, syscall.SIGKILL)
+	}
+	return c
+}
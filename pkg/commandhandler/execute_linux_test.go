@@ -0,0 +1,177 @@
+// Copyright (c) Microsoft Corporation.
+// Licensed under the MIT License.
+package commandhandler
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io/ioutil"
+	"os"
+	"path"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// processRunning returns true if the process exists and isn't a zombie waiting to be reaped
+func processRunning(pid int) bool {
+	stat, err := ioutil.ReadFile(path.Join("/proc", strconv.Itoa(pid), "stat"))
+	if err != nil {
+		return false
+	}
+	// the state follows the command name, which is in parentheses
+	fields := strings.Fields(string(stat[strings.LastIndex(string(stat), ")")+1:]))
+	return len(fields) > 0 && fields[0] != "Z"
+}
+
+// backgroundPid returns the pid of the background process started by the command
+func backgroundPid(t *testing.T) int {
+	b, err := os.ReadFile(path.Join(workingDir, "background.pid"))
+	require.NoError(t, err)
+	pid, err := strconv.Atoi(strings.TrimSpace(string(b)))
+	require.NoError(t, err)
+	return pid
+}
+
+func indexOf(lines []string, line string) int {
+	for i, l := range lines {
+		if l == line {
+			return i
+		}
+	}
+	return -1
+}
+
+const commandWithBackgroundProcess = "sleep 30 & echo $! > background.pid; sleep 30"
+
+func TestExecuteWithOptionsTimeoutKillsProcessGroup(t *testing.T) {
+	defer cleanupTest()
+	cmd := New()
+	start := time.Now()
+	result, err := cmd.ExecuteWithOptions(context.Background(), commandWithBackgroundProcess, ExecuteOptions{WorkingDir: workingDir, LogDir: workingDir, Timeout: 500 * time.Millisecond}, extensionLogger)
+	assert.True(t, errors.Is(err, ErrCommandTimedOut), "unexpected error %v", err)
+	assert.True(t, result.TimedOut)
+	assert.Equal(t, TimeoutExitCode, result.ExitCode)
+	assert.GreaterOrEqual(t, result.Duration, 500*time.Millisecond)
+	assert.Less(t, time.Since(start), 10*time.Second)
+
+	pid := backgroundPid(t)
+	assert.Eventually(t, func() bool { return !processRunning(pid) }, 5*time.Second, 50*time.Millisecond, "the background process was killed")
+}
+
+func TestExecuteWithOptionsCanceled(t *testing.T) {
+	defer cleanupTest()
+	ctx, cancel := context.WithCancel(context.Background())
+	time.AfterFunc(500*time.Millisecond, cancel)
+
+	cmd := New()
+	result, err := cmd.ExecuteWithOptions(ctx, commandWithBackgroundProcess, ExecuteOptions{WorkingDir: workingDir, LogDir: workingDir}, extensionLogger)
+	assert.True(t, errors.Is(err, ErrCommandCanceled), "unexpected error %v", err)
+	assert.True(t, result.Canceled)
+	assert.False(t, result.TimedOut)
+	assert.Equal(t, CanceledExitCode, result.ExitCode)
+
+	pid := backgroundPid(t)
+	assert.Eventually(t, func() bool { return !processRunning(pid) }, 5*time.Second, 50*time.Millisecond, "the background process was killed")
+}
+
+func TestExecuteWithOptionsExitCode(t *testing.T) {
+	defer cleanupTest()
+	cmd := New()
+	result, err := cmd.ExecuteWithOptions(context.Background(), "echo $CustomAction_FOO; exit 7", ExecuteOptions{WorkingDir: workingDir, LogDir: workingDir, Timeout: time.Minute, EnvVariables: map[string]string{"FOO": "bar"}}, extensionLogger)
+	assert.Error(t, err)
+	assert.False(t, errors.Is(err, ErrCommandTimedOut))
+	assert.Equal(t, 7, result.ExitCode)
+	fileBytes, err := ioutil.ReadFile(path.Join(workingDir, "stdout"))
+	assert.NoError(t, err)
+	assert.Equal(t, "bar\n", string(fileBytes))
+}
+
+func TestExecuteWithOptionsStreamsOutput(t *testing.T) {
+	defer cleanupTest()
+	var stdout bytes.Buffer
+	var lines []string
+	cmd := New()
+	result, err := cmd.ExecuteWithOptions(context.Background(), "echo first; echo second; echo oops 1>&2", ExecuteOptions{
+		WorkingDir:  workingDir,
+		LogDir:      workingDir,
+		StreamToLog: true,
+		Stdout:      &stdout,
+		OnLine:      func(stream OutputStream, line string) { lines = append(lines, string(stream)+":"+line) },
+		TailSize:    10,
+	}, extensionLogger)
+	assert.NoError(t, err)
+	// the streams are read concurrently, so only the order within each is known
+	assert.ElementsMatch(t, []string{"stdout:first", "stdout:second", "stderr:oops"}, lines)
+	assert.Less(t, indexOf(lines, "stdout:first"), indexOf(lines, "stdout:second"))
+	assert.Equal(t, "first\nsecond\n", stdout.String())
+	assert.Equal(t, "...second\n", result.StdoutTail)
+	assert.Equal(t, "oops\n", result.StderrTail)
+
+	// the output is still written to the files
+	fileBytes, err := ioutil.ReadFile(path.Join(workingDir, "stdout"))
+	assert.NoError(t, err)
+	assert.Equal(t, stdout.String(), string(fileBytes))
+
+	substatuses := result.Substatuses("script")
+	assert.Len(t, substatuses, 2)
+	assert.Equal(t, "scriptStdOut", substatuses[0].Name)
+	assert.Equal(t, "success", substatuses[0].Status)
+	assert.Equal(t, result.StdoutTail, substatuses[0].FormattedMessage.Message)
+	assert.Equal(t, "scriptStdErr", substatuses[1].Name)
+}
+
+func TestExecuteArgsDoesNotUseShell(t *testing.T) {
+	defer cleanupTest()
+	cmd := New()
+	args := []string{"%s|%s\n", "$(echo injected); exit 3", "$CustomAction_FOO"}
+	result, err := cmd.ExecuteArgs(context.Background(), "printf", args, ExecuteOptions{WorkingDir: workingDir, LogDir: workingDir, Timeout: time.Minute}, extensionLogger)
+	assert.NoError(t, err)
+	assert.Equal(t, 0, result.ExitCode)
+	fileBytes, err := ioutil.ReadFile(path.Join(workingDir, "stdout"))
+	assert.NoError(t, err)
+	assert.Equal(t, "$(echo injected); exit 3|$CustomAction_FOO\n", string(fileBytes))
+}
+
+func TestExecuteArgsEnvVariablesAndExitCode(t *testing.T) {
+	defer cleanupTest()
+	cmd := New()
+	result, err := cmd.ExecuteArgs(context.Background(), "/bin/sh", []string{"-c", "echo $CustomAction_FOO; exit 7"}, ExecuteOptions{WorkingDir: workingDir, LogDir: workingDir, EnvVariables: map[string]string{"FOO": "bar"}}, extensionLogger)
+	assert.Error(t, err)
+	assert.Equal(t, 7, result.ExitCode)
+	fileBytes, err := ioutil.ReadFile(path.Join(workingDir, "stdout"))
+	assert.NoError(t, err)
+	assert.Equal(t, "bar\n", string(fileBytes))
+}
+
+func TestExecuteArgsTimeoutKillsProcessGroup(t *testing.T) {
+	defer cleanupTest()
+	cmd := New()
+	result, err := cmd.ExecuteArgs(context.Background(), "/bin/sh", []string{"-c", commandWithBackgroundProcess}, ExecuteOptions{WorkingDir: workingDir, LogDir: workingDir, Timeout: 500 * time.Millisecond}, extensionLogger)
+	assert.True(t, errors.Is(err, ErrCommandTimedOut), "unexpected error %v", err)
+	assert.Equal(t, TimeoutExitCode, result.ExitCode)
+
+	pid := backgroundPid(t)
+	assert.Eventually(t, func() bool { return !processRunning(pid) }, 5*time.Second, 50*time.Millisecond, "the background process was killed")
+}
+
+func TestExecuteWithOptionsCleanEnvironment(t *testing.T) {
+	defer cleanupTest()
+	cmd := New()
+	options := ExecuteOptions{
+		WorkingDir:   workingDir,
+		LogDir:       workingDir,
+		EnvVariables: map[string]string{"FOO": "bar"},
+		Environment:  &Environment{Clean: true, Secrets: map[string]string{"TOKEN": "supersecret"}},
+	}
+	_, err := cmd.ExecuteArgs(context.Background(), "/usr/bin/env", nil, options, extensionLogger)
+	assert.NoError(t, err)
+	fileBytes, err := ioutil.ReadFile(path.Join(workingDir, "stdout"))
+	assert.NoError(t, err)
+	assert.ElementsMatch(t, []string{"CustomAction_FOO=bar", "TOKEN=supersecret"}, strings.Fields(string(fileBytes)))
+}
@@ -0,0 +1,53 @@
+// Copyright (c) Microsoft Corporation.
+// Licensed under the MIT License.
+package commandhandler
+
+import (
+	"context"
+	"io/ioutil"
+	"path"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestExecuteWithOptions(t *testing.T) {
+	defer cleanupTest()
+	cmd := New()
+	result, err := cmd.ExecuteWithOptions(context.Background(), "echo 1 2 3 4", ExecuteOptions{WorkingDir: workingDir, LogDir: workingDir, Timeout: time.Minute}, extensionLogger)
+	assert.NoError(t, err)
+	assert.Equal(t, 0, result.ExitCode)
+	assert.False(t, result.TimedOut)
+	assert.False(t, result.Canceled)
+	assert.Greater(t, result.Duration, time.Duration(0))
+	fileBytes, err := ioutil.ReadFile(path.Join(workingDir, "stdout"))
+	assert.NoError(t, err)
+	assert.Equal(t, "1 2 3 4", strings.TrimSuffix(strings.TrimSuffix(string(fileBytes), lineReturnCharacter), " "))
+}
+
+func TestExecuteWithOptionsNonExistingCommand(t *testing.T) {
+	defer cleanupTest()
+	cmd := New()
+	result, err := cmd.ExecuteWithOptions(context.Background(), "command_does_not_exist", ExecuteOptions{WorkingDir: workingDir, LogDir: workingDir}, extensionLogger)
+	assert.Error(t, err)
+	assert.Equal(t, commandNotExistReturnCode, result.ExitCode)
+	assert.False(t, result.TimedOut)
+}
+
+func TestExecuteArgsNonExistingExecutable(t *testing.T) {
+	defer cleanupTest()
+	cmd := New()
+	result, err := cmd.ExecuteArgs(context.Background(), "command_does_not_exist", []string{"yaba"}, ExecuteOptions{WorkingDir: workingDir, LogDir: workingDir}, extensionLogger)
+	assert.Error(t, err)
+	assert.Equal(t, commandNotExistReturnCode, result.ExitCode)
+
+	_, err = cmd.ExecuteArgs(context.Background(), "", nil, ExecuteOptions{WorkingDir: workingDir, LogDir: workingDir}, extensionLogger)
+	assert.Error(t, err)
+}
+
+func TestCommandLine(t *testing.T) {
+	assert.Equal(t, "echo", commandLine("echo", nil))
+	assert.Equal(t, `echo a "b c" "" "it's"`, commandLine("echo", []string{"a", "b c", "", "it's"}))
+}
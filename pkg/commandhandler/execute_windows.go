@@ -0,0 +1,42 @@
+// Copyright (c) Microsoft Corporation.
+// Licensed under the MIT License.
+package commandhandler
+
+import (
+	"context"
+	"os/exec"
+	"strconv"
+	"syscall"
+)
+
+// commandNotFoundExitCode is the exit code cmd returns for a command that doesn't exist
+const commandNotFoundExitCode = 1
+
+// envNamesCaseSensitive is false because Windows environment variable names aren't case sensitive
+const envNamesCaseSensitive = false
+
+// newCommandContext returns the command run by cmd, whose process tree is killed when the
+// context is done
+func newCommandContext(ctx context.Context, cmd, workdir string) *exec.Cmd {
+	c := newArgsCommandContext(ctx, "cmd", nil, workdir)
+	// see execCommonWithEnvVariables for why the command line is set directly
+	c.SysProcAttr = &syscall.SysProcAttr{CmdLine: "/C " + cmd}
+	return c
+}
+
+// newArgsCommandContext returns the executable run directly, whose process tree is killed when
+// the context is done. The arguments are quoted the way CommandLineToArgvW parses them.
+func newArgsCommandContext(ctx context.Context, name string, args []string, workdir string) *exec.Cmd {
+	c := exec.CommandContext(ctx, name, args...)
+	c.Dir = workdir
+	c.Cancel = func() error {
+		// killing the process would leave the processes it started running
+		err := exec.Command("taskkill", "/T", "/F", "/PID", strconv.Itoa(c.Process.Pid)).Run()
+		if err != nil {
+			return c.Process.Kill()
+		}
+		return nil
+	}
+	c.WaitDelay = killWaitDelay
+	return c
+}
@@ -0,0 +1,140 @@
+// Copyright (c) Microsoft Corporation.
+// Licensed under the MIT License.
+package commandhandler
+
+import (
+	"bytes"
+	"io"
+	"sync"
+	"unicode/utf8"
+)
+
+// OutputStream identifies the stream a line of command output was written to
+type OutputStream string
+
+const (
+	Stdout OutputStream = "stdout"
+	Stderr OutputStream = "stderr"
+)
+
+// maxLineLength is the longest line passed on before it is complete, so output without
+// newlines doesn't grow the line buffer without limit
+const maxLineLength = 64 * 1024
+
+// LineFunc is called for each line a command writes, without the line ending
+type LineFunc func(stream OutputStream, line string)
+
+// tailEllipsis replaces the output dropped from the start of a tail
+const tailEllipsis = "..."
+
+// tailBuffer keeps the last bytes written to it
+type tailBuffer struct {
+	max     int
+	b       []byte
+	written int64
+}
+
+func (t *tailBuffer) Write(p []byte) (int, error) {
+	t.written += int64(len(p))
+	if len(p) >= t.max {
+		t.b = append(t.b[:0], p[len(p)-t.max:]...)
+		return len(p), nil
+	}
+	if overflow := len(t.b) + len(p) - t.max; overflow > 0 {
+		t.b = append(t.b[:0], t.b[overflow:]...)
+	}
+	t.b = append(t.b, p...)
+	return len(p), nil
+}
+
+// String returns the tail. If earlier output was dropped, the tail starts with an ellipsis
+// and is cut at the start of a character, so it is no longer than max.
+func (t *tailBuffer) String() string {
+	if t.written <= int64(len(t.b)) {
+		return string(t.b)
+	}
+	n := t.max - len(tailEllipsis)
+	if n <= 0 {
+		return tailEllipsis[:t.max]
+	}
+	b := t.b
+	if len(b) > n {
+		b = b[len(b)-n:]
+	}
+	for len(b) > 0 && !utf8.RuneStart(b[0]) {
+		b = b[1:]
+	}
+	return tailEllipsis + string(b)
+}
+
+// outputSink receives the output of both streams of a command. Writes are serialized, so
+// callers' writers and line functions aren't called concurrently.
+type outputSink struct {
+	mu      sync.Mutex
+	onLines []LineFunc
+}
+
+// streamWriter copies one stream of a command to its file, tail buffer and the caller's writer,
+// and splits it into lines for the line functions
+type streamWriter struct {
+	sink    *outputSink
+	stream  OutputStream
+	file    io.Writer
+	w       io.Writer // the caller's writer, dropped if it fails so the command isn't affected
+	tail    *tailBuffer
+	partial []byte
+}
+
+func (sw *streamWriter) Write(p []byte) (int, error) {
+	sw.sink.mu.Lock()
+	defer sw.sink.mu.Unlock()
+
+	if _, err := sw.file.Write(p); err != nil {
+		return 0, err
+	}
+	if sw.w != nil {
+		if _, err := sw.w.Write(p); err != nil {
+			sw.w = nil
+		}
+	}
+	if sw.tail != nil {
+		sw.tail.Write(p)
+	}
+	if len(sw.sink.onLines) == 0 {
+		return len(p), nil
+	}
+
+	sw.partial = append(sw.partial, p...)
+	for {
+		i := bytes.IndexByte(sw.partial, '\n')
+		if i < 0 {
+			break
+		}
+		sw.emitLocked(sw.partial[:i])
+		sw.partial = sw.partial[i+1:]
+	}
+	if len(sw.partial) >= maxLineLength {
+		sw.emitLocked(sw.partial)
+		sw.partial = nil
+	}
+	// don't hold on to the memory of lines already passed on
+	sw.partial = append([]byte(nil), sw.partial...)
+	return len(p), nil
+}
+
+// flush passes on the last line if the command didn't end it
+func (sw *streamWriter) flush() {
+	sw.sink.mu.Lock()
+	defer sw.sink.mu.Unlock()
+	if len(sw.partial) > 0 {
+		sw.emitLocked(sw.partial)
+		sw.partial = nil
+	}
+}
+
+func (sw *streamWriter) emitLocked(line []byte) {
+	line = bytes.TrimSuffix(line, []byte("\r"))
+	for _, onLine := range sw.sink.onLines {
+		onLine(sw.stream, string(line))
+	}
+}
@@ -0,0 +1,53 @@
+// Copyright (c) Microsoft Corporation.
+// Licensed under the MIT License.
+package commandhandler
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestTailBuffer(t *testing.T) {
+	tail := &tailBuffer{max: 5}
+	tail.Write([]byte("abc"))
+	assert.Equal(t, "abc", tail.String())
+	tail.Write([]byte("de"))
+	assert.Equal(t, "abcde", tail.String())
+	tail.Write([]byte("fg"))
+	assert.Equal(t, "...fg", tail.String())
+	tail.Write([]byte("0123456789"))
+	assert.Equal(t, "...89", tail.String())
+
+	// the tail starts at a character
+	tail = &tailBuffer{max: 7}
+	tail.Write([]byte("x€€ab"))
+	assert.Equal(t, "...ab", tail.String())
+	tail = &tailBuffer{max: 8}
+	tail.Write([]byte("x€€ab"))
+	assert.Equal(t, "...€ab", tail.String())
+}
+
+func TestStreamWriterSplitsLines(t *testing.T) {
+	var lines []string
+	sink := &outputSink{onLines: []LineFunc{func(stream OutputStream, line string) {
+		lines = append(lines, string(stream)+":"+line)
+	}}}
+	var file, w bytes.Buffer
+	sw := &streamWriter{sink: sink, stream: Stdout, file: &file, w: &w}
+
+	sw.Write([]byte("one\r\ntw"))
+	sw.Write([]byte("o\nthree"))
+	assert.Equal(t, []string{"stdout:one", "stdout:two"}, lines)
+	sw.flush()
+	assert.Equal(t, []string{"stdout:one", "stdout:two", "stdout:three"}, lines)
+	assert.Equal(t, "one\r\ntwo\nthree", file.String())
+	assert.Equal(t, file.String(), w.String())
+
+	// lines without an end are passed on once they are long enough
+	lines = nil
+	sw.Write([]byte(strings.Repeat("x", maxLineLength)))
+	assert.Len(t, lines, 1)
+}
@@ -0,0 +1,56 @@
+// Copyright (c) Microsoft Corporation.
+// Licensed under the MIT License.
+package commandhandler
+
+import (
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// SandboxOptions limit the privileges and resources of a command, so customer scripts can't use
+// those of the extension. They are supported on Linux only.
+type SandboxOptions struct {
+	// User is the name or uid of the user the command runs as. HOME, USER and LOGNAME are set for it
+	// if the user exists. Directories created for the working directory are owned by the user, but
+	// the directories above them must allow the user to traverse them. The supplementary groups of
+	// the command are those of the user, or none for a uid without an account. The command keeps
+	// those of the extension if it runs as the user of the extension.
+	User string
+
+	// Group is the name or gid of the group the command runs as, which requires User. It defaults to
+	// the primary group of the user, and is required if the user is a uid without an account.
+	Group string
+
+	Umask  *os.FileMode    // The file mode creation mask of the command, such as 0027
+	Nice   *int            // The niceness of the command, from -20 to 19
+	Limits *ResourceLimits // The resource limits of the command
+
+	// Cgroup is the cgroup v2 directory the command is started in, relative to /sys/fs/cgroup unless
+	// it is absolute. It is created if it doesn't exist. The limits of the cgroup are left to the caller.
+	Cgroup string
+}
+
+// ResourceLimits are the rlimits of a command. Both the soft and hard limits are set, so the
+// command can't raise them. Zero leaves a limit as it is inherited from the extension.
+type ResourceLimits struct {
+	CPUTime   time.Duration // The CPU time the command may use, rounded up to seconds
+	Memory    uint64        // The bytes of address space each process may use
+	OpenFiles uint64        // The number of files each process may have open
+	Processes uint64        // The number of processes the user may have, counting those not started by the command
+}
+
+// firstMissingDir returns the highest directory of the path that doesn't exist, or an empty
+// string if the path exists
+func firstMissingDir(dir string) string {
+	missing := ""
+	for dir = filepath.Clean(dir); ; dir = filepath.Dir(dir) {
+		if _, err := os.Stat(dir); !os.IsNotExist(err) {
+			return missing
+		}
+		missing = dir
+		if filepath.Dir(dir) == dir {
+			return missing
+		}
+	}
+}
@@ -0,0 +1,298 @@
+// Copyright (c) Microsoft Corporation.
+// Licensed under the MIT License.
+package commandhandler
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"os/user"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/pkg/errors"
+	"golang.org/x/sys/unix"
+)
+
+// cgroupRoot is where the cgroup v2 hierarchy is mounted
+const cgroupRoot = "/sys/fs/cgroup"
+
+// gateStart is written to the gate to let the command start
+const gateStart = "start"
+
+// sandbox applies SandboxOptions to a command. The rlimits and niceness can only be set for a
+// running process, so the command is started by /bin/sh, which waits on a pipe until they are
+// applied before running the command with exec. Values are passed to /bin/sh as arguments, so
+// they are never parsed as part of the script.
+type sandbox struct {
+	options *SandboxOptions
+	cgroup  *os.File // The cgroup directory, which the command is started in
+	gateR   *os.File // The end of the gate read by the command
+	gateW   *os.File // The end of the gate written once the rlimits and niceness are applied
+}
+
+// newSandbox configures the command to run with the options, and returns the sandbox to call
+// once it has started. The directories from createdDir down to the working directory, which were
+// created for the command, are given to the user it runs as.
+func newSandbox(c *exec.Cmd, options *SandboxOptions, createdDir string) (*sandbox, error) {
+	if options == nil {
+		return nil, nil
+	}
+	if options.Umask != nil && *options.Umask&^os.ModePerm != 0 {
+		return nil, fmt.Errorf("invalid umask %04o", *options.Umask)
+	}
+	if options.Nice != nil && (*options.Nice < -20 || *options.Nice > 19) {
+		return nil, fmt.Errorf("invalid nice value %d, which must be from -20 to 19", *options.Nice)
+	}
+
+	s := &sandbox{options: options}
+	if c.SysProcAttr == nil {
+		c.SysProcAttr = &syscall.SysProcAttr{}
+	}
+	if options.User != "" || options.Group != "" {
+		if err := s.setCredential(c, createdDir); err != nil {
+			s.close()
+			return nil, err
+		}
+	}
+	if options.Cgroup != "" {
+		if err := s.openCgroup(c); err != nil {
+			s.close()
+			return nil, err
+		}
+	}
+	if err := s.wrap(c); err != nil {
+		s.close()
+		return nil, err
+	}
+	return s, nil
+}
+
+func (s *sandbox) setCredential(c *exec.Cmd, createdDir string) error {
+	if s.options.User == "" {
+		return fmt.Errorf("a user is required to run as group %s", s.options.Group)
+	}
+	u, uid, err := lookupUser(s.options.User)
+	if err != nil {
+		return err
+	}
+	cred := &syscall.Credential{Uid: uid}
+	if u != nil {
+		if cred.Gid, err = parseID(u.Gid); err != nil {
+			return errors.Wrapf(err, "invalid primary group of user %s", s.options.User)
+		}
+		if cred.Groups, err = supplementaryGroups(u); err != nil {
+			return err
+		}
+	} else if s.options.Group == "" {
+		return fmt.Errorf("a group is required to run as uid %s, which has no account", s.options.User)
+	}
+	if s.options.Group != "" {
+		if cred.Gid, err = lookupGroup(s.options.Group); err != nil {
+			return err
+		}
+	}
+	if int(uid) == os.Geteuid() {
+		// the groups of the extension are kept, since only root can set them
+		cred.Groups, cred.NoSetGroups = nil, true
+	}
+	c.SysProcAttr.Credential = cred
+
+	if u != nil {
+		c.Env = setEnvVariable(c.Env, "HOME", u.HomeDir)
+		c.Env = setEnvVariable(c.Env, "USER", u.Username)
+		c.Env = setEnvVariable(c.Env, "LOGNAME", u.Username)
+	}
+
+	if createdDir == "" {
+		return nil
+	}
+	createdDir = filepath.Clean(createdDir)
+	for dir := filepath.Clean(c.Dir); ; dir = filepath.Dir(dir) {
+		if err := os.Chown(dir, int(cred.Uid), int(cred.Gid)); err != nil {
+			return errors.Wrapf(err, "failed to give directory %s to the user of the command", dir)
+		}
+		if dir == createdDir || filepath.Dir(dir) == dir {
+			return nil
+		}
+	}
+}
+
+func (s *sandbox) openCgroup(c *exec.Cmd) error {
+	dir := s.options.Cgroup
+	if !filepath.IsAbs(dir) {
+		dir = filepath.Join(cgroupRoot, dir)
+	}
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return errors.Wrapf(err, "failed to create cgroup %s", dir)
+	}
+	f, err := os.Open(dir)
+	if err != nil {
+		return errors.Wrapf(err, "failed to open cgroup %s", dir)
+	}
+	s.cgroup = f
+	c.SysProcAttr.UseCgroupFD = true
+	c.SysProcAttr.CgroupFD = int(f.Fd())
+	return nil
+}
+
+// wrap runs the command through the gate if the sandbox needs to be applied after it has started
+func (s *sandbox) wrap(c *exec.Cmd) error {
+	needsGate := s.options.Nice != nil || (s.options.Limits != nil && *s.options.Limits != ResourceLimits{})
+	if c.Err != nil || (!needsGate && s.options.Umask == nil) {
+		// a command that can't be found is left to fail as it would without a sandbox
+		return nil
+	}
+
+	var script strings.Builder
+	args := []string{"sh"}
+	if needsGate {
+		r, w, err := os.Pipe()
+		if err != nil {
+			return errors.Wrap(err, "failed to create the pipe that starts the command")
+		}
+		s.gateR, s.gateW = r, w
+		// extra files start at descriptor 3
+		fd := strconv.Itoa(3 + len(c.ExtraFiles))
+		c.ExtraFiles = append(c.ExtraFiles, r)
+		fmt.Fprintf(&script, `read -r gate <&%s && [ "$gate" = %s ] || exit 126; exec %s<&-; `, fd, gateStart, fd)
+	}
+	if s.options.Umask != nil {
+		script.WriteString(`umask "$1"; shift; `)
+		args = append(args, fmt.Sprintf("%04o", uint32(*s.options.Umask)))
+	}
+	script.WriteString(`exec "$@"`)
+
+	c.Args = append(append([]string{"/bin/sh", "-c", script.String()}, args...), append([]string{c.Path}, c.Args[1:]...)...)
+	c.Path = "/bin/sh"
+	return nil
+}
+
+// started applies the rlimits and niceness to the command, then lets it start
+func (s *sandbox) started(pid int) error {
+	if s == nil {
+		return nil
+	}
+	// the command has its own copies of these
+	closeFile(&s.cgroup)
+	closeFile(&s.gateR)
+	if s.gateW == nil {
+		return nil
+	}
+
+	if l := s.options.Limits; l != nil {
+		cpuSeconds := uint64((l.CPUTime + time.Second - 1) / time.Second)
+		for _, limit := range []struct {
+			name     string
+			resource int
+			value    uint64
+		}{
+			{"CPU time", unix.RLIMIT_CPU, cpuSeconds},
+			{"memory", unix.RLIMIT_AS, l.Memory},
+			{"open files", unix.RLIMIT_NOFILE, l.OpenFiles},
+			{"processes", unix.RLIMIT_NPROC, l.Processes},
+		} {
+			if limit.value == 0 {
+				continue
+			}
+			if err := unix.Prlimit(pid, limit.resource, &unix.Rlimit{Cur: limit.value, Max: limit.value}, nil); err != nil {
+				return errors.Wrapf(err, "failed to limit %s", limit.name)
+			}
+		}
+	}
+	if s.options.Nice != nil {
+		if err := unix.Setpriority(unix.PRIO_PROCESS, pid, *s.options.Nice); err != nil {
+			return errors.Wrap(err, "failed to set the nice value")
+		}
+	}
+
+	_, err := s.gateW.Write([]byte(gateStart + "\n"))
+	closeFile(&s.gateW)
+	return errors.Wrap(err, "failed to start the command")
+}
+
+// close releases the files of the sandbox
+func (s *sandbox) close() {
+	if s == nil {
+		return
+	}
+	closeFile(&s.cgroup)
+	closeFile(&s.gateR)
+	closeFile(&s.gateW)
+}
+
+func closeFile(f **os.File) {
+	if *f != nil {
+		(*f).Close()
+		*f = nil
+	}
+}
+
+// lookupUser returns the account and uid of a user name or uid. The account is nil for a
+// uid that has none.
+func lookupUser(name string) (*user.User, uint32, error) {
+	if uid, err := parseID(name); err == nil {
+		u, _ := user.LookupId(name)
+		return u, uid, nil
+	}
+	u, err := user.Lookup(name)
+	if err != nil {
+		return nil, 0, err
+	}
+	uid, err := parseID(u.Uid)
+	if err != nil {
+		return nil, 0, errors.Wrapf(err, "invalid uid of user %s", name)
+	}
+	return u, uid, nil
+}
+
+// lookupGroup returns the gid of a group name or gid
+func lookupGroup(name string) (uint32, error) {
+	if gid, err := parseID(name); err == nil {
+		return gid, nil
+	}
+	g, err := user.LookupGroup(name)
+	if err != nil {
+		return 0, err
+	}
+	gid, err := parseID(g.Gid)
+	if err != nil {
+		return 0, errors.Wrapf(err, "invalid gid of group %s", name)
+	}
+	return gid, nil
+}
+
+func supplementaryGroups(u *user.User) ([]uint32, error) {
+	ids, err := u.GroupIds()
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to look up the groups of user %s", u.Username)
+	}
+	groups := make([]uint32, 0, len(ids))
+	for _, id := range ids {
+		gid, err := parseID(id)
+		if err != nil {
+			return nil, errors.Wrapf(err, "invalid gid of a group of user %s", u.Username)
+		}
+		groups = append(groups, gid)
+	}
+	return groups, nil
+}
+
+func parseID(id string) (uint32, error) {
+	n, err := strconv.ParseUint(id, 10, 32)
+	return uint32(n), err
+}
+
+// setEnvVariable returns the environment with the variable set to the value, replacing any value it had
+func setEnvVariable(env []string, name, value string) []string {
+	for i, v := range env {
+		if strings.HasPrefix(v, name+"=") {
+			env[i] = name + "=" + value
+			return env
+		}
+	}
+	return append(env, name+"="+value)
+}
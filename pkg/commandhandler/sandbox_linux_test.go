@@ -0,0 +1,134 @@
+// Copyright (c) Microsoft Corporation.
+// Licensed under the MIT License.
+package commandhandler
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/user"
+	"path"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// executeSandboxed runs the command in the sandbox and returns its stdout
+func executeSandboxed(t *testing.T, command string, workingDir string, sandbox *SandboxOptions) string {
+	cmd := New()
+	result, err := cmd.ExecuteWithOptions(context.Background(), command, ExecuteOptions{WorkingDir: workingDir, LogDir: workingDir, Timeout: time.Minute, Sandbox: sandbox}, extensionLogger)
+	require.NoError(t, err)
+	assert.Equal(t, 0, result.ExitCode)
+	fileBytes, err := ioutil.ReadFile(path.Join(workingDir, "stdout"))
+	require.NoError(t, err)
+	return string(fileBytes)
+}
+
+func TestSandboxLimits(t *testing.T) {
+	defer cleanupTest()
+	umask := os.FileMode(0027)
+	nice := 5
+	sandbox := &SandboxOptions{
+		Umask:  &umask,
+		Nice:   &nice,
+		Limits: &ResourceLimits{CPUTime: 1500 * time.Millisecond, OpenFiles: 64, Memory: 1 << 30},
+	}
+	out := executeSandboxed(t, "umask; ulimit -t; ulimit -n; ulimit -v; cut -d' ' -f19 /proc/self/stat", workingDir, sandbox)
+	assert.Equal(t, "0027\n2\n64\n1048576\n5\n", out)
+}
+
+func TestSandboxDoesNotParseValues(t *testing.T) {
+	defer cleanupTest()
+	umask := os.FileMode(0077)
+	cmd := New()
+	result, err := cmd.ExecuteArgs(context.Background(), "printf", []string{"%s\n", "$1; exit 3"}, ExecuteOptions{WorkingDir: workingDir, LogDir: workingDir, Sandbox: &SandboxOptions{Umask: &umask, Nice: new(int)}}, extensionLogger)
+	require.NoError(t, err)
+	assert.Equal(t, 0, result.ExitCode)
+	fileBytes, err := ioutil.ReadFile(path.Join(workingDir, "stdout"))
+	require.NoError(t, err)
+	assert.Equal(t, "$1; exit 3\n", string(fileBytes))
+
+	result, err = cmd.ExecuteArgs(context.Background(), "command_does_not_exist", nil, ExecuteOptions{WorkingDir: workingDir, LogDir: workingDir, Sandbox: &SandboxOptions{Nice: new(int)}}, extensionLogger)
+	assert.Error(t, err)
+	assert.Equal(t, commandNotExistReturnCode, result.ExitCode)
+}
+
+func TestSandboxInvalidOptions(t *testing.T) {
+	defer cleanupTest()
+	umask := os.FileMode(01777)
+	nice := 20
+	cmd := New()
+	for _, sandbox := range []*SandboxOptions{{Umask: &umask}, {Nice: &nice}, {User: "user_does_not_exist"}, {User: "12345"}, {Group: "0"}} {
+		_, err := cmd.ExecuteWithOptions(context.Background(), "echo yaba", ExecuteOptions{WorkingDir: workingDir, LogDir: workingDir, Sandbox: sandbox}, extensionLogger)
+		assert.Error(t, err, "%+v", sandbox)
+	}
+}
+
+// the sandboxes below don't start the command through the gate, and don't need root
+func TestSandboxUmask(t *testing.T) {
+	defer cleanupTest()
+	umask := os.FileMode(0077)
+	out := executeSandboxed(t, "umask; touch file; stat -c %a file", workingDir, &SandboxOptions{Umask: &umask})
+	assert.Equal(t, "0077\n600\n", out)
+}
+
+func TestSandboxCurrentUser(t *testing.T) {
+	current, err := user.Current()
+	require.NoError(t, err)
+	base, err := ioutil.TempDir("", "sandbox")
+	require.NoError(t, err)
+	defer os.RemoveAll(base)
+
+	// the groups of the extension are kept when running as its own user
+	groups := strings.TrimSpace(executeSandboxed(t, "id -G", base, nil))
+	dir := filepath.Join(base, "created")
+	out := executeSandboxed(t, "id -u; id -g; id -G; echo $HOME", dir, &SandboxOptions{User: current.Username})
+	assert.Equal(t, fmt.Sprintf("%s\n%s\n%s\n%s\n", current.Uid, current.Gid, groups, current.HomeDir), out)
+}
+
+func TestSandboxUser(t *testing.T) {
+	if os.Geteuid() != 0 {
+		t.Skip("running as another user requires root")
+	}
+	nobody, err := user.Lookup("nobody")
+	if err != nil {
+		t.Skip("the nobody user doesn't exist")
+	}
+
+	// the directories are created for the command, so it can use them
+	base, err := ioutil.TempDir("", "sandbox")
+	require.NoError(t, err)
+	defer os.RemoveAll(base)
+	require.NoError(t, os.Chmod(base, 0755))
+	dir := filepath.Join(base, "created", "workingDir")
+
+	out := executeSandboxed(t, "id -u; id -g; echo $HOME; touch file", dir, &SandboxOptions{User: "nobody"})
+	assert.Equal(t, fmt.Sprintf("%s\n%s\n%s\n", nobody.Uid, nobody.Gid, nobody.HomeDir), out)
+
+	// a uid without an account needs a group
+	out = executeSandboxed(t, "id -u; id -g", filepath.Join(base, "uid"), &SandboxOptions{User: "12345", Group: nobody.Gid})
+	assert.Equal(t, fmt.Sprintf("12345\n%s\n", nobody.Gid), out)
+}
+
+func TestSandboxCgroup(t *testing.T) {
+	if os.Geteuid() != 0 {
+		t.Skip("creating a cgroup requires root")
+	}
+	if _, err := os.Stat(filepath.Join(cgroupRoot, "cgroup.controllers")); err != nil {
+		t.Skip("cgroup v2 isn't mounted at " + cgroupRoot)
+	}
+	name := fmt.Sprintf("commandhandler-test-%d", os.Getpid())
+	if err := os.Mkdir(filepath.Join(cgroupRoot, name), 0755); err != nil {
+		t.Skipf("can't create a cgroup: %v", err)
+	}
+	defer os.Remove(filepath.Join(cgroupRoot, name))
+	defer cleanupTest()
+
+	out := executeSandboxed(t, "cat /proc/self/cgroup", workingDir, &SandboxOptions{Cgroup: name})
+	assert.True(t, strings.HasSuffix(strings.TrimSpace(out), "/"+name), out)
+}
@@ -0,0 +1,25 @@
+// Copyright (c) Microsoft Corporation.
+// Licensed under the MIT License.
+package commandhandler
+
+import (
+	"os/exec"
+
+	"github.com/pkg/errors"
+)
+
+type sandbox struct{}
+
+// newSandbox returns an error for any options, which aren't supported on Windows
+func newSandbox(c *exec.Cmd, options *SandboxOptions, createdDir string) (*sandbox, error) {
+	if options != nil {
+		return nil, errors.New("sandboxing commands is not supported on Windows")
+	}
+	return nil, nil
+}
+
+func (s *sandbox) started(pid int) error {
+	return nil
+}
+
+func (s *sandbox) close() {}
@@ -5,13 +5,20 @@ package decrypt
 import (
 	"bytes"
 	"fmt"
+	"os"
 	"os/exec"
 	"path"
 	"path/filepath"
 
+	"github.com/Azure/azure-extension-platform/pkg/internal/cms"
 	"github.com/pkg/errors"
 )
 
+// UseOpensslFallback makes DecryptProtectedSettings retry with the openssl command line tool
+// if the protected settings cannot be decrypted natively. It is off by default and only
+// needed for messages using algorithms the native decryptor doesn't support.
+var UseOpensslFallback = false
+
 var getCertificateDir = func(configFolder string) (certificateFolder string) {
 	return path.Join(configFolder, "..", "..")
 }
@@ -22,6 +29,50 @@ func DecryptProtectedSettings(configFolder string, thumbprint string, decoded []
 	crt := filepath.Join(getCertificateDir(configFolder), fmt.Sprintf("%s.crt", thumbprint))
 	prv := filepath.Join(getCertificateDir(configFolder), fmt.Sprintf("%s.prv", thumbprint))
 
+	v, err := decryptNative(crt, prv, decoded)
+	if err == nil {
+		return v, nil
+	}
+	if !UseOpensslFallback {
+		return "", err
+	}
+
+	v, opensslErr := decryptWithOpenssl(crt, prv, decoded)
+	if opensslErr != nil {
+		return "", errors.Wrapf(opensslErr, "decrypting protected settings natively failed: %v", err)
+	}
+	return v, nil
+}
+
+// decryptNative decrypts the CMS enveloped data using the certificate and private key files
+func decryptNative(crt, prv string, decoded []byte) (string, error) {
+	crtBytes, err := os.ReadFile(crt)
+	if err != nil {
+		return "", errors.Wrap(err, "failed to read the protected settings certificate")
+	}
+	cert, err := cms.ParseCertificate(crtBytes)
+	if err != nil {
+		return "", errors.Wrapf(err, "failed to parse the protected settings certificate %s", crt)
+	}
+
+	prvBytes, err := os.ReadFile(prv)
+	if err != nil {
+		return "", errors.Wrap(err, "failed to read the protected settings private key")
+	}
+	key, err := cms.ParsePrivateKey(prvBytes)
+	if err != nil {
+		return "", errors.Wrapf(err, "failed to parse the protected settings private key %s", prv)
+	}
+
+	v, err := cms.Decrypt(decoded, cert, key)
+	if err != nil {
+		return "", errors.Wrap(err, "decrypting protected settings failed")
+	}
+	return string(v), nil
+}
+
+// decryptWithOpenssl decrypts the protected settings using the openssl command line tool
+func decryptWithOpenssl(crt, prv string, decoded []byte) (string, error) {
 	// we use os/exec instead of azure-docker-extension/pkg/executil here as
 	// other extension handlers depend on this package for parsing handler
 	// settings.
@@ -71,3 +71,41 @@ func TestCanEncryptAndDecrypt(t *testing.T) {
 	assert.Equal(t, "value1", protSettings.Key1, "values associated with key1 should be the same")
 	assert.Equal(t, stringToEncrypt, decrypted, "the decrypted message should be the same as the original")
 }
+
+func TestDecryptMissingCertificate(t *testing.T) {
+	initTest(t)
+	defer cleanupTest()
+	_, err := DecryptProtectedSettings(testdir, "DOESNOTEXIST", []byte("yaba"))
+	assert.Error(t, err)
+	assert.Contains(t, err.Error(), "failed to read the protected settings certificate")
+}
+
+func TestDecryptWithoutOpensslFallback(t *testing.T) {
+	initTest(t)
+	defer cleanupTest()
+	certHandler, err := encrypt.New(testdir)
+	assert.NoError(t, err, "certificate creation should succeed")
+	thumbprint, err := certHandler.GetThumbprint()
+	assert.NoError(t, err, "getting thumbprint should succeed")
+
+	_, err = DecryptProtectedSettings(testdir, thumbprint, []byte("not a cms message"))
+	assert.Error(t, err)
+	assert.NotContains(t, err.Error(), "smime", "openssl should not be used unless opted in")
+}
+
+func TestDecryptWithOpensslFallback(t *testing.T) {
+	initTest(t)
+	defer cleanupTest()
+	UseOpensslFallback = true
+	defer func() { UseOpensslFallback = false }()
+	certHandler, err := encrypt.New(testdir)
+	assert.NoError(t, err, "certificate creation should succeed")
+	thumbprint, err := certHandler.GetThumbprint()
+	assert.NoError(t, err, "getting thumbprint should succeed")
+
+	// both decryptors fail and both errors are reported
+	_, err = DecryptProtectedSettings(testdir, thumbprint, []byte("not a cms message"))
+	assert.Error(t, err)
+	assert.Contains(t, err.Error(), "natively")
+	assert.Contains(t, err.Error(), "smime")
+}
@@ -5,12 +5,74 @@ package encrypt
 // this package is meant for creating protected settings for testing extensions
 // not intended for use in production code
 
+import (
+	"fmt"
+
+	"github.com/Azure/azure-extension-platform/pkg/internal/crypto"
+)
+
 type ICertHandler interface {
 	GetThumbprint() (certThumbprint string, err error)
 	Encrypt(bytesToEncrypt []byte) (encryptedBytes []byte, err error)
 }
 
-// certLocation is ignored for windows
-func New(certLocation string) (ICertHandler, error) {
-	return newCertHandler(certLocation)
+// ContentCipher is the symmetric algorithm used to encrypt the protected settings
+type ContentCipher int
+
+const (
+	AES256CBC ContentCipher = iota // the default
+	AES192CBC
+	AES128CBC
+	DESEDE3CBC
+)
+
+// CertificateOptions describe the self signed certificate created by New
+type CertificateOptions = crypto.CertificateOptions
+
+// KeyType is the algorithm and size of the certificate key
+type KeyType = crypto.KeyType
+
+const (
+	RSA2048   = crypto.RSA2048 // the default
+	RSA3072   = crypto.RSA3072
+	RSA4096   = crypto.RSA4096
+	ECDSAP256 = crypto.ECDSAP256 // rejected by New, protected settings can't be encrypted for ECDSA certificates
+	ECDSAP384 = crypto.ECDSAP384 // rejected by New, protected settings can't be encrypted for ECDSA certificates
+)
+
+// Option customizes the certificate handler returned by New
+type Option func(*options)
+
+type options struct {
+	contentCipher      ContentCipher
+	certificateOptions CertificateOptions
+}
+
+// WithContentCipher selects the content cipher. It is ignored for windows.
+func WithContentCipher(contentCipher ContentCipher) Option {
+	return func(o *options) {
+		o.contentCipher = contentCipher
+	}
+}
+
+// WithCertificateOptions describes the self signed certificate to create. It is ignored for windows,
+// which uses an existing certificate from the machine store.
+func WithCertificateOptions(certificateOptions CertificateOptions) Option {
+	return func(o *options) {
+		o.certificateOptions = certificateOptions
+	}
+}
+
+// certLocation is ignored for windows. An error is returned for certificate options with an
+// ECDSA key, because the content key of protected settings can only be transported with RSA.
+func New(certLocation string, opts ...Option) (ICertHandler, error) {
+	o := options{contentCipher: AES256CBC}
+	for _, opt := range opts {
+		opt(&o)
+	}
+	switch o.certificateOptions.KeyType {
+	case ECDSAP256, ECDSAP384:
+		return nil, fmt.Errorf("protected settings can't be encrypted for a certificate with key type %d, which isn't RSA", o.certificateOptions.KeyType)
+	}
+	return newCertHandler(certLocation, o)
 }
@@ -3,47 +3,82 @@
 package encrypt
 
 import (
-	"bytes"
+	"crypto/x509"
 	"fmt"
-	"github.com/Azure/azure-extension-platform/pkg/internal/crypto"
-	"os/exec"
+	"os"
 	"path"
 	"path/filepath"
+
+	"github.com/Azure/azure-extension-platform/pkg/internal/cms"
+	"github.com/Azure/azure-extension-platform/pkg/internal/crypto"
 )
 
 type LinuxCertificateHandler struct {
 	CertOperations crypto.CertificateOperations
+	ContentCipher  ContentCipher
 	certLocation   string
+	cert           *x509.Certificate
 }
 
 func (ch *LinuxCertificateHandler) GetThumbprint() (certThumbprint string, err error) {
 	return ch.CertOperations.GetCertificateThumbprint(), nil
 }
 
+// Encrypt encrypts the bytes as CMS enveloped data that DecryptProtectedSettings can decrypt
+// using the certificate written to the cert location
 func (ch *LinuxCertificateHandler) Encrypt(bytesToEncrypt []byte) (encryptedBytes []byte, err error) {
+	contentEncryption, err := toContentEncryption(ch.ContentCipher)
+	if err != nil {
+		return nil, err
+	}
+
+	cert, err := ch.certificate()
+	if err != nil {
+		return nil, err
+	}
+
+	encryptedBytes, err = cms.Encrypt(bytesToEncrypt, []*x509.Certificate{cert}, contentEncryption)
+	if err != nil {
+		return nil, fmt.Errorf("encryption failed: error=%v", err)
+	}
+	return encryptedBytes, nil
+}
+
+// certificate returns the recipient certificate, reading it from the cert location if necessary
+func (ch *LinuxCertificateHandler) certificate() (*x509.Certificate, error) {
+	if ch.cert != nil {
+		return ch.cert, nil
+	}
+
 	thumbprint, err := ch.GetThumbprint()
 	if err != nil {
 		return nil, err
 	}
 	crt := filepath.Join(ch.certLocation, fmt.Sprintf("%s.crt", thumbprint))
+	crtBytes, err := os.ReadFile(crt)
+	if err != nil {
+		return nil, fmt.Errorf("could not read certificate: error=%v", err)
+	}
+	return cms.ParseCertificate(crtBytes)
+}
 
-	// we use os/exec instead of azure-docker-extension/pkg/executil here as
-	// other extension handlers depend on this package for parsing handler
-	// settings.
-	cmd := exec.Command("openssl", "smime", "-outform", "DER", "-encrypt", crt)
-	var bOut, bErr bytes.Buffer
-	cmd.Stdin = bytes.NewReader(bytesToEncrypt)
-	cmd.Stdout = &bOut
-	cmd.Stderr = &bErr
-
-	if err := cmd.Run(); err != nil {
-		return nil, fmt.Errorf("encryption failed: error=%v stderr=%s", err, string(bErr.Bytes()))
+func toContentEncryption(contentCipher ContentCipher) (cms.ContentEncryption, error) {
+	switch contentCipher {
+	case AES256CBC:
+		return cms.AES256CBC, nil
+	case AES192CBC:
+		return cms.AES192CBC, nil
+	case AES128CBC:
+		return cms.AES128CBC, nil
+	case DESEDE3CBC:
+		return cms.DESEDE3CBC, nil
+	default:
+		return 0, fmt.Errorf("unsupported content cipher %d", contentCipher)
 	}
-	return bOut.Bytes(), nil
 }
 
-func newCertHandler(certLocation string) (ICertHandler, error) {
-	cert, err := crypto.NewSelfSignedx509Certificate()
+func newCertHandler(certLocation string, o options) (ICertHandler, error) {
+	cert, err := crypto.NewSelfSignedx509CertificateWithOptions(o.certificateOptions)
 	if err != nil {
 		return nil, err
 	}
@@ -59,5 +94,5 @@ func newCertHandler(certLocation string) (ICertHandler, error) {
 	if err != nil {
 		return nil, err
 	}
-	return &LinuxCertificateHandler{CertOperations: cert, certLocation: certLocation}, nil
+	return &LinuxCertificateHandler{CertOperations: cert, ContentCipher: o.contentCipher, certLocation: certLocation, cert: &cert.Cert}, nil
 }
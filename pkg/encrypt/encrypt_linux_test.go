@@ -0,0 +1,129 @@
+// Copyright (c) Microsoft Corporation.
+// Licensed under the MIT License.
+package encrypt
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"testing"
+
+	"github.com/Azure/azure-extension-platform/pkg/decrypt"
+	"github.com/stretchr/testify/require"
+)
+
+var allContentCiphers = []ContentCipher{AES256CBC, AES192CBC, AES128CBC, DESEDE3CBC}
+
+// decrypt.DecryptProtectedSettings looks for certificates two folders above the config folder
+func newTestCertLocation(t *testing.T) (certLocation string, configFolder string) {
+	certLocation = t.TempDir()
+	configFolder = filepath.Join(certLocation, "Extension", "config")
+	require.NoError(t, os.MkdirAll(configFolder, 0700))
+	return certLocation, configFolder
+}
+
+func TestEncryptDefaultsToAES256(t *testing.T) {
+	certLocation, _ := newTestCertLocation(t)
+	certHandler, err := New(certLocation)
+	require.NoError(t, err)
+	require.Equal(t, AES256CBC, certHandler.(*LinuxCertificateHandler).ContentCipher)
+}
+
+func TestEncryptDecryptMatrix(t *testing.T) {
+	for _, contentCipher := range allContentCiphers {
+		certLocation, configFolder := newTestCertLocation(t)
+		certHandler, err := New(certLocation, WithContentCipher(contentCipher))
+		require.NoError(t, err, "certificate creation should succeed")
+		thumbprint, err := certHandler.GetThumbprint()
+		require.NoError(t, err)
+
+		for _, length := range []int{1, 15, 16, 17, 1024} {
+			t.Run(fmt.Sprintf("%d/%d", contentCipher, length), func(t *testing.T) {
+				protectedSettings := bytes.Repeat([]byte("s"), length)
+				encryptedBytes, err := certHandler.Encrypt(protectedSettings)
+				require.NoError(t, err, "encryption should succeed")
+				require.NotEqual(t, protectedSettings, encryptedBytes)
+
+				decrypted, err := decrypt.DecryptProtectedSettings(configFolder, thumbprint, encryptedBytes)
+				require.NoError(t, err, "decryption should succeed")
+				require.Equal(t, string(protectedSettings), decrypted)
+			})
+		}
+	}
+}
+
+func TestEncryptUnsupportedContentCipher(t *testing.T) {
+	certLocation, _ := newTestCertLocation(t)
+	certHandler, err := New(certLocation, WithContentCipher(ContentCipher(42)))
+	require.NoError(t, err)
+
+	_, err = certHandler.Encrypt([]byte("yaba"))
+	require.Error(t, err)
+}
+
+func TestEncryptReadsCertificateFromCertLocation(t *testing.T) {
+	certLocation, configFolder := newTestCertLocation(t)
+	certHandler, err := New(certLocation)
+	require.NoError(t, err)
+	original := certHandler.(*LinuxCertificateHandler)
+
+	// a handler built without the parsed certificate reads it from disk
+	certHandler = &LinuxCertificateHandler{CertOperations: original.CertOperations, certLocation: certLocation}
+	thumbprint, _ := certHandler.GetThumbprint()
+	encryptedBytes, err := certHandler.Encrypt([]byte("yaba"))
+	require.NoError(t, err)
+
+	decrypted, err := decrypt.DecryptProtectedSettings(configFolder, thumbprint, encryptedBytes)
+	require.NoError(t, err)
+	require.Equal(t, "yaba", decrypted)
+}
+
+func TestEncryptDecryptsWithOpenssl(t *testing.T) {
+	if _, err := exec.LookPath("openssl"); err != nil {
+		t.Skip("openssl is not installed")
+	}
+
+	for _, contentCipher := range allContentCiphers {
+		certLocation, _ := newTestCertLocation(t)
+		certHandler, err := New(certLocation, WithContentCipher(contentCipher))
+		require.NoError(t, err)
+		thumbprint, _ := certHandler.GetThumbprint()
+		encryptedBytes, err := certHandler.Encrypt([]byte("yaba"))
+		require.NoError(t, err)
+
+		cmd := exec.Command("openssl", "cms", "-decrypt", "-inform", "DER",
+			"-recip", filepath.Join(certLocation, thumbprint+".crt"),
+			"-inkey", filepath.Join(certLocation, thumbprint+".prv"))
+		cmd.Stdin = bytes.NewReader(encryptedBytes)
+		var stderr bytes.Buffer
+		cmd.Stderr = &stderr
+		decrypted, err := cmd.Output()
+		require.NoError(t, err, "openssl failed for content cipher %d: %s", contentCipher, stderr.String())
+		require.Equal(t, "yaba", string(decrypted))
+	}
+}
+
+func TestEncryptWithCertificateOptions(t *testing.T) {
+	for _, keyType := range []KeyType{RSA2048, RSA3072, RSA4096} {
+		certLocation, configFolder := newTestCertLocation(t)
+		certHandler, err := New(certLocation, WithCertificateOptions(CertificateOptions{KeyType: keyType}))
+		require.NoError(t, err)
+		thumbprint, _ := certHandler.GetThumbprint()
+
+		encryptedBytes, err := certHandler.Encrypt([]byte("yaba"))
+		require.NoError(t, err, "encryption should succeed for key type %d", keyType)
+		decrypted, err := decrypt.DecryptProtectedSettings(configFolder, thumbprint, encryptedBytes)
+		require.NoError(t, err, "decryption should succeed for key type %d", keyType)
+		require.Equal(t, "yaba", decrypted)
+	}
+}
+
+func TestNewRejectsECDSACertificate(t *testing.T) {
+	for _, keyType := range []KeyType{ECDSAP256, ECDSAP384} {
+		certLocation, _ := newTestCertLocation(t)
+		_, err := New(certLocation, WithCertificateOptions(CertificateOptions{KeyType: keyType}))
+		require.Error(t, err, "key type %d", keyType)
+	}
+}
@@ -102,7 +102,7 @@ func (cHandler *certHandler) Encrypt(bytesToEncrypt []byte) (encryptedBytes []by
 	return encryptedBytes, nil
 }
 
-func newCertHandler(certLocation string) (ICertHandler, error) {
+func newCertHandler(certLocation string, o options) (ICertHandler, error) {
 	handle, err := syscall.CertOpenStore(windows.CERT_STORE_PROV_SYSTEM, 0, 0, windows.CERT_SYSTEM_STORE_LOCAL_MACHINE, uintptr(unsafe.Pointer(syscall.StringToUTF16Ptr("MY"))))
 	defer syscall.CertCloseStore(handle, 0)
 
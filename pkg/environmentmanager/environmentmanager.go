@@ -17,3 +17,11 @@ type IGetVMExtensionEnvironmentManager interface {
 	GetHandlerSettings(el logging.ILogger, he *handlerenv.HandlerEnvironment) (*settings.HandlerSettings, error)
 	SetSequenceNumberInternal(extensionName, extensionVersion string, seqNo uint) error
 }
+
+// Optionally implemented by environment managers that support multiconfig extensions, where each
+// extension instance has its own {configName}.{seqNo}.settings file and sequence number
+type IGetVMExtensionMultiConfigEnvironmentManager interface {
+	FindSeqNumForConfig(el logging.ILogger, configFolder, configName string) (uint, error)
+	GetHandlerSettingsForConfig(el logging.ILogger, he *handlerenv.HandlerEnvironment, configName string) (*settings.HandlerSettings, error)
+	SetSequenceNumberForConfigInternal(extensionName, extensionVersion, configName string, seqNo uint) error
+}
@@ -2,7 +2,10 @@
 // Licensed under the MIT License.
 package exithelper
 
-import "os"
+import (
+	"os"
+	"sync"
+)
 
 const (
 	MiscError          = 1
@@ -20,7 +23,50 @@ type IExitHelper interface {
 type ExitHelper struct{}
 
 func (*ExitHelper) Exit(exitCode int) {
+	RunExitHandlers()
 	os.Exit(exitCode)
 }
 
 var Exiter IExitHelper = &ExitHelper{}
+
+type exitHandler struct {
+	f func()
+}
+
+var (
+	exitHandlersLock sync.Mutex
+	exitHandlers     []*exitHandler
+)
+
+// AddExitHandler registers a function that ExitHelper runs before the process exits, such as
+// writing buffered output. It returns a function that removes the handler.
+func AddExitHandler(f func()) (remove func()) {
+	h := &exitHandler{f: f}
+	exitHandlersLock.Lock()
+	defer exitHandlersLock.Unlock()
+	exitHandlers = append(exitHandlers, h)
+
+	return func() {
+		exitHandlersLock.Lock()
+		defer exitHandlersLock.Unlock()
+		for i, registered := range exitHandlers {
+			if registered == h {
+				exitHandlers = append(exitHandlers[:i], exitHandlers[i+1:]...)
+				return
+			}
+		}
+	}
+}
+
+// RunExitHandlers runs the registered exit handlers in the order they were added. Implementations
+// of IExitHelper other than ExitHelper should call it before exiting.
+func RunExitHandlers() {
+	exitHandlersLock.Lock()
+	handlers := make([]*exitHandler, len(exitHandlers))
+	copy(handlers, exitHandlers)
+	exitHandlersLock.Unlock()
+
+	for _, h := range handlers {
+		h.f()
+	}
+}
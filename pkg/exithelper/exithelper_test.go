@@ -0,0 +1,25 @@
+// Copyright (c) Microsoft Corporation.
+// Licensed under the MIT License.
+package exithelper
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func Test_exitHandlers(t *testing.T) {
+	var ran []string
+	removeFirst := AddExitHandler(func() { ran = append(ran, "first") })
+	removeSecond := AddExitHandler(func() { ran = append(ran, "second") })
+	defer removeSecond()
+
+	RunExitHandlers()
+	require.Equal(t, []string{"first", "second"}, ran)
+
+	ran = nil
+	removeFirst()
+	removeFirst()
+	RunExitHandlers()
+	require.Equal(t, []string{"second"}, ran)
+}
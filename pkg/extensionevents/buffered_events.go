@@ -0,0 +1,118 @@
+// Copyright (c) Microsoft Corporation.
+// Licensed under the MIT License.
+package extensionevents
+
+import (
+	"encoding/json"
+	"fmt"
+	"time"
+)
+
+const (
+	// DefaultBufferMaxEvents is the number of buffered events that are written together by default
+	DefaultBufferMaxEvents = 50
+
+	// DefaultBufferFlushInterval is how long events are buffered by default before they are written
+	DefaultBufferFlushInterval = 5 * time.Second
+)
+
+// BufferOptions controls how events are buffered before they are written
+type BufferOptions struct {
+	MaxEvents     int           // Events are written once this many are buffered. If zero, DefaultBufferMaxEvents is used.
+	FlushInterval time.Duration // Events are written at most this long after they are logged. If zero, DefaultBufferFlushInterval is used.
+}
+
+// eventBuffer holds events until they are written to a single file, which the Guest Agent reads as a list of events.
+// It is protected by the mutex of the ExtensionEventManager.
+type eventBuffer struct {
+	options BufferOptions
+	events  []extensionEvent
+	timer   *time.Timer
+}
+
+// EnableBuffering buffers events in memory and writes them together, which is cheaper for extensions that
+// log many events. Buffered events are written when MaxEvents are buffered, FlushInterval after the first
+// of them was logged, and on Flush. VMExtension.Do flushes the events of the extension when the operation
+// completes or panics, and ExitHelper flushes them before exiting when they are buffered through
+// InitializationInfo.EventBufferOptions. Other code that exits the process, or goroutines that may panic,
+// should call Flush first, or register it with exithelper.AddExitHandler.
+func (eem *ExtensionEventManager) EnableBuffering(options BufferOptions) {
+	if options.MaxEvents <= 0 {
+		options.MaxEvents = DefaultBufferMaxEvents
+	}
+	if options.FlushInterval <= 0 {
+		options.FlushInterval = DefaultBufferFlushInterval
+	}
+
+	eem.mu.Lock()
+	defer eem.mu.Unlock()
+	if eem.buffer != nil {
+		eem.buffer.options = options
+		return
+	}
+	eem.buffer = &eventBuffer{options: options}
+}
+
+// DisableBuffering writes the buffered events and goes back to writing each event as it is logged
+func (eem *ExtensionEventManager) DisableBuffering() {
+	eem.mu.Lock()
+	defer eem.mu.Unlock()
+	if eem.buffer == nil {
+		return
+	}
+	eem.flushLocked()
+	eem.buffer = nil
+}
+
+// Flush writes the buffered events. It does nothing if buffering isn't enabled.
+func (eem *ExtensionEventManager) Flush() {
+	eem.mu.Lock()
+	defer eem.mu.Unlock()
+	eem.flushLocked()
+}
+
+func (eem *ExtensionEventManager) flushLocked() {
+	b := eem.buffer
+	if b == nil || len(b.events) == 0 {
+		return
+	}
+	if b.timer != nil {
+		b.timer.Stop()
+		b.timer = nil
+	}
+
+	events := b.events
+	b.events = nil
+	eem.writeEventsLocked(events)
+}
+
+// writeEventsLocked writes the events to a single file. Events that would exceed the size limit of the
+// pending events together are split in half, so as many of them are written as fit.
+func (eem *ExtensionEventManager) writeEventsLocked(events []extensionEvent) {
+	data, err := json.Marshal(events)
+	if err != nil {
+		eem.extensionLogger.Error("Unable to serialize extension events: <%v>", err)
+		return
+	}
+	if len(events) > 1 {
+		if _, tooLarge := eem.checkPendingEventsLocked(int64(len(data))); tooLarge {
+			half := len(events) / 2
+			eem.writeEventsLocked(events[:half])
+			eem.writeEventsLocked(events[half:])
+			return
+		}
+	}
+	eem.writeEventFileLocked(data, fmt.Sprintf("%d buffered events", len(events)))
+}
+
+// add buffers the event, writing the buffer if it is full. The manager must be locked.
+func (b *eventBuffer) add(eem *ExtensionEventManager, e extensionEvent) {
+	b.events = append(b.events, e)
+	if len(b.events) >= b.options.MaxEvents {
+		eem.flushLocked()
+		return
+	}
+	if b.timer == nil {
+		b.timer = time.AfterFunc(b.options.FlushInterval, eem.Flush)
+	}
+}
@@ -0,0 +1,109 @@
+// Copyright (c) Microsoft Corporation.
+// Licensed under the MIT License.
+package extensionevents
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/Azure/azure-extension-platform/pkg/exithelper"
+	"github.com/Azure/azure-extension-platform/pkg/logging"
+	"github.com/stretchr/testify/require"
+)
+
+// readEventBatches returns the events in each file, in the order the files were written
+func readEventBatches(t *testing.T) [][]extensionEvent {
+	dir, _ := ioutil.ReadDir(eventstestdir)
+	var batches [][]extensionEvent
+	for _, f := range dir {
+		// an event being written is renamed from a temporary file
+		if filepath.Ext(f.Name()) != eventFileExtension {
+			continue
+		}
+		b, err := os.ReadFile(path.Join(eventstestdir, f.Name()))
+		require.NoError(t, err)
+		var batch []extensionEvent
+		require.NoError(t, json.Unmarshal(b, &batch), "buffered events are written as a list")
+		batches = append(batches, batch)
+	}
+	return batches
+}
+
+func Test_bufferedEventsFlushWhenFull(t *testing.T) {
+	eem := New(logging.New(nil), getHandlerEnvironment(eventstestdir))
+	defer clearEventTestDir()
+	eem.EnableBuffering(BufferOptions{MaxEvents: 3, FlushInterval: time.Hour})
+	defer eem.DisableBuffering()
+
+	for _, message := range []string{"1", "2", "3", "4", "5", "6", "7"} {
+		eem.LogInformationalEvent("buffered", message)
+	}
+	batches := readEventBatches(t)
+	require.Len(t, batches, 2)
+	require.Len(t, batches[0], 3)
+	require.Equal(t, "1", batches[0][0].Message)
+	require.Equal(t, "6", batches[1][2].Message)
+
+	eem.Flush()
+	batches = readEventBatches(t)
+	require.Len(t, batches, 3)
+	require.Equal(t, "7", batches[2][0].Message)
+
+	eem.Flush()
+	require.Len(t, readEventBatches(t), 3, "an empty buffer isn't written")
+}
+
+func Test_bufferedEventsFlushAfterInterval(t *testing.T) {
+	eem := New(logging.New(nil), getHandlerEnvironment(eventstestdir))
+	defer clearEventTestDir()
+	eem.EnableBuffering(BufferOptions{FlushInterval: 50 * time.Millisecond})
+	defer eem.DisableBuffering()
+
+	eem.LogWarningEvent("buffered", "soon")
+	require.Empty(t, readEventBatches(t))
+	require.Eventually(t, func() bool { return len(readEventBatches(t)) == 1 }, 5*time.Second, 10*time.Millisecond)
+}
+
+func Test_bufferedEventsDisableBuffering(t *testing.T) {
+	eem := New(logging.New(nil), getHandlerEnvironment(eventstestdir))
+	defer clearEventTestDir()
+	eem.EnableBuffering(BufferOptions{FlushInterval: time.Hour})
+
+	// no exit handler is registered for the buffer, which Do flushes before exiting
+	eem.LogErrorEvent("buffered", "exiting")
+	exithelper.RunExitHandlers()
+	require.Empty(t, readEventBatches(t))
+
+	// disabling buffering writes the buffer and goes back to a file per event
+	eem.DisableBuffering()
+	require.Len(t, readEventBatches(t), 1)
+	eem.LogErrorEvent("unbuffered", "written now")
+	dir, _ := ioutil.ReadDir(eventstestdir)
+	require.Equal(t, 2, len(dir))
+}
+
+func Test_bufferedEventsAreSplitToFitPendingSize(t *testing.T) {
+	eem := New(logging.New(nil), getHandlerEnvironment(eventstestdir))
+	defer clearEventTestDir()
+	eem.EnableBuffering(BufferOptions{MaxEvents: 8, FlushInterval: time.Hour})
+	defer eem.DisableBuffering()
+
+	// each event is about 300 bytes, so the batch of 8 doesn't fit but 4 of them do
+	eem.SetPendingEventLimits(0, 1500)
+	for i := 0; i < 8; i++ {
+		eem.LogInformationalEvent("buffered", strings.Repeat("x", 100))
+	}
+
+	written := 0
+	for _, batch := range readEventBatches(t) {
+		written += len(batch)
+	}
+	require.GreaterOrEqual(t, written, 4, "the events that fit are written")
+	require.Less(t, written, 8)
+}
@@ -5,14 +5,16 @@ package extensionevents
 import (
 	"encoding/json"
 	"fmt"
-	"io/ioutil"
 	"os"
-	"path"
-	"strconv"
+	"path/filepath"
+	"strings"
+	"sync"
 	"time"
+	"unicode/utf8"
 
 	"github.com/Azure/azure-extension-platform/pkg/handlerenv"
 	"github.com/Azure/azure-extension-platform/pkg/logging"
+	"github.com/Azure/azure-extension-platform/pkg/redact"
 )
 
 const (
@@ -23,6 +25,26 @@ const (
 	eventLevelInformational = "Informational"
 )
 
+const (
+	// MaxEventMessageLength is the longest message the Guest Agent accepts; longer messages are truncated
+	MaxEventMessageLength = 3 * 1024
+
+	// DefaultMaxPendingEvents is the number of event files the Guest Agent collects from an extension per
+	// period. Events beyond it are dropped instead of piling up in the events folder.
+	DefaultMaxPendingEvents = 360
+
+	// DefaultMaxPendingEventsSize is the total size in bytes of the event files waiting for the Guest Agent
+	// above which events are dropped
+	DefaultMaxPendingEventsSize = 4 * 1024 * 1024
+
+	// pendingEventsRefreshInterval is how long the count and size of the pending events are cached
+	// before the events folder is read again. It is read for every event once they are near a limit.
+	pendingEventsRefreshInterval = 10 * time.Second
+
+	eventFileExtension     = ".json"
+	eventTempFileExtension = ".tmp" // the Guest Agent only reads .json files, so it never sees partial events
+)
+
 type extensionEvent struct {
 	Version     string `json:"Version"`
 	Timestamp   string `json:"Timestamp"`
@@ -36,14 +58,30 @@ type extensionEvent struct {
 
 // ExtensionEventManager allows extensions to log events that will be collected
 // by the Guest Agent
+// It is safe for concurrent use.
 type ExtensionEventManager struct {
-	extensionLogger logging.ILogger
-	eventsFolder    string
-	operationID     string
-	prefix          string
+	mu                   sync.Mutex
+	extensionLogger      logging.ILogger
+	eventsFolder         string
+	operationID          string
+	prefix               string
+	maxPendingEvents     int
+	maxPendingEventsSize int64
+	eventCount           uint64                 // events written by this process, which makes file names unique
+	pendingCount         int                    // event files waiting for the Guest Agent when the folder was last read, plus those written since
+	pendingSize          int64                  // total size of those event files
+	pendingReadTime      time.Time              // when the events folder was last read, zero to read it for the next event
+	contextFields        map[string]interface{} // added to every structured event
+	buffer               *eventBuffer           // set in buffered mode
 }
 
 func (eem *ExtensionEventManager) logEvent(taskName string, eventLevel string, message string) {
+	eem.writeEvent(taskName, eventLevel, message, true)
+}
+
+// writeEvent writes an event. A plain message gets the prefix set by SetPrefix, and is redacted and
+// truncated; otherwise the message must already be redacted and fit in MaxEventMessageLength.
+func (eem *ExtensionEventManager) writeEvent(taskName string, eventLevel string, message string, plain bool) {
 	if eem.eventsFolder == "" {
 		eem.extensionLogger.Warn("EventsFolder not set. Not writing event.")
 		return
@@ -54,59 +92,184 @@ func (eem *ExtensionEventManager) logEvent(taskName string, eventLevel string, m
 	pid := fmt.Sprintf("%v", os.Getpid())
 	tid := getThreadID()
 
-	fullMessage := message
-	if eem.prefix != "" {
-		fullMessage = eem.prefix + message
-	}
+	eem.mu.Lock()
+	defer eem.mu.Unlock()
 
+	if plain {
+		message = truncateMessage(redact.Redact(eem.prefix + message))
+	}
 	extensionEvent := extensionEvent{
 		Version:     extensionVersion,
 		Timestamp:   timestamp,
 		TaskName:    taskName,
 		EventLevel:  eventLevel,
-		Message:     fullMessage,
+		Message:     message,
 		EventPid:    pid,
 		EventTid:    tid,
 		OperationID: eem.operationID,
 	}
 
-	// File name is the unix time in milliseconds
-	fileName := strconv.FormatInt(time.Now().UTC().UnixNano()/1000, 10)
-	filePath := path.Join(eem.eventsFolder, fileName) + ".json"
+	if eem.buffer != nil {
+		eem.buffer.add(eem, extensionEvent)
+		return
+	}
 
 	b, err := json.Marshal(extensionEvent)
 	if err != nil {
 		eem.extensionLogger.Error("Unable to serialize extension event: <%v>", err)
 		return
 	}
+	eem.writeEventFileLocked(b, fmt.Sprintf("event for task '%s'", taskName))
+}
 
-	err = ioutil.WriteFile(filePath, b, 0644)
-	if err != nil {
+// writeEventFileLocked writes an event file holding one event, or a list of events
+func (eem *ExtensionEventManager) writeEventFileLocked(b []byte, description string) {
+	if reason, _ := eem.checkPendingEventsLocked(int64(len(b))); reason != "" {
+		eem.extensionLogger.Warn("Not writing %s: %s", description, reason)
+		return
+	}
+
+	// File name is the unix time in nanoseconds, so events sort in the order they were written, followed
+	// by the pid and a count which keep names unique across goroutines and processes
+	eem.eventCount++
+	fileName := fmt.Sprintf("%d_%d_%d%s", time.Now().UTC().UnixNano(), os.Getpid(), eem.eventCount, eventFileExtension)
+	if err := writeFileAtomically(eem.eventsFolder, fileName, b); err != nil {
 		eem.extensionLogger.Error("Unable to write event file: <%v>", err)
+		return
 	}
+	eem.pendingCount++
+	eem.pendingSize += int64(len(b))
+}
+
+// checkPendingEventsLocked returns why an event of the given size can't be written, or an empty
+// string if it can, and whether it is because of its size. Event files the Guest Agent hasn't
+// collected yet count towards the limits. Their count and size are cached, and read again once the
+// cache is stale or close to a limit, since the Guest Agent collects events and other processes
+// write them.
+func (eem *ExtensionEventManager) checkPendingEventsLocked(eventSize int64) (reason string, tooLarge bool) {
+	if eem.maxPendingEvents <= 0 && eem.maxPendingEventsSize <= 0 {
+		return "", false
+	}
+
+	if eem.pendingReadTime.IsZero() || time.Since(eem.pendingReadTime) >= pendingEventsRefreshInterval || eem.nearPendingLimitLocked(eventSize) {
+		if err := eem.readPendingEventsLocked(); err != nil {
+			// writing the event will fail and report the error
+			return "", false
+		}
+	}
+	count, size := eem.pendingCount, eem.pendingSize+eventSize
+
+	if eem.maxPendingEvents > 0 && count >= eem.maxPendingEvents {
+		return fmt.Sprintf("%d events are waiting for the Guest Agent", count), false
+	}
+	if eem.maxPendingEventsSize > 0 && size > eem.maxPendingEventsSize {
+		return fmt.Sprintf("the events waiting for the Guest Agent would exceed %d bytes", eem.maxPendingEventsSize), true
+	}
+	return "", false
+}
+
+// nearPendingLimitLocked returns true if the cached count or size of the pending events, with an
+// event of the given size, is within a tenth of a limit
+func (eem *ExtensionEventManager) nearPendingLimitLocked(eventSize int64) bool {
+	if eem.maxPendingEvents > 0 && 10*(eem.pendingCount+1) >= 9*eem.maxPendingEvents {
+		return true
+	}
+	return eem.maxPendingEventsSize > 0 && 10*(eem.pendingSize+eventSize) >= 9*eem.maxPendingEventsSize
+}
+
+// readPendingEventsLocked counts the event files in the events folder and their size
+func (eem *ExtensionEventManager) readPendingEventsLocked() error {
+	entries, err := os.ReadDir(eem.eventsFolder)
+	if err != nil {
+		return err
+	}
+	count, size := 0, int64(0)
+	for _, entry := range entries {
+		if entry.IsDir() || !strings.HasSuffix(entry.Name(), eventFileExtension) {
+			continue
+		}
+		count++
+		if info, err := entry.Info(); err == nil {
+			size += info.Size()
+		}
+	}
+	eem.pendingCount, eem.pendingSize, eem.pendingReadTime = count, size, time.Now()
+	return nil
+}
+
+// writeFileAtomically writes to a temporary file and renames it, so readers see the whole file or nothing
+func writeFileAtomically(folder string, fileName string, b []byte) error {
+	tmpFile, err := os.CreateTemp(folder, fileName+".*"+eventTempFileExtension)
+	if err != nil {
+		return err
+	}
+	tmpName := tmpFile.Name()
+
+	_, err = tmpFile.Write(b)
+	if closeErr := tmpFile.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Chmod(tmpName, 0644)
+	}
+	if err == nil {
+		err = os.Rename(tmpName, filepath.Join(folder, fileName))
+	}
+	if err != nil {
+		os.Remove(tmpName)
+	}
+	return err
+}
+
+// truncateMessage shortens the message to MaxEventMessageLength bytes without splitting a character
+func truncateMessage(message string) string {
+	if len(message) <= MaxEventMessageLength {
+		return message
+	}
+	end := MaxEventMessageLength
+	for end > 0 && !utf8.RuneStart(message[end]) {
+		end--
+	}
+	return message[:end]
 }
 
 // New creates a new instance of the ExtensionEventManager
 func New(el logging.ILogger, he *handlerenv.HandlerEnvironment) *ExtensionEventManager {
 	eem := &ExtensionEventManager{
-		extensionLogger: el,
-		eventsFolder:    he.EventsFolder,
-		operationID:     "",
+		extensionLogger:      el,
+		eventsFolder:         he.EventsFolder,
+		operationID:          "",
+		maxPendingEvents:     DefaultMaxPendingEvents,
+		maxPendingEventsSize: DefaultMaxPendingEventsSize,
 	}
 
 	return eem
 }
 
+// SetPendingEventLimits sets how many event files, and how many bytes of them, may wait for the Guest
+// Agent to collect them. New events are dropped beyond either limit. Zero removes a limit.
+func (eem *ExtensionEventManager) SetPendingEventLimits(maxEvents int, maxSizeInBytes int64) {
+	eem.mu.Lock()
+	defer eem.mu.Unlock()
+	eem.maxPendingEvents = maxEvents
+	eem.maxPendingEventsSize = maxSizeInBytes
+	eem.pendingReadTime = time.Time{}
+}
+
 // "SetOperationId()" sets operation Id passed by user while logging extension events
 // This is made as separate function (not included in "logEvent()") to enable users to set Operation ID globally for their extension.
 // "operationID" corresponds to "Context3" column in 'GuestAgentGenericLogs' table (Rdos cluster)
 func (eem *ExtensionEventManager) SetOperationID(operationID string) {
+	eem.mu.Lock()
+	defer eem.mu.Unlock()
 	eem.operationID = operationID
 }
 
 // "SetPrefix()" sets a prefix to use for all messages
 // The prefix will continue to be used until "SetPrefix()" is called with an empty string
 func (eem *ExtensionEventManager) SetPrefix(prefix string) {
+	eem.mu.Lock()
+	defer eem.mu.Unlock()
 	eem.prefix = prefix
 }
 
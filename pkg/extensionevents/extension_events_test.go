@@ -8,12 +8,16 @@ import (
 	"os"
 	"path"
 	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
 	"testing"
 	"time"
 
 	"github.com/Azure/azure-extension-platform/pkg/constants"
 	"github.com/Azure/azure-extension-platform/pkg/handlerenv"
 	"github.com/Azure/azure-extension-platform/pkg/logging"
+	"github.com/Azure/azure-extension-platform/pkg/redact"
 	"github.com/stretchr/testify/require"
 )
 
@@ -101,6 +105,126 @@ func Test_NoTaskName(t *testing.T) {
 	verifyEventFile(t, dir[0].Name(), "Critical", "critical message")
 }
 
+func Test_secretsAreRedacted(t *testing.T) {
+	el := logging.New(nil)
+	he := getHandlerEnvironment(eventstestdir)
+	eem := New(el, he)
+	defer clearEventTestDir()
+	redact.AddValue("chipmunkkey")
+	defer redact.Default().Clear()
+
+	eem.LogErrorEvent("download", "could not use key chipmunkkey")
+
+	dir, _ := ioutil.ReadDir(eventstestdir)
+	require.Equal(t, 1, len(dir))
+	verifyEventFile(t, dir[0].Name(), "Error", "could not use key [REDACTED]")
+}
+
+func Test_concurrentEventsDontCollide(t *testing.T) {
+	el := logging.New(nil)
+	he := getHandlerEnvironment(eventstestdir)
+	eem := New(el, he)
+	defer clearEventTestDir()
+
+	var wg sync.WaitGroup
+	for i := 0; i < 50; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			eem.SetPrefix("(chipmunk) ")
+			eem.SetOperationID("op")
+			eem.LogInformationalEvent("concurrent", "message")
+		}()
+	}
+	wg.Wait()
+
+	dir, _ := ioutil.ReadDir(eventstestdir)
+	require.Equal(t, 50, len(dir), "every event has its own file and no temporary files are left")
+	for _, f := range dir {
+		verifyEventFile(t, f.Name(), "Informational", "(chipmunk) message")
+	}
+}
+
+func Test_pendingEventLimits(t *testing.T) {
+	el := logging.New(nil)
+	he := getHandlerEnvironment(eventstestdir)
+	eem := New(el, he)
+	defer clearEventTestDir()
+
+	eem.SetPendingEventLimits(3, 0)
+	for i := 0; i < 5; i++ {
+		eem.LogInformationalEvent("limited", "message")
+	}
+	dir, _ := ioutil.ReadDir(eventstestdir)
+	require.Equal(t, 3, len(dir), "events beyond the count are dropped")
+
+	// once the Guest Agent collects the events, new ones are written again
+	clearEventTestDir()
+	eem.SetPendingEventLimits(0, 600)
+	for i := 0; i < 5; i++ {
+		eem.LogInformationalEvent("limited", strings.Repeat("x", 100))
+	}
+	dir, _ = ioutil.ReadDir(eventstestdir)
+	require.Equal(t, 2, len(dir), "events beyond the size are dropped")
+
+	clearEventTestDir()
+	eem.SetPendingEventLimits(0, 0)
+	for i := 0; i < 5; i++ {
+		eem.LogInformationalEvent("unlimited", "message")
+	}
+	dir, _ = ioutil.ReadDir(eventstestdir)
+	require.Equal(t, 5, len(dir))
+}
+
+func Test_pendingEventsAreCached(t *testing.T) {
+	el := logging.New(nil)
+	he := getHandlerEnvironment(eventstestdir)
+	eem := New(el, he)
+	defer clearEventTestDir()
+
+	eem.SetPendingEventLimits(100, 0)
+	eem.LogInformationalEvent("cached", "message")
+	require.Equal(t, 1, eem.pendingCount)
+
+	// events written by another process aren't counted until the folder is read again
+	writeOtherEvents := func(from, to int) {
+		for i := from; i < to; i++ {
+			require.NoError(t, ioutil.WriteFile(filepath.Join(eventstestdir, "other_"+strconv.Itoa(i)+".json"), []byte("{}"), 0644))
+		}
+	}
+	writeOtherEvents(0, 50)
+	eem.LogInformationalEvent("cached", "message")
+	require.Equal(t, 2, eem.pendingCount)
+
+	eem.pendingReadTime = time.Now().Add(-pendingEventsRefreshInterval)
+	eem.LogInformationalEvent("cached", "message")
+	require.Equal(t, 53, eem.pendingCount)
+
+	// close to the limit, the folder is read for every event
+	writeOtherEvents(50, 87)
+	eem.pendingReadTime = time.Now().Add(-pendingEventsRefreshInterval)
+	eem.LogInformationalEvent("cached", "message")
+	require.Equal(t, 91, eem.pendingCount)
+	writeOtherEvents(87, 95)
+	eem.LogInformationalEvent("cached", "message")
+	eem.LogInformationalEvent("cached", "message")
+	dir, _ := ioutil.ReadDir(eventstestdir)
+	require.Equal(t, 100, len(dir), "the events beyond the limit are dropped")
+}
+
+func Test_longMessagesAreTruncated(t *testing.T) {
+	el := logging.New(nil)
+	he := getHandlerEnvironment(eventstestdir)
+	eem := New(el, he)
+	defer clearEventTestDir()
+
+	eem.LogInformationalEvent("long", strings.Repeat("x", MaxEventMessageLength-1)+"é and more")
+
+	dir, _ := ioutil.ReadDir(eventstestdir)
+	require.Equal(t, 1, len(dir))
+	verifyEventFile(t, dir[0].Name(), "Informational", strings.Repeat("x", MaxEventMessageLength-1))
+}
+
 func verifyEventFile(t *testing.T, fileName string, expectedLevel string, expectedMessage string) {
 	require.Equal(t, ".json", filepath.Ext(fileName))
 	openedFile, err := os.Open(path.Join(eventstestdir, fileName))
@@ -0,0 +1,244 @@
+// Copyright (c) Microsoft Corporation.
+// Licensed under the MIT License.
+package extensionevents
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"math"
+	"strings"
+	"sync"
+	"time"
+	"unicode/utf8"
+
+	"github.com/Azure/azure-extension-platform/pkg/redact"
+)
+
+// EventLevel is the level of an event as reported to the Guest Agent
+type EventLevel string
+
+const (
+	EventLevelCritical      EventLevel = eventLevelCritical
+	EventLevelError         EventLevel = eventLevelError
+	EventLevelWarning       EventLevel = eventLevelWarning
+	EventLevelVerbose       EventLevel = eventLevelVerbose
+	EventLevelInformational EventLevel = eventLevelInformational
+)
+
+const (
+	// EventFieldMessage is the field holding the message of a structured event
+	EventFieldMessage = "message"
+
+	// EventFieldDurationMs is the field holding the duration measured by an EventTimer
+	EventFieldDurationMs = "durationMs"
+
+	// EventFieldError is the field holding the error passed to EventTimer.StopWithError
+	EventFieldError = "error"
+)
+
+// EventFields are the dimensions of a structured event. The Guest Agent only collects the message of an
+// event, so the fields are written to the message as a JSON object with its keys sorted, which queries can
+// parse. Strings, booleans and numbers are written as they are, time.Duration as a number of milliseconds,
+// time.Time in RFC 3339 format, and errors and other values as their string representation.
+type EventFields map[string]interface{}
+
+// truncatedFieldSuffix ends string fields shortened to fit a structured event in MaxEventMessageLength
+const truncatedFieldSuffix = "..."
+
+// LogEvent writes a structured event. The message is written under EventFieldMessage, and the fields set
+// with SetContextField are added to the fields of the event, which take precedence. The prefix set by
+// SetPrefix isn't added. If the event doesn't fit in MaxEventMessageLength, its longest strings are
+// shortened, ending with "...", and then its largest fields are dropped until it does.
+func (eem *ExtensionEventManager) LogEvent(level EventLevel, taskName string, message string, fields EventFields) {
+	eem.mu.Lock()
+	payload := make(map[string]interface{}, len(eem.contextFields)+len(fields)+1)
+	for k, v := range eem.contextFields {
+		payload[k] = v
+	}
+	eem.mu.Unlock()
+
+	for k, v := range fields {
+		payload[k] = normalizeFieldValue(v)
+	}
+	if message != "" {
+		payload[EventFieldMessage] = message
+	}
+
+	for k, v := range payload {
+		if s, ok := v.(string); ok {
+			payload[k] = redact.Redact(s)
+		}
+	}
+
+	encoded, err := encodeEventPayload(payload)
+	if err != nil {
+		eem.extensionLogger.Error("Unable to serialize extension event fields: <%v>", err)
+		return
+	}
+	eem.writeEvent(taskName, string(level), encoded, false)
+}
+
+// encodeEventPayload encodes the payload, shortening its longest strings and then dropping its largest
+// fields until it fits in MaxEventMessageLength
+func encodeEventPayload(payload map[string]interface{}) (string, error) {
+	for {
+		encoded, err := encodePayload(payload)
+		if err != nil || len(encoded) <= MaxEventMessageLength {
+			return encoded, err
+		}
+		excess := len(encoded) - MaxEventMessageLength
+
+		longestKey, longest := "", ""
+		for k, v := range payload {
+			if s, ok := v.(string); ok && len(s) > len(truncatedFieldSuffix) &&
+				(len(s) > len(longest) || (len(s) == len(longest) && k < longestKey)) {
+				longestKey, longest = k, s
+			}
+		}
+		if longestKey != "" {
+			// every byte removed shortens the encoded string by at least a byte
+			keep := len(longest) - len(truncatedFieldSuffix) - excess
+			if keep < 0 {
+				keep = 0
+			}
+			for keep > 0 && !utf8.RuneStart(longest[keep]) {
+				keep--
+			}
+			payload[longestKey] = longest[:keep] + truncatedFieldSuffix
+			continue
+		}
+
+		largestKey, largestSize := "", -1
+		for k, v := range payload {
+			field, _ := encodePayload(map[string]interface{}{k: v})
+			if len(field) > largestSize || (len(field) == largestSize && k < largestKey) {
+				largestKey, largestSize = k, len(field)
+			}
+		}
+		delete(payload, largestKey)
+	}
+}
+
+// encodePayload encodes the fields of a structured event as a JSON object
+func encodePayload(payload map[string]interface{}) (string, error) {
+	// the payload only holds JSON compatible values, and maps are encoded with their keys sorted
+	var b bytes.Buffer
+	encoder := json.NewEncoder(&b)
+	encoder.SetEscapeHTML(false)
+	if err := encoder.Encode(payload); err != nil {
+		return "", err
+	}
+	return strings.TrimSuffix(b.String(), "\n"), nil
+}
+
+// SetContextField adds a field to every structured event written by LogEvent and event timers, such as
+// the sequence number or a correlation id. A nil value removes the field.
+func (eem *ExtensionEventManager) SetContextField(key string, value interface{}) {
+	eem.mu.Lock()
+	defer eem.mu.Unlock()
+	if value == nil {
+		delete(eem.contextFields, key)
+		return
+	}
+	if eem.contextFields == nil {
+		eem.contextFields = map[string]interface{}{}
+	}
+	eem.contextFields[key] = normalizeFieldValue(value)
+}
+
+// normalizeFieldValue converts a field value to one that serializes deterministically to JSON
+func normalizeFieldValue(v interface{}) interface{} {
+	switch t := v.(type) {
+	case nil, string, bool,
+		int, int8, int16, int32, int64,
+		uint, uint8, uint16, uint32, uint64:
+		return t
+	case float32:
+		return normalizeFloat(float64(t))
+	case float64:
+		return normalizeFloat(t)
+	case time.Duration:
+		return t.Milliseconds()
+	case time.Time:
+		return t.UTC().Format(time.RFC3339Nano)
+	case error:
+		return t.Error()
+	case fmt.Stringer:
+		return t.String()
+	default:
+		return fmt.Sprint(t)
+	}
+}
+
+// normalizeFloat writes values JSON can't represent as strings
+func normalizeFloat(f float64) interface{} {
+	if math.IsNaN(f) || math.IsInf(f, 0) {
+		return fmt.Sprint(f)
+	}
+	return f
+}
+
+// EventTimer measures the duration of an operation and writes it as a structured event when stopped
+type EventTimer struct {
+	eem      *ExtensionEventManager
+	taskName string
+	fields   EventFields
+	start    time.Time
+
+	mu      sync.Mutex
+	stopped bool
+}
+
+// StartTimer starts measuring an operation. The fields are added to the event written when it is stopped.
+func (eem *ExtensionEventManager) StartTimer(taskName string, fields EventFields) *EventTimer {
+	copied := make(EventFields, len(fields))
+	for k, v := range fields {
+		copied[k] = v
+	}
+	return &EventTimer{
+		eem:      eem,
+		taskName: taskName,
+		fields:   copied,
+		start:    time.Now(),
+	}
+}
+
+// Elapsed returns the time since the timer was started
+func (t *EventTimer) Elapsed() time.Duration {
+	return time.Since(t.start)
+}
+
+// Stop writes an event with the duration in EventFieldDurationMs and returns the duration. The fields
+// are added to those passed to StartTimer. Only the first call to Stop or StopWithError writes an event.
+func (t *EventTimer) Stop(level EventLevel, message string, fields EventFields) time.Duration {
+	duration := t.Elapsed()
+
+	t.mu.Lock()
+	alreadyStopped := t.stopped
+	t.stopped = true
+	t.mu.Unlock()
+	if alreadyStopped {
+		return duration
+	}
+
+	all := make(EventFields, len(t.fields)+len(fields)+1)
+	for k, v := range t.fields {
+		all[k] = v
+	}
+	for k, v := range fields {
+		all[k] = v
+	}
+	all[EventFieldDurationMs] = duration
+	t.eem.LogEvent(level, t.taskName, message, all)
+	return duration
+}
+
+// StopWithError stops the timer with an informational event if err is nil, or an error event with the
+// error in EventFieldError otherwise
+func (t *EventTimer) StopWithError(message string, err error) time.Duration {
+	if err == nil {
+		return t.Stop(EventLevelInformational, message, nil)
+	}
+	return t.Stop(EventLevelError, message, EventFields{EventFieldError: err})
+}
@@ -0,0 +1,125 @@
+// Copyright (c) Microsoft Corporation.
+// Licensed under the MIT License.
+package extensionevents
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"math"
+	"os"
+	"path"
+	"strings"
+	"testing"
+	"time"
+	"unicode/utf8"
+
+	"github.com/Azure/azure-extension-platform/pkg/logging"
+	"github.com/stretchr/testify/require"
+)
+
+func readOnlyEvent(t *testing.T) extensionEvent {
+	dir, _ := ioutil.ReadDir(eventstestdir)
+	require.Equal(t, 1, len(dir))
+	b, err := os.ReadFile(path.Join(eventstestdir, dir[0].Name()))
+	require.NoError(t, err)
+	var ee extensionEvent
+	require.NoError(t, json.Unmarshal(b, &ee))
+	return ee
+}
+
+func Test_logEventFieldsAreSorted(t *testing.T) {
+	eem := New(logging.New(nil), getHandlerEnvironment(eventstestdir))
+	defer clearEventTestDir()
+
+	eem.LogEvent(EventLevelWarning, "download", "retrying", EventFields{
+		"url":      "https://yaba/?a=1&b=2",
+		"attempt":  2,
+		"elapsed":  1500 * time.Millisecond,
+		"at":       time.Date(2021, 3, 4, 5, 6, 7, 0, time.UTC),
+		"cause":    errors.New("timeout"),
+		"ratio":    math.Inf(1),
+		"complete": false,
+	})
+
+	ee := readOnlyEvent(t)
+	require.Equal(t, "Warning", ee.EventLevel)
+	require.Equal(t, "download", ee.TaskName)
+	require.Equal(t,
+		`{"at":"2021-03-04T05:06:07Z","attempt":2,"cause":"timeout","complete":false,"elapsed":1500,"message":"retrying","ratio":"+Inf","url":"https://yaba/?a=1&b=2"}`,
+		ee.Message)
+}
+
+func Test_logEventContextFields(t *testing.T) {
+	eem := New(logging.New(nil), getHandlerEnvironment(eventstestdir))
+	defer clearEventTestDir()
+
+	eem.SetContextField("seqNo", 3)
+	eem.SetContextField("region", "westus")
+	eem.SetContextField("removed", "yes")
+	eem.SetContextField("removed", nil)
+	eem.LogEvent(EventLevelInformational, "enable", "", EventFields{"region": "eastus"})
+
+	ee := readOnlyEvent(t)
+	require.Equal(t, `{"region":"eastus","seqNo":3}`, ee.Message, "event fields override context fields")
+
+	// plain events are unchanged
+	clearEventTestDir()
+	eem.LogInformationalEvent("enable", "plain")
+	require.Equal(t, "plain", readOnlyEvent(t).Message)
+}
+
+func Test_logEventFitsWithPrefix(t *testing.T) {
+	eem := New(logging.New(nil), getHandlerEnvironment(eventstestdir))
+	defer clearEventTestDir()
+
+	eem.SetPrefix("(chipmunk) ")
+	eem.LogEvent(EventLevelError, "download", "failed", EventFields{
+		"output":  strings.Repeat("é", MaxEventMessageLength),
+		"attempt": 2,
+	})
+
+	ee := readOnlyEvent(t)
+	require.LessOrEqual(t, len(ee.Message), MaxEventMessageLength)
+	var fields map[string]interface{}
+	require.NoError(t, json.Unmarshal([]byte(ee.Message), &fields), "the prefix isn't added and the JSON isn't cut: %s", ee.Message)
+	require.Equal(t, "failed", fields[EventFieldMessage])
+	require.Equal(t, float64(2), fields["attempt"])
+	output := fields["output"].(string)
+	require.True(t, strings.HasSuffix(output, "..."))
+	require.True(t, utf8.ValidString(output))
+	require.Greater(t, len(output), MaxEventMessageLength/2, "only as much as needed is cut")
+
+	// fields that can't be shortened are dropped
+	clearEventTestDir()
+	many := EventFields{}
+	for i := 0; i < 500; i++ {
+		many[fmt.Sprintf("field%03d", i)] = i
+	}
+	eem.LogEvent(EventLevelInformational, "many", "", many)
+	ee = readOnlyEvent(t)
+	require.LessOrEqual(t, len(ee.Message), MaxEventMessageLength)
+	require.NoError(t, json.Unmarshal([]byte(ee.Message), &fields))
+	require.NotEmpty(t, fields)
+}
+
+func Test_eventTimer(t *testing.T) {
+	eem := New(logging.New(nil), getHandlerEnvironment(eventstestdir))
+	defer clearEventTestDir()
+
+	timer := eem.StartTimer("install", EventFields{"package": "yaba"})
+	time.Sleep(20 * time.Millisecond)
+	duration := timer.StopWithError("install failed", errors.New("disk full"))
+	require.GreaterOrEqual(t, duration, 20*time.Millisecond)
+	timer.Stop(EventLevelInformational, "ignored", nil)
+
+	ee := readOnlyEvent(t)
+	require.Equal(t, "Error", ee.EventLevel)
+	var fields map[string]interface{}
+	require.NoError(t, json.Unmarshal([]byte(ee.Message), &fields))
+	require.Equal(t, "yaba", fields["package"])
+	require.Equal(t, "disk full", fields[EventFieldError])
+	require.Equal(t, "install failed", fields[EventFieldMessage])
+	require.GreaterOrEqual(t, fields[EventFieldDurationMs], float64(20))
+}
@@ -0,0 +1,261 @@
+// Copyright (c) Microsoft Corporation.
+// Licensed under the MIT License.
+package heartbeat
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/Azure/azure-extension-platform/pkg/extensionerrors"
+	"github.com/Azure/azure-extension-platform/pkg/logging"
+	"github.com/Azure/azure-extension-platform/pkg/status"
+)
+
+// DefaultInterval is used when a HeartbeatWriter is created without an interval
+const DefaultInterval = 30 * time.Second
+
+// stoppedMarkerSuffix is appended to the name of the heartbeat file for the marker written by MarkStopped
+const stoppedMarkerSuffix = ".stopped"
+
+type HeartbeatStatus string
+
+const (
+	// StatusReady indicates the extension is running and healthy
+	StatusReady HeartbeatStatus = "ready"
+
+	// StatusNotReady indicates the extension is running but unhealthy
+	StatusNotReady HeartbeatStatus = "notready"
+)
+
+// Heartbeat is the health of the extension as reported to the guest agent
+type Heartbeat struct {
+	Status  HeartbeatStatus
+	Code    int
+	Message string
+}
+
+// HealthProbe is called by the HeartbeatWriter before each write to determine the current health
+type HealthProbe func() Heartbeat
+
+// heartbeatItem is the format of the heartbeat file read by the guest agent
+type heartbeatItem struct {
+	Version   string          `json:"version"`
+	Heartbeat heartbeatStatus `json:"heartbeat"`
+}
+
+type heartbeatStatus struct {
+	Status           HeartbeatStatus         `json:"status"`
+	Code             int                     `json:"code"`
+	FormattedMessage status.FormattedMessage `json:"formattedMessage"`
+}
+
+// Write persists the heartbeat to the specified file. The operation consists of writing
+// to a temporary file in the same folder and moving it to the final destination for atomicity.
+func Write(heartbeatFile string, hb Heartbeat) error {
+	if heartbeatFile == "" {
+		return extensionerrors.ErrArgCannotBeNullOrEmpty
+	}
+
+	b, err := json.MarshalIndent([]heartbeatItem{
+		{
+			Version: "1.0", // this is the protocol version do not change unless you are sure
+			Heartbeat: heartbeatStatus{
+				Status: hb.Status,
+				Code:   hb.Code,
+				FormattedMessage: status.FormattedMessage{
+					Lang:    "en-US",
+					Message: hb.Message,
+				},
+			},
+		},
+	}, "", "\t")
+	if err != nil {
+		return fmt.Errorf("heartbeat: failed to marshal into json: %v", err)
+	}
+
+	folder, fn := filepath.Split(heartbeatFile)
+	if folder == "" {
+		folder = "."
+	}
+	tmpFile, err := os.CreateTemp(folder, fn)
+	if err != nil {
+		return fmt.Errorf("heartbeat: failed to create temporary file: %v", err)
+	}
+	tmpFile.Close()
+
+	if err := os.WriteFile(tmpFile.Name(), b, 0644); err != nil {
+		os.Remove(tmpFile.Name())
+		return fmt.Errorf("heartbeat: failed to write path=%s error=%v", tmpFile.Name(), err)
+	}
+
+	if err := os.Rename(tmpFile.Name(), heartbeatFile); err != nil {
+		os.Remove(tmpFile.Name())
+		return fmt.Errorf("heartbeat: failed to move to path=%s error=%v", heartbeatFile, err)
+	}
+
+	return nil
+}
+
+// MarkStopped writes a final not ready heartbeat with the message, along with a marker next to the
+// heartbeat file. The guest agent runs disable and uninstall in a new process, so a HeartbeatWriter
+// started by enable can't be stopped directly. It stops writing once it sees the marker instead,
+// which is removed when a HeartbeatWriter starts again.
+func MarkStopped(heartbeatFile string, message string) error {
+	if heartbeatFile == "" {
+		return extensionerrors.ErrArgCannotBeNullOrEmpty
+	}
+	if err := os.WriteFile(heartbeatFile+stoppedMarkerSuffix, []byte(message), 0644); err != nil {
+		return fmt.Errorf("heartbeat: failed to write the stopped marker: %v", err)
+	}
+	return Write(heartbeatFile, stoppedHeartbeat(message))
+}
+
+// readStoppedMarker returns the message of the marker written by MarkStopped, and false if there is none
+func readStoppedMarker(heartbeatFile string) (string, bool) {
+	b, err := os.ReadFile(heartbeatFile + stoppedMarkerSuffix)
+	if err != nil {
+		return "", false
+	}
+	return string(b), true
+}
+
+func stoppedHeartbeat(message string) Heartbeat {
+	return Heartbeat{Status: StatusNotReady, Message: message}
+}
+
+// HeartbeatWriter periodically writes the result of a HealthProbe to the heartbeat file
+type HeartbeatWriter struct {
+	el            logging.ILogger
+	heartbeatFile string
+	interval      time.Duration
+	probe         HealthProbe
+
+	mutex sync.Mutex
+	stop  chan struct{}
+	done  chan struct{}
+
+	stoppedByMarker int32 // set atomically when the writer stops because of MarkStopped
+}
+
+// New creates a HeartbeatWriter for the heartbeat file passed by the guest agent in
+// HandlerEnvironment.HeartbeatFile. An interval of zero uses DefaultInterval.
+func New(el logging.ILogger, heartbeatFile string, interval time.Duration, probe HealthProbe) (*HeartbeatWriter, error) {
+	if heartbeatFile == "" {
+		return nil, extensionerrors.ErrArgCannotBeNullOrEmpty
+	}
+	if el == nil || probe == nil {
+		return nil, extensionerrors.ErrArgCannotBeNull
+	}
+	if interval <= 0 {
+		interval = DefaultInterval
+	}
+
+	return &HeartbeatWriter{
+		el:            el,
+		heartbeatFile: heartbeatFile,
+		interval:      interval,
+		probe:         probe,
+	}, nil
+}
+
+// Start writes the first heartbeat and then keeps writing one every interval until Stop is called.
+// An error is returned if the first heartbeat cannot be written or the writer is already running.
+func (hw *HeartbeatWriter) Start() error {
+	hw.mutex.Lock()
+	defer hw.mutex.Unlock()
+
+	if hw.stop != nil {
+		return fmt.Errorf("heartbeat: writer is already running")
+	}
+
+	// the extension is running again, so an earlier MarkStopped no longer applies
+	if err := os.Remove(hw.heartbeatFile + stoppedMarkerSuffix); err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("heartbeat: failed to remove the stopped marker: %v", err)
+	}
+	atomic.StoreInt32(&hw.stoppedByMarker, 0)
+
+	if err := Write(hw.heartbeatFile, hw.runProbe()); err != nil {
+		return err
+	}
+
+	hw.stop = make(chan struct{})
+	hw.done = make(chan struct{})
+	go hw.run(hw.stop, hw.done)
+	return nil
+}
+
+// Stop stops writing heartbeats and waits for any write in progress to complete.
+// Calling Stop on a writer that isn't running does nothing.
+func (hw *HeartbeatWriter) Stop() {
+	hw.mutex.Lock()
+	defer hw.mutex.Unlock()
+
+	if hw.stop == nil {
+		return
+	}
+
+	close(hw.stop)
+	<-hw.done
+	hw.stop = nil
+	hw.done = nil
+}
+
+// IsRunning returns true between Start and Stop, unless MarkStopped was called for the heartbeat file
+func (hw *HeartbeatWriter) IsRunning() bool {
+	hw.mutex.Lock()
+	defer hw.mutex.Unlock()
+	return hw.stop != nil && atomic.LoadInt32(&hw.stoppedByMarker) == 0
+}
+
+func (hw *HeartbeatWriter) run(stop <-chan struct{}, done chan<- struct{}) {
+	defer close(done)
+
+	ticker := time.NewTicker(hw.interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+			if _, stopped := readStoppedMarker(hw.heartbeatFile); stopped {
+				hw.stoppedElsewhere()
+				return
+			}
+			if err := Write(hw.heartbeatFile, hw.runProbe()); err != nil {
+				// keep going, the next write may succeed
+				hw.el.Warn("Failed to write heartbeat: %v", err)
+			}
+			// MarkStopped may have been called while the heartbeat was written, which replaced the final one
+			if message, stopped := readStoppedMarker(hw.heartbeatFile); stopped {
+				if err := Write(hw.heartbeatFile, stoppedHeartbeat(message)); err != nil {
+					hw.el.Warn("Failed to write heartbeat: %v", err)
+				}
+				hw.stoppedElsewhere()
+				return
+			}
+		}
+	}
+}
+
+func (hw *HeartbeatWriter) stoppedElsewhere() {
+	atomic.StoreInt32(&hw.stoppedByMarker, 1)
+	hw.el.Info("Stopped heartbeat, the extension was stopped by another process")
+}
+
+// runProbe calls the health probe, reporting not ready if it panics
+func (hw *HeartbeatWriter) runProbe() (hb Heartbeat) {
+	defer func() {
+		if r := recover(); r != nil {
+			hw.el.Error("Health probe panicked: %v", r)
+			hb = Heartbeat{Status: StatusNotReady, Message: fmt.Sprintf("health probe panicked: %v", r)}
+		}
+	}()
+
+	return hw.probe()
+}
@@ -0,0 +1,155 @@
+// Copyright (c) Microsoft Corporation.
+// Licensed under the MIT License.
+package heartbeat
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/Azure/azure-extension-platform/pkg/extensionerrors"
+	"github.com/Azure/azure-extension-platform/pkg/logging"
+	"github.com/stretchr/testify/require"
+)
+
+var el = logging.New(nil)
+
+func readHeartbeatFile(t *testing.T, heartbeatFile string) []heartbeatItem {
+	b, err := os.ReadFile(heartbeatFile)
+	require.NoError(t, err, "could not read heartbeat file")
+	var items []heartbeatItem
+	require.NoError(t, json.Unmarshal(b, &items), "heartbeat file is not valid json")
+	require.Len(t, items, 1)
+	return items
+}
+
+func Test_writeHeartbeat(t *testing.T) {
+	heartbeatFile := filepath.Join(t.TempDir(), "heartbeat.log")
+	err := Write(heartbeatFile, Heartbeat{Status: StatusReady, Code: 0, Message: "all good"})
+	require.NoError(t, err)
+
+	items := readHeartbeatFile(t, heartbeatFile)
+	require.Equal(t, "1.0", items[0].Version)
+	require.Equal(t, StatusReady, items[0].Heartbeat.Status)
+	require.Equal(t, 0, items[0].Heartbeat.Code)
+	require.Equal(t, "all good", items[0].Heartbeat.FormattedMessage.Message)
+
+	// overwrite and ensure no temporary files are left behind
+	err = Write(heartbeatFile, Heartbeat{Status: StatusNotReady, Code: 5, Message: "not good"})
+	require.NoError(t, err)
+	items = readHeartbeatFile(t, heartbeatFile)
+	require.Equal(t, StatusNotReady, items[0].Heartbeat.Status)
+	require.Equal(t, 5, items[0].Heartbeat.Code)
+
+	entries, err := os.ReadDir(filepath.Dir(heartbeatFile))
+	require.NoError(t, err)
+	require.Len(t, entries, 1)
+}
+
+func Test_writeHeartbeatNoFile(t *testing.T) {
+	err := Write("", Heartbeat{Status: StatusReady})
+	require.Equal(t, extensionerrors.ErrArgCannotBeNullOrEmpty, err)
+}
+
+func Test_newHeartbeatWriterInvalidArgs(t *testing.T) {
+	probe := func() Heartbeat { return Heartbeat{Status: StatusReady} }
+
+	_, err := New(el, "", time.Second, probe)
+	require.Equal(t, extensionerrors.ErrArgCannotBeNullOrEmpty, err)
+
+	_, err = New(el, "heartbeat.log", time.Second, nil)
+	require.Equal(t, extensionerrors.ErrArgCannotBeNull, err)
+
+	hw, err := New(el, "heartbeat.log", 0, probe)
+	require.NoError(t, err)
+	require.Equal(t, DefaultInterval, hw.interval)
+}
+
+func Test_heartbeatWriterWritesPeriodically(t *testing.T) {
+	heartbeatFile := filepath.Join(t.TempDir(), "heartbeat.log")
+	var calls int32
+	probe := func() Heartbeat {
+		atomic.AddInt32(&calls, 1)
+		return Heartbeat{Status: StatusReady, Message: "running"}
+	}
+
+	hw, err := New(el, heartbeatFile, 10*time.Millisecond, probe)
+	require.NoError(t, err)
+	require.NoError(t, hw.Start())
+	require.True(t, hw.IsRunning())
+
+	// the first heartbeat is written synchronously
+	items := readHeartbeatFile(t, heartbeatFile)
+	require.Equal(t, StatusReady, items[0].Heartbeat.Status)
+
+	require.Eventually(t, func() bool { return atomic.LoadInt32(&calls) >= 3 }, 5*time.Second, 5*time.Millisecond)
+
+	hw.Stop()
+	require.False(t, hw.IsRunning())
+	callsAfterStop := atomic.LoadInt32(&calls)
+	time.Sleep(50 * time.Millisecond)
+	require.Equal(t, callsAfterStop, atomic.LoadInt32(&calls), "heartbeat written after stop")
+
+	// stopping twice is harmless
+	hw.Stop()
+}
+
+func Test_heartbeatWriterAlreadyRunning(t *testing.T) {
+	heartbeatFile := filepath.Join(t.TempDir(), "heartbeat.log")
+	hw, err := New(el, heartbeatFile, time.Hour, func() Heartbeat { return Heartbeat{Status: StatusReady} })
+	require.NoError(t, err)
+	require.NoError(t, hw.Start())
+	defer hw.Stop()
+
+	require.Error(t, hw.Start())
+}
+
+func Test_heartbeatWriterStartFailsIfFolderMissing(t *testing.T) {
+	heartbeatFile := filepath.Join(t.TempDir(), "missing", "heartbeat.log")
+	hw, err := New(el, heartbeatFile, time.Hour, func() Heartbeat { return Heartbeat{Status: StatusReady} })
+	require.NoError(t, err)
+	require.Error(t, hw.Start())
+	require.False(t, hw.IsRunning())
+}
+
+func Test_heartbeatWriterProbePanics(t *testing.T) {
+	heartbeatFile := filepath.Join(t.TempDir(), "heartbeat.log")
+	hw, err := New(el, heartbeatFile, time.Hour, func() Heartbeat { panic("yaba") })
+	require.NoError(t, err)
+	require.NoError(t, hw.Start())
+	defer hw.Stop()
+
+	items := readHeartbeatFile(t, heartbeatFile)
+	require.Equal(t, StatusNotReady, items[0].Heartbeat.Status)
+	require.Contains(t, items[0].Heartbeat.FormattedMessage.Message, "yaba")
+}
+
+func Test_markStoppedStopsWriter(t *testing.T) {
+	heartbeatFile := filepath.Join(t.TempDir(), "heartbeat.log")
+	hw, err := New(el, heartbeatFile, 10*time.Millisecond, func() Heartbeat { return Heartbeat{Status: StatusReady} })
+	require.NoError(t, err)
+	require.NoError(t, hw.Start())
+	defer hw.Stop()
+
+	// as done by disable, which runs in another process
+	require.NoError(t, MarkStopped(heartbeatFile, "the extension is disabled"))
+	require.Eventually(t, func() bool { return !hw.IsRunning() }, 5*time.Second, 5*time.Millisecond)
+	time.Sleep(50 * time.Millisecond)
+	items := readHeartbeatFile(t, heartbeatFile)
+	require.Equal(t, StatusNotReady, items[0].Heartbeat.Status)
+	require.Equal(t, "the extension is disabled", items[0].Heartbeat.FormattedMessage.Message)
+
+	// starting again, as done by the next enable, removes the marker
+	hw.Stop()
+	require.NoError(t, hw.Start())
+	require.True(t, hw.IsRunning())
+	require.Equal(t, StatusReady, readHeartbeatFile(t, heartbeatFile)[0].Heartbeat.Status)
+	require.NoFileExists(t, heartbeatFile+stoppedMarkerSuffix)
+}
+
+func Test_markStoppedNoFile(t *testing.T) {
+	require.Equal(t, extensionerrors.ErrArgCannotBeNullOrEmpty, MarkStopped("", "stopped"))
+}
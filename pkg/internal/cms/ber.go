@@ -0,0 +1,142 @@
+// Copyright (c) Microsoft Corporation.
+// Licensed under the MIT License.
+package cms
+
+import (
+	"bytes"
+
+	"github.com/pkg/errors"
+)
+
+// maxBERDepth bounds the nesting of the messages we accept
+const maxBERDepth = 64
+
+var errInvalidBER = errors.New("cms: invalid BER encoding")
+
+// berToDER rewrites indefinite and non-minimal lengths in a BER encoded message as DER definite
+// lengths so it can be parsed by encoding/asn1. Encoders such as openssl produce indefinite
+// lengths when streaming. Constructed strings are left as they are.
+func berToDER(ber []byte) ([]byte, error) {
+	var out bytes.Buffer
+	rest, err := convertBERElement(ber, &out, 0)
+	if err != nil {
+		return nil, err
+	}
+	if len(rest) > 0 {
+		return nil, errors.Wrap(errInvalidBER, "trailing data after message")
+	}
+	return out.Bytes(), nil
+}
+
+// convertBERElement converts the first element of ber, writes it to out and returns the remaining bytes
+func convertBERElement(ber []byte, out *bytes.Buffer, depth int) ([]byte, error) {
+	if depth > maxBERDepth {
+		return nil, errors.Wrap(errInvalidBER, "message is nested too deeply")
+	}
+	if len(ber) < 2 {
+		return nil, errors.Wrap(errInvalidBER, "truncated element")
+	}
+
+	// identifier octets
+	identifierLength := 1
+	if ber[0]&0x1f == 0x1f {
+		for {
+			if identifierLength >= len(ber) {
+				return nil, errors.Wrap(errInvalidBER, "truncated tag")
+			}
+			b := ber[identifierLength]
+			identifierLength++
+			if b&0x80 == 0 {
+				break
+			}
+		}
+	}
+	identifier := ber[:identifierLength]
+	isConstructed := ber[0]&0x20 != 0
+	ber = ber[identifierLength:]
+
+	// length octets
+	if len(ber) < 1 {
+		return nil, errors.Wrap(errInvalidBER, "truncated length")
+	}
+	lengthByte := ber[0]
+	ber = ber[1:]
+
+	if lengthByte == 0x80 {
+		if !isConstructed {
+			return nil, errors.Wrap(errInvalidBER, "indefinite length on a primitive element")
+		}
+
+		var content bytes.Buffer
+		for {
+			if len(ber) < 2 {
+				return nil, errors.Wrap(errInvalidBER, "missing end of contents")
+			}
+			if ber[0] == 0 && ber[1] == 0 {
+				ber = ber[2:]
+				break
+			}
+			var err error
+			ber, err = convertBERElement(ber, &content, depth+1)
+			if err != nil {
+				return nil, err
+			}
+		}
+		writeDERElement(out, identifier, content.Bytes())
+		return ber, nil
+	}
+
+	length := int(lengthByte)
+	if lengthByte&0x80 != 0 {
+		numBytes := int(lengthByte & 0x7f)
+		if numBytes > 4 || numBytes > len(ber) {
+			return nil, errors.Wrap(errInvalidBER, "invalid length")
+		}
+		length = 0
+		for _, b := range ber[:numBytes] {
+			length = length<<8 | int(b)
+		}
+		ber = ber[numBytes:]
+	}
+	if length < 0 || length > len(ber) {
+		return nil, errors.Wrap(errInvalidBER, "length exceeds message")
+	}
+
+	contentBytes := ber[:length]
+	ber = ber[length:]
+	if !isConstructed {
+		writeDERElement(out, identifier, contentBytes)
+		return ber, nil
+	}
+
+	var content bytes.Buffer
+	for len(contentBytes) > 0 {
+		var err error
+		contentBytes, err = convertBERElement(contentBytes, &content, depth+1)
+		if err != nil {
+			return nil, err
+		}
+	}
+	writeDERElement(out, identifier, content.Bytes())
+	return ber, nil
+}
+
+func writeDERElement(out *bytes.Buffer, identifier []byte, content []byte) {
+	out.Write(identifier)
+	writeDERLength(out, len(content))
+	out.Write(content)
+}
+
+func writeDERLength(out *bytes.Buffer, length int) {
+	if length < 0x80 {
+		out.WriteByte(byte(length))
+		return
+	}
+
+	var lengthBytes []byte
+	for l := length; l > 0; l >>= 8 {
+		lengthBytes = append([]byte{byte(l)}, lengthBytes...)
+	}
+	out.WriteByte(0x80 | byte(len(lengthBytes)))
+	out.Write(lengthBytes)
+}
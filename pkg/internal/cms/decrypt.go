@@ -0,0 +1,242 @@
+// Copyright (c) Microsoft Corporation.
+// Licensed under the MIT License.
+package cms
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/des"
+	"crypto/rsa"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+
+	"github.com/pkg/errors"
+)
+
+// Decrypt decrypts a DER or BER encoded CMS EnvelopedData message that was encrypted for cert,
+// using the private key of the certificate
+func Decrypt(message []byte, cert *x509.Certificate, key crypto.PrivateKey) ([]byte, error) {
+	if cert == nil || key == nil {
+		return nil, errors.New("cms: the certificate and private key are required")
+	}
+
+	der, err := berToDER(message)
+	if err != nil {
+		return nil, err
+	}
+
+	var ci contentInfo
+	if _, err := asn1.Unmarshal(der, &ci); err != nil {
+		return nil, errors.Wrapf(ErrNotEnvelopedData, "cannot parse content info: %v", err)
+	}
+	if !ci.ContentType.Equal(oidEnvelopedData) {
+		return nil, errors.Wrapf(ErrNotEnvelopedData, "content type is %v", ci.ContentType)
+	}
+
+	var ed envelopedData
+	if _, err := asn1.Unmarshal(ci.Content.Bytes, &ed); err != nil {
+		return nil, errors.Wrapf(ErrNotEnvelopedData, "cannot parse enveloped data: %v", err)
+	}
+
+	recipient, err := findRecipient(ed.RecipientInfos, cert)
+	if err != nil {
+		return nil, err
+	}
+
+	contentKey, err := decryptContentKey(recipient, key)
+	if err != nil {
+		return nil, err
+	}
+
+	return decryptContent(&ed.EncryptedContentInfo, contentKey)
+}
+
+// findRecipient returns the key transport recipient info that identifies cert. Recipient infos that
+// can't be parsed are skipped, since they may be meant for other recipients.
+func findRecipient(recipientInfos []asn1.RawValue, cert *x509.Certificate) (*keyTransRecipientInfo, error) {
+	var parseErr error
+	for _, ri := range recipientInfos {
+		// other recipient info types are tagged, key transport is an untagged sequence
+		if ri.Class != asn1.ClassUniversal || ri.Tag != asn1.TagSequence {
+			continue
+		}
+
+		var ktri keyTransRecipientInfo
+		if _, err := asn1.Unmarshal(ri.FullBytes, &ktri); err != nil {
+			parseErr = err
+			continue
+		}
+		if recipientMatches(&ktri, cert) {
+			return &ktri, nil
+		}
+	}
+
+	if parseErr != nil {
+		return nil, errors.Wrapf(ErrNoMatchingRecipient, "serial number %v, and a recipient info couldn't be parsed: %v", cert.SerialNumber, parseErr)
+	}
+	return nil, errors.Wrapf(ErrNoMatchingRecipient, "serial number %v", cert.SerialNumber)
+}
+
+func recipientMatches(ktri *keyTransRecipientInfo, cert *x509.Certificate) bool {
+	rid := ktri.Rid
+	switch {
+	case rid.Class == asn1.ClassUniversal && rid.Tag == asn1.TagSequence:
+		var ias issuerAndSerialNumber
+		if _, err := asn1.Unmarshal(rid.FullBytes, &ias); err != nil {
+			return false
+		}
+		return bytes.Equal(ias.Issuer.FullBytes, cert.RawIssuer) && ias.SerialNumber.Cmp(cert.SerialNumber) == 0
+	case rid.Class == asn1.ClassContextSpecific && rid.Tag == 0:
+		return len(cert.SubjectKeyId) > 0 && bytes.Equal(rid.Bytes, cert.SubjectKeyId)
+	default:
+		return false
+	}
+}
+
+func decryptContentKey(ktri *keyTransRecipientInfo, key crypto.PrivateKey) ([]byte, error) {
+	rsaKey, ok := key.(*rsa.PrivateKey)
+	if !ok {
+		return nil, errors.Wrapf(ErrUnsupportedAlgorithm, "key transport requires an RSA private key, got %T", key)
+	}
+
+	algorithm := ktri.KeyEncryptionAlgorithm.Algorithm
+	switch {
+	case algorithm.Equal(oidRSAEncryption):
+		contentKey, err := rsa.DecryptPKCS1v15(nil, rsaKey, ktri.EncryptedKey)
+		if err != nil {
+			return nil, errors.Wrap(err, "cms: failed to decrypt the content encryption key")
+		}
+		return contentKey, nil
+	case algorithm.Equal(oidRSAESOAEP):
+		opts, err := parseOAEPParameters(ktri.KeyEncryptionAlgorithm)
+		if err != nil {
+			return nil, err
+		}
+		contentKey, err := rsaKey.Decrypt(nil, ktri.EncryptedKey, opts)
+		if err != nil {
+			return nil, errors.Wrap(err, "cms: failed to decrypt the content encryption key")
+		}
+		return contentKey, nil
+	default:
+		return nil, errors.Wrapf(ErrUnsupportedAlgorithm, "key encryption %v", algorithm)
+	}
+}
+
+func parseOAEPParameters(algorithm pkix.AlgorithmIdentifier) (*rsa.OAEPOptions, error) {
+	var params rsaesOAEPParams
+	if len(algorithm.Parameters.FullBytes) > 0 && algorithm.Parameters.Tag != asn1.TagNull {
+		if _, err := asn1.Unmarshal(algorithm.Parameters.FullBytes, &params); err != nil {
+			return nil, errors.Wrapf(ErrUnsupportedAlgorithm, "cannot parse OAEP parameters: %v", err)
+		}
+	}
+
+	hash, err := hashForOID(params.HashAlgorithm.Algorithm)
+	if err != nil {
+		return nil, err
+	}
+
+	mgfHash := hash
+	if len(params.MaskGenAlgorithm.Algorithm) > 0 {
+		if !params.MaskGenAlgorithm.Algorithm.Equal(oidMGF1) {
+			return nil, errors.Wrapf(ErrUnsupportedAlgorithm, "mask generation %v", params.MaskGenAlgorithm.Algorithm)
+		}
+		var mgfHashAlgorithm pkix.AlgorithmIdentifier
+		if _, err := asn1.Unmarshal(params.MaskGenAlgorithm.Parameters.FullBytes, &mgfHashAlgorithm); err != nil {
+			return nil, errors.Wrapf(ErrUnsupportedAlgorithm, "cannot parse MGF1 parameters: %v", err)
+		}
+		if mgfHash, err = hashForOID(mgfHashAlgorithm.Algorithm); err != nil {
+			return nil, err
+		}
+	} else {
+		// the default mask generation function is MGF1 with SHA-1
+		mgfHash = crypto.SHA1
+	}
+
+	return &rsa.OAEPOptions{Hash: hash, MGFHash: mgfHash}, nil
+}
+
+func decryptContent(eci *encryptedContentInfo, contentKey []byte) ([]byte, error) {
+	block, err := newContentCipher(eci.ContentEncryptionAlgorithm.Algorithm, contentKey)
+	if err != nil {
+		return nil, err
+	}
+
+	var iv []byte
+	if _, err := asn1.Unmarshal(eci.ContentEncryptionAlgorithm.Parameters.FullBytes, &iv); err != nil {
+		return nil, errors.Wrapf(ErrNotEnvelopedData, "cannot parse the initialization vector: %v", err)
+	}
+	if len(iv) != block.BlockSize() {
+		return nil, errors.Wrapf(ErrNotEnvelopedData, "initialization vector is %d bytes, expected %d", len(iv), block.BlockSize())
+	}
+
+	ciphertext, err := encryptedContentBytes(eci.EncryptedContent)
+	if err != nil {
+		return nil, err
+	}
+	if len(ciphertext) == 0 || len(ciphertext)%block.BlockSize() != 0 {
+		return nil, errors.Wrapf(ErrNotEnvelopedData, "encrypted content is %d bytes, not a multiple of the block size", len(ciphertext))
+	}
+
+	plaintext := make([]byte, len(ciphertext))
+	cipher.NewCBCDecrypter(block, iv).CryptBlocks(plaintext, ciphertext)
+	return removePadding(plaintext, block.BlockSize())
+}
+
+func newContentCipher(algorithm asn1.ObjectIdentifier, key []byte) (cipher.Block, error) {
+	var expectedKeyLength int
+	var newCipher func([]byte) (cipher.Block, error)
+	switch {
+	case algorithm.Equal(oidAES128CBC):
+		expectedKeyLength, newCipher = 16, aes.NewCipher
+	case algorithm.Equal(oidAES192CBC):
+		expectedKeyLength, newCipher = 24, aes.NewCipher
+	case algorithm.Equal(oidAES256CBC):
+		expectedKeyLength, newCipher = 32, aes.NewCipher
+	case algorithm.Equal(oidDESEDE3CBC):
+		expectedKeyLength, newCipher = 24, des.NewTripleDESCipher
+	default:
+		return nil, errors.Wrapf(ErrUnsupportedAlgorithm, "content encryption %v", algorithm)
+	}
+
+	if len(key) != expectedKeyLength {
+		return nil, errors.Errorf("cms: content encryption key is %d bytes, expected %d", len(key), expectedKeyLength)
+	}
+	return newCipher(key)
+}
+
+// encryptedContentBytes returns the encrypted content, which BER encoders may split into several octet strings
+func encryptedContentBytes(raw asn1.RawValue) ([]byte, error) {
+	if !raw.IsCompound {
+		return raw.Bytes, nil
+	}
+
+	var content []byte
+	rest := raw.Bytes
+	for len(rest) > 0 {
+		var chunk []byte
+		var err error
+		rest, err = asn1.Unmarshal(rest, &chunk)
+		if err != nil {
+			return nil, errors.Wrapf(ErrNotEnvelopedData, "cannot parse the encrypted content: %v", err)
+		}
+		content = append(content, chunk...)
+	}
+	return content, nil
+}
+
+// removePadding removes PKCS#7 padding
+func removePadding(plaintext []byte, blockSize int) ([]byte, error) {
+	paddingLength := int(plaintext[len(plaintext)-1])
+	if paddingLength == 0 || paddingLength > blockSize || paddingLength > len(plaintext) {
+		return nil, ErrInvalidPadding
+	}
+	for _, b := range plaintext[len(plaintext)-paddingLength:] {
+		if int(b) != paddingLength {
+			return nil, ErrInvalidPadding
+		}
+	}
+	return plaintext[:len(plaintext)-paddingLength], nil
+}
@@ -0,0 +1,169 @@
+// Copyright (c) Microsoft Corporation.
+// Licensed under the MIT License.
+package cms
+
+import (
+	"crypto"
+	"crypto/x509"
+	"encoding/asn1"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/require"
+)
+
+// the messages in testdata were encrypted with openssl, e.g.
+// openssl cms -encrypt -aes256 -outform DER -in plaintext.json -out aes256.p7m recipient.crt
+func readTestFile(t *testing.T, name string) []byte {
+	b, err := os.ReadFile(filepath.Join("testdata", name))
+	require.NoError(t, err)
+	return b
+}
+
+func readTestCertificate(t *testing.T, name string) *x509.Certificate {
+	cert, err := ParseCertificate(readTestFile(t, name))
+	require.NoError(t, err)
+	return cert
+}
+
+func readTestPrivateKey(t *testing.T, name string) crypto.PrivateKey {
+	key, err := ParsePrivateKey(readTestFile(t, name))
+	require.NoError(t, err)
+	return key
+}
+
+func Test_decryptOpensslMessages(t *testing.T) {
+	cert := readTestCertificate(t, "recipient.crt")
+	key := readTestPrivateKey(t, "recipient.prv")
+	expected := readTestFile(t, "plaintext.json")
+
+	for _, name := range []string{
+		"aes128.p7m",
+		"aes192.p7m",
+		"aes256.p7m",
+		"des3.p7m",
+		"smime.p7m",
+		"oaep.p7m",
+		"oaep_sha256.p7m",
+		"keyid.p7m",
+		"stream.p7m",
+		"multi.p7m",
+	} {
+		t.Run(name, func(t *testing.T) {
+			plaintext, err := Decrypt(readTestFile(t, name), cert, key)
+			require.NoError(t, err)
+			require.Equal(t, expected, plaintext)
+		})
+	}
+}
+
+func Test_decryptWithPKCS1Key(t *testing.T) {
+	cert := readTestCertificate(t, "recipient.crt")
+	key := readTestPrivateKey(t, "recipient_pkcs1.prv")
+
+	plaintext, err := Decrypt(readTestFile(t, "aes256.p7m"), cert, key)
+	require.NoError(t, err)
+	require.Equal(t, readTestFile(t, "plaintext.json"), plaintext)
+}
+
+func Test_decryptNoMatchingRecipient(t *testing.T) {
+	cert := readTestCertificate(t, "recipient.crt")
+	key := readTestPrivateKey(t, "recipient.prv")
+
+	_, err := Decrypt(readTestFile(t, "other.p7m"), cert, key)
+	require.Equal(t, ErrNoMatchingRecipient, errors.Cause(err))
+}
+
+func Test_findRecipientSkipsUnparsableRecipientInfos(t *testing.T) {
+	cert := readTestCertificate(t, "recipient.crt")
+	ri, err := newRecipientInfo(cert, make([]byte, 32))
+	require.NoError(t, err)
+
+	// a sequence that isn't a key transport recipient info
+	unparsable := asn1.RawValue{Class: asn1.ClassUniversal, Tag: asn1.TagSequence, IsCompound: true, FullBytes: []byte{0x30, 0x03, 0x02, 0x01, 0x00}}
+	var valid asn1.RawValue
+	_, err = asn1.Unmarshal(ri, &valid)
+	require.NoError(t, err)
+
+	ktri, err := findRecipient([]asn1.RawValue{unparsable, valid}, cert)
+	require.NoError(t, err)
+	require.NotNil(t, ktri)
+
+	_, err = findRecipient([]asn1.RawValue{unparsable}, cert)
+	require.Equal(t, ErrNoMatchingRecipient, errors.Cause(err))
+}
+
+func Test_decryptWrongKey(t *testing.T) {
+	cert := readTestCertificate(t, "recipient.crt")
+	key := readTestPrivateKey(t, "other.prv")
+
+	_, err := Decrypt(readTestFile(t, "aes256.p7m"), cert, key)
+	require.Error(t, err)
+}
+
+func Test_decryptNotEnvelopedData(t *testing.T) {
+	cert := readTestCertificate(t, "recipient.crt")
+	key := readTestPrivateKey(t, "recipient.prv")
+
+	_, err := Decrypt([]byte("yaba"), cert, key)
+	require.Error(t, err)
+
+	// a certificate is valid DER but not a CMS message
+	_, err = Decrypt(cert.Raw, cert, key)
+	require.Equal(t, ErrNotEnvelopedData, errors.Cause(err))
+}
+
+func Test_decryptTruncatedMessage(t *testing.T) {
+	cert := readTestCertificate(t, "recipient.crt")
+	key := readTestPrivateKey(t, "recipient.prv")
+	message := readTestFile(t, "aes256.p7m")
+
+	for _, length := range []int{0, 1, 10, len(message) / 2, len(message) - 1} {
+		_, err := Decrypt(message[:length], cert, key)
+		require.Error(t, err, "truncated to %d bytes", length)
+	}
+}
+
+func Test_decryptMissingCertificateOrKey(t *testing.T) {
+	_, err := Decrypt(readTestFile(t, "aes256.p7m"), nil, nil)
+	require.Error(t, err)
+}
+
+func Test_removePadding(t *testing.T) {
+	plaintext, err := removePadding([]byte{'a', 'b', 2, 2}, 4)
+	require.NoError(t, err)
+	require.Equal(t, []byte("ab"), plaintext)
+
+	_, err = removePadding([]byte{'a', 'b', 1, 2}, 4)
+	require.Equal(t, ErrInvalidPadding, err)
+
+	_, err = removePadding([]byte{'a', 'b', 'c', 0}, 4)
+	require.Equal(t, ErrInvalidPadding, err)
+
+	_, err = removePadding([]byte{5, 5, 5, 5}, 4)
+	require.Equal(t, ErrInvalidPadding, err)
+}
+
+func Test_berToDER(t *testing.T) {
+	// SEQUENCE (indefinite) { OCTET STRING "ab", SEQUENCE (indefinite) { INTEGER 1 } }
+	ber := []byte{0x30, 0x80, 0x04, 0x02, 'a', 'b', 0x30, 0x80, 0x02, 0x01, 0x01, 0x00, 0x00, 0x00, 0x00}
+	der, err := berToDER(ber)
+	require.NoError(t, err)
+	require.Equal(t, []byte{0x30, 0x09, 0x04, 0x02, 'a', 'b', 0x30, 0x03, 0x02, 0x01, 0x01}, der)
+
+	// non minimal length
+	der, err = berToDER([]byte{0x04, 0x81, 0x02, 'a', 'b'})
+	require.NoError(t, err)
+	require.Equal(t, []byte{0x04, 0x02, 'a', 'b'}, der)
+
+	_, err = berToDER([]byte{0x30, 0x80, 0x04, 0x02, 'a', 'b'})
+	require.Error(t, err, "missing end of contents")
+
+	_, err = berToDER([]byte{0x04, 0x80, 0x00, 0x00})
+	require.Error(t, err, "indefinite primitive")
+
+	_, err = berToDER([]byte{0x04, 0x05, 'a'})
+	require.Error(t, err, "length exceeds message")
+}
@@ -0,0 +1,173 @@
+// Copyright (c) Microsoft Corporation.
+// Licensed under the MIT License.
+package cms
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/des"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"io"
+
+	"github.com/pkg/errors"
+)
+
+// ContentEncryption is the symmetric algorithm used to encrypt the content of a message
+type ContentEncryption int
+
+const (
+	AES256CBC ContentEncryption = iota
+	AES192CBC
+	AES128CBC
+	DESEDE3CBC
+)
+
+// Encrypt encrypts plaintext as DER encoded CMS EnvelopedData for each of the recipients.
+// The content encryption key is transported to each recipient with RSA PKCS#1 v1.5,
+// which every supported decryptor, including openssl and Windows, understands.
+func Encrypt(plaintext []byte, recipients []*x509.Certificate, contentEncryption ContentEncryption) ([]byte, error) {
+	if len(recipients) == 0 {
+		return nil, errors.New("cms: at least one recipient is required")
+	}
+
+	algorithm, keyLength, err := contentEncryptionParameters(contentEncryption)
+	if err != nil {
+		return nil, err
+	}
+
+	contentKey := make([]byte, keyLength)
+	if _, err := io.ReadFull(rand.Reader, contentKey); err != nil {
+		return nil, errors.Wrap(err, "cms: failed to generate the content encryption key")
+	}
+
+	recipientInfos := make([]asn1.RawValue, len(recipients))
+	for i, cert := range recipients {
+		ri, err := newRecipientInfo(cert, contentKey)
+		if err != nil {
+			return nil, err
+		}
+		recipientInfos[i] = asn1.RawValue{FullBytes: ri}
+	}
+
+	eci, err := encryptContent(plaintext, algorithm, contentKey)
+	if err != nil {
+		return nil, err
+	}
+
+	ed, err := asn1.Marshal(envelopedData{
+		Version:              0,
+		RecipientInfos:       recipientInfos,
+		EncryptedContentInfo: *eci,
+	})
+	if err != nil {
+		return nil, errors.Wrap(err, "cms: failed to encode the enveloped data")
+	}
+
+	message, err := asn1.Marshal(contentInfo{
+		ContentType: oidEnvelopedData,
+		Content:     asn1.RawValue{Class: asn1.ClassContextSpecific, Tag: 0, IsCompound: true, Bytes: ed},
+	})
+	if err != nil {
+		return nil, errors.Wrap(err, "cms: failed to encode the content info")
+	}
+	return message, nil
+}
+
+func contentEncryptionParameters(contentEncryption ContentEncryption) (asn1.ObjectIdentifier, int, error) {
+	switch contentEncryption {
+	case AES256CBC:
+		return oidAES256CBC, 32, nil
+	case AES192CBC:
+		return oidAES192CBC, 24, nil
+	case AES128CBC:
+		return oidAES128CBC, 16, nil
+	case DESEDE3CBC:
+		return oidDESEDE3CBC, 24, nil
+	default:
+		return nil, 0, errors.Wrapf(ErrUnsupportedAlgorithm, "content encryption %d", contentEncryption)
+	}
+}
+
+// newRecipientInfo returns the key transport recipient info for cert, identified by issuer and serial number
+func newRecipientInfo(cert *x509.Certificate, contentKey []byte) ([]byte, error) {
+	if cert == nil {
+		return nil, errors.New("cms: recipient certificate cannot be nil")
+	}
+	publicKey, ok := cert.PublicKey.(*rsa.PublicKey)
+	if !ok {
+		return nil, errors.Wrapf(ErrUnsupportedAlgorithm, "key transport requires an RSA certificate, got %T", cert.PublicKey)
+	}
+
+	encryptedKey, err := rsa.EncryptPKCS1v15(rand.Reader, publicKey, contentKey)
+	if err != nil {
+		return nil, errors.Wrap(err, "cms: failed to encrypt the content encryption key")
+	}
+
+	rid, err := asn1.Marshal(issuerAndSerialNumber{
+		Issuer:       asn1.RawValue{FullBytes: cert.RawIssuer},
+		SerialNumber: cert.SerialNumber,
+	})
+	if err != nil {
+		return nil, errors.Wrap(err, "cms: failed to encode the recipient identifier")
+	}
+
+	ri, err := asn1.Marshal(keyTransRecipientInfo{
+		Version: 0,
+		Rid:     asn1.RawValue{FullBytes: rid},
+		KeyEncryptionAlgorithm: pkix.AlgorithmIdentifier{
+			Algorithm:  oidRSAEncryption,
+			Parameters: asn1.NullRawValue,
+		},
+		EncryptedKey: encryptedKey,
+	})
+	if err != nil {
+		return nil, errors.Wrap(err, "cms: failed to encode the recipient info")
+	}
+	return ri, nil
+}
+
+func encryptContent(plaintext []byte, algorithm asn1.ObjectIdentifier, contentKey []byte) (*encryptedContentInfo, error) {
+	var block cipher.Block
+	var err error
+	if algorithm.Equal(oidDESEDE3CBC) {
+		block, err = des.NewTripleDESCipher(contentKey)
+	} else {
+		block, err = aes.NewCipher(contentKey)
+	}
+	if err != nil {
+		return nil, errors.Wrap(err, "cms: failed to create the content cipher")
+	}
+
+	iv := make([]byte, block.BlockSize())
+	if _, err := io.ReadFull(rand.Reader, iv); err != nil {
+		return nil, errors.Wrap(err, "cms: failed to generate the initialization vector")
+	}
+	ivBytes, err := asn1.Marshal(iv)
+	if err != nil {
+		return nil, errors.Wrap(err, "cms: failed to encode the initialization vector")
+	}
+
+	padded := addPadding(plaintext, block.BlockSize())
+	ciphertext := make([]byte, len(padded))
+	cipher.NewCBCEncrypter(block, iv).CryptBlocks(ciphertext, padded)
+
+	return &encryptedContentInfo{
+		ContentType: oidData,
+		ContentEncryptionAlgorithm: pkix.AlgorithmIdentifier{
+			Algorithm:  algorithm,
+			Parameters: asn1.RawValue{FullBytes: ivBytes},
+		},
+		EncryptedContent: asn1.RawValue{Class: asn1.ClassContextSpecific, Tag: 0, Bytes: ciphertext},
+	}, nil
+}
+
+// addPadding adds PKCS#7 padding
+func addPadding(plaintext []byte, blockSize int) []byte {
+	paddingLength := blockSize - len(plaintext)%blockSize
+	return append(append([]byte{}, plaintext...), bytes.Repeat([]byte{byte(paddingLength)}, paddingLength)...)
+}
@@ -0,0 +1,87 @@
+// Copyright (c) Microsoft Corporation.
+// Licensed under the MIT License.
+package cms
+
+import (
+	"bytes"
+	"crypto/x509"
+	"fmt"
+	"os/exec"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+var allContentEncryptions = []ContentEncryption{AES256CBC, AES192CBC, AES128CBC, DESEDE3CBC}
+
+func Test_encryptDecryptRoundTrip(t *testing.T) {
+	cert := readTestCertificate(t, "recipient.crt")
+	key := readTestPrivateKey(t, "recipient.prv")
+
+	for _, ce := range allContentEncryptions {
+		for _, length := range []int{0, 1, 7, 8, 15, 16, 17, 4096} {
+			t.Run(fmt.Sprintf("%d/%d", ce, length), func(t *testing.T) {
+				plaintext := bytes.Repeat([]byte{'x'}, length)
+				message, err := Encrypt(plaintext, []*x509.Certificate{cert}, ce)
+				require.NoError(t, err)
+
+				decrypted, err := Decrypt(message, cert, key)
+				require.NoError(t, err)
+				require.Equal(t, plaintext, decrypted)
+			})
+		}
+	}
+}
+
+func Test_encryptMultipleRecipients(t *testing.T) {
+	recipient := readTestCertificate(t, "recipient.crt")
+	other := readTestCertificate(t, "other.crt")
+	plaintext := readTestFile(t, "plaintext.json")
+
+	message, err := Encrypt(plaintext, []*x509.Certificate{other, recipient}, AES256CBC)
+	require.NoError(t, err)
+
+	decrypted, err := Decrypt(message, recipient, readTestPrivateKey(t, "recipient.prv"))
+	require.NoError(t, err)
+	require.Equal(t, plaintext, decrypted)
+
+	decrypted, err = Decrypt(message, other, readTestPrivateKey(t, "other.prv"))
+	require.NoError(t, err)
+	require.Equal(t, plaintext, decrypted)
+}
+
+func Test_encryptInvalidArguments(t *testing.T) {
+	cert := readTestCertificate(t, "recipient.crt")
+
+	_, err := Encrypt([]byte("yaba"), nil, AES256CBC)
+	require.Error(t, err)
+
+	_, err = Encrypt([]byte("yaba"), []*x509.Certificate{nil}, AES256CBC)
+	require.Error(t, err)
+
+	_, err = Encrypt([]byte("yaba"), []*x509.Certificate{cert}, ContentEncryption(42))
+	require.Error(t, err)
+}
+
+func Test_encryptDecryptsWithOpenssl(t *testing.T) {
+	if _, err := exec.LookPath("openssl"); err != nil {
+		t.Skip("openssl is not installed")
+	}
+	cert := readTestCertificate(t, "recipient.crt")
+	plaintext := readTestFile(t, "plaintext.json")
+
+	for _, ce := range allContentEncryptions {
+		message, err := Encrypt(plaintext, []*x509.Certificate{cert}, ce)
+		require.NoError(t, err)
+
+		cmd := exec.Command("openssl", "cms", "-decrypt", "-inform", "DER",
+			"-recip", filepath.Join("testdata", "recipient.crt"), "-inkey", filepath.Join("testdata", "recipient.prv"))
+		cmd.Stdin = bytes.NewReader(message)
+		var stderr bytes.Buffer
+		cmd.Stderr = &stderr
+		decrypted, err := cmd.Output()
+		require.NoError(t, err, "openssl failed for content encryption %d: %s", ce, stderr.String())
+		require.Equal(t, plaintext, decrypted)
+	}
+}
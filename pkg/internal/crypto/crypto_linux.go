@@ -3,6 +3,9 @@
 package crypto
 
 import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
 	"crypto/rand"
 	"crypto/rsa"
 	"crypto/sha1"
@@ -10,6 +13,7 @@ import (
 	"crypto/x509/pkix"
 	"encoding/hex"
 	"encoding/pem"
+	"fmt"
 	"github.com/Azure/azure-extension-platform/pkg/extensionerrors"
 	"math/big"
 	"os"
@@ -17,18 +21,14 @@ import (
 	"time"
 )
 
-const (
-	COUNTRY             = "US"
-	LOCALITY            = "Redmond"
-	COMMON_NAME         = "Hybrid Runbook Worker"
-	ORGANIZATIONAL_UNIT = "Azure Automation"
-	ORGANIZATION        = "Microsoft Corporation"
-	STATE               = "Washington"
-)
+// defaultValidity is used when CertificateOptions doesn't specify when the certificate expires
+const defaultValidity = time.Hour * 24 * 365 * 10
 
 type SelfSignedCertificateKey struct {
-	Cert    x509.Certificate
-	PrivKey rsa.PrivateKey
+	Cert x509.Certificate
+	// PrivKey is only set for RSA keys, PrivateKey is set for all key types
+	PrivKey    rsa.PrivateKey
+	PrivateKey crypto.Signer
 }
 
 type CertificateOperations interface {
@@ -37,33 +37,57 @@ type CertificateOperations interface {
 	GetCertificateThumbprint() string
 }
 
+// NewSelfSignedx509Certificate creates a self signed certificate with the default options
 func NewSelfSignedx509Certificate() (*SelfSignedCertificateKey, error) {
-	privateKey, err := rsa.GenerateKey(rand.Reader, 1024)
+	return NewSelfSignedx509CertificateWithOptions(CertificateOptions{})
+}
 
+// NewSelfSignedx509CertificateWithOptions creates a self signed certificate described by opts
+func NewSelfSignedx509CertificateWithOptions(opts CertificateOptions) (*SelfSignedCertificateKey, error) {
+	privateKey, keyUsage, err := generateKey(opts.KeyType)
 	if err != nil {
-		return nil, extensionerrors.AddStackToError(err)
+		return nil, err
+	}
+
+	certSubject := opts.Subject
+	if isEmptySubject(certSubject) {
+		certSubject = DefaultCertificateSubject()
+	}
+
+	serialNumber := opts.SerialNumber
+	if serialNumber == nil {
+		// RFC 5280 allows up to 20 octets, 128 random bits make collisions practically impossible
+		serialNumber, err = rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 128))
+		if err != nil {
+			return nil, extensionerrors.AddStackToError(err)
+		}
+	} else if serialNumber.Sign() <= 0 {
+		return nil, fmt.Errorf("the certificate serial number must be positive")
 	}
 
-	certSubject := pkix.Name{
-		Country:            []string{COUNTRY},
-		Locality:           []string{LOCALITY},
-		Province:           []string{STATE},
-		Organization:       []string{ORGANIZATION},
-		OrganizationalUnit: []string{ORGANIZATIONAL_UNIT},
-		CommonName:         COMMON_NAME,
-		SerialNumber:       "666",
+	notBefore := opts.NotBefore
+	if notBefore.IsZero() {
+		notBefore = time.Now()
 	}
+	notAfter := opts.NotAfter
+	if notAfter.IsZero() {
+		notAfter = notBefore.Add(defaultValidity)
+	}
+	if !notAfter.After(notBefore) {
+		return nil, fmt.Errorf("the certificate must expire after it becomes valid")
+	}
+
 	certTemplate := x509.Certificate{
 		Subject:               certSubject,
-		NotBefore:             time.Unix(0, 0),
-		NotAfter:              time.Now().Add(time.Hour * 24 * 365 * 10), // 10 years from now
-		KeyUsage:              x509.KeyUsageKeyEncipherment | x509.KeyUsageDigitalSignature,
+		NotBefore:             notBefore,
+		NotAfter:              notAfter,
+		KeyUsage:              keyUsage,
 		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
 		BasicConstraintsValid: true,
-		SerialNumber:          big.NewInt(666),
+		SerialNumber:          serialNumber,
 	}
 
-	certBytes, err := x509.CreateCertificate(rand.Reader, &certTemplate, &certTemplate, &privateKey.PublicKey, privateKey)
+	certBytes, err := x509.CreateCertificate(rand.Reader, &certTemplate, &certTemplate, privateKey.Public(), privateKey)
 	if err != nil {
 		return nil, extensionerrors.AddStackToError(err)
 	}
@@ -73,7 +97,43 @@ func NewSelfSignedx509Certificate() (*SelfSignedCertificateKey, error) {
 		return nil, extensionerrors.AddStackToError(err)
 	}
 
-	return &SelfSignedCertificateKey{Cert: *x509Cert, PrivKey: *privateKey}, nil
+	cert := &SelfSignedCertificateKey{Cert: *x509Cert, PrivateKey: privateKey}
+	if rsaKey, ok := privateKey.(*rsa.PrivateKey); ok {
+		cert.PrivKey = *rsaKey
+	}
+	return cert, nil
+}
+
+// generateKey creates a key of the given type and returns it along with the key usages it supports
+func generateKey(keyType KeyType) (crypto.Signer, x509.KeyUsage, error) {
+	var key crypto.Signer
+	var err error
+	switch keyType {
+	case RSA2048:
+		key, err = rsa.GenerateKey(rand.Reader, 2048)
+	case RSA3072:
+		key, err = rsa.GenerateKey(rand.Reader, 3072)
+	case RSA4096:
+		key, err = rsa.GenerateKey(rand.Reader, 4096)
+	case ECDSAP256:
+		key, err = ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	case ECDSAP384:
+		key, err = ecdsa.GenerateKey(elliptic.P384(), rand.Reader)
+	default:
+		return nil, 0, fmt.Errorf("unsupported key type %d", keyType)
+	}
+	if err != nil {
+		return nil, 0, extensionerrors.AddStackToError(err)
+	}
+
+	if _, isRSA := key.(*rsa.PrivateKey); isRSA {
+		return key, x509.KeyUsageKeyEncipherment | x509.KeyUsageDigitalSignature, nil
+	}
+	return key, x509.KeyUsageKeyAgreement | x509.KeyUsageDigitalSignature, nil
+}
+
+func isEmptySubject(name pkix.Name) bool {
+	return len(name.ToRDNSequence()) == 0
 }
 
 func (cert *SelfSignedCertificateKey) WriteCertificateToDisk(certificateOutputPath string) error {
@@ -96,7 +156,12 @@ func (cert *SelfSignedCertificateKey) WriteKeyToDisk(keyOutputPath string) error
 	if err != nil {
 		return extensionerrors.AddStackToError(err)
 	}
-	if err := pem.Encode(keyFH, &pem.Block{Type: "RSA PRIVATE KEY", Bytes: x509.MarshalPKCS1PrivateKey(&cert.PrivKey)}); err != nil {
+	block, err := cert.privateKeyPEMBlock()
+	if err != nil {
+		keyFH.Close()
+		return err
+	}
+	if err := pem.Encode(keyFH, block); err != nil {
 		return extensionerrors.AddStackToError(err)
 	}
 	if err := keyFH.Close(); err != nil {
@@ -105,6 +170,24 @@ func (cert *SelfSignedCertificateKey) WriteKeyToDisk(keyOutputPath string) error
 	return nil
 }
 
+// privateKeyPEMBlock encodes RSA keys as PKCS#1 and ECDSA keys as SEC 1
+func (cert *SelfSignedCertificateKey) privateKeyPEMBlock() (*pem.Block, error) {
+	switch key := cert.PrivateKey.(type) {
+	case nil:
+		return &pem.Block{Type: "RSA PRIVATE KEY", Bytes: x509.MarshalPKCS1PrivateKey(&cert.PrivKey)}, nil
+	case *rsa.PrivateKey:
+		return &pem.Block{Type: "RSA PRIVATE KEY", Bytes: x509.MarshalPKCS1PrivateKey(key)}, nil
+	case *ecdsa.PrivateKey:
+		keyBytes, err := x509.MarshalECPrivateKey(key)
+		if err != nil {
+			return nil, extensionerrors.AddStackToError(err)
+		}
+		return &pem.Block{Type: "EC PRIVATE KEY", Bytes: keyBytes}, nil
+	default:
+		return nil, fmt.Errorf("unsupported private key type %T", key)
+	}
+}
+
 func (cert *SelfSignedCertificateKey) GetCertificateThumbprint() string {
 	sigBytes := sha1.Sum(cert.Cert.Raw)
 	return strings.ToUpper(hex.EncodeToString(sigBytes[:]))
@@ -3,10 +3,19 @@
 package crypto
 
 import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rsa"
 	"crypto/tls"
+	"crypto/x509/pkix"
 	"fmt"
+	"math/big"
 	"os"
+	"path/filepath"
 	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
 )
 
 var TestInit = func() { os.Mkdir("./testoutput", 0770) }
@@ -74,3 +83,96 @@ func TestCreateSelfSignedCertificate_CertificatesAndKeysAreExclusive(t *testing.
 		fmt.Printf("Mismatched Cert and key didn't match as expected: %s\n", err.Error())
 	}
 }
+
+func writeAndLoadKeyPair(t *testing.T, cert *SelfSignedCertificateKey) {
+	certPath := filepath.Join(t.TempDir(), "cert.crt")
+	keyPath := filepath.Join(t.TempDir(), "cert.prv")
+	require.NoError(t, cert.WriteCertificateToDisk(certPath))
+	require.NoError(t, cert.WriteKeyToDisk(keyPath))
+	_, err := tls.LoadX509KeyPair(certPath, keyPath)
+	require.NoError(t, err, "certificate and key don't match")
+}
+
+func TestCreateSelfSignedCertificate_DefaultOptions(t *testing.T) {
+	cert, err := NewSelfSignedx509Certificate()
+	require.NoError(t, err)
+
+	rsaKey, ok := cert.Cert.PublicKey.(*rsa.PublicKey)
+	require.True(t, ok, "default key should be RSA")
+	require.Equal(t, 2048, rsaKey.N.BitLen())
+	require.Equal(t, COMMON_NAME, cert.Cert.Subject.CommonName)
+	require.NotEqual(t, int64(666), cert.Cert.SerialNumber.Int64())
+	require.True(t, cert.Cert.NotAfter.After(time.Now().Add(time.Hour*24*365*9)))
+	require.Equal(t, 2048, cert.PrivKey.N.BitLen(), "PrivKey should be set for RSA keys")
+}
+
+func TestCreateSelfSignedCertificate_KeyTypes(t *testing.T) {
+	for _, tc := range []struct {
+		keyType KeyType
+		check   func(t *testing.T, publicKey interface{})
+	}{
+		{RSA2048, func(t *testing.T, publicKey interface{}) {
+			require.Equal(t, 2048, publicKey.(*rsa.PublicKey).N.BitLen())
+		}},
+		{RSA3072, func(t *testing.T, publicKey interface{}) {
+			require.Equal(t, 3072, publicKey.(*rsa.PublicKey).N.BitLen())
+		}},
+		{RSA4096, func(t *testing.T, publicKey interface{}) {
+			require.Equal(t, 4096, publicKey.(*rsa.PublicKey).N.BitLen())
+		}},
+		{ECDSAP256, func(t *testing.T, publicKey interface{}) {
+			require.Equal(t, elliptic.P256(), publicKey.(*ecdsa.PublicKey).Curve)
+		}},
+		{ECDSAP384, func(t *testing.T, publicKey interface{}) {
+			require.Equal(t, elliptic.P384(), publicKey.(*ecdsa.PublicKey).Curve)
+		}},
+	} {
+		t.Run(fmt.Sprintf("%d", tc.keyType), func(t *testing.T) {
+			cert, err := NewSelfSignedx509CertificateWithOptions(CertificateOptions{KeyType: tc.keyType})
+			require.NoError(t, err)
+			tc.check(t, cert.Cert.PublicKey)
+			writeAndLoadKeyPair(t, cert)
+		})
+	}
+}
+
+func TestCreateSelfSignedCertificate_UnsupportedKeyType(t *testing.T) {
+	_, err := NewSelfSignedx509CertificateWithOptions(CertificateOptions{KeyType: KeyType(42)})
+	require.Error(t, err)
+}
+
+func TestCreateSelfSignedCertificate_SubjectSerialAndValidity(t *testing.T) {
+	notBefore := time.Now().Add(-time.Hour).UTC().Truncate(time.Second)
+	notAfter := notBefore.Add(time.Hour * 24 * 30)
+	cert, err := NewSelfSignedx509CertificateWithOptions(CertificateOptions{
+		Subject:      pkix.Name{CommonName: "chipmunk", Organization: []string{"yaba"}},
+		SerialNumber: big.NewInt(42),
+		NotBefore:    notBefore,
+		NotAfter:     notAfter,
+	})
+	require.NoError(t, err)
+	require.Equal(t, "chipmunk", cert.Cert.Subject.CommonName)
+	require.Equal(t, []string{"yaba"}, cert.Cert.Subject.Organization)
+	require.Equal(t, "chipmunk", cert.Cert.Issuer.CommonName)
+	require.Equal(t, int64(42), cert.Cert.SerialNumber.Int64())
+	require.True(t, notBefore.Equal(cert.Cert.NotBefore))
+	require.True(t, notAfter.Equal(cert.Cert.NotAfter))
+}
+
+func TestCreateSelfSignedCertificate_RandomSerialNumbers(t *testing.T) {
+	cert1, err := NewSelfSignedx509CertificateWithOptions(CertificateOptions{KeyType: ECDSAP256})
+	require.NoError(t, err)
+	cert2, err := NewSelfSignedx509CertificateWithOptions(CertificateOptions{KeyType: ECDSAP256})
+	require.NoError(t, err)
+	require.Positive(t, cert1.Cert.SerialNumber.Sign())
+	require.NotEqual(t, 0, cert1.Cert.SerialNumber.Cmp(cert2.Cert.SerialNumber))
+}
+
+func TestCreateSelfSignedCertificate_InvalidOptions(t *testing.T) {
+	now := time.Now()
+	_, err := NewSelfSignedx509CertificateWithOptions(CertificateOptions{NotBefore: now, NotAfter: now.Add(-time.Hour)})
+	require.Error(t, err, "expiry before start should fail")
+
+	_, err = NewSelfSignedx509CertificateWithOptions(CertificateOptions{SerialNumber: big.NewInt(-1)})
+	require.Error(t, err, "negative serial number should fail")
+}
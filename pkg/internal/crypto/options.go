@@ -0,0 +1,51 @@
+// Copyright (c) Microsoft Corporation.
+// Licensed under the MIT License.
+package crypto
+
+import (
+	"crypto/x509/pkix"
+	"math/big"
+	"time"
+)
+
+const (
+	COUNTRY             = "US"
+	LOCALITY            = "Redmond"
+	COMMON_NAME         = "Hybrid Runbook Worker"
+	ORGANIZATIONAL_UNIT = "Azure Automation"
+	ORGANIZATION        = "Microsoft Corporation"
+	STATE               = "Washington"
+)
+
+// KeyType is the algorithm and size of the key of a self signed certificate
+type KeyType int
+
+const (
+	RSA2048 KeyType = iota
+	RSA3072
+	RSA4096
+	ECDSAP256
+	ECDSAP384
+)
+
+// CertificateOptions describe the self signed certificate to create. Zero values are replaced by defaults:
+// an RSA 2048 key, the default subject, a random serial number and a ten year validity starting now.
+type CertificateOptions struct {
+	KeyType      KeyType
+	Subject      pkix.Name
+	SerialNumber *big.Int
+	NotBefore    time.Time
+	NotAfter     time.Time
+}
+
+// DefaultCertificateSubject is the subject used when none is specified
+func DefaultCertificateSubject() pkix.Name {
+	return pkix.Name{
+		Country:            []string{COUNTRY},
+		Locality:           []string{LOCALITY},
+		Province:           []string{STATE},
+		Organization:       []string{ORGANIZATION},
+		OrganizationalUnit: []string{ORGANIZATIONAL_UNIT},
+		CommonName:         COMMON_NAME,
+	}
+}
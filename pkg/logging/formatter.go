@@ -0,0 +1,89 @@
+// Copyright (c) Microsoft Corporation.
+// Licensed under the MIT License.
+package logging
+
+import (
+	"bytes"
+	"encoding/json"
+	"strings"
+	"time"
+)
+
+// Entry is a single log statement
+type Entry struct {
+	Time           time.Time
+	Level          Level
+	Message        string
+	SequenceNumber *uint  // The sequence number being processed, if known
+	Operation      string // The operation being run, such as enable, if known
+	Pid            int
+	Caller         string // The file and line that logged the entry, e.g. enabledisable.go:42
+	Stack          string // The call stack, which is logged with errors
+}
+
+// Formatter turns log entries into the bytes written to the log. Formatters are called
+// with the logger locked, so they don't need to be safe for concurrent use.
+type Formatter interface {
+	Format(e *Entry) []byte
+}
+
+// TextFormatter writes entries as lines of text prefixed with the level and time, which is the
+// format ExtensionLogger has always used
+type TextFormatter struct {
+	OmitTimestamp bool // Leaves out the time, as done for standard output
+}
+
+// Format implements Formatter
+func (f *TextFormatter) Format(e *Entry) []byte {
+	var b bytes.Buffer
+	prefix := f.prefix(e)
+	b.WriteString(prefix)
+	b.WriteString(e.Message)
+	if !strings.HasSuffix(e.Message, "\n") {
+		b.WriteByte('\n')
+	}
+	if e.Stack != "" {
+		b.WriteString(prefix)
+		b.WriteString(e.Stack)
+		b.WriteByte('\n')
+	}
+	return b.Bytes()
+}
+
+func (f *TextFormatter) prefix(e *Entry) string {
+	prefix := e.Level.String() + " "
+	if !f.OmitTimestamp {
+		prefix += e.Time.UTC().Format("2006/01/02 15:04:05 ")
+	}
+	return prefix
+}
+
+// JSONFormatter writes each entry as a line of JSON for log pipelines
+type JSONFormatter struct{}
+
+type jsonEntry struct {
+	Timestamp      string `json:"timestamp"`
+	Level          string `json:"level"`
+	SequenceNumber *uint  `json:"seqNo,omitempty"`
+	Operation      string `json:"operation,omitempty"`
+	Pid            int    `json:"pid"`
+	Caller         string `json:"caller,omitempty"`
+	Message        string `json:"message"`
+	Stack          string `json:"stack,omitempty"`
+}
+
+// Format implements Formatter
+func (f *JSONFormatter) Format(e *Entry) []byte {
+	// a struct of strings and numbers always marshals
+	b, _ := json.Marshal(jsonEntry{
+		Timestamp:      e.Time.UTC().Format(time.RFC3339Nano),
+		Level:          e.Level.String(),
+		SequenceNumber: e.SequenceNumber,
+		Operation:      e.Operation,
+		Pid:            e.Pid,
+		Caller:         e.Caller,
+		Message:        strings.TrimSuffix(e.Message, "\n"),
+		Stack:          e.Stack,
+	})
+	return append(b, '\n')
+}
@@ -0,0 +1,90 @@
+// Copyright (c) Microsoft Corporation.
+// Licensed under the MIT License.
+package logging
+
+import (
+	"bytes"
+	"encoding/json"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func newBufferLogger(formatter Formatter) (*ExtensionLogger, *bytes.Buffer) {
+	var b bytes.Buffer
+	return &ExtensionLogger{writer: &b, formatter: formatter, level: DefaultLevel, stackTraceLevel: DefaultStackTraceLevel}, &b
+}
+
+func readJSONLines(t *testing.T, b *bytes.Buffer) []map[string]interface{} {
+	var entries []map[string]interface{}
+	for _, line := range strings.Split(strings.TrimSuffix(b.String(), "\n"), "\n") {
+		var entry map[string]interface{}
+		require.NoError(t, json.Unmarshal([]byte(line), &entry), line)
+		entries = append(entries, entry)
+	}
+	return entries
+}
+
+func Test_textFormatter(t *testing.T) {
+	e := &Entry{Time: time.Date(2021, 3, 4, 5, 6, 7, 0, time.UTC), Level: LevelWarning, Message: "yaba"}
+	require.Equal(t, "Warning 2021/03/04 05:06:07 yaba\n", string((&TextFormatter{}).Format(e)))
+	require.Equal(t, "Warning yaba\n", string((&TextFormatter{OmitTimestamp: true}).Format(e)))
+
+	e.Stack = "stack"
+	require.Equal(t, "Warning yaba\nWarning stack\n", string((&TextFormatter{OmitTimestamp: true}).Format(e)))
+}
+
+func Test_jsonFormatterFields(t *testing.T) {
+	el, b := newBufferLogger(&JSONFormatter{})
+	el.Info("before the operation is known")
+	el.SetOperation("enable")
+	el.SetSequenceNumber(3)
+	el.Warn("something weird %s", "happened")
+	el.Error("we ran out of cupcakes")
+
+	entries := readJSONLines(t, b)
+	require.Len(t, entries, 3)
+
+	require.Equal(t, "Info", entries[0]["level"])
+	require.NotContains(t, entries[0], "seqNo")
+	require.NotContains(t, entries[0], "operation")
+
+	warn := entries[1]
+	require.Equal(t, "Warning", warn["level"])
+	require.Equal(t, "something weird happened", warn["message"])
+	require.Equal(t, float64(3), warn["seqNo"])
+	require.Equal(t, "enable", warn["operation"])
+	require.NotZero(t, warn["pid"])
+	require.Regexp(t, `^formatter_test\.go:\d+$`, warn["caller"])
+	_, err := time.Parse(time.RFC3339Nano, warn["timestamp"].(string))
+	require.NoError(t, err)
+	require.NotContains(t, warn, "stack")
+
+	require.Equal(t, "Error", entries[2]["level"])
+	require.Contains(t, entries[2]["stack"], "goroutine")
+}
+
+func Test_jsonFormatterStream(t *testing.T) {
+	el, b := newBufferLogger(&JSONFormatter{})
+	el.InfoFromStream("output: ", strings.NewReader("line 1\nline 2\n"))
+
+	entries := readJSONLines(t, b)
+	require.Len(t, entries, 1)
+	require.Equal(t, "output: line 1\nline 2", entries[0]["message"])
+	require.Regexp(t, `^formatter_test\.go:\d+$`, entries[0]["caller"])
+}
+
+func Test_setFormatter(t *testing.T) {
+	el, b := newBufferLogger(&TextFormatter{})
+	el.SetFormatter(&JSONFormatter{})
+	el.Info("yaba")
+	require.True(t, json.Valid(bytes.TrimSpace(b.Bytes())))
+
+	// nil restores the text format, without a timestamp for standard output
+	b.Reset()
+	el.SetFormatter(nil)
+	el.Info("yaba")
+	require.Equal(t, "Info yaba\n", b.String())
+}
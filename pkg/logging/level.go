@@ -0,0 +1,86 @@
+// Copyright (c) Microsoft Corporation.
+// Licensed under the MIT License.
+package logging
+
+import (
+	"fmt"
+	"os"
+	"strings"
+)
+
+// LogLevelEnvVar overrides the minimum level logged, for example to turn on debug logging
+// of an extension on a single VM
+const LogLevelEnvVar = "AZURE_EXTENSION_LOG_LEVEL"
+
+// Level is the severity of a log entry. Higher levels are more verbose.
+type Level int
+
+const (
+	LevelNone Level = iota - 1 // Lower than any entry: as the minimum level nothing is logged, as the stack trace level no stacks are attached
+	LevelError
+	LevelWarning
+	LevelInfo
+	LevelVerbose
+	LevelDebug
+)
+
+const (
+	// DefaultLevel is the minimum level logged unless configured otherwise
+	DefaultLevel = LevelInfo
+
+	// DefaultStackTraceLevel is the level up to which entries have the call stack attached
+	DefaultStackTraceLevel = LevelError
+)
+
+// String returns the name of the level as written to the log
+func (l Level) String() string {
+	switch l {
+	case LevelNone:
+		return "None"
+	case LevelError:
+		return "Error"
+	case LevelWarning:
+		return "Warning"
+	case LevelInfo:
+		return "Info"
+	case LevelVerbose:
+		return "Verbose"
+	case LevelDebug:
+		return "Debug"
+	default:
+		return "Unknown"
+	}
+}
+
+// ParseLevel parses the name of a level, ignoring case. "warn" is accepted for warning.
+func ParseLevel(s string) (Level, error) {
+	switch strings.ToLower(strings.TrimSpace(s)) {
+	case "none":
+		return LevelNone, nil
+	case "error":
+		return LevelError, nil
+	case "warning", "warn":
+		return LevelWarning, nil
+	case "info":
+		return LevelInfo, nil
+	case "verbose":
+		return LevelVerbose, nil
+	case "debug":
+		return LevelDebug, nil
+	default:
+		return LevelNone, fmt.Errorf("unknown log level '%s'", s)
+	}
+}
+
+// LevelFromEnvironment returns the level set by LogLevelEnvVar, and false if it isn't set
+func LevelFromEnvironment() (Level, bool, error) {
+	s := os.Getenv(LogLevelEnvVar)
+	if s == "" {
+		return LevelNone, false, nil
+	}
+	l, err := ParseLevel(s)
+	if err != nil {
+		return LevelNone, false, fmt.Errorf("environment variable %s: %w", LogLevelEnvVar, err)
+	}
+	return l, true, nil
+}
@@ -0,0 +1,94 @@
+// Copyright (c) Microsoft Corporation.
+// Licensed under the MIT License.
+package logging
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func Test_parseLevel(t *testing.T) {
+	for s, expected := range map[string]Level{
+		"none":    LevelNone,
+		"Error":   LevelError,
+		"warn":    LevelWarning,
+		"WARNING": LevelWarning,
+		" info ":  LevelInfo,
+		"verbose": LevelVerbose,
+		"debug":   LevelDebug,
+	} {
+		l, err := ParseLevel(s)
+		require.NoError(t, err, s)
+		require.Equal(t, expected, l, s)
+	}
+
+	_, err := ParseLevel("chatty")
+	require.Error(t, err)
+}
+
+func Test_levelFiltering(t *testing.T) {
+	el, b := newBufferLogger(&TextFormatter{OmitTimestamp: true})
+	el.Verbose("not logged by default")
+	el.Debug("not logged by default")
+	el.Info("yaba")
+	require.Equal(t, "Info yaba\n", b.String())
+
+	b.Reset()
+	el.SetLevel(LevelDebug)
+	require.True(t, el.Enabled(LevelDebug))
+	el.Verbose("flip %d", 1)
+	el.Debug("flop %d", 2)
+	require.Equal(t, "Verbose flip 1\nDebug flop 2\n", b.String())
+
+	b.Reset()
+	el.SetLevel(LevelWarning)
+	require.False(t, el.Enabled(LevelInfo))
+	el.Info("dropped")
+	el.InfoFromStream("dropped: ", strings.NewReader("stream"))
+	el.Warn("kept")
+	require.Equal(t, "Warning kept\n", b.String())
+
+	b.Reset()
+	el.SetLevel(LevelNone)
+	el.Error("dropped")
+	require.Empty(t, b.String())
+}
+
+func Test_stackTraceLevel(t *testing.T) {
+	el, b := newBufferLogger(&TextFormatter{OmitTimestamp: true})
+	el.Warn("no stack")
+	require.Equal(t, "Warning no stack\n", b.String())
+
+	b.Reset()
+	el.SetStackTraceLevel(LevelWarning)
+	el.Warn("with stack")
+	require.Contains(t, b.String(), "goroutine")
+
+	b.Reset()
+	el.SetStackTraceLevel(LevelNone)
+	el.Error("no stack")
+	require.Equal(t, "Error no stack\n", b.String())
+}
+
+func Test_levelFromEnvironment(t *testing.T) {
+	t.Setenv(LogLevelEnvVar, "")
+	_, isSet, err := LevelFromEnvironment()
+	require.NoError(t, err)
+	require.False(t, isSet)
+	require.Equal(t, LevelInfo, newStandardOutput().Level())
+
+	t.Setenv(LogLevelEnvVar, "Debug")
+	l, isSet, err := LevelFromEnvironment()
+	require.NoError(t, err)
+	require.True(t, isSet)
+	require.Equal(t, LevelDebug, l)
+	require.Equal(t, LevelDebug, newStandardOutput().Level())
+
+	t.Setenv(LogLevelEnvVar, "chatty")
+	_, isSet, err = LevelFromEnvironment()
+	require.Error(t, err)
+	require.False(t, isSet)
+	require.Equal(t, LevelInfo, newStandardOutput().Level())
+}
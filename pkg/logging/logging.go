@@ -5,19 +5,16 @@ package logging
 import (
 	"fmt"
 	"io"
-	"io/fs"
-	"io/ioutil"
-	"log"
 	"os"
 	"path"
 	"path/filepath"
+	"runtime"
 	"runtime/debug"
-	"sort"
-	"strconv"
-	"strings"
+	"sync"
 	"time"
 
 	"github.com/Azure/azure-extension-platform/pkg/handlerenv"
+	"github.com/Azure/azure-extension-platform/pkg/redact"
 )
 
 const (
@@ -26,13 +23,6 @@ const (
 	logLevelInfo    = "Info "
 )
 
-const (
-	thirtyMB            = 30 * 1024 * 1034 // 31,457,280 bytes
-	fortyMB             = 40 * 1024 * 1024 // 41,943,040 bytes
-	logDirThresholdLow  = thirtyMB
-	logDirThresholdHigh = fortyMB
-)
-
 type StreamLogReader interface {
 	ErrorFromStream(prefix string, streamReader io.Reader)
 	WarnFromStream(prefix string, streamReader io.Reader)
@@ -48,14 +38,28 @@ type ILogger interface {
 	Close()
 }
 
+// ILeveledLogger is an ILogger that can also log messages more verbose than Info
+type ILeveledLogger interface {
+	ILogger
+	Verbose(format string, v ...interface{})
+	Debug(format string, v ...interface{})
+}
+
 // ExtensionLogger exposes logging capabilities to the extension
 // It automatically appends time stamps and debug level to each message
 // and ensures all logs are placed in the logs folder passed by the agent
+// Messages more verbose than the level of the logger are dropped, and secrets
+// in the default redact registry are removed from the rest
 type ExtensionLogger struct {
-	errorLogger *log.Logger
-	infoLogger  *log.Logger
-	warnLogger  *log.Logger
-	file        *os.File
+	mu              sync.Mutex
+	writer          io.Writer
+	formatter       Formatter
+	file            *os.File
+	sequenceNumber  *uint
+	operation       string
+	level           Level         // The most verbose level logged
+	stackTraceLevel Level         // The most verbose level logged with the call stack
+	rotation        *fileRotation // Set for file loggers
 }
 
 // New creates a new logging instance. If the handlerEnvironment is nil, we'll use a
@@ -67,6 +71,11 @@ func New(he *handlerenv.HandlerEnvironment) *ExtensionLogger {
 // Allows the caller to specify their own name for the file
 // Supports cycling of logs to prevent filling up the disk
 func NewWithName(he *handlerenv.HandlerEnvironment, logFileFormat string) *ExtensionLogger {
+	return NewWithPolicy(he, logFileFormat, DefaultRotationPolicy())
+}
+
+// NewWithPolicy is NewWithName with control over how log files are rotated
+func NewWithPolicy(he *handlerenv.HandlerEnvironment, logFileFormat string, policy RotationPolicy) *ExtensionLogger {
 	if he == nil {
 		return newStandardOutput()
 	}
@@ -76,24 +85,25 @@ func NewWithName(he *handlerenv.HandlerEnvironment, logFileFormat string) *Exten
 	}
 
 	// Rotate log folder to prevent filling up the disk
-	err := rotateLogFolder(he.LogFolder, logFileFormat)
+	err := rotateLogFolderWithPolicy(he.LogFolder, logFileFormat, policy, "", "")
 	if err != nil {
 		return newStandardOutput()
 	}
 
-	fileName := fmt.Sprintf(logFileFormat, strconv.FormatInt(time.Now().UTC().Unix(), 10))
-	filePath := path.Join(he.LogFolder, fileName)
-	writer, err := os.OpenFile(filePath, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0666)
+	filePath := path.Join(he.LogFolder, logFileName(he.LogFolder, logFileFormat, false))
+	writer, size, err := openLogFile(filePath)
 	if err != nil {
 		return newStandardOutput()
 	}
 
-	return &ExtensionLogger{
-		errorLogger: log.New(writer, logLevelError, log.Ldate|log.Ltime|log.LUTC),
-		infoLogger:  log.New(writer, logLevelInfo, log.Ldate|log.Ltime|log.LUTC),
-		warnLogger:  log.New(writer, logLevelWarning, log.Ldate|log.Ltime|log.LUTC),
-		file:        writer,
+	logger := newExtensionLogger(writer, &TextFormatter{}, writer)
+	logger.rotation = &fileRotation{
+		logFolder:     he.LogFolder,
+		logFileFormat: logFileFormat,
+		policy:        policy,
+		size:          size,
 	}
+	return logger
 }
 
 func GetCallStack() string {
@@ -101,16 +111,83 @@ func GetCallStack() string {
 }
 
 func newStandardOutput() *ExtensionLogger {
-	return &ExtensionLogger{
-		errorLogger: log.New(os.Stdout, logLevelError, 0),
-		infoLogger:  log.New(os.Stdout, logLevelInfo, 0),
-		warnLogger:  log.New(os.Stdout, logLevelWarning, 0),
-		file:        nil,
+	return newExtensionLogger(os.Stdout, &TextFormatter{OmitTimestamp: true}, nil)
+}
+
+// newExtensionLogger creates a logger at the default level, or the level set by LogLevelEnvVar
+func newExtensionLogger(writer io.Writer, formatter Formatter, file *os.File) *ExtensionLogger {
+	logger := &ExtensionLogger{
+		writer:          writer,
+		formatter:       formatter,
+		file:            file,
+		level:           DefaultLevel,
+		stackTraceLevel: DefaultStackTraceLevel,
+	}
+	level, isSet, err := LevelFromEnvironment()
+	if isSet {
+		logger.level = level
+	} else if err != nil {
+		logger.Warn("%v", err)
 	}
+	return logger
+}
+
+// SetFormatter changes the format of the log. A nil formatter restores the default text format.
+func (logger *ExtensionLogger) SetFormatter(formatter Formatter) {
+	logger.mu.Lock()
+	defer logger.mu.Unlock()
+	if formatter == nil {
+		formatter = &TextFormatter{OmitTimestamp: logger.file == nil}
+	}
+	logger.formatter = formatter
+}
+
+// SetSequenceNumber adds the sequence number being processed to subsequent log entries
+func (logger *ExtensionLogger) SetSequenceNumber(seqNo uint) {
+	logger.mu.Lock()
+	defer logger.mu.Unlock()
+	logger.sequenceNumber = &seqNo
+}
+
+// SetOperation adds the operation being run to subsequent log entries
+func (logger *ExtensionLogger) SetOperation(operation string) {
+	logger.mu.Lock()
+	defer logger.mu.Unlock()
+	logger.operation = operation
+}
+
+// SetLevel sets the most verbose level logged. LevelNone turns off logging.
+func (logger *ExtensionLogger) SetLevel(level Level) {
+	logger.mu.Lock()
+	defer logger.mu.Unlock()
+	logger.level = level
+}
+
+// Level returns the most verbose level logged
+func (logger *ExtensionLogger) Level() Level {
+	logger.mu.Lock()
+	defer logger.mu.Unlock()
+	return logger.level
+}
+
+// Enabled returns true if messages of the level are logged, which lets callers skip building
+// expensive debug messages
+func (logger *ExtensionLogger) Enabled(level Level) bool {
+	return level <= logger.Level()
+}
+
+// SetStackTraceLevel sets the most verbose level logged with the call stack. By default only errors
+// have the call stack attached. LevelNone never attaches it.
+func (logger *ExtensionLogger) SetStackTraceLevel(level Level) {
+	logger.mu.Lock()
+	defer logger.mu.Unlock()
+	logger.stackTraceLevel = level
 }
 
 // Close closes the file
 func (logger *ExtensionLogger) Close() {
+	logger.mu.Lock()
+	defer logger.mu.Unlock()
 	if logger.file != nil {
 		logger.file.Close()
 	}
@@ -118,119 +195,120 @@ func (logger *ExtensionLogger) Close() {
 
 // Error logs an error. Format is the same as fmt.Print
 func (logger *ExtensionLogger) Error(format string, v ...interface{}) {
-	logger.errorLogger.Printf(format+"\n", v...)
-	logger.errorLogger.Printf(GetCallStack() + "\n")
+	logger.log(LevelError, format, v...)
 }
 
 // Warn logs a warning. Format is the same as fmt.Print
 func (logger *ExtensionLogger) Warn(format string, v ...interface{}) {
-	logger.warnLogger.Printf(format+"\n", v...)
+	logger.log(LevelWarning, format, v...)
 }
 
 // Info logs an information statement. Format is the same as fmt.Print
 func (logger *ExtensionLogger) Info(format string, v ...interface{}) {
-	logger.infoLogger.Printf(format+"\n", v...)
+	logger.log(LevelInfo, format, v...)
+}
+
+// Verbose logs details that help follow what the extension is doing. They aren't logged by default.
+// Format is the same as fmt.Print
+func (logger *ExtensionLogger) Verbose(format string, v ...interface{}) {
+	logger.log(LevelVerbose, format, v...)
+}
+
+// Debug logs diagnostic information. It isn't logged by default. Format is the same as fmt.Print
+func (logger *ExtensionLogger) Debug(format string, v ...interface{}) {
+	logger.log(LevelDebug, format, v...)
+}
+
+// log formats and writes the message if the level is enabled
+func (logger *ExtensionLogger) log(level Level, format string, v ...interface{}) {
+	if !logger.Enabled(level) {
+		return
+	}
+	e := logger.newEntry(level, fmt.Sprintf(format, v...))
+	logger.mu.Lock()
+	attachStack := level <= logger.stackTraceLevel
+	logger.mu.Unlock()
+	if attachStack {
+		e.Stack = GetCallStack()
+	}
+	logger.write(e)
+}
+
+// logStream writes the stream if the level is enabled, and otherwise drains it so
+// the writer on the other end isn't blocked
+func (logger *ExtensionLogger) logStream(level Level, prefix string, streamReader io.Reader) {
+	if !logger.Enabled(level) {
+		io.Copy(io.Discard, streamReader)
+		return
+	}
+	logger.writeStream(logger.newEntry(level, prefix), streamReader)
 }
 
 // Error logs an error. Get the message from a stream directly
 func (logger *ExtensionLogger) ErrorFromStream(prefix string, streamReader io.Reader) {
-	logger.errorLogger.Print(prefix)
-	io.Copy(logger.errorLogger.Writer(), streamReader)
-	logger.errorLogger.Writer().Write([]byte(fmt.Sprintln())) // add a newline at the end of the stream contents
+	logger.logStream(LevelError, prefix, streamReader)
 }
 
 // Warn logs a warning. Get the message from a stream directly
 func (logger *ExtensionLogger) WarnFromStream(prefix string, streamReader io.Reader) {
-	logger.warnLogger.Print(prefix)
-	io.Copy(logger.warnLogger.Writer(), streamReader)
-	logger.warnLogger.Writer().Write([]byte(fmt.Sprintln())) // add a newline at the end of the stream contents
+	logger.logStream(LevelWarning, prefix, streamReader)
 }
 
 // Info logs an information statement. Get the message from a stream directly
 func (logger *ExtensionLogger) InfoFromStream(prefix string, streamReader io.Reader) {
-	logger.infoLogger.Print(prefix)
-	io.Copy(logger.infoLogger.Writer(), streamReader)
-	logger.infoLogger.Writer().Write([]byte(fmt.Sprintln())) // add a newline at the end of the stream contents
+	logger.logStream(LevelInfo, prefix, streamReader)
 }
 
-// Function to get directory size
-func getDirSize(dirPath string) (size int64, err error) {
-	err = filepath.Walk(dirPath, func(_ string, info os.FileInfo, err error) error {
-
-		if err != nil {
-			return err
-		}
-
-		if !info.IsDir() {
-			size += info.Size()
-		}
-
-		return err
-	})
-
-	if err != nil {
-		err = fmt.Errorf("unable to compute directory size, error: %v", err)
+// newEntry creates an entry for the caller of the exported logging method
+func (logger *ExtensionLogger) newEntry(level Level, message string) *Entry {
+	e := &Entry{
+		Time:    time.Now(),
+		Level:   level,
+		Message: message,
+		Pid:     os.Getpid(),
 	}
-	return
-}
-
-// Function to rotate log files present in logFolder to avoid filling customer disk space
-// File name matching is done on file name pattern provided before '%'
-func rotateLogFolder(logFolder string, logFileFormat string) (err error) {
-	size, err := getDirSize(logFolder)
-	if err != nil {
-		return
+	// skip newEntry, log or logStream, and the logging method
+	if _, file, line, ok := runtime.Caller(3); ok {
+		e.Caller = fmt.Sprintf("%s:%d", filepath.Base(file), line)
 	}
+	return e
+}
 
-	// If directory size is still under high threshold value, nothing to do
-	if size < logDirThresholdHigh {
-		return
-	}
+func (logger *ExtensionLogger) write(e *Entry) {
+	logger.mu.Lock()
+	defer logger.mu.Unlock()
+	logger.wrote(logger.writeLocked(e))
+}
 
-	// Get all log files in logFolder
-	// Files are already sorted according to filenames
-	// Log file names contains unix timestamp as suffix, Thus we have files sorted according to age as well
-	var dirEntries []fs.FileInfo
+// writeLocked writes the entry and returns the number of bytes written
+func (logger *ExtensionLogger) writeLocked(e *Entry) int64 {
+	e.SequenceNumber = logger.sequenceNumber
+	e.Operation = logger.operation
+	e.Message = redact.Redact(e.Message)
+	e.Stack = redact.Redact(e.Stack)
+	n, _ := logger.writer.Write(logger.formatter.Format(e))
+	return int64(n)
+}
 
-	dirEntries, err = ioutil.ReadDir(logFolder)
-	if err != nil {
-		err = fmt.Errorf("unable to read log folder, error: %v", err)
+// writeStream logs the prefix followed by the contents of the stream. The text format copies the
+// stream to the log as it is read, redacting it a line at a time; other formats need the whole
+// stream to log it as a single entry.
+func (logger *ExtensionLogger) writeStream(e *Entry, streamReader io.Reader) {
+	logger.mu.Lock()
+	defer logger.mu.Unlock()
+
+	if _, isText := logger.formatter.(*TextFormatter); isText {
+		written := logger.writeLocked(e)
+		copied, _ := redact.Copy(logger.writer, streamReader)
+		n, _ := logger.writer.Write([]byte(fmt.Sprintln())) // add a newline at the end of the stream contents
+		logger.wrote(written + copied + int64(n))
 		return
 	}
 
-	// Sort directory entries according to time (oldest to newest)
-	sort.Slice(dirEntries, func(idx1, idx2 int) bool {
-		return dirEntries[idx1].ModTime().Before(dirEntries[idx2].ModTime())
-	})
-
-	// Get log file name prefix
-	logFilePrefix := strings.Split(logFileFormat, "%")
-
-	for _, file := range dirEntries {
-		// Once directory size goes below lower threshold limit, stop deletion
-		if size < logDirThresholdLow {
-			break
-		}
-
-		// Skip directories
-		if file.IsDir() {
-			continue
-		}
-
-		// log file names are prefixed according to logFileFormat specified
-		if !strings.HasPrefix(file.Name(), logFilePrefix[0]) {
-			continue
-		}
-
-		// Delete the file
-		err = os.Remove(filepath.Join(logFolder, file.Name()))
-		if err != nil {
-			err = fmt.Errorf("unable to delete log files, error: %v", err)
-			return
-		}
-
-		// Subtract file size from total directory size
-		size = size - file.Size()
+	contents, err := io.ReadAll(streamReader)
+	e.Message += string(contents)
+	if err != nil {
+		e.Message += fmt.Sprintf(" (failed to read the stream: %v)", err)
 	}
-	return
+	logger.wrote(logger.writeLocked(e))
 }
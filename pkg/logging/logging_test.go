@@ -15,6 +15,7 @@ import (
 
 	"github.com/Azure/azure-extension-platform/pkg/constants"
 	"github.com/Azure/azure-extension-platform/pkg/handlerenv"
+	"github.com/Azure/azure-extension-platform/pkg/redact"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
 )
@@ -227,3 +228,18 @@ func Test_rotateLogFolder(t *testing.T) {
 	size, _ := getDirSize(logtestdir)
 	require.LessOrEqual(t, size, int64(logDirThresholdHigh))
 }
+
+func Test_secretsAreRedacted(t *testing.T) {
+	redact.AddValue("chipmunkkey")
+	defer redact.Default().Clear()
+
+	el, b := newBufferLogger(&TextFormatter{OmitTimestamp: true})
+	el.Info("key is %s", "chipmunkkey")
+	el.InfoFromStream("output: ", strings.NewReader("echo chipmunkkey\n"))
+	require.Equal(t, "Info key is [REDACTED]\nInfo output: \necho [REDACTED]\n\n", b.String(), "the stream is logged as it is without secrets")
+
+	b.Reset()
+	el.SetFormatter(&JSONFormatter{})
+	el.Error("bad key chipmunkkey")
+	require.NotContains(t, b.String(), "chipmunkkey")
+}
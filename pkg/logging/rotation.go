@@ -0,0 +1,346 @@
+// Copyright (c) Microsoft Corporation.
+// Licensed under the MIT License.
+package logging
+
+import (
+	"compress/gzip"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	thirtyMB            = 30 * 1024 * 1024 // 31,457,280 bytes
+	fortyMB             = 40 * 1024 * 1024 // 41,943,040 bytes
+	logDirThresholdLow  = thirtyMB
+	logDirThresholdHigh = fortyMB
+
+	compressedLogFileSuffix = ".gz"
+)
+
+// RotationPolicy controls how log files are rotated to prevent filling up the disk.
+// Log files are the files in the log folder named with the prefix of the log file format,
+// the part before '%'. A zero field turns off that part of the policy.
+type RotationPolicy struct {
+	// MaxFolderSize is the size of the log folder in bytes above which the oldest log files are deleted
+	MaxFolderSize int64
+
+	// TargetFolderSize is the size in bytes the log folder is brought under when it exceeds MaxFolderSize
+	TargetFolderSize int64
+
+	// MaxFiles is the number of log files kept, including the one being written
+	MaxFiles int
+
+	// MaxAge is how long log files are kept after they were last written
+	MaxAge time.Duration
+
+	// MaxFileSize is the size in bytes at which a new log file is started, so a long running
+	// process doesn't write a single file that can't be rotated
+	MaxFileSize int64
+
+	// Compress gzips log files once they are no longer written: the file a logger rolls over from,
+	// and when a logger is created, the files of earlier processes other than the most recently
+	// written one, which a process still running may be writing.
+	Compress bool
+}
+
+// DefaultRotationPolicy returns the policy used by New and NewWithName, which deletes the oldest
+// log files once the log folder reaches 40MB until it is below 30MB
+func DefaultRotationPolicy() RotationPolicy {
+	return RotationPolicy{
+		MaxFolderSize:    logDirThresholdHigh,
+		TargetFolderSize: logDirThresholdLow,
+	}
+}
+
+// fileRotation is the state a file logger needs to roll over to a new file
+type fileRotation struct {
+	logFolder     string
+	logFileFormat string
+	policy        RotationPolicy
+	size          int64 // bytes in the current file
+	errorLogged   bool  // set once a rotation error has been logged, so it isn't logged for every entry
+}
+
+// openLogFile opens the log file for appending and returns its current size
+func openLogFile(filePath string) (*os.File, int64, error) {
+	file, err := os.OpenFile(filePath, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0666)
+	if err != nil {
+		return nil, 0, err
+	}
+	var size int64
+	if fi, err := file.Stat(); err == nil {
+		size = fi.Size()
+	}
+	return file, size, nil
+}
+
+// logFileName returns the name of a new log file. Processes started in the same second share a log
+// file, but a process rolling over needs a file of its own.
+func logFileName(logFolder string, logFileFormat string, unique bool) string {
+	timestamp := strconv.FormatInt(time.Now().UTC().Unix(), 10)
+	fileName := fmt.Sprintf(logFileFormat, timestamp)
+	for i := 1; unique && (fileExists(filepath.Join(logFolder, fileName)) || fileExists(filepath.Join(logFolder, fileName+compressedLogFileSuffix))); i++ {
+		fileName = fmt.Sprintf(logFileFormat, fmt.Sprintf("%s_%d", timestamp, i))
+	}
+	return fileName
+}
+
+func fileExists(filePath string) bool {
+	_, err := os.Stat(filePath)
+	return err == nil
+}
+
+// wrote records bytes written to the log file and starts a new file once it reaches MaxFileSize.
+// The logger must be locked.
+func (logger *ExtensionLogger) wrote(n int64) {
+	r := logger.rotation
+	if r == nil || logger.file == nil {
+		return
+	}
+	r.size += n
+	if r.policy.MaxFileSize <= 0 || r.size < r.policy.MaxFileSize {
+		return
+	}
+
+	// keep writing to the full file if a new one can't be opened, rather than trying again for every entry
+	r.size = 0
+	filePath := filepath.Join(r.logFolder, logFileName(r.logFolder, r.logFileFormat, true))
+	file, _, err := openLogFile(filePath)
+	if err != nil {
+		logger.rotationFailed(fmt.Errorf("unable to open a new log file, error: %v", err))
+		return
+	}
+
+	previousFile := logger.file.Name()
+	logger.file.Close()
+	logger.file = file
+	logger.writer = file
+	if err := rotateLogFolderWithPolicy(r.logFolder, r.logFileFormat, r.policy, filePath, previousFile); err != nil {
+		logger.rotationFailed(err)
+	}
+}
+
+// rotationFailed logs the first error rotating the log files, which doesn't stop the logger. The
+// logger must be locked.
+func (logger *ExtensionLogger) rotationFailed(err error) {
+	if logger.rotation.errorLogged {
+		return
+	}
+	logger.rotation.errorLogged = true
+	e := &Entry{Time: time.Now(), Level: LevelWarning, Message: fmt.Sprintf("log rotation failed: %v", err), Pid: os.Getpid()}
+	logger.rotation.size += logger.writeLocked(e)
+}
+
+// Function to get directory size
+func getDirSize(dirPath string) (size int64, err error) {
+	err = filepath.Walk(dirPath, func(_ string, info os.FileInfo, err error) error {
+
+		if err != nil {
+			return err
+		}
+
+		if !info.IsDir() {
+			size += info.Size()
+		}
+
+		return err
+	})
+
+	if err != nil {
+		err = fmt.Errorf("unable to compute directory size, error: %v", err)
+	}
+	return
+}
+
+// Function to rotate log files present in logFolder to avoid filling customer disk space
+// File name matching is done on file name pattern provided before '%'
+func rotateLogFolder(logFolder string, logFileFormat string) (err error) {
+	return rotateLogFolderWithPolicy(logFolder, logFileFormat, DefaultRotationPolicy(), "", "")
+}
+
+// rotateLogFolderWithPolicy compresses and deletes log files in logFolder as the policy requires.
+// currentFile is the path of the file being written, which is left alone. previousFile is the path
+// of the file the logger rolled over from, which is the only one compressed; if it is empty, every
+// file other than the most recently written one is compressed.
+func rotateLogFolderWithPolicy(logFolder string, logFileFormat string, policy RotationPolicy, currentFile string, previousFile string) (err error) {
+	// Get log file name prefix
+	logFilePrefix := strings.Split(logFileFormat, "%")[0]
+
+	files, err := getLogFiles(logFolder, logFilePrefix, currentFile)
+	if err != nil {
+		return
+	}
+
+	if policy.Compress {
+		newest := ""
+		for _, file := range files {
+			if !strings.HasSuffix(file.Name(), compressedLogFileSuffix) {
+				newest = file.Name()
+			}
+		}
+		for i, file := range files {
+			if strings.HasSuffix(file.Name(), compressedLogFileSuffix) {
+				continue
+			}
+			if previousFile != "" && file.Name() != filepath.Base(previousFile) {
+				continue
+			}
+			if previousFile == "" && file.Name() == newest {
+				// another process may still be writing it
+				continue
+			}
+			compressed, err := compressLogFile(filepath.Join(logFolder, file.Name()))
+			if err != nil {
+				return err
+			}
+			files[i] = compressed
+		}
+	}
+
+	// Files are sorted oldest to newest, so expired files and files beyond MaxFiles are at the start
+	deleteCount := 0
+	if policy.MaxAge > 0 {
+		cutoff := time.Now().Add(-policy.MaxAge)
+		for deleteCount < len(files) && files[deleteCount].ModTime().Before(cutoff) {
+			deleteCount++
+		}
+	}
+	if policy.MaxFiles > 0 {
+		// the current file counts towards MaxFiles, and one is about to be created if there is none
+		if excess := len(files) - (policy.MaxFiles - 1); excess > deleteCount {
+			deleteCount = excess
+		}
+	}
+	for _, file := range files[:deleteCount] {
+		if err = os.Remove(filepath.Join(logFolder, file.Name())); err != nil {
+			err = fmt.Errorf("unable to delete log files, error: %v", err)
+			return
+		}
+	}
+	files = files[deleteCount:]
+
+	if policy.MaxFolderSize <= 0 {
+		return
+	}
+	size, err := getDirSize(logFolder)
+	if err != nil {
+		return
+	}
+
+	// If directory size is still under high threshold value, nothing to do
+	if size < policy.MaxFolderSize {
+		return
+	}
+
+	for _, file := range files {
+		// Once directory size goes below lower threshold limit, stop deletion
+		if size < policy.TargetFolderSize {
+			break
+		}
+
+		// Delete the file
+		err = os.Remove(filepath.Join(logFolder, file.Name()))
+		if err != nil {
+			err = fmt.Errorf("unable to delete log files, error: %v", err)
+			return
+		}
+
+		// Subtract file size from total directory size
+		size = size - file.Size()
+	}
+	return
+}
+
+// getLogFiles returns the log files in logFolder other than currentFile, sorted by time (oldest to newest)
+func getLogFiles(logFolder string, logFilePrefix string, currentFile string) ([]fs.FileInfo, error) {
+	dirEntries, err := os.ReadDir(logFolder)
+	if err != nil {
+		return nil, fmt.Errorf("unable to read log folder, error: %v", err)
+	}
+
+	var files []fs.FileInfo
+	for _, entry := range dirEntries {
+		// Skip directories, and files not prefixed according to logFileFormat specified
+		if entry.IsDir() || !strings.HasPrefix(entry.Name(), logFilePrefix) {
+			continue
+		}
+		if currentFile != "" && entry.Name() == filepath.Base(currentFile) {
+			continue
+		}
+		info, err := entry.Info()
+		if err != nil {
+			// deleted since the folder was read
+			continue
+		}
+		files = append(files, info)
+	}
+
+	sort.SliceStable(files, func(idx1, idx2 int) bool {
+		return files[idx1].ModTime().Before(files[idx2].ModTime())
+	})
+	return files, nil
+}
+
+// compressLogFile replaces the file with a gzipped copy, which keeps its modification time so
+// it rotates in the same order. A compressed file is never overwritten: if the file was compressed
+// before, such as when processes started in the same second shared it, the copy gets another name.
+func compressLogFile(filePath string) (fs.FileInfo, error) {
+	src, err := os.Open(filePath)
+	if err != nil {
+		return nil, fmt.Errorf("unable to compress log file, error: %v", err)
+	}
+	defer src.Close()
+	srcInfo, err := src.Stat()
+	if err != nil {
+		return nil, fmt.Errorf("unable to compress log file, error: %v", err)
+	}
+
+	compressedPath := filePath + compressedLogFileSuffix
+	for i := 1; ; i++ {
+		err = writeCompressed(compressedPath, src)
+		if !os.IsExist(err) {
+			break
+		}
+		compressedPath = fmt.Sprintf("%s.%d%s", filePath, i, compressedLogFileSuffix)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("unable to compress log file, error: %v", err)
+	}
+	os.Chtimes(compressedPath, srcInfo.ModTime(), srcInfo.ModTime())
+	src.Close()
+	if err := os.Remove(filePath); err != nil {
+		return nil, fmt.Errorf("unable to delete compressed log file, error: %v", err)
+	}
+	return os.Stat(compressedPath)
+}
+
+// writeCompressed creates the compressed file, returning an error that satisfies os.IsExist if
+// it exists. The file is removed if it can't be written.
+func writeCompressed(compressedPath string, src io.Reader) (err error) {
+	dst, err := os.OpenFile(compressedPath, os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0666)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		dst.Close()
+		if err != nil {
+			os.Remove(compressedPath)
+		}
+	}()
+
+	zw := gzip.NewWriter(dst)
+	if _, err := io.Copy(zw, src); err != nil {
+		return err
+	}
+	if err := zw.Close(); err != nil {
+		return err
+	}
+	return dst.Close()
+}
@@ -0,0 +1,172 @@
+// Copyright (c) Microsoft Corporation.
+// Licensed under the MIT License.
+package logging
+
+import (
+	"compress/gzip"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/Azure/azure-extension-platform/pkg/handlerenv"
+	"github.com/stretchr/testify/require"
+)
+
+// writeOldLogFiles creates log files last written the given number of hours ago
+func writeOldLogFiles(t *testing.T, dir string, hoursAgo ...int) {
+	for _, h := range hoursAgo {
+		p := filepath.Join(dir, fmt.Sprintf("yaba_%dhoursago.log", h))
+		require.NoError(t, os.WriteFile(p, []byte("old log\n"), 0644))
+		modTime := time.Now().Add(-time.Duration(h) * time.Hour)
+		require.NoError(t, os.Chtimes(p, modTime, modTime))
+	}
+}
+
+func logFileNames(t *testing.T, dir string) []string {
+	entries, err := os.ReadDir(dir)
+	require.NoError(t, err)
+	var names []string
+	for _, entry := range entries {
+		names = append(names, entry.Name())
+	}
+	sort.Strings(names)
+	return names
+}
+
+func Test_thresholds(t *testing.T) {
+	require.Equal(t, 31457280, thirtyMB)
+	require.Equal(t, 41943040, fortyMB)
+}
+
+func Test_rotationMaxFiles(t *testing.T) {
+	dir := t.TempDir()
+	writeOldLogFiles(t, dir, 5, 4, 3, 2, 1)
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "other.txt"), nil, 0644))
+
+	el := NewWithPolicy(&handlerenv.HandlerEnvironment{LogFolder: dir}, "yaba_%v.log", RotationPolicy{MaxFiles: 3})
+	el.Info("yaba")
+	el.Close()
+
+	names := logFileNames(t, dir)
+	require.Len(t, names, 4, "two old log files, the new one and the unrelated file: %v", names)
+	require.Contains(t, names, "other.txt")
+	require.Contains(t, names, "yaba_2hoursago.log")
+	require.Contains(t, names, "yaba_1hoursago.log")
+}
+
+func Test_rotationMaxAge(t *testing.T) {
+	dir := t.TempDir()
+	writeOldLogFiles(t, dir, 72, 48, 1)
+
+	el := NewWithPolicy(&handlerenv.HandlerEnvironment{LogFolder: dir}, "yaba_%v.log", RotationPolicy{MaxAge: 24 * time.Hour})
+	el.Close()
+
+	names := logFileNames(t, dir)
+	require.Len(t, names, 2, "the file written an hour ago and the new one: %v", names)
+}
+
+func Test_rotationMaxFolderSize(t *testing.T) {
+	dir := t.TempDir()
+	writeOldLogFiles(t, dir, 4, 3, 2, 1)
+
+	// each old file is 8 bytes, so the two oldest are deleted to bring the folder under 20 bytes
+	el := NewWithPolicy(&handlerenv.HandlerEnvironment{LogFolder: dir}, "yaba_%v.log", RotationPolicy{MaxFolderSize: 30, TargetFolderSize: 20})
+	el.Close()
+
+	size, err := getDirSize(dir)
+	require.NoError(t, err)
+	require.Less(t, size, int64(20))
+	require.Len(t, logFileNames(t, dir), 3)
+}
+
+func Test_rotationMaxFileSize(t *testing.T) {
+	dir := t.TempDir()
+	el := NewWithPolicy(&handlerenv.HandlerEnvironment{LogFolder: dir}, "yaba_%v.log", RotationPolicy{MaxFileSize: 100, MaxFiles: 3})
+	for i := 0; i < 10; i++ {
+		el.Info("%s", strings.Repeat("x", 60))
+	}
+	el.InfoFromStream("stream: ", strings.NewReader(strings.Repeat("y", 200)))
+	el.Info("last")
+	el.Close()
+
+	names := logFileNames(t, dir)
+	require.Len(t, names, 3, "MaxFiles is applied when rolling over: %v", names)
+	var all strings.Builder
+	for _, name := range names {
+		b, err := os.ReadFile(filepath.Join(dir, name))
+		require.NoError(t, err)
+		require.LessOrEqual(t, len(b), 400, "files roll over once they reach MaxFileSize")
+		all.Write(b)
+	}
+	require.Contains(t, all.String(), strings.Repeat("y", 200), "streams aren't split across files")
+	require.Contains(t, all.String(), "last")
+}
+
+func Test_rotationCompress(t *testing.T) {
+	dir := t.TempDir()
+	writeOldLogFiles(t, dir, 2, 1)
+
+	el := NewWithPolicy(&handlerenv.HandlerEnvironment{LogFolder: dir}, "yaba_%v.log", RotationPolicy{MaxFileSize: 50, Compress: true})
+	el.Info("%s", strings.Repeat("x", 60))
+	el.Info("current")
+	el.Close()
+
+	var compressed, plain []string
+	for _, name := range logFileNames(t, dir) {
+		if strings.HasSuffix(name, compressedLogFileSuffix) {
+			compressed = append(compressed, name)
+		} else {
+			plain = append(plain, name)
+		}
+	}
+	require.Len(t, compressed, 2, "the older file and the rolled over file are compressed")
+	require.Len(t, plain, 2, "the current file and the newest old file, which may still be written, aren't compressed")
+	require.Contains(t, plain, "yaba_1hoursago.log")
+
+	var contents []string
+	for _, name := range compressed {
+		f, err := os.Open(filepath.Join(dir, name))
+		require.NoError(t, err)
+		zr, err := gzip.NewReader(f)
+		require.NoError(t, err)
+		b, err := io.ReadAll(zr)
+		require.NoError(t, err)
+		f.Close()
+		contents = append(contents, string(b))
+	}
+	require.Contains(t, strings.Join(contents, ""), "old log")
+	require.Contains(t, strings.Join(contents, ""), strings.Repeat("x", 60))
+}
+
+func Test_rotationCompressKeepsExistingCompressedFile(t *testing.T) {
+	dir := t.TempDir()
+	writeOldLogFiles(t, dir, 2)
+	old := filepath.Join(dir, "yaba_2hoursago.log")
+	_, err := compressLogFile(old)
+	require.NoError(t, err)
+
+	// a file with the same name is written again and compressed
+	writeOldLogFiles(t, dir, 2)
+	_, err = compressLogFile(old)
+	require.NoError(t, err)
+	require.Equal(t, []string{"yaba_2hoursago.log.1.gz", "yaba_2hoursago.log.gz"}, logFileNames(t, dir))
+}
+
+func Test_rotationErrorIsLoggedOnce(t *testing.T) {
+	dir := t.TempDir()
+	el := NewWithPolicy(&handlerenv.HandlerEnvironment{LogFolder: dir}, "yaba_%v.log", RotationPolicy{MaxFileSize: 50})
+	defer el.Close()
+	var b strings.Builder
+	el.writer = &b
+	// the new file can't be opened once the folder is gone
+	require.NoError(t, os.RemoveAll(dir))
+	for i := 0; i < 3; i++ {
+		el.Info("%s", strings.Repeat("x", 60))
+	}
+	require.Equal(t, 1, strings.Count(b.String(), "log rotation failed"), b.String())
+}
@@ -0,0 +1,276 @@
+// Copyright (c) Microsoft Corporation.
+// Licensed under the MIT License.
+package redact
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+)
+
+const (
+	// Placeholder replaces secrets in redacted text
+	Placeholder = "[REDACTED]"
+
+	// MinValueLength is the shortest secret value that is redacted. Shorter values, such as
+	// true or 443, would mangle unrelated text without hiding anything meaningful.
+	MinValueLength = 4
+
+	// streamChunkSize is how much of a stream Copy reads at a time
+	streamChunkSize = 32 * 1024
+
+	// maxStreamLine is how long a line Copy holds before redacting part of it
+	maxStreamLine = 64 * 1024
+)
+
+// Registry holds the secrets to remove from text written to disk, such as logs, events and status.
+// It is safe for concurrent use.
+type Registry struct {
+	mu       sync.RWMutex
+	values   map[string]struct{}
+	patterns []*regexp.Regexp
+	replacer *strings.Replacer // built from values when needed, nil after values change
+	sorted   []string          // values longest first, built with replacer
+}
+
+var defaultRegistry = NewRegistry()
+
+// NewRegistry returns an empty Registry
+func NewRegistry() *Registry {
+	return &Registry{values: map[string]struct{}{}}
+}
+
+// Default returns the registry used by the logger, extension events and status reports
+func Default() *Registry {
+	return defaultRegistry
+}
+
+// AddValue adds a secret value. Values shorter than MinValueLength are ignored.
+func (r *Registry) AddValue(value string) {
+	if len(value) < MinValueLength {
+		return
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if _, exists := r.values[value]; !exists {
+		r.values[value] = struct{}{}
+		r.replacer, r.sorted = nil, nil
+	}
+}
+
+// AddPattern adds a regular expression whose matches are secrets, such as a SAS token signature
+func (r *Registry) AddPattern(pattern string) error {
+	re, err := regexp.Compile(pattern)
+	if err != nil {
+		return err
+	}
+	r.AddRegexp(re)
+	return nil
+}
+
+// AddRegexp adds a compiled regular expression whose matches are secrets
+func (r *Registry) AddRegexp(re *regexp.Regexp) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.patterns = append(r.patterns, re)
+}
+
+// AddProtectedSettings adds every string value in the decrypted protected settings. Settings
+// that aren't JSON are added as a whole.
+func (r *Registry) AddProtectedSettings(protectedSettings string) {
+	protectedSettings = strings.TrimSpace(protectedSettings)
+	if protectedSettings == "" {
+		return
+	}
+
+	var v interface{}
+	if err := json.Unmarshal([]byte(protectedSettings), &v); err != nil {
+		r.AddValue(protectedSettings)
+		return
+	}
+	r.addJSONValues(v)
+}
+
+func (r *Registry) addJSONValues(v interface{}) {
+	switch t := v.(type) {
+	case string:
+		r.AddValue(t)
+	case map[string]interface{}:
+		for _, child := range t {
+			r.addJSONValues(child)
+		}
+	case []interface{}:
+		for _, child := range t {
+			r.addJSONValues(child)
+		}
+	}
+}
+
+// IsEmpty returns true if there is nothing to redact
+func (r *Registry) IsEmpty() bool {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	return len(r.values) == 0 && len(r.patterns) == 0
+}
+
+// Clear removes all values and patterns
+func (r *Registry) Clear() {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.values = map[string]struct{}{}
+	r.patterns = nil
+	r.replacer, r.sorted = nil, nil
+}
+
+// Redact returns the text with every secret replaced by Placeholder
+func (r *Registry) Redact(s string) string {
+	if s == "" {
+		return s
+	}
+
+	replacer, _, patterns := r.snapshot()
+	if replacer != nil {
+		s = replacer.Replace(s)
+	}
+	for _, re := range patterns {
+		s = re.ReplaceAllLiteralString(s, Placeholder)
+	}
+	return s
+}
+
+// Copy copies src to dst with every secret replaced by Placeholder, without holding the whole
+// stream in memory. The stream is redacted a line at a time, holding back as much text as the
+// longest value so values spanning lines are still found. Patterns only match within a line, and
+// lines longer than 64KB are split. It returns the number of bytes written to dst.
+func (r *Registry) Copy(dst io.Writer, src io.Reader) (int64, error) {
+	var written int64
+	emit := func(b []byte) error {
+		n, err := io.WriteString(dst, r.Redact(string(b)))
+		written += int64(n)
+		return err
+	}
+
+	var pending []byte
+	chunk := make([]byte, streamChunkSize)
+	for {
+		n, readErr := src.Read(chunk)
+		pending = append(pending, chunk[:n]...)
+		if cut := r.safeCut(pending); cut > 0 {
+			if err := emit(pending[:cut]); err != nil {
+				return written, err
+			}
+			pending = append(pending[:0], pending[cut:]...)
+		}
+		if readErr != nil {
+			if err := emit(pending); err != nil {
+				return written, err
+			}
+			if readErr == io.EOF {
+				readErr = nil
+			}
+			return written, readErr
+		}
+	}
+}
+
+// safeCut returns how much of the start of the text can be redacted on its own: the lines no
+// value can continue past, or most of a line that is too long to hold. It is zero if more text
+// is needed.
+func (r *Registry) safeCut(b []byte) int {
+	_, values, _ := r.snapshot()
+	window := 0
+	if len(values) > 0 {
+		window = len(values[0]) - 1
+	}
+	limit := len(b) - window
+	if limit <= 0 {
+		return 0
+	}
+	cut := bytes.LastIndexByte(b[:limit], '\n') + 1
+	if cut == 0 {
+		if len(b) < maxStreamLine {
+			return 0
+		}
+		cut = limit
+	}
+
+	// move the cut to the start of any value it would split
+	for moved := true; moved && cut > 0; {
+		moved = false
+		for _, v := range values {
+			start := cut - len(v) + 1
+			if start < 0 {
+				start = 0
+			}
+			if i := bytes.Index(b[start:cut+len(v)-1], []byte(v)); i >= 0 && start+i < cut {
+				cut = start + i
+				moved = true
+			}
+		}
+	}
+	return cut
+}
+
+// snapshot returns the replacer for the values and the values longest first, building them if
+// needed, and the patterns
+func (r *Registry) snapshot() (*strings.Replacer, []string, []*regexp.Regexp) {
+	r.mu.RLock()
+	replacer, sorted, patterns, count := r.replacer, r.sorted, r.patterns, len(r.values)
+	r.mu.RUnlock()
+	if replacer != nil || count == 0 {
+		return replacer, sorted, patterns
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.replacer == nil && len(r.values) > 0 {
+		values := make([]string, 0, len(r.values))
+		for v := range r.values {
+			values = append(values, v)
+		}
+		// the replacer prefers earlier arguments, so a secret containing another is replaced whole
+		sort.Slice(values, func(i, j int) bool {
+			if len(values[i]) != len(values[j]) {
+				return len(values[i]) > len(values[j])
+			}
+			return values[i] < values[j]
+		})
+		oldnew := make([]string, 0, 2*len(values))
+		for _, v := range values {
+			oldnew = append(oldnew, v, Placeholder)
+		}
+		r.replacer = strings.NewReplacer(oldnew...)
+		r.sorted = values
+	}
+	return r.replacer, r.sorted, r.patterns
+}
+
+// AddValue adds a secret value to the default registry
+func AddValue(value string) {
+	defaultRegistry.AddValue(value)
+}
+
+// AddPattern adds a regular expression to the default registry
+func AddPattern(pattern string) error {
+	return defaultRegistry.AddPattern(pattern)
+}
+
+// AddProtectedSettings adds the string values of the decrypted protected settings to the default registry
+func AddProtectedSettings(protectedSettings string) {
+	defaultRegistry.AddProtectedSettings(protectedSettings)
+}
+
+// Redact removes the secrets in the default registry from the text
+func Redact(s string) string {
+	return defaultRegistry.Redact(s)
+}
+
+// Copy copies src to dst with the secrets in the default registry removed
+func Copy(dst io.Writer, src io.Reader) (int64, error) {
+	return defaultRegistry.Copy(dst, src)
+}
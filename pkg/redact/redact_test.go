@@ -0,0 +1,98 @@
+// Copyright (c) Microsoft Corporation.
+// Licensed under the MIT License.
+package redact
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+	"sync"
+	"testing"
+	"testing/iotest"
+
+	"github.com/stretchr/testify/require"
+)
+
+func Test_redactValues(t *testing.T) {
+	r := NewRegistry()
+	require.True(t, r.IsEmpty())
+	require.Equal(t, "nothing to hide", r.Redact("nothing to hide"))
+
+	r.AddValue("hunter2")
+	r.AddValue("hunter22")
+	r.AddValue("abc") // too short
+	require.False(t, r.IsEmpty())
+	require.Equal(t, "password=[REDACTED] and [REDACTED], abc", r.Redact("password=hunter22 and hunter2, abc"))
+
+	r.Clear()
+	require.True(t, r.IsEmpty())
+	require.Equal(t, "hunter2", r.Redact("hunter2"))
+}
+
+func Test_redactPatterns(t *testing.T) {
+	r := NewRegistry()
+	require.Error(t, r.AddPattern("sig=("))
+	require.NoError(t, r.AddPattern(`sig=[A-Za-z0-9%]+`))
+	require.Equal(t, "https://yaba.blob.core.windows.net/c/f?sv=1&[REDACTED]", r.Redact("https://yaba.blob.core.windows.net/c/f?sv=1&sig=abc%3D"))
+}
+
+func Test_addProtectedSettings(t *testing.T) {
+	r := NewRegistry()
+	r.AddProtectedSettings(`{"storageAccountKey":"chipmunkkey","port":4433,"enabled":true,"commands":["echo flipflop", "ls"],"nested":{"token":"t0ken"}}`)
+	require.Equal(t, "[REDACTED] [REDACTED] [REDACTED] ls 4433 true", r.Redact("chipmunkkey echo flipflop t0ken ls 4433 true"))
+	require.Equal(t, "storageAccountKey", r.Redact("storageAccountKey"), "names aren't secrets")
+
+	r = NewRegistry()
+	r.AddProtectedSettings("not json but secret")
+	require.Equal(t, "[REDACTED]!", r.Redact("not json but secret!"))
+
+	r = NewRegistry()
+	r.AddProtectedSettings("  ")
+	require.True(t, r.IsEmpty())
+}
+
+func Test_redactConcurrently(t *testing.T) {
+	r := NewRegistry()
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			secret := fmt.Sprintf("secret%d", i)
+			r.AddValue(secret)
+			require.Equal(t, Placeholder, r.Redact(secret))
+		}(i)
+	}
+	wg.Wait()
+}
+
+func Test_copyRedactsStream(t *testing.T) {
+	r := NewRegistry()
+	r.AddValue("hunter2")
+	r.AddValue("-----BEGIN KEY-----\nchipmunk\n-----END KEY-----")
+	require.NoError(t, r.AddPattern(`sig=[a-z]+`))
+
+	// read a byte at a time, so every value spans reads
+	var b bytes.Buffer
+	in := "password hunter2\n-----BEGIN KEY-----\nchipmunk\n-----END KEY-----\nurl?sig=abc\nhunter2"
+	n, err := r.Copy(&b, iotest.OneByteReader(strings.NewReader(in)))
+	require.NoError(t, err)
+	require.Equal(t, "password [REDACTED]\n[REDACTED]\nurl?[REDACTED]\n[REDACTED]", b.String())
+	require.Equal(t, int64(b.Len()), n)
+
+	// a line too long to hold is split, but not within a value
+	b.Reset()
+	r = NewRegistry()
+	r.AddValue("hunter2")
+	long := strings.Repeat("x", maxStreamLine-9) + "hunter2" + strings.Repeat("y", maxStreamLine)
+	require.Equal(t, maxStreamLine-9, r.safeCut([]byte(long[:maxStreamLine])))
+	_, err = r.Copy(&b, strings.NewReader(long))
+	require.NoError(t, err)
+	require.Equal(t, strings.Repeat("x", maxStreamLine-9)+Placeholder+strings.Repeat("y", maxStreamLine), b.String())
+
+	// read errors are returned after copying what was read
+	b.Reset()
+	_, err = r.Copy(&b, iotest.TimeoutReader(iotest.HalfReader(strings.NewReader("hunter2 and more"))))
+	require.Error(t, err)
+	require.NotContains(t, b.String(), "hunter2")
+}
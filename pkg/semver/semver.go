@@ -0,0 +1,193 @@
+// Copyright (c) Microsoft Corporation.
+// Licensed under the MIT License.
+package semver
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// ErrInvalidVersion is returned for strings that aren't versions
+var ErrInvalidVersion = errors.New("invalid version")
+
+// Version is a semantic version. Extension versions may have a fourth, revision, component
+// such as 1.0.0.1, which is compared after the patch number.
+type Version struct {
+	Major      uint64
+	Minor      uint64
+	Patch      uint64
+	Revision   uint64
+	Prerelease string // the dot separated identifiers after '-', e.g. rc.1
+	Build      string // the build metadata after '+', which is ignored for comparisons
+}
+
+// Parse parses versions such as 1.2.3, 1.2.3.4, 1.2, v1.2.3-rc.1 and 1.2.3+build.5.
+// Missing minor and patch numbers are 0.
+func Parse(s string) (Version, error) {
+	var v Version
+	rest := strings.TrimPrefix(strings.TrimSpace(s), "v")
+
+	if i := strings.IndexByte(rest, '+'); i >= 0 {
+		v.Build = rest[i+1:]
+		rest = rest[:i]
+		if !validIdentifiers(v.Build, false) {
+			return Version{}, errors.Wrapf(ErrInvalidVersion, "'%s' has invalid build metadata", s)
+		}
+	}
+	if i := strings.IndexByte(rest, '-'); i >= 0 {
+		v.Prerelease = rest[i+1:]
+		rest = rest[:i]
+		if !validIdentifiers(v.Prerelease, true) {
+			return Version{}, errors.Wrapf(ErrInvalidVersion, "'%s' has an invalid prerelease", s)
+		}
+	}
+
+	parts := strings.Split(rest, ".")
+	if len(parts) > 4 {
+		return Version{}, errors.Wrapf(ErrInvalidVersion, "'%s' has more than four components", s)
+	}
+	numbers := []*uint64{&v.Major, &v.Minor, &v.Patch, &v.Revision}
+	for i, part := range parts {
+		if !isNumeric(part) {
+			return Version{}, errors.Wrapf(ErrInvalidVersion, "'%s' has a non numeric component '%s'", s, part)
+		}
+		n, err := strconv.ParseUint(part, 10, 64)
+		if err != nil {
+			return Version{}, errors.Wrapf(ErrInvalidVersion, "'%s': %v", s, err)
+		}
+		*numbers[i] = n
+	}
+	return v, nil
+}
+
+// MustParse is like Parse but panics if s isn't a version. It is meant for constants.
+func MustParse(s string) Version {
+	v, err := Parse(s)
+	if err != nil {
+		panic(err)
+	}
+	return v
+}
+
+// String returns the version as major.minor.patch, followed by the revision if it isn't 0
+func (v Version) String() string {
+	s := fmt.Sprintf("%d.%d.%d", v.Major, v.Minor, v.Patch)
+	if v.Revision != 0 {
+		s += fmt.Sprintf(".%d", v.Revision)
+	}
+	if v.Prerelease != "" {
+		s += "-" + v.Prerelease
+	}
+	if v.Build != "" {
+		s += "+" + v.Build
+	}
+	return s
+}
+
+// Compare returns -1 if v is lower than o, 1 if it is higher and 0 if they have the same precedence
+func (v Version) Compare(o Version) int {
+	for _, c := range [][2]uint64{{v.Major, o.Major}, {v.Minor, o.Minor}, {v.Patch, o.Patch}, {v.Revision, o.Revision}} {
+		if c[0] != c[1] {
+			if c[0] < c[1] {
+				return -1
+			}
+			return 1
+		}
+	}
+	return comparePrerelease(v.Prerelease, o.Prerelease)
+}
+
+// LessThan returns true if v has a lower precedence than o
+func (v Version) LessThan(o Version) bool {
+	return v.Compare(o) < 0
+}
+
+// Equal returns true if v and o have the same precedence
+func (v Version) Equal(o Version) bool {
+	return v.Compare(o) == 0
+}
+
+// comparePrerelease compares prereleases as semver 2.0 does: a version without a prerelease is
+// higher, numeric identifiers compare numerically and are lower than alphanumeric ones, and a
+// prerelease that is a prefix of another is lower
+func comparePrerelease(a, b string) int {
+	if a == b {
+		return 0
+	}
+	if a == "" {
+		return 1
+	}
+	if b == "" {
+		return -1
+	}
+
+	as, bs := strings.Split(a, "."), strings.Split(b, ".")
+	for i := 0; i < len(as) && i < len(bs); i++ {
+		if c := compareIdentifier(as[i], bs[i]); c != 0 {
+			return c
+		}
+	}
+	switch {
+	case len(as) < len(bs):
+		return -1
+	case len(as) > len(bs):
+		return 1
+	default:
+		return 0
+	}
+}
+
+func compareIdentifier(a, b string) int {
+	aNumeric, bNumeric := isNumeric(a), isNumeric(b)
+	switch {
+	case aNumeric && bNumeric:
+		// compare by length first so numbers of any size compare correctly
+		if len(a) != len(b) {
+			if len(a) < len(b) {
+				return -1
+			}
+			return 1
+		}
+		return strings.Compare(a, b)
+	case aNumeric:
+		return -1
+	case bNumeric:
+		return 1
+	default:
+		return strings.Compare(a, b)
+	}
+}
+
+func isNumeric(s string) bool {
+	if s == "" {
+		return false
+	}
+	for _, c := range s {
+		if c < '0' || c > '9' {
+			return false
+		}
+	}
+	return true
+}
+
+// validIdentifiers checks dot separated identifiers of [0-9A-Za-z-]. Numeric prerelease
+// identifiers may not have leading zeros.
+func validIdentifiers(s string, isPrerelease bool) bool {
+	for _, identifier := range strings.Split(s, ".") {
+		if identifier == "" {
+			return false
+		}
+		for _, c := range identifier {
+			if !(c >= '0' && c <= '9' || c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c == '-') {
+				return false
+			}
+		}
+		if isPrerelease && len(identifier) > 1 && identifier[0] == '0' && isNumeric(identifier) {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,73 @@
+// Copyright (c) Microsoft Corporation.
+// Licensed under the MIT License.
+package semver
+
+import (
+	"testing"
+
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/require"
+)
+
+func Test_parse(t *testing.T) {
+	v, err := Parse("1.2.3")
+	require.NoError(t, err)
+	require.Equal(t, Version{Major: 1, Minor: 2, Patch: 3}, v)
+
+	v, err = Parse("v1.2.3.4-rc.1+build.5")
+	require.NoError(t, err)
+	require.Equal(t, Version{Major: 1, Minor: 2, Patch: 3, Revision: 4, Prerelease: "rc.1", Build: "build.5"}, v)
+	require.Equal(t, "1.2.3.4-rc.1+build.5", v.String())
+
+	v, err = Parse("2.1")
+	require.NoError(t, err)
+	require.Equal(t, "2.1.0", v.String())
+}
+
+func Test_parseInvalid(t *testing.T) {
+	for _, s := range []string{"", "a.b.c", "1..2", "1.2.3.4.5", "1.2.3-", "1.2.3-rc..1", "1.2.3-01", "1.2.3+", "1.2.3-r$c", "-1.2.3", "99999999999999999999.0.0"} {
+		_, err := Parse(s)
+		require.Equal(t, ErrInvalidVersion, errors.Cause(err), "'%s' should be invalid", s)
+	}
+}
+
+func Test_compare(t *testing.T) {
+	// in ascending order
+	ordered := []string{
+		"0.9.9",
+		"1.0.0-alpha",
+		"1.0.0-alpha.1",
+		"1.0.0-alpha.beta",
+		"1.0.0-beta",
+		"1.0.0-beta.2",
+		"1.0.0-beta.11",
+		"1.0.0-rc.1",
+		"1.0.0",
+		"1.0.0.1",
+		"1.0.1",
+		"1.2.0",
+		"1.10.0",
+		"2.0.0",
+	}
+	for i := range ordered {
+		for j := range ordered {
+			a, b := MustParse(ordered[i]), MustParse(ordered[j])
+			switch {
+			case i < j:
+				require.True(t, a.LessThan(b), "%s < %s", a, b)
+				require.Equal(t, -1, a.Compare(b))
+			case i > j:
+				require.Equal(t, 1, a.Compare(b), "%s > %s", a, b)
+			default:
+				require.True(t, a.Equal(b))
+			}
+		}
+	}
+
+	require.True(t, MustParse("1.0.0+build.1").Equal(MustParse("1.0.0+build.2")), "build metadata is ignored")
+	require.True(t, MustParse("1.0").Equal(MustParse("1.0.0.0")))
+}
+
+func Test_mustParsePanics(t *testing.T) {
+	require.Panics(t, func() { MustParse("yaba") })
+}
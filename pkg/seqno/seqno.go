@@ -5,10 +5,9 @@ package seqno
 import (
 	"io/ioutil"
 	"os"
-	"path/filepath"
+	"regexp"
 	"sort"
 	"strconv"
-	"strings"
 	"time"
 
 	"github.com/Azure/azure-extension-platform/pkg/extensionerrors"
@@ -22,10 +21,11 @@ type ISequenceNumberRetriever interface {
 }
 
 type ProdSequenceNumberRetriever struct {
+	ConfigName string // For multiconfig extensions, the extension instance to retrieve the sequence number for
 }
 
-func (*ProdSequenceNumberRetriever) GetSequenceNumber(name, version string) (uint, error) {
-	return getSequenceNumberInternal(name, version)
+func (snr *ProdSequenceNumberRetriever) GetSequenceNumber(name, version string) (uint, error) {
+	return getSequenceNumberInternal(name, version, snr.ConfigName)
 }
 
 // GetCurrentSequenceNumber returns the current sequence number the extension is using
@@ -42,12 +42,27 @@ func GetCurrentSequenceNumber(el logging.ILogger, retriever ISequenceNumberRetri
 }
 
 func SetSequenceNumber(extName, extVersion string, seqNo uint) error {
-	return setSequenceNumberInternal(extName, extVersion, seqNo)
+	return setSequenceNumberInternal(extName, extVersion, "", seqNo)
+}
+
+// SetSequenceNumberForConfig records the sequence number of a single instance of a multiconfig extension
+func SetSequenceNumberForConfig(extName, extVersion, configName string, seqNo uint) error {
+	return setSequenceNumberInternal(extName, extVersion, configName, seqNo)
 }
 
 // findSeqnum finds the most recently used file under the config folder
 // Note that this is different than just choosing the highest number, which may be incorrect
 func FindSeqNum(el logging.ILogger, configFolder string) (uint, error) {
+	return findSeqNumInternal(el, configFolder, "")
+}
+
+// FindSeqNumForConfig finds the most recently used {configName}.{seqNo}.settings file under the config folder
+// for an instance of a multiconfig extension
+func FindSeqNumForConfig(el logging.ILogger, configFolder string, configName string) (uint, error) {
+	return findSeqNumInternal(el, configFolder, configName)
+}
+
+func findSeqNumInternal(el logging.ILogger, configFolder string, configName string) (uint, error) {
 	// try getting the sequence number from the environment first
 	seqNoString := os.Getenv(configSequenceNumber)
 	if seqNoString == "" {
@@ -62,10 +77,7 @@ func FindSeqNum(el logging.ILogger, configFolder string) (uint, error) {
 		}
 	}
 
-	g, err := filepath.Glob(configFolder + "/*.settings")
-	if err != nil {
-		return 0, err
-	}
+	pattern := settingsFileNamePattern(configName)
 
 	// Start by finding the file with the latest time
 	files, err := ioutil.ReadDir(configFolder)
@@ -76,7 +88,7 @@ func FindSeqNum(el logging.ILogger, configFolder string) (uint, error) {
 	var modTime time.Time
 	var names []string
 	for _, fi := range files {
-		if fi.Mode().IsRegular() && strings.HasSuffix(fi.Name(), ".settings") {
+		if fi.Mode().IsRegular() && pattern.MatchString(fi.Name()) {
 			if !fi.ModTime().Before(modTime) {
 				if fi.ModTime().After(modTime) {
 					modTime = fi.ModTime()
@@ -93,7 +105,7 @@ func FindSeqNum(el logging.ILogger, configFolder string) (uint, error) {
 		el.Error("Cannot find the seqNo from %s. Not enough files", configFolder)
 		return 0, extensionerrors.ErrNoSettingsFiles
 	} else if len(names) == 1 {
-		i, err := strconv.Atoi(strings.Replace(names[0], ".settings", "", 1))
+		i, err := parseSettingsFileName(names[0], pattern)
 		if err != nil {
 			el.Error("Can't parse int from filename: %s", names[0])
 			return 0, extensionerrors.ErrInvalidSettingsFileName
@@ -103,10 +115,9 @@ func FindSeqNum(el logging.ILogger, configFolder string) (uint, error) {
 	} else {
 		// For some reason we have two or more files with the same time stamp.
 		// Revert to choosing the highest number.
-		seqs := make([]int, len(g))
-		for _, v := range g {
-			f := filepath.Base(v)
-			i, err := strconv.Atoi(strings.Replace(f, ".settings", "", 1))
+		seqs := make([]int, 0, len(names))
+		for _, f := range names {
+			i, err := parseSettingsFileName(f, pattern)
 			if err != nil {
 				el.Error("Can't parse int from filename: %s", f)
 				return 0, extensionerrors.ErrInvalidSettingsFileName
@@ -118,3 +129,23 @@ func FindSeqNum(el logging.ILogger, configFolder string) (uint, error) {
 		return uint(seqs[0]), nil
 	}
 }
+
+// settingsFileNamePattern matches the settings files of the config, whose sequence number is the
+// first submatch. Multiconfig settings files are named {configName}.{seqNo}.settings, and are left
+// out for other configs. Without a config name, the settings files are named {seqNo}.settings, and
+// other names without a dot, which the sequence number can't be parsed from, still match.
+func settingsFileNamePattern(configName string) *regexp.Regexp {
+	if configName != "" {
+		return regexp.MustCompile(`^` + regexp.QuoteMeta(configName) + `\.(\d+)\.settings$`)
+	}
+	return regexp.MustCompile(`^([^.]*)\.settings$`)
+}
+
+// parseSettingsFileName returns the sequence number from a settings file name matched by the pattern
+func parseSettingsFileName(fileName string, pattern *regexp.Regexp) (int, error) {
+	m := pattern.FindStringSubmatch(fileName)
+	if m == nil {
+		return 0, extensionerrors.ErrInvalidSettingsFileName
+	}
+	return strconv.Atoi(m[1])
+}
@@ -17,8 +17,8 @@ import (
 var mostRecentSequenceFileName = "mrseq"
 
 // sequence number for the extension from the registry
-func getSequenceNumberInternal(name, version string) (uint, error) {
-	mrseqPath, err := getMrseqFilePath()
+func getSequenceNumberInternal(name, version, configName string) (uint, error) {
+	mrseqPath, err := getMrseqFilePath(configName)
 	if err != nil {
 		return 0, err
 	}
@@ -38,25 +38,30 @@ func getSequenceNumberInternal(name, version string) (uint, error) {
 
 }
 
-func setSequenceNumberInternal(extName, extVersion string, seqNo uint) error {
+func setSequenceNumberInternal(extName, extVersion, configName string, seqNo uint) error {
 	b := []byte(fmt.Sprintf("%v", seqNo))
 
-	mrseqPath, err := getMrseqFilePath()
+	mrseqPath, err := getMrseqFilePath(configName)
 	if err != nil {
 		return err
 	}
 	err = ioutil.WriteFile(mrseqPath, b, constants.FilePermissions_UserOnly_ReadWrite)
 	if err != nil {
-		return fmt.Errorf("could not write sequence number file %s, error: %v", mostRecentSequenceFileName, err)
+		return fmt.Errorf("could not write sequence number file %s, error: %v", filepath.Base(mrseqPath), err)
 	}
 	return nil
 }
 
-func getMrseqFilePath() (string, error) {
+// getMrseqFilePath returns the path of the mrseq file. Each instance of a multiconfig extension
+// tracks its sequence number in its own {configName}.mrseq file.
+func getMrseqFilePath(configName string) (string, error) {
 	// mrseq file path must always be present in the same directory as the extension executable
 	currentDir, err := utils.GetCurrentProcessWorkingDir()
 	if err != nil {
 		return "", err
 	}
+	if configName != "" {
+		return filepath.Join(currentDir, configName+"."+mostRecentSequenceFileName), nil
+	}
 	return filepath.Join(currentDir, mostRecentSequenceFileName), nil
 }
@@ -14,22 +14,49 @@ import (
 func Test_writeReadSequenceNumberFile(t *testing.T) {
 	defer cleanupTest()
 	seqno := uint(rand.Int())
-	err := setSequenceNumberInternal("some name", "some version", seqno)
+	err := setSequenceNumberInternal("some name", "some version", "", seqno)
 	assert.NoError(t, err, "set sequence number should succeed")
-	readSeqno, err := getSequenceNumberInternal("some name", "some version")
+	readSeqno, err := getSequenceNumberInternal("some name", "some version", "")
 	assert.NoError(t, err, "get sequence number should succeed")
 	assert.Equal(t, seqno, readSeqno, "read sequence number should be same as set sequence number")
 }
 
 func Test_ReadSequenceNumberNoMrseqFile(t *testing.T) {
 	seqno := uint(0)
-	readSeqno, err := getSequenceNumberInternal("some name", "some version")
+	readSeqno, err := getSequenceNumberInternal("some name", "some version", "")
 	assert.ErrorIs(t, err, extensionerrors.ErrNoMrseqFile)
 	assert.Equal(t, seqno, readSeqno, "read sequence number should be same as set sequence number")
 }
 
+func Test_writeReadSequenceNumberFileForConfig(t *testing.T) {
+	defer cleanupTest()
+	defer cleanupConfigTest("chipmunk")
+	err := setSequenceNumberInternal("some name", "some version", "", 3)
+	assert.NoError(t, err, "set sequence number should succeed")
+	err = setSequenceNumberInternal("some name", "some version", "chipmunk", 8)
+	assert.NoError(t, err, "set sequence number for config should succeed")
+
+	readSeqno, err := getSequenceNumberInternal("some name", "some version", "chipmunk")
+	assert.NoError(t, err, "get sequence number for config should succeed")
+	assert.Equal(t, uint(8), readSeqno, "config sequence number should be independent of the handler sequence number")
+	readSeqno, err = getSequenceNumberInternal("some name", "some version", "")
+	assert.NoError(t, err, "get sequence number should succeed")
+	assert.Equal(t, uint(3), readSeqno, "handler sequence number should be independent of the config sequence number")
+
+	_, err = getSequenceNumberInternal("some name", "some version", "squirrel")
+	assert.ErrorIs(t, err, extensionerrors.ErrNoMrseqFile)
+}
+
+func cleanupConfigTest(configName string) {
+	mrseqFilePath, err := getMrseqFilePath(configName)
+	if err != nil {
+		return
+	}
+	os.Remove(mrseqFilePath)
+}
+
 func cleanupTest() {
-	mrseqFilePath, err := getMrseqFilePath()
+	mrseqFilePath, err := getMrseqFilePath("")
 	if err != nil {
 		return
 	}
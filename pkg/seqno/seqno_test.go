@@ -162,6 +162,46 @@ func Test_findSeqNoNormalExecution(t *testing.T) {
 	require.Equal(t, uint(3), seqNo)
 }
 
+func Test_findSeqNoForConfigIgnoresOtherConfigs(t *testing.T) {
+	el := logging.New(nil)
+	testhelpers.CleanupTestDirectory(t, sequenceNumberTestFolder)
+	writeSequenceNumberFile(t, sequenceNumberTestFolder, "chipmunk.4")
+	time.Sleep(10 * time.Millisecond)
+	writeSequenceNumberFile(t, sequenceNumberTestFolder, "chipmunk.7")
+	time.Sleep(10 * time.Millisecond)
+	writeSequenceNumberFile(t, sequenceNumberTestFolder, "squirrel.12")
+
+	seqNo, err := FindSeqNumForConfig(el, sequenceNumberTestFolder, "chipmunk")
+	require.NoError(t, err, "findSeqNumForConfig failed")
+	require.Equal(t, uint(7), seqNo)
+
+	seqNo, err = FindSeqNumForConfig(el, sequenceNumberTestFolder, "squirrel")
+	require.NoError(t, err, "findSeqNumForConfig failed")
+	require.Equal(t, uint(12), seqNo)
+}
+
+func Test_findSeqNoForConfigSameTimestamp(t *testing.T) {
+	el := logging.New(nil)
+	testhelpers.CleanupTestDirectory(t, sequenceNumberTestFolder)
+	timeStamp := time.Now()
+	writeSequenceNumberFileTs(t, sequenceNumberTestFolder, "chipmunk.2", timeStamp)
+	writeSequenceNumberFileTs(t, sequenceNumberTestFolder, "chipmunk.10", timeStamp)
+	writeSequenceNumberFileTs(t, sequenceNumberTestFolder, "squirrel.50", timeStamp)
+
+	seqNo, err := FindSeqNumForConfig(el, sequenceNumberTestFolder, "chipmunk")
+	require.NoError(t, err, "findSeqNumForConfig failed")
+	require.Equal(t, uint(10), seqNo)
+}
+
+func Test_findSeqNoForConfigNoFiles(t *testing.T) {
+	el := logging.New(nil)
+	testhelpers.CleanupTestDirectory(t, sequenceNumberTestFolder)
+	writeSequenceNumberFile(t, sequenceNumberTestFolder, "squirrel.1")
+
+	_, err := FindSeqNumForConfig(el, sequenceNumberTestFolder, "chipmunk")
+	require.Equal(t, extensionerrors.ErrNoSettingsFiles, err)
+}
+
 func writeSequenceNumberFileTs(t *testing.T, testDirectory string, name string, timeStamp time.Time) {
 	fullPath := writeSequenceNumberFile(t, testDirectory, name)
 	err := os.Chtimes(fullPath, timeStamp, timeStamp)
@@ -177,3 +217,32 @@ func writeSequenceNumberFile(t *testing.T, testDirectory string, name string) st
 
 	return fullPath
 }
+
+func Test_findSeqNoForConfigSharedPrefix(t *testing.T) {
+	el := logging.New(nil)
+	testhelpers.CleanupTestDirectory(t, sequenceNumberTestFolder)
+	timeStamp := time.Now()
+	writeSequenceNumberFileTs(t, sequenceNumberTestFolder, "chipmunk.4", timeStamp.Add(-time.Minute))
+	writeSequenceNumberFileTs(t, sequenceNumberTestFolder, "chipmunk.bar.9", timeStamp)
+	writeSequenceNumberFileTs(t, sequenceNumberTestFolder, "chipmunk.3", timeStamp.Add(-time.Minute))
+
+	seqNo, err := FindSeqNumForConfig(el, sequenceNumberTestFolder, "chipmunk")
+	require.NoError(t, err, "findSeqNumForConfig failed")
+	require.Equal(t, uint(4), seqNo)
+
+	seqNo, err = FindSeqNumForConfig(el, sequenceNumberTestFolder, "chipmunk.bar")
+	require.NoError(t, err, "findSeqNumForConfig failed")
+	require.Equal(t, uint(9), seqNo)
+}
+
+func Test_findSeqNoIgnoresMulticonfigFiles(t *testing.T) {
+	el := logging.New(nil)
+	testhelpers.CleanupTestDirectory(t, sequenceNumberTestFolder)
+	timeStamp := time.Now()
+	writeSequenceNumberFileTs(t, sequenceNumberTestFolder, "5", timeStamp.Add(-time.Minute))
+	writeSequenceNumberFileTs(t, sequenceNumberTestFolder, "chipmunk.8", timeStamp)
+
+	seqNo, err := FindSeqNum(el, sequenceNumberTestFolder)
+	require.NoError(t, err, "findSeqNum failed")
+	require.Equal(t, uint(5), seqNo)
+}
@@ -16,8 +16,8 @@ const (
 
 // getSequenceNumberInternal is the Windows specific logic for reading the current
 // sequence number for the extension from the registry
-func getSequenceNumberInternal(name, version string) (uint, error) {
-	extensionKeyName := getExtensionKeyName(name, version)
+func getSequenceNumberInternal(name, version, configName string) (uint, error) {
+	extensionKeyName := getExtensionKeyName(name, version, configName)
 	k, err := registry.OpenKey(registry.LOCAL_MACHINE, extensionKeyName, registry.QUERY_VALUE)
 	if err != nil {
 		if err == registry.ErrNotExist {
@@ -63,14 +63,27 @@ func getSequenceNumberInternal(name, version string) (uint, error) {
 	return uint(value), nil
 }
 
-func getExtensionKeyName(name string, version string) (keyName string) {
-	return fmt.Sprintf("Software\\Microsoft\\Windows Azure\\HandlerState\\%s_%s", name, version)
+// getExtensionKeyName returns the registry key holding the sequence number. Each instance of a
+// multiconfig extension tracks its sequence number in a subkey named after the instance.
+func getExtensionKeyName(name, version, configName string) (keyName string) {
+	keyName = fmt.Sprintf("Software\\Microsoft\\Windows Azure\\HandlerState\\%s_%s", name, version)
+	if configName != "" {
+		keyName = keyName + "\\" + configName
+	}
+	return keyName
 }
 
 // setSequenceNumberInternal writes the sequence number for the extension to the registry
-func setSequenceNumberInternal(extName, extVersion string, seqNo uint) error {
-	extensionKeyName := getExtensionKeyName(extName, extVersion)
-	k, err := registry.OpenKey(registry.LOCAL_MACHINE, extensionKeyName, registry.WRITE)
+func setSequenceNumberInternal(extName, extVersion, configName string, seqNo uint) error {
+	extensionKeyName := getExtensionKeyName(extName, extVersion, configName)
+	var k registry.Key
+	var err error
+	if configName != "" {
+		// the per instance subkey is owned by the extension, so create it if needed
+		k, _, err = registry.CreateKey(registry.LOCAL_MACHINE, extensionKeyName, registry.WRITE)
+	} else {
+		k, err = registry.OpenKey(registry.LOCAL_MACHINE, extensionKeyName, registry.WRITE)
+	}
 	if err != nil {
 		return fmt.Errorf("VmExtension: Cannot write sequence registry key due to '%v'", err)
 	}
@@ -17,7 +17,7 @@ const (
 
 func Test_getSequenceNumberInternalNoRegistryKey(t *testing.T) {
 	ensureRegistryKeyMissing(t, testKeyName)
-	sn, err := getSequenceNumberInternal(testExtensionName, testExtensionVersion)
+	sn, err := getSequenceNumberInternal(testExtensionName, testExtensionVersion, "")
 	require.Error(t, err, extensionerrors.ErrNotFound)
 	require.Equal(t, uint(0), sn)
 }
@@ -25,7 +25,7 @@ func Test_getSequenceNumberInternalNoRegistryKey(t *testing.T) {
 func Test_getSequenceNumberInternalNoValue(t *testing.T) {
 	ensureRegistryKeyCreated(t, testKeyName)
 	ensureRegistryValueMissing(t, testKeyName, sequenceNumberKeyName)
-	sn, err := getSequenceNumberInternal(testExtensionName, testExtensionVersion)
+	sn, err := getSequenceNumberInternal(testExtensionName, testExtensionVersion, "")
 	require.Error(t, err, extensionerrors.ErrNotFound)
 	require.Equal(t, uint(0), sn)
 }
@@ -33,23 +33,23 @@ func Test_getSequenceNumberInternalNoValue(t *testing.T) {
 func Test_getSequenceNumberInternalHasValue(t *testing.T) {
 	ensureRegistryKeyCreated(t, testKeyName)
 	ensureRegistryValueCreated(t, testKeyName, sequenceNumberKeyName, 5)
-	sn, err := getSequenceNumberInternal(testExtensionName, testExtensionVersion)
+	sn, err := getSequenceNumberInternal(testExtensionName, testExtensionVersion, "")
 	require.NoError(t, err, "getSequenceNumberInternal failed")
 	require.Equal(t, uint(5), sn)
 }
 
 func Test_setSequenceNumberInternalNoRegistryKey(t *testing.T) {
 	ensureRegistryKeyMissing(t, testKeyName)
-	err := setSequenceNumberInternal(testExtensionName, testExtensionVersion, 42)
+	err := setSequenceNumberInternal(testExtensionName, testExtensionVersion, "", 42)
 	require.Error(t, err, extensionerrors.ErrNotFound)
 }
 
 func Test_setSequenceNumberInternalValidReplace(t *testing.T) {
 	ensureRegistryKeyCreated(t, testKeyName)
 	ensureRegistryValueCreated(t, testKeyName, sequenceNumberKeyName, 5)
-	err := setSequenceNumberInternal(testExtensionName, testExtensionVersion, 42)
+	err := setSequenceNumberInternal(testExtensionName, testExtensionVersion, "", 42)
 	require.NoError(t, err, "setSequenceNumberInternal failed")
-	sn, err := getSequenceNumberInternal(testExtensionName, testExtensionVersion)
+	sn, err := getSequenceNumberInternal(testExtensionName, testExtensionVersion, "")
 	require.NoError(t, err, "getSquenceNumberInternal failed")
 	require.Equal(t, uint(42), sn)
 }
@@ -57,9 +57,9 @@ func Test_setSequenceNumberInternalValidReplace(t *testing.T) {
 func Test_setSequenceNumberInternalValidSet(t *testing.T) {
 	ensureRegistryKeyCreated(t, testKeyName)
 	ensureRegistryValueMissing(t, testKeyName, sequenceNumberKeyName)
-	err := setSequenceNumberInternal(testExtensionName, testExtensionVersion, 42)
+	err := setSequenceNumberInternal(testExtensionName, testExtensionVersion, "", 42)
 	require.NoError(t, err, "setSequenceNumberInternal failed")
-	sn, err := getSequenceNumberInternal(testExtensionName, testExtensionVersion)
+	sn, err := getSequenceNumberInternal(testExtensionName, testExtensionVersion, "")
 	require.NoError(t, err, "getSquenceNumberInternal failed")
 	require.Equal(t, uint(42), sn)
 }
@@ -0,0 +1,144 @@
+// Copyright (c) Microsoft Corporation.
+// Licensed under the MIT License.
+package settings
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+const (
+	publicSettingsSectionName    = "publicSettings"
+	protectedSettingsSectionName = "protectedSettings"
+)
+
+// TypedHandlerSettings contains the public and protected settings decoded into extension defined types
+type TypedHandlerSettings[Pub any, Prot any] struct {
+	Public    Pub
+	Protected Prot
+}
+
+// FieldError describes a single setting that could not be decoded or failed validation
+type FieldError struct {
+	// dotted path of the offending setting, rooted at publicSettings or protectedSettings
+	// e.g. "publicSettings.scripts[0].name"
+	Path string
+
+	// human readable description of the problem. Never contains the value of the setting.
+	Message string
+}
+
+func (fe FieldError) String() string {
+	return fmt.Sprintf("%s: %s", fe.Path, fe.Message)
+}
+
+// SettingsValidationError is returned by Bind when the settings do not match the schema
+// or cannot be decoded into the requested types
+type SettingsValidationError struct {
+	Fields []FieldError
+}
+
+func (sve *SettingsValidationError) Error() string {
+	messages := make([]string, len(sve.Fields))
+	for i, fe := range sve.Fields {
+		messages[i] = fe.String()
+	}
+	return "invalid settings: " + strings.Join(messages, "; ")
+}
+
+// Bind validates the public and protected settings against the supplied JSON Schema documents
+// and decodes them into Pub and Prot. A nil or empty schema skips validation for that half.
+// Missing settings are validated as an empty object and leave the corresponding type zero valued.
+// Validation and decoding failures for both halves are reported together as a *SettingsValidationError.
+func Bind[Pub any, Prot any](hs *HandlerSettings, publicSchema, protectedSchema []byte) (*TypedHandlerSettings[Pub, Prot], error) {
+	if hs == nil {
+		return nil, errors.New("handler settings cannot be nil")
+	}
+
+	compiledPublicSchema, err := compileSchema(publicSchema)
+	if err != nil {
+		return nil, fmt.Errorf("public settings schema: %v", err)
+	}
+	compiledProtectedSchema, err := compileSchema(protectedSchema)
+	if err != nil {
+		return nil, fmt.Errorf("protected settings schema: %v", err)
+	}
+
+	typed := &TypedHandlerSettings[Pub, Prot]{}
+	var fieldErrors []FieldError
+	fieldErrors = append(fieldErrors, bindSection(hs.PublicSettings, compiledPublicSchema, publicSettingsSectionName, &typed.Public)...)
+	fieldErrors = append(fieldErrors, bindSection(hs.ProtectedSettings, compiledProtectedSchema, protectedSettingsSectionName, &typed.Protected)...)
+	if len(fieldErrors) > 0 {
+		return nil, &SettingsValidationError{Fields: fieldErrors}
+	}
+
+	return typed, nil
+}
+
+// bindSection validates a single settings json string and decodes it into target
+func bindSection(settingsJson string, s *schema, sectionName string, target interface{}) []FieldError {
+	isEmpty := strings.TrimSpace(settingsJson) == ""
+	documentForValidation := settingsJson
+	if isEmpty {
+		documentForValidation = "{}"
+	}
+
+	document, err := decodeJSONValue([]byte(documentForValidation))
+	if err != nil {
+		return []FieldError{{Path: sectionName, Message: fmt.Sprintf("is not valid json: %v", err)}}
+	}
+
+	if fieldErrors := s.validate(document, sectionName); len(fieldErrors) > 0 {
+		return fieldErrors
+	}
+
+	if isEmpty {
+		return nil
+	}
+
+	if err := json.Unmarshal([]byte(settingsJson), target); err != nil {
+		return []FieldError{decodeErrorToFieldError(err, sectionName)}
+	}
+	return nil
+}
+
+func decodeErrorToFieldError(err error, sectionName string) FieldError {
+	var typeErr *json.UnmarshalTypeError
+	if errors.As(err, &typeErr) {
+		return FieldError{
+			Path:    joinFieldPath(sectionName, normalizeDecodeFieldPath(typeErr.Field)),
+			Message: fmt.Sprintf("cannot decode json %s into %s", typeErr.Value, typeErr.Type.String()),
+		}
+	}
+
+	var syntaxErr *json.SyntaxError
+	if errors.As(err, &syntaxErr) {
+		return FieldError{Path: sectionName, Message: fmt.Sprintf("is not valid json at offset %d", syntaxErr.Offset)}
+	}
+
+	return FieldError{Path: sectionName, Message: err.Error()}
+}
+
+// normalizeDecodeFieldPath rewrites array indices reported by encoding/json ("scripts.0.name")
+// in the same form used for schema validation errors ("scripts[0].name")
+func normalizeDecodeFieldPath(field string) string {
+	if field == "" {
+		return field
+	}
+
+	var sb strings.Builder
+	for i, segment := range strings.Split(field, ".") {
+		if _, err := strconv.Atoi(segment); err == nil && i > 0 {
+			sb.WriteString("[" + segment + "]")
+			continue
+		}
+		if i > 0 {
+			sb.WriteString(".")
+		}
+		sb.WriteString(segment)
+	}
+	return sb.String()
+}
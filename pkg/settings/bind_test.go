@@ -0,0 +1,177 @@
+// Copyright (c) Microsoft Corporation.
+// Licensed under the MIT License.
+package settings
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+type testPublicSettings struct {
+	CommandToExecute string       `json:"commandToExecute"`
+	TimeoutInSeconds int          `json:"timeoutInSeconds"`
+	Scripts          []testScript `json:"scripts"`
+}
+
+type testScript struct {
+	Name string `json:"name"`
+}
+
+type testProtectedSettings struct {
+	Password string `json:"password"`
+}
+
+var testPublicSchema = []byte(`{
+	"type": "object",
+	"required": ["commandToExecute"],
+	"additionalProperties": false,
+	"properties": {
+		"commandToExecute": {"type": "string", "minLength": 1},
+		"timeoutInSeconds": {"type": "integer", "minimum": 1, "maximum": 3600},
+		"scripts": {
+			"type": "array",
+			"maxItems": 2,
+			"items": {
+				"type": "object",
+				"required": ["name"],
+				"properties": {"name": {"type": "string", "pattern": "^[a-z]+$"}}
+			}
+		}
+	}
+}`)
+
+var testProtectedSchema = []byte(`{
+	"type": "object",
+	"required": ["password"],
+	"properties": {"password": {"type": "string"}}
+}`)
+
+func Test_bindValidSettings(t *testing.T) {
+	hs := &HandlerSettings{
+		PublicSettings:    `{"commandToExecute":"ls","timeoutInSeconds":30,"scripts":[{"name":"first"}]}`,
+		ProtectedSettings: `{"password":"hunter2"}`,
+	}
+
+	typed, err := Bind[testPublicSettings, testProtectedSettings](hs, testPublicSchema, testProtectedSchema)
+	require.NoError(t, err)
+	require.Equal(t, "ls", typed.Public.CommandToExecute)
+	require.Equal(t, 30, typed.Public.TimeoutInSeconds)
+	require.Equal(t, "first", typed.Public.Scripts[0].Name)
+	require.Equal(t, "hunter2", typed.Protected.Password)
+}
+
+func Test_bindWithoutSchema(t *testing.T) {
+	hs := &HandlerSettings{PublicSettings: `{"commandToExecute":"ls"}`}
+
+	typed, err := Bind[testPublicSettings, testProtectedSettings](hs, nil, nil)
+	require.NoError(t, err)
+	require.Equal(t, "ls", typed.Public.CommandToExecute)
+	require.Empty(t, typed.Protected.Password)
+}
+
+func Test_bindReportsFieldPaths(t *testing.T) {
+	hs := &HandlerSettings{
+		PublicSettings: `{"timeoutInSeconds":1.5,"scripts":[{"name":"UPPER"},{}],"unknown":true}`,
+	}
+
+	_, err := Bind[testPublicSettings, testProtectedSettings](hs, testPublicSchema, testProtectedSchema)
+	require.Error(t, err)
+	validationErr, ok := err.(*SettingsValidationError)
+	require.True(t, ok, "SettingsValidationError not returned")
+
+	paths := make([]string, len(validationErr.Fields))
+	for i, fe := range validationErr.Fields {
+		paths[i] = fe.Path
+	}
+	require.ElementsMatch(t, []string{
+		"publicSettings.commandToExecute",
+		"publicSettings.scripts[0].name",
+		"publicSettings.scripts[1].name",
+		"publicSettings.timeoutInSeconds",
+		"publicSettings.unknown",
+		"protectedSettings.password",
+	}, paths)
+}
+
+func Test_bindErrorDoesNotContainValues(t *testing.T) {
+	hs := &HandlerSettings{
+		PublicSettings:    `{"commandToExecute":"ls"}`,
+		ProtectedSettings: `{"password":12345678}`,
+	}
+
+	_, err := Bind[testPublicSettings, testProtectedSettings](hs, testPublicSchema, testProtectedSchema)
+	require.Error(t, err)
+	require.Contains(t, err.Error(), "protectedSettings.password: expected string, got number")
+	require.NotContains(t, err.Error(), "12345678")
+}
+
+func Test_bindDecodeErrorHasFieldPath(t *testing.T) {
+	hs := &HandlerSettings{PublicSettings: `{"commandToExecute":"ls","scripts":[{"name":5}]}`}
+
+	_, err := Bind[testPublicSettings, testProtectedSettings](hs, nil, nil)
+	require.Error(t, err)
+	validationErr, ok := err.(*SettingsValidationError)
+	require.True(t, ok, "SettingsValidationError not returned")
+	require.Equal(t, 1, len(validationErr.Fields))
+	// older versions of encoding/json do not report the array index
+	require.True(t, strings.HasPrefix(validationErr.Fields[0].Path, "publicSettings.scripts"))
+	require.True(t, strings.HasSuffix(validationErr.Fields[0].Path, ".name"))
+	require.NotContains(t, validationErr.Fields[0].Path, ".0.")
+}
+
+func Test_bindInvalidSchema(t *testing.T) {
+	hs := &HandlerSettings{}
+
+	_, err := Bind[testPublicSettings, testProtectedSettings](hs, []byte(`{"type": 5}`), nil)
+	require.Error(t, err)
+	_, ok := err.(*SettingsValidationError)
+	require.False(t, ok, "an invalid schema is not a settings validation error")
+}
+
+func Test_bindEnumAndConst(t *testing.T) {
+	schema := []byte(`{
+		"properties": {
+			"mode": {"enum": ["fast", "slow"]},
+			"version": {"const": 2}
+		}
+	}`)
+
+	_, err := Bind[map[string]interface{}, map[string]interface{}](&HandlerSettings{PublicSettings: `{"mode":"fast","version":2.0}`}, schema, nil)
+	require.NoError(t, err)
+
+	_, err = Bind[map[string]interface{}, map[string]interface{}](&HandlerSettings{PublicSettings: `{"mode":"medium","version":3}`}, schema, nil)
+	require.Error(t, err)
+	require.Contains(t, err.Error(), `publicSettings.mode: must be one of ["fast", "slow"]`)
+	require.Contains(t, err.Error(), "publicSettings.version: must be equal to 2")
+}
+
+func Test_bindUnsupportedSchemaKeywords(t *testing.T) {
+	hs := &HandlerSettings{PublicSettings: `{"mode":"fast"}`}
+	for _, schema := range []string{
+		`{"$ref": "#/definitions/mode"}`,
+		`{"properties": {"mode": {"oneOf": [{"const": "slow"}]}}}`,
+		`{"additionalProperties": {"format": "uri"}}`,
+		`{"type": "text"}`,
+	} {
+		_, err := Bind[map[string]interface{}, map[string]interface{}](hs, []byte(schema), nil)
+		require.Error(t, err, schema)
+	}
+
+	// annotations don't affect validation
+	_, err := Bind[map[string]interface{}, map[string]interface{}](hs, []byte(`{"$schema": "http://json-schema.org/draft-07/schema#", "title": "settings", "properties": {"mode": {"description": "how fast", "type": "string"}}}`), nil)
+	require.NoError(t, err)
+}
+
+func Test_decodeJSONValueRejectsTrailingData(t *testing.T) {
+	_, err := decodeJSONValue([]byte(`{"a": 1} {"b": 2}`))
+	require.Error(t, err)
+	_, err = decodeJSONValue([]byte(`"yaba" x`))
+	require.Error(t, err)
+
+	v, err := decodeJSONValue([]byte(" 2 \n"))
+	require.NoError(t, err)
+	require.Equal(t, json.Number("2"), v)
+}
@@ -0,0 +1,441 @@
+// Copyright (c) Microsoft Corporation.
+// Licensed under the MIT License.
+package settings
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math/big"
+	"regexp"
+	"sort"
+	"strings"
+	"unicode/utf8"
+)
+
+// schema is a compiled JSON Schema document. Only the subset of draft-07 keywords that
+// extension settings need is supported: type, enum, const, properties, required,
+// additionalProperties, items, minItems, maxItems, minLength, maxLength, pattern,
+// minimum, maximum, exclusiveMinimum and exclusiveMaximum. Annotations such as title and
+// description are ignored. Any other keyword, such as $ref or oneOf, fails compilation, because
+// ignoring it would accept settings the schema is meant to reject.
+type schema struct {
+	Types                []string
+	Enum                 []interface{}
+	Const                *interface{}
+	Properties           map[string]*schema
+	Required             []string
+	AdditionalProperties *schema
+	NoAdditional         bool
+	Items                *schema
+	MinItems             *int
+	MaxItems             *int
+	MinLength            *int
+	MaxLength            *int
+	Pattern              *regexp.Regexp
+	Minimum              *big.Float
+	Maximum              *big.Float
+	ExclusiveMinimum     *big.Float
+	ExclusiveMaximum     *big.Float
+}
+
+// rawSchema mirrors the JSON representation of the supported schema keywords
+type rawSchema struct {
+	Type                 json.RawMessage            `json:"type"`
+	Enum                 []json.RawMessage          `json:"enum"`
+	Const                json.RawMessage            `json:"const"`
+	Properties           map[string]json.RawMessage `json:"properties"`
+	Required             []string                   `json:"required"`
+	AdditionalProperties json.RawMessage            `json:"additionalProperties"`
+	Items                json.RawMessage            `json:"items"`
+	MinItems             *int                       `json:"minItems"`
+	MaxItems             *int                       `json:"maxItems"`
+	MinLength            *int                       `json:"minLength"`
+	MaxLength            *int                       `json:"maxLength"`
+	Pattern              *string                    `json:"pattern"`
+	Minimum              json.Number                `json:"minimum"`
+	Maximum              json.Number                `json:"maximum"`
+	ExclusiveMinimum     json.Number                `json:"exclusiveMinimum"`
+	ExclusiveMaximum     json.Number                `json:"exclusiveMaximum"`
+}
+
+// schemaKeywords are the keywords of rawSchema, along with the annotations that don't affect validation
+var schemaKeywords = map[string]bool{
+	"type": true, "enum": true, "const": true, "properties": true, "required": true,
+	"additionalProperties": true, "items": true, "minItems": true, "maxItems": true,
+	"minLength": true, "maxLength": true, "pattern": true, "minimum": true, "maximum": true,
+	"exclusiveMinimum": true, "exclusiveMaximum": true,
+
+	"$schema": true, "$id": true, "$comment": true, "title": true, "description": true,
+	"default": true, "examples": true, "readOnly": true, "writeOnly": true,
+}
+
+// schemaTypes are the values of the type keyword
+var schemaTypes = map[string]bool{
+	"null": true, "boolean": true, "string": true, "number": true, "integer": true, "array": true, "object": true,
+}
+
+// compileSchema parses a JSON Schema document. An empty document yields a nil schema,
+// which accepts any value.
+func compileSchema(b []byte) (*schema, error) {
+	if len(bytes.TrimSpace(b)) == 0 {
+		return nil, nil
+	}
+	return compileSchemaAt(b, "#")
+}
+
+func compileSchemaAt(b []byte, location string) (*schema, error) {
+	var keywords map[string]json.RawMessage
+	if err := json.Unmarshal(b, &keywords); err != nil {
+		return nil, fmt.Errorf("invalid schema at %s: %v", location, err)
+	}
+	var unsupported []string
+	for keyword := range keywords {
+		if !schemaKeywords[keyword] {
+			unsupported = append(unsupported, keyword)
+		}
+	}
+	if len(unsupported) > 0 {
+		sort.Strings(unsupported)
+		return nil, fmt.Errorf("invalid schema at %s: unsupported keywords %s", location, strings.Join(unsupported, ", "))
+	}
+
+	var raw rawSchema
+	if err := json.Unmarshal(b, &raw); err != nil {
+		return nil, fmt.Errorf("invalid schema at %s: %v", location, err)
+	}
+
+	s := &schema{
+		Required:  raw.Required,
+		MinItems:  raw.MinItems,
+		MaxItems:  raw.MaxItems,
+		MinLength: raw.MinLength,
+		MaxLength: raw.MaxLength,
+	}
+
+	if len(raw.Type) > 0 {
+		var single string
+		if err := json.Unmarshal(raw.Type, &single); err == nil {
+			s.Types = []string{single}
+		} else if err := json.Unmarshal(raw.Type, &s.Types); err != nil {
+			return nil, fmt.Errorf("invalid schema at %s: type must be a string or an array of strings", location)
+		}
+		for _, t := range s.Types {
+			if !schemaTypes[t] {
+				return nil, fmt.Errorf("invalid schema at %s: unknown type '%s'", location, t)
+			}
+		}
+	}
+
+	for _, e := range raw.Enum {
+		v, err := decodeJSONValue(e)
+		if err != nil {
+			return nil, fmt.Errorf("invalid schema at %s: %v", location, err)
+		}
+		s.Enum = append(s.Enum, v)
+	}
+
+	if len(raw.Const) > 0 {
+		v, err := decodeJSONValue(raw.Const)
+		if err != nil {
+			return nil, fmt.Errorf("invalid schema at %s: %v", location, err)
+		}
+		s.Const = &v
+	}
+
+	if len(raw.Properties) > 0 {
+		s.Properties = make(map[string]*schema, len(raw.Properties))
+		for name, propertyBytes := range raw.Properties {
+			ps, err := compileSchemaAt(propertyBytes, location+"/properties/"+name)
+			if err != nil {
+				return nil, err
+			}
+			s.Properties[name] = ps
+		}
+	}
+
+	if len(raw.AdditionalProperties) > 0 {
+		var allowed bool
+		if err := json.Unmarshal(raw.AdditionalProperties, &allowed); err == nil {
+			s.NoAdditional = !allowed
+		} else {
+			ap, err := compileSchemaAt(raw.AdditionalProperties, location+"/additionalProperties")
+			if err != nil {
+				return nil, err
+			}
+			s.AdditionalProperties = ap
+		}
+	}
+
+	if len(raw.Items) > 0 {
+		is, err := compileSchemaAt(raw.Items, location+"/items")
+		if err != nil {
+			return nil, err
+		}
+		s.Items = is
+	}
+
+	if raw.Pattern != nil {
+		re, err := regexp.Compile(*raw.Pattern)
+		if err != nil {
+			return nil, fmt.Errorf("invalid schema at %s: pattern: %v", location, err)
+		}
+		s.Pattern = re
+	}
+
+	var err error
+	if s.Minimum, err = parseSchemaNumber(raw.Minimum); err != nil {
+		return nil, fmt.Errorf("invalid schema at %s: minimum: %v", location, err)
+	}
+	if s.Maximum, err = parseSchemaNumber(raw.Maximum); err != nil {
+		return nil, fmt.Errorf("invalid schema at %s: maximum: %v", location, err)
+	}
+	if s.ExclusiveMinimum, err = parseSchemaNumber(raw.ExclusiveMinimum); err != nil {
+		return nil, fmt.Errorf("invalid schema at %s: exclusiveMinimum: %v", location, err)
+	}
+	if s.ExclusiveMaximum, err = parseSchemaNumber(raw.ExclusiveMaximum); err != nil {
+		return nil, fmt.Errorf("invalid schema at %s: exclusiveMaximum: %v", location, err)
+	}
+
+	return s, nil
+}
+
+func parseSchemaNumber(n json.Number) (*big.Float, error) {
+	if n == "" {
+		return nil, nil
+	}
+	f, _, err := big.ParseFloat(string(n), 10, 128, big.ToNearestEven)
+	return f, err
+}
+
+// decodeJSONValue decodes a single json value while preserving the exact representation of numbers
+func decodeJSONValue(b []byte) (interface{}, error) {
+	decoder := json.NewDecoder(bytes.NewReader(b))
+	decoder.UseNumber()
+	var v interface{}
+	if err := decoder.Decode(&v); err != nil {
+		return nil, err
+	}
+	if _, err := decoder.Token(); err != io.EOF {
+		return nil, fmt.Errorf("unexpected data after the json value")
+	}
+	return v, nil
+}
+
+// validate checks v against the schema and returns one FieldError per violation
+func (s *schema) validate(v interface{}, path string) []FieldError {
+	if s == nil {
+		return nil
+	}
+
+	var errs []FieldError
+	fail := func(format string, args ...interface{}) {
+		errs = append(errs, FieldError{Path: path, Message: fmt.Sprintf(format, args...)})
+	}
+
+	if len(s.Types) > 0 && !matchesAnyType(v, s.Types) {
+		fail("expected %s, got %s", strings.Join(s.Types, " or "), jsonTypeName(v))
+		// the remaining keywords are type specific, so there is nothing more to say
+		return errs
+	}
+
+	if s.Const != nil && !jsonEqual(v, *s.Const) {
+		fail("must be equal to %s", formatJSONValue(*s.Const))
+	}
+
+	if len(s.Enum) > 0 {
+		found := false
+		for _, e := range s.Enum {
+			if jsonEqual(v, e) {
+				found = true
+				break
+			}
+		}
+		if !found {
+			allowed := make([]string, len(s.Enum))
+			for i, e := range s.Enum {
+				allowed[i] = formatJSONValue(e)
+			}
+			fail("must be one of [%s]", strings.Join(allowed, ", "))
+		}
+	}
+
+	switch value := v.(type) {
+	case string:
+		length := utf8.RuneCountInString(value)
+		if s.MinLength != nil && length < *s.MinLength {
+			fail("length must be at least %d", *s.MinLength)
+		}
+		if s.MaxLength != nil && length > *s.MaxLength {
+			fail("length must be at most %d", *s.MaxLength)
+		}
+		if s.Pattern != nil && !s.Pattern.MatchString(value) {
+			fail("must match pattern %q", s.Pattern.String())
+		}
+	case json.Number:
+		n, _, err := big.ParseFloat(string(value), 10, 128, big.ToNearestEven)
+		if err != nil {
+			fail("invalid number %s", value)
+			break
+		}
+		if s.Minimum != nil && n.Cmp(s.Minimum) < 0 {
+			fail("must be >= %s", s.Minimum.Text('g', -1))
+		}
+		if s.Maximum != nil && n.Cmp(s.Maximum) > 0 {
+			fail("must be <= %s", s.Maximum.Text('g', -1))
+		}
+		if s.ExclusiveMinimum != nil && n.Cmp(s.ExclusiveMinimum) <= 0 {
+			fail("must be > %s", s.ExclusiveMinimum.Text('g', -1))
+		}
+		if s.ExclusiveMaximum != nil && n.Cmp(s.ExclusiveMaximum) >= 0 {
+			fail("must be < %s", s.ExclusiveMaximum.Text('g', -1))
+		}
+	case []interface{}:
+		if s.MinItems != nil && len(value) < *s.MinItems {
+			fail("must contain at least %d items", *s.MinItems)
+		}
+		if s.MaxItems != nil && len(value) > *s.MaxItems {
+			fail("must contain at most %d items", *s.MaxItems)
+		}
+		if s.Items != nil {
+			for i, item := range value {
+				errs = append(errs, s.Items.validate(item, fmt.Sprintf("%s[%d]", path, i))...)
+			}
+		}
+	case map[string]interface{}:
+		for _, name := range s.Required {
+			if _, ok := value[name]; !ok {
+				errs = append(errs, FieldError{Path: joinFieldPath(path, name), Message: "is required"})
+			}
+		}
+
+		// iterate in a stable order so that error messages are deterministic
+		names := make([]string, 0, len(value))
+		for name := range value {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		for _, name := range names {
+			childPath := joinFieldPath(path, name)
+			if ps, ok := s.Properties[name]; ok {
+				errs = append(errs, ps.validate(value[name], childPath)...)
+			} else if s.NoAdditional {
+				errs = append(errs, FieldError{Path: childPath, Message: "is not a recognized setting"})
+			} else if s.AdditionalProperties != nil {
+				errs = append(errs, s.AdditionalProperties.validate(value[name], childPath)...)
+			}
+		}
+	}
+
+	return errs
+}
+
+func joinFieldPath(parent, name string) string {
+	if parent == "" {
+		return name
+	}
+	return parent + "." + name
+}
+
+func matchesAnyType(v interface{}, types []string) bool {
+	for _, t := range types {
+		if matchesType(v, t) {
+			return true
+		}
+	}
+	return false
+}
+
+func matchesType(v interface{}, t string) bool {
+	switch t {
+	case "null":
+		return v == nil
+	case "boolean":
+		_, ok := v.(bool)
+		return ok
+	case "string":
+		_, ok := v.(string)
+		return ok
+	case "number":
+		_, ok := v.(json.Number)
+		return ok
+	case "integer":
+		n, ok := v.(json.Number)
+		if !ok {
+			return false
+		}
+		f, _, err := big.ParseFloat(string(n), 10, 128, big.ToNearestEven)
+		return err == nil && f.IsInt()
+	case "array":
+		_, ok := v.([]interface{})
+		return ok
+	case "object":
+		_, ok := v.(map[string]interface{})
+		return ok
+	}
+	return false
+}
+
+func jsonTypeName(v interface{}) string {
+	switch v.(type) {
+	case nil:
+		return "null"
+	case bool:
+		return "boolean"
+	case string:
+		return "string"
+	case json.Number:
+		return "number"
+	case []interface{}:
+		return "array"
+	case map[string]interface{}:
+		return "object"
+	}
+	return fmt.Sprintf("%T", v)
+}
+
+func jsonEqual(a, b interface{}) bool {
+	an, aIsNumber := a.(json.Number)
+	bn, bIsNumber := b.(json.Number)
+	if aIsNumber && bIsNumber {
+		af, _, errA := big.ParseFloat(string(an), 10, 128, big.ToNearestEven)
+		bf, _, errB := big.ParseFloat(string(bn), 10, 128, big.ToNearestEven)
+		return errA == nil && errB == nil && af.Cmp(bf) == 0
+	}
+
+	switch av := a.(type) {
+	case []interface{}:
+		bv, ok := b.([]interface{})
+		if !ok || len(av) != len(bv) {
+			return false
+		}
+		for i := range av {
+			if !jsonEqual(av[i], bv[i]) {
+				return false
+			}
+		}
+		return true
+	case map[string]interface{}:
+		bv, ok := b.(map[string]interface{})
+		if !ok || len(av) != len(bv) {
+			return false
+		}
+		for k := range av {
+			bValue, exists := bv[k]
+			if !exists || !jsonEqual(av[k], bValue) {
+				return false
+			}
+		}
+		return true
+	}
+	return a == b
+}
+
+func formatJSONValue(v interface{}) string {
+	b, err := json.Marshal(v)
+	if err != nil {
+		return fmt.Sprintf("%v", v)
+	}
+	return string(b)
+}
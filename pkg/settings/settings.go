@@ -14,6 +14,7 @@ import (
 	"github.com/Azure/azure-extension-platform/pkg/extensionerrors"
 	"github.com/Azure/azure-extension-platform/pkg/handlerenv"
 	"github.com/Azure/azure-extension-platform/pkg/logging"
+	"github.com/Azure/azure-extension-platform/pkg/redact"
 )
 
 const (
@@ -49,6 +50,17 @@ type handlerSettingsContainer struct {
 func GetHandlerSettings(el logging.ILogger, he *handlerenv.HandlerEnvironment, seqNo uint) (hs *HandlerSettings, _ error) {
 	// The file will be under the config folder with the path {seqNo}.settings
 	settingsFileName := filepath.Join(he.ConfigFolder, fmt.Sprintf("%d%s", seqNo, settingsFileSuffix))
+	return getHandlerSettingsFromFile(el, he, settingsFileName)
+}
+
+// GetHandlerSettingsForConfig reads and parses the settings of a single instance of a multiconfig extension
+func GetHandlerSettingsForConfig(el logging.ILogger, he *handlerenv.HandlerEnvironment, configName string, seqNo uint) (hs *HandlerSettings, _ error) {
+	// The file will be under the config folder with the path {configName}.{seqNo}.settings
+	settingsFileName := filepath.Join(he.ConfigFolder, fmt.Sprintf("%s.%d%s", configName, seqNo, settingsFileSuffix))
+	return getHandlerSettingsFromFile(el, he, settingsFileName)
+}
+
+func getHandlerSettingsFromFile(el logging.ILogger, he *handlerenv.HandlerEnvironment, settingsFileName string) (hs *HandlerSettings, _ error) {
 	parsedHs, err := parseHandlerSettingsFile(el, settingsFileName)
 	if err != nil {
 		return hs, err
@@ -59,14 +71,9 @@ func GetHandlerSettings(el logging.ILogger, he *handlerenv.HandlerEnvironment, s
 		return hs, err
 	}
 
-	var publicSettingJsonString = ""
-	// parsedHS.PublicSettings is an interface, has to be marshaled to get the string representation
-	if parsedHs.PublicSettings != nil {
-		jsonBytes, err := json.Marshal(parsedHs.PublicSettings)
-		if err != nil {
-			return hs, err
-		}
-		publicSettingJsonString = string(jsonBytes)
+	publicSettingJsonString, err := marshalPublicSettings(parsedHs)
+	if err != nil {
+		return hs, err
 	}
 
 	hs = &HandlerSettings{
@@ -77,6 +84,40 @@ func GetHandlerSettings(el logging.ILogger, he *handlerenv.HandlerEnvironment, s
 	return hs, nil
 }
 
+// GetPublicSettings reads the public settings of the handler without decrypting the protected settings
+func GetPublicSettings(el logging.ILogger, he *handlerenv.HandlerEnvironment, seqNo uint) (string, error) {
+	settingsFileName := filepath.Join(he.ConfigFolder, fmt.Sprintf("%d%s", seqNo, settingsFileSuffix))
+	return getPublicSettingsFromFile(el, settingsFileName)
+}
+
+// GetPublicSettingsForConfig reads the public settings of a single instance of a multiconfig extension
+// without decrypting the protected settings
+func GetPublicSettingsForConfig(el logging.ILogger, he *handlerenv.HandlerEnvironment, configName string, seqNo uint) (string, error) {
+	settingsFileName := filepath.Join(he.ConfigFolder, fmt.Sprintf("%s.%d%s", configName, seqNo, settingsFileSuffix))
+	return getPublicSettingsFromFile(el, settingsFileName)
+}
+
+func getPublicSettingsFromFile(el logging.ILogger, settingsFileName string) (string, error) {
+	parsedHs, err := parseHandlerSettingsFile(el, settingsFileName)
+	if err != nil {
+		return "", err
+	}
+	return marshalPublicSettings(parsedHs)
+}
+
+// marshalPublicSettings returns the JSON of the public settings, or an empty string if there are none
+func marshalPublicSettings(hs handlerSettings) (string, error) {
+	// hs.PublicSettings is an interface, has to be marshaled to get the string representation
+	if hs.PublicSettings == nil {
+		return "", nil
+	}
+	jsonBytes, err := json.Marshal(hs.PublicSettings)
+	if err != nil {
+		return "", err
+	}
+	return string(jsonBytes), nil
+}
+
 // unmarshalProtectedSettings decodes the protected settings from handler
 // runtime settings JSON file, decrypts it using the certificates and unmarshals
 // into the given struct v.
@@ -97,6 +138,10 @@ func unmarshalProtectedSettings(el logging.ILogger, configFolder string, hs hand
 	}
 
 	v, err := decrypt.DecryptProtectedSettings(configFolder, hs.SettingsCertThumbprint, decoded)
+	if err == nil {
+		// keep the values out of anything the extension writes to disk
+		redact.AddProtectedSettings(v)
+	}
 	return v, err
 }
 
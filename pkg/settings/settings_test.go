@@ -78,6 +78,22 @@ func Test_settingsNoProtectedSettings(t *testing.T) {
 	validateHandlerSettings(t, hs)
 }
 
+func Test_settingsForConfig(t *testing.T) {
+	he := getTestHandlerEnvironment()
+	err := initHandlerEnvironmentDirs(he)
+	defer cleanuphandlerEnvDir(he)
+	el := logging.New(nil)
+	settingsFile := filepath.Join(he.ConfigFolder, fmt.Sprintf("chipmunk.%d%s", testSeqNo, settingsFileSuffix))
+	writeSettingsToFile(t, testThumbprint, "", 1, settingsFile)
+
+	hs, err := GetHandlerSettingsForConfig(el, he, "chipmunk", testSeqNo)
+	require.NoError(t, err)
+	validateHandlerSettings(t, hs)
+
+	_, err = GetHandlerSettingsForConfig(el, he, "squirrel", testSeqNo)
+	require.Equal(t, extensionerrors.ErrInvalidSettingsFile, err)
+}
+
 func Test_settingsNoThumbprint(t *testing.T) {
 	he := getTestHandlerEnvironment()
 	err := initHandlerEnvironmentDirs(he)
@@ -102,6 +118,25 @@ func Test_settingsCannotDecodeProtectedSettings(t *testing.T) {
 	require.Equal(t, extensionerrors.ErrInvalidProtectedSettingsData, err)
 }
 
+func Test_publicSettingsDoesNotDecrypt(t *testing.T) {
+	he := getTestHandlerEnvironment()
+	err := initHandlerEnvironmentDirs(he)
+	defer cleanuphandlerEnvDir(he)
+	el := logging.New(nil)
+	writeSettingsToFile(t, testThumbprint, "&(*@#&JH", 1, getTestSettingsFileName(he))
+
+	// the protected settings can't be decoded, but they aren't read
+	public, err := GetPublicSettings(el, he, testSeqNo)
+	require.NoError(t, err)
+	validateHandlerSettings(t, &HandlerSettings{PublicSettings: public})
+
+	settingsFile := filepath.Join(he.ConfigFolder, fmt.Sprintf("chipmunk.%d%s", testSeqNo, settingsFileSuffix))
+	writeSettingsToFile(t, testThumbprint, "&(*@#&JH", 1, settingsFile)
+	public, err = GetPublicSettingsForConfig(el, he, "chipmunk", testSeqNo)
+	require.NoError(t, err)
+	validateHandlerSettings(t, &HandlerSettings{PublicSettings: public})
+}
+
 func Test_settingsNoRuntimeSettings(t *testing.T) {
 	he := getTestHandlerEnvironment()
 	err := initHandlerEnvironmentDirs(he)
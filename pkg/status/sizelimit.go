@@ -0,0 +1,155 @@
+// Copyright (c) Microsoft Corporation.
+// Licensed under the MIT License.
+package status
+
+import (
+	"fmt"
+	"unicode/utf8"
+)
+
+// DefaultMaxStatusFileSizeInBytes is the largest status file the guest agent accepts
+const DefaultMaxStatusFileSizeInBytes = 128 * 1024
+
+// truncationMarker replaces the middle of messages that were shortened to fit the status file
+const truncationMarker = "\n...[truncated]...\n"
+
+// messageField points at a message that can be shortened to fit the size limit
+type messageField struct {
+	path    string
+	message *string
+}
+
+// Truncate returns a copy of the report whose serialized form is no larger than maxSizeInBytes,
+// along with the paths of the messages that had to be shortened. The longest messages are shortened
+// first, keeping their beginning and end and marking the removed part. An error is returned if the
+// report is too large even with empty messages. The report is returned unchanged if maxSizeInBytes
+// is zero or negative.
+func (r StatusReport) Truncate(maxSizeInBytes int) (StatusReport, []string, error) {
+	if maxSizeInBytes <= 0 {
+		return r, nil, nil
+	}
+
+	b, err := r.marshal()
+	if err != nil {
+		return nil, nil, fmt.Errorf("status: failed to marshal into json: %v", err)
+	}
+	if len(b) <= maxSizeInBytes {
+		return r, nil, nil
+	}
+
+	truncated, fields := r.copyWithMessageFields()
+	original := make([]string, len(fields))
+	longest := 0
+	for i, f := range fields {
+		original[i] = *f.message
+		if len(original[i]) > longest {
+			longest = len(original[i])
+		}
+	}
+
+	// find the largest per-message length for which the report fits
+	fits := func(maxMessageLength int) (bool, error) {
+		for i, f := range fields {
+			*f.message = truncateMessage(original[i], maxMessageLength)
+		}
+		b, err := truncated.marshal()
+		if err != nil {
+			return false, fmt.Errorf("status: failed to marshal into json: %v", err)
+		}
+		return len(b) <= maxSizeInBytes, nil
+	}
+
+	if ok, err := fits(0); err != nil {
+		return nil, nil, err
+	} else if !ok {
+		return nil, nil, fmt.Errorf("status: report exceeds %d bytes even without messages", maxSizeInBytes)
+	}
+
+	low, high := 0, longest
+	for low < high {
+		mid := (low + high + 1) / 2
+		ok, err := fits(mid)
+		if err != nil {
+			return nil, nil, err
+		}
+		if ok {
+			low = mid
+		} else {
+			high = mid - 1
+		}
+	}
+
+	if _, err := fits(low); err != nil {
+		return nil, nil, err
+	}
+
+	var truncatedFields []string
+	for i, f := range fields {
+		if *f.message != original[i] {
+			truncatedFields = append(truncatedFields, f.path)
+		}
+	}
+
+	return truncated, truncatedFields, nil
+}
+
+// copyWithMessageFields returns a deep copy of the report and the messages within it
+func (r StatusReport) copyWithMessageFields() (StatusReport, []messageField) {
+	c := make(StatusReport, len(r))
+	var fields []messageField
+	for i := range r {
+		c[i] = r[i]
+		fields = append(fields, messageField{
+			path:    fmt.Sprintf("[%d].status.formattedMessage.message", i),
+			message: &c[i].Status.FormattedMessage.Message,
+		})
+
+		if r[i].Status.Substatuses == nil {
+			continue
+		}
+		c[i].Status.Substatuses = make([]Substatus, len(r[i].Status.Substatuses))
+		for j, ss := range r[i].Status.Substatuses {
+			if ss.FormattedMessage != nil {
+				fm := *ss.FormattedMessage
+				ss.FormattedMessage = &fm
+				fields = append(fields, messageField{
+					path:    fmt.Sprintf("[%d].status.substatus[%d].formattedMessage.message", i, j),
+					message: &fm.Message,
+				})
+			}
+			c[i].Status.Substatuses[j] = ss
+		}
+	}
+
+	return c, fields
+}
+
+// truncateMessage shortens message to at most maxLength bytes by keeping its beginning and end
+// and replacing the middle with the truncation marker. Runes are never split.
+func truncateMessage(message string, maxLength int) string {
+	if len(message) <= maxLength {
+		return message
+	}
+	if maxLength <= len(truncationMarker) {
+		return truncateToRuneBoundary(message, maxLength)
+	}
+
+	remaining := maxLength - len(truncationMarker)
+	head := truncateToRuneBoundary(message, remaining-remaining/2)
+	tail := message[len(message)-remaining/2:]
+	for len(tail) > 0 && !utf8.RuneStart(tail[0]) {
+		tail = tail[1:]
+	}
+	return head + truncationMarker + tail
+}
+
+// truncateToRuneBoundary returns the longest prefix of s no longer than n bytes that doesn't split a rune
+func truncateToRuneBoundary(s string, n int) string {
+	if len(s) <= n {
+		return s
+	}
+	for n > 0 && !utf8.RuneStart(s[n]) {
+		n--
+	}
+	return s[:n]
+}
@@ -0,0 +1,142 @@
+// Copyright (c) Microsoft Corporation.
+// Licensed under the MIT License.
+package status
+
+import (
+	"os"
+	"path"
+	"strings"
+	"testing"
+	"unicode/utf8"
+
+	"github.com/Azure/azure-extension-platform/pkg/testhelpers"
+	"github.com/stretchr/testify/require"
+)
+
+func marshaledSize(t *testing.T, r StatusReport) int {
+	b, err := r.marshal()
+	require.NoError(t, err)
+	return len(b)
+}
+
+func Test_truncateSmallReportUnchanged(t *testing.T) {
+	report := New(StatusSuccess, "Enable", "all good").WithSubstatus("StdOut", StatusSuccess, 0, "hello")
+	truncated, fields, err := report.Truncate(DefaultMaxStatusFileSizeInBytes)
+	require.NoError(t, err)
+	require.Empty(t, fields)
+	require.Equal(t, report, truncated)
+}
+
+func Test_truncateNoLimit(t *testing.T) {
+	report := New(StatusSuccess, "Enable", strings.Repeat("a", 1000))
+	truncated, fields, err := report.Truncate(0)
+	require.NoError(t, err)
+	require.Empty(t, fields)
+	require.Equal(t, report, truncated)
+}
+
+func Test_truncateKeepsHeadAndTail(t *testing.T) {
+	message := "BEGIN" + strings.Repeat("x", 5000) + "END"
+	report := New(StatusError, "Enable", message)
+
+	truncated, fields, err := report.Truncate(1024)
+	require.NoError(t, err)
+	require.Equal(t, []string{"[0].status.formattedMessage.message"}, fields)
+	require.LessOrEqual(t, marshaledSize(t, truncated), 1024)
+
+	truncatedMessage := truncated[0].Status.FormattedMessage.Message
+	require.True(t, strings.HasPrefix(truncatedMessage, "BEGIN"))
+	require.True(t, strings.HasSuffix(truncatedMessage, "END"))
+	require.Contains(t, truncatedMessage, truncationMarker)
+
+	// the original report isn't modified
+	require.Equal(t, message, report[0].Status.FormattedMessage.Message)
+}
+
+func Test_truncateMultipleSubstatusesShortensLongestFirst(t *testing.T) {
+	report := New(StatusSuccess, "Enable", "short message").
+		WithSubstatus("StdOut", StatusSuccess, 0, strings.Repeat("o", 20000)).
+		WithSubstatus("StdErr", StatusSuccess, 0, strings.Repeat("e", 200)).
+		WithSubstatus("Empty", StatusSuccess, 0, "").
+		WithSubstatus("Big", StatusError, 1, strings.Repeat("b", 10000))
+
+	truncated, fields, err := report.Truncate(4096)
+	require.NoError(t, err)
+	require.LessOrEqual(t, marshaledSize(t, truncated), 4096)
+	require.Equal(t, []string{
+		"[0].status.substatus[0].formattedMessage.message",
+		"[0].status.substatus[3].formattedMessage.message",
+	}, fields)
+
+	// the short messages survive intact
+	require.Equal(t, "short message", truncated[0].Status.FormattedMessage.Message)
+	require.Equal(t, strings.Repeat("e", 200), truncated[0].Status.Substatuses[1].FormattedMessage.Message)
+	require.Nil(t, truncated[0].Status.Substatuses[2].FormattedMessage)
+	require.Equal(t, 4, len(truncated[0].Status.Substatuses))
+
+	// the original report isn't modified
+	require.Equal(t, 20000, len(report[0].Status.Substatuses[0].FormattedMessage.Message))
+	require.Equal(t, 10000, len(report[0].Status.Substatuses[3].FormattedMessage.Message))
+}
+
+func Test_truncateDoesNotSplitRunes(t *testing.T) {
+	report := New(StatusSuccess, "Enable", strings.Repeat("é", 3000)).
+		WithSubstatus("StdOut", StatusSuccess, 0, strings.Repeat("日本", 2000))
+
+	truncated, fields, err := report.Truncate(2048)
+	require.NoError(t, err)
+	require.Equal(t, 2, len(fields))
+	require.LessOrEqual(t, marshaledSize(t, truncated), 2048)
+	require.True(t, utf8.ValidString(truncated[0].Status.FormattedMessage.Message))
+	require.True(t, utf8.ValidString(truncated[0].Status.Substatuses[0].FormattedMessage.Message))
+}
+
+func Test_truncateImpossible(t *testing.T) {
+	report := New(StatusSuccess, "Enable", strings.Repeat("a", 1000))
+	_, _, err := report.Truncate(10)
+	require.Error(t, err)
+}
+
+func Test_truncateMessage(t *testing.T) {
+	require.Equal(t, "abc", truncateMessage("abc", 10))
+	require.Equal(t, "abc", truncateMessage("abcdef", 3))
+	require.Equal(t, "", truncateMessage("abcdef", 0))
+
+	message := strings.Repeat("a", 50) + strings.Repeat("z", 50)
+	truncatedMessage := truncateMessage(message, 40)
+	require.Equal(t, 40, len(truncatedMessage))
+	require.True(t, strings.HasPrefix(truncatedMessage, "aaaa"))
+	require.True(t, strings.HasSuffix(truncatedMessage, "zzzz"))
+}
+
+func Test_statusSaveWithSizeLimit(t *testing.T) {
+	report := New(StatusSuccess, "Enable", "done").
+		WithSubstatus("StdOut", StatusSuccess, 0, strings.Repeat("o", 10000)).
+		WithSubstatus("StdErr", StatusSuccess, 0, strings.Repeat("e", 10000))
+	testhelpers.CleanupTestDirectory(t, statusTestDirectory)
+
+	fields, err := report.SaveWithSizeLimit(statusTestDirectory, "", 3, 2048)
+	require.NoError(t, err, "SaveWithSizeLimit failed")
+	require.Equal(t, 2, len(fields))
+
+	info, err := os.Stat(path.Join(statusTestDirectory, "3.status"))
+	require.NoError(t, err, "status file doesn't exist")
+	require.LessOrEqual(t, info.Size(), int64(2048))
+
+	fields, err = report.SaveWithSizeLimit(statusTestDirectory, "chipmunk", 3, 2048)
+	require.NoError(t, err, "SaveWithSizeLimit failed")
+	require.Equal(t, 2, len(fields))
+	_, err = os.Stat(path.Join(statusTestDirectory, "chipmunk.3.status"))
+	require.NoError(t, err, "config status file doesn't exist")
+}
+
+func Test_statusSaveEnforcesMaxSize(t *testing.T) {
+	report := New(StatusError, "Enable", strings.Repeat("x", 2*DefaultMaxStatusFileSizeInBytes))
+	testhelpers.CleanupTestDirectory(t, statusTestDirectory)
+	err := report.Save(statusTestDirectory, 9)
+	require.NoError(t, err, "Save failed")
+
+	info, err := os.Stat(path.Join(statusTestDirectory, "9.status"))
+	require.NoError(t, err, "status file doesn't exist")
+	require.LessOrEqual(t, info.Size(), int64(DefaultMaxStatusFileSizeInBytes))
+}
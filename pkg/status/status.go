@@ -8,6 +8,8 @@ import (
 	"os"
 	"path/filepath"
 	"time"
+
+	"github.com/Azure/azure-extension-platform/pkg/redact"
 )
 
 const (
@@ -66,10 +68,13 @@ type Status struct {
 	Substatuses      []Substatus      `json:"substatus"`
 }
 
+// Substatus reports the outcome of a named part of an operation, such as an individual
+// script or component
 type Substatus struct {
-	Name   string `json:"name"`
-	Status string `json:"status"`
-	Code   int    `json:"code"`
+	Name             string            `json:"name"`
+	Status           string            `json:"status"`
+	Code             int               `json:"code"`
+	FormattedMessage *FormattedMessage `json:"formattedMessage,omitempty"`
 }
 
 // FormattedMessage is a struct used for serializing status
@@ -118,6 +123,67 @@ func NewError(operation string, ec ErrorClarification) StatusReport {
 	}
 }
 
+// NewSubstatus creates a new Substatus instance. The formatted message is omitted if message is empty.
+func NewSubstatus(name string, t StatusType, code int, message string) Substatus {
+	ss := Substatus{
+		Name:   name,
+		Status: string(t),
+		Code:   code,
+	}
+	if message != "" {
+		ss.FormattedMessage = &FormattedMessage{
+			Lang:    "en",
+			Message: message,
+		}
+	}
+	return ss
+}
+
+// WithSubstatus appends a substatus to the status of the report and returns the report
+// so calls can be chained
+func (r StatusReport) WithSubstatus(name string, t StatusType, code int, message string) StatusReport {
+	return r.AddSubstatuses(NewSubstatus(name, t, code, message))
+}
+
+// AddSubstatuses appends the substatuses to the status of the report, after any existing
+// substatuses, and returns the report so calls can be chained. An empty report is returned unchanged.
+func (r StatusReport) AddSubstatuses(substatuses ...Substatus) StatusReport {
+	if len(r) == 0 || len(substatuses) == 0 {
+		return r
+	}
+
+	last := &r[len(r)-1].Status
+	last.Substatuses = append(last.Substatuses, substatuses...)
+	return r
+}
+
+// redacted returns a copy of the report with the secrets in the default redact registry
+// removed from its messages
+func (r StatusReport) redacted() StatusReport {
+	if redact.Default().IsEmpty() {
+		return r
+	}
+
+	c := make(StatusReport, len(r))
+	for i, item := range r {
+		c[i] = item
+		c[i].Status.FormattedMessage.Message = redact.Redact(item.Status.FormattedMessage.Message)
+		if item.Status.Substatuses == nil {
+			continue
+		}
+		c[i].Status.Substatuses = make([]Substatus, len(item.Status.Substatuses))
+		for j, ss := range item.Status.Substatuses {
+			if ss.FormattedMessage != nil {
+				fm := *ss.FormattedMessage
+				fm.Message = redact.Redact(fm.Message)
+				ss.FormattedMessage = &fm
+			}
+			c[i].Status.Substatuses[j] = ss
+		}
+	}
+	return c
+}
+
 func (r StatusReport) marshal() ([]byte, error) {
 	return json.MarshalIndent(r, "", "\t")
 }
@@ -125,8 +191,37 @@ func (r StatusReport) marshal() ([]byte, error) {
 // Save persists the status message to the specified status folder using the
 // sequence number. The operation consists of writing to a temporary file in the
 // same folder and moving it to the final destination for atomicity.
+// Messages are truncated to keep the file within DefaultMaxStatusFileSizeInBytes.
 func (r StatusReport) Save(statusFolder string, seqNo uint) error {
+	_, err := r.SaveWithSizeLimit(statusFolder, "", seqNo, DefaultMaxStatusFileSizeInBytes)
+	return err
+}
+
+// SaveForConfig persists the status of a single instance of a multiconfig extension
+// to {configName}.{seqNo}.status in the specified status folder, within DefaultMaxStatusFileSizeInBytes.
+func (r StatusReport) SaveForConfig(statusFolder string, configName string, seqNo uint) error {
+	_, err := r.SaveWithSizeLimit(statusFolder, configName, seqNo, DefaultMaxStatusFileSizeInBytes)
+	return err
+}
+
+// SaveWithSizeLimit persists the status like Save, or like SaveForConfig if configName is set,
+// truncating messages so the file is no larger than maxSizeInBytes. It returns the paths of
+// the messages that were truncated. A maxSizeInBytes of zero or less disables the limit.
+func (r StatusReport) SaveWithSizeLimit(statusFolder string, configName string, seqNo uint, maxSizeInBytes int) (truncatedFields []string, _ error) {
 	fn := fmt.Sprintf("%d.status", seqNo)
+	if configName != "" {
+		fn = fmt.Sprintf("%s.%d.status", configName, seqNo)
+	}
+
+	r, truncatedFields, err := r.redacted().Truncate(maxSizeInBytes)
+	if err != nil {
+		return nil, err
+	}
+
+	return truncatedFields, r.saveToFile(statusFolder, fn)
+}
+
+func (r StatusReport) saveToFile(statusFolder string, fn string) error {
 	path := filepath.Join(statusFolder, fn)
 	tmpFile, err := os.CreateTemp(statusFolder, fn)
 	if err != nil {
@@ -8,6 +8,7 @@ import (
 	"path"
 	"testing"
 
+	"github.com/Azure/azure-extension-platform/pkg/redact"
 	"github.com/Azure/azure-extension-platform/pkg/testhelpers"
 
 	"github.com/stretchr/testify/require"
@@ -61,6 +62,52 @@ func Test_newError(t *testing.T) {
 	require.Equal(t, 42, report[0].Status.Substatuses[0].Code)
 }
 
+func Test_newSubstatus(t *testing.T) {
+	ss := NewSubstatus("StdOut", StatusSuccess, 0, "hello chipmunk")
+	require.Equal(t, "StdOut", ss.Name)
+	require.Equal(t, string(StatusSuccess), ss.Status)
+	require.NotNil(t, ss.FormattedMessage)
+	require.Equal(t, "hello chipmunk", ss.FormattedMessage.Message)
+
+	ss = NewSubstatus("StdErr", StatusSuccess, 0, "")
+	require.Nil(t, ss.FormattedMessage)
+	b, err := json.Marshal(ss)
+	require.NoError(t, err)
+	require.NotContains(t, string(b), "formattedMessage")
+}
+
+func Test_statusWithSubstatuses(t *testing.T) {
+	report := New(StatusSuccess, "Enable", "done").
+		WithSubstatus("StdOut", StatusSuccess, 0, "out").
+		WithSubstatus("StdErr", StatusError, 1, "err").
+		AddSubstatuses(NewSubstatus("Component", StatusTransitioning, 2, ""))
+
+	substatuses := report[0].Status.Substatuses
+	require.Equal(t, 3, len(substatuses))
+	require.Equal(t, "StdOut", substatuses[0].Name)
+	require.Equal(t, "StdErr", substatuses[1].Name)
+	require.Equal(t, string(StatusError), substatuses[1].Status)
+	require.Equal(t, 1, substatuses[1].Code)
+	require.Equal(t, "err", substatuses[1].FormattedMessage.Message)
+	require.Equal(t, "Component", substatuses[2].Name)
+}
+
+func Test_errorWithSubstatusesKeepsClarification(t *testing.T) {
+	report := NewError("Enable", ErrorClarification{Code: 42, Message: "unhappy chipmunks"}).
+		WithSubstatus("StdErr", StatusError, 0, "boom")
+
+	substatuses := report[0].Status.Substatuses
+	require.Equal(t, 2, len(substatuses))
+	require.Equal(t, ErrorClarificationSubStatusName, substatuses[0].Name)
+	require.Equal(t, "StdErr", substatuses[1].Name)
+}
+
+func Test_addSubstatusesEmptyReport(t *testing.T) {
+	var report StatusReport
+	report = report.WithSubstatus("StdOut", StatusSuccess, 0, "out")
+	require.Equal(t, 0, len(report))
+}
+
 func Test_statusSaveFolderDoesntExist(t *testing.T) {
 	report := New(StatusSuccess, "flip", "flop")
 	err := report.Save("./flopperdoodle", 5)
@@ -85,6 +132,25 @@ func Test_statusSaveNewFile(t *testing.T) {
 	require.Equal(t, StatusSuccess, report[0].Status.Status)
 }
 
+func Test_statusSaveRedactsSecrets(t *testing.T) {
+	redact.AddValue("chipmunkkey")
+	defer redact.Default().Clear()
+
+	report := New(StatusError, "flip", "bad key chipmunkkey").WithSubstatus("script", StatusError, 1, "echo chipmunkkey")
+	testhelpers.CleanupTestDirectory(t, statusTestDirectory)
+	require.NoError(t, report.Save(statusTestDirectory, 5))
+
+	b, err := os.ReadFile(path.Join(statusTestDirectory, "5.status"))
+	require.NoError(t, err)
+	require.NotContains(t, string(b), "chipmunkkey")
+
+	var r StatusReport
+	require.NoError(t, json.Unmarshal(b, &r))
+	require.Equal(t, "bad key [REDACTED]", r[0].Status.FormattedMessage.Message)
+	require.Equal(t, "echo [REDACTED]", r[0].Status.Substatuses[0].FormattedMessage.Message)
+	require.Equal(t, "bad key chipmunkkey", report[0].Status.FormattedMessage.Message, "the report isn't modified")
+}
+
 func Test_statusSaveExistingFile(t *testing.T) {
 	report := New(StatusSuccess, "flip", "flop")
 	testhelpers.CleanupTestDirectory(t, statusTestDirectory)
@@ -93,3 +159,15 @@ func Test_statusSaveExistingFile(t *testing.T) {
 	err = report.Save(statusTestDirectory, 7)
 	require.NoError(t, err, "second ave report failed")
 }
+
+func Test_statusSaveForConfig(t *testing.T) {
+	report := New(StatusSuccess, "flip", "flop")
+	testhelpers.CleanupTestDirectory(t, statusTestDirectory)
+	err := report.SaveForConfig(statusTestDirectory, "chipmunk", 5)
+	require.NoError(t, err, "save report failed")
+
+	_, err = os.Stat(path.Join(statusTestDirectory, "chipmunk.5.status"))
+	require.NoError(t, err, "config status file doesn't exist")
+	_, err = os.Stat(path.Join(statusTestDirectory, "5.status"))
+	require.True(t, os.IsNotExist(err), "handler status file should not be written")
+}
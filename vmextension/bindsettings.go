@@ -0,0 +1,32 @@
+// Copyright (c) Microsoft Corporation.
+// Licensed under the MIT License.
+package vmextension
+
+import (
+	"errors"
+
+	"github.com/Azure/azure-extension-platform/pkg/settings"
+)
+
+// BindSettings reads the settings for the requested sequence number, validates them against the
+// provided JSON Schema documents and decodes them into Pub and Prot.
+// Settings that fail validation are returned as an ErrorWithClarification with the ErrorInvalidSettings
+// code, so returning the error from the enable callback reports the offending field paths in the status file.
+func BindSettings[Pub any, Prot any](ext *VMExtension, publicSchema, protectedSchema []byte) (*settings.TypedHandlerSettings[Pub, Prot], error) {
+	hs, err := ext.GetSettings()
+	if err != nil {
+		return nil, err
+	}
+
+	typed, err := settings.Bind[Pub, Prot](hs, publicSchema, protectedSchema)
+	if err != nil {
+		var validationErr *settings.SettingsValidationError
+		if errors.As(err, &validationErr) {
+			ext.ExtensionLogger.Error("settings validation failed: %v", validationErr)
+			return nil, NewErrorWithClarification(ErrorInvalidSettings, validationErr)
+		}
+		return nil, err
+	}
+
+	return typed, nil
+}
@@ -0,0 +1,54 @@
+// Copyright (c) Microsoft Corporation.
+// Licensed under the MIT License.
+package vmextension
+
+import (
+	"testing"
+
+	"github.com/Azure/azure-extension-platform/pkg/extensionerrors"
+	"github.com/Azure/azure-extension-platform/pkg/settings"
+	"github.com/stretchr/testify/require"
+)
+
+type bindTestPublicSettings struct {
+	Flipper string `json:"Flipper"`
+}
+
+type bindTestProtectedSettings struct {
+	Secret string `json:"secret"`
+}
+
+func Test_bindSettingsSucceeds(t *testing.T) {
+	ext := createTestVMExtension()
+	ext.GetSettings = func() (*settings.HandlerSettings, error) {
+		return &settings.HandlerSettings{PublicSettings: `{"Flipper":"flip"}`, ProtectedSettings: `{"secret":"chipmunk"}`}, nil
+	}
+
+	typed, err := BindSettings[bindTestPublicSettings, bindTestProtectedSettings](ext, []byte(`{"required":["Flipper"]}`), nil)
+	require.NoError(t, err)
+	require.Equal(t, "flip", typed.Public.Flipper)
+	require.Equal(t, "chipmunk", typed.Protected.Secret)
+}
+
+func Test_bindSettingsValidationFailureIsClarified(t *testing.T) {
+	ext := createTestVMExtension()
+	ext.GetSettings = func() (*settings.HandlerSettings, error) {
+		return &settings.HandlerSettings{PublicSettings: `{"Flopper":"flop"}`}, nil
+	}
+
+	_, err := BindSettings[bindTestPublicSettings, bindTestProtectedSettings](ext, []byte(`{"required":["Flipper"]}`), nil)
+	ewc, ok := err.(ErrorWithClarification)
+	require.True(t, ok, "ErrorWithClarification not returned")
+	require.Equal(t, ErrorInvalidSettings, ewc.ErrorCode)
+	require.Contains(t, ewc.Error(), "publicSettings.Flipper: is required")
+}
+
+func Test_bindSettingsCannotGetSettings(t *testing.T) {
+	ext := createTestVMExtension()
+	ext.GetSettings = func() (*settings.HandlerSettings, error) {
+		return nil, extensionerrors.ErrInvalidSettingsFile
+	}
+
+	_, err := BindSettings[bindTestPublicSettings, bindTestProtectedSettings](ext, nil, nil)
+	require.Equal(t, extensionerrors.ErrInvalidSettingsFile, err)
+}
@@ -3,25 +3,33 @@
 package vmextension
 
 import (
+	"errors"
 	"fmt"
 	"os"
 	"path"
 	"syscall"
 
 	"github.com/Azure/azure-extension-platform/pkg/status"
-
-	"github.com/Azure/azure-extension-platform/pkg/exithelper"
 )
 
 const disabledFileName = "disable"
 
 var (
 	disableDependency disableDependencies = &disableDependencyImpl{}
+
+	// errSequenceNumberUnchanged is returned by enable when the extension requires the sequence number
+	// to change and it hasn't, which Do turns into a successful exit
+	errSequenceNumberUnchanged = errors.New("sequence number has not increased")
 )
 
 const (
 	ErrorNoSequenceNumber = -10001
 	ErrorUnparseableSeqNo = -10002
+	ErrorInvalidSettings  = -10003
+
+	ErrorInvalidUpdateVersion = -10004
+	ErrorMigrationFailed      = -10005
+	ErrorUpdateFailed         = -10006
 )
 
 func enable(ext *VMExtension) (string, error) {
@@ -45,13 +53,13 @@ func enable(ext *VMExtension) (string, error) {
 
 	if ext.exec.requiresSeqNoChange && ext.CurrentSequenceNumber != nil && requestedSequenceNumber <= *ext.CurrentSequenceNumber {
 		ext.ExtensionLogger.Info("sequence number has not increased. Exiting.")
-		exithelper.Exiter.Exit(0)
+		return "", errSequenceNumberUnchanged
 	}
 
 	ext.ExtensionLogger.Info("Running operation %v for seqNo %v", enableCmd.operation.ToString(), requestedSequenceNumber)
 	reportStatus(ext, status.StatusTransitioning, enableCmd, "")
 
-	err = ext.exec.manager.SetSequenceNumberInternal(ext.Name, ext.Version, requestedSequenceNumber)
+	err = setSequenceNumber(ext, requestedSequenceNumber)
 	if err != nil {
 		msg := "failed to write new sequence number"
 		ext.ExtensionLogger.Warn("%s: %v", msg, err)
@@ -69,7 +77,10 @@ func enable(ext *VMExtension) (string, error) {
 	}
 
 	// execute the command, save its error
-	msg, runErr := ext.exec.enableCallback(ext)
+	ext.progress = newProgressReporter(ext, enableCmd, ext.exec.progressReportInterval)
+	msg, substatuses, runErr := runEnableCallback(ext)
+	// stop reporting progress so it can't overwrite the final status
+	ext.progress.stop()
 	if runErr != nil {
 		unifiedErr := runErr
 		ewc, supportsEwc := runErr.(ErrorWithClarification)
@@ -86,19 +97,30 @@ func enable(ext *VMExtension) (string, error) {
 
 		if supportsEwc {
 			// The extension supports error clarifications
-			reportErrorWithClarification(ext, enableCmd, ewc.ErrorCode, msgToReport)
+			reportErrorWithClarification(ext, enableCmd, ewc.ErrorCode, msgToReport, substatuses...)
 		} else {
 			// The extension does not support error clarifications
-			reportStatus(ext, status.StatusError, enableCmd, msgToReport)
+			reportStatus(ext, status.StatusError, enableCmd, msgToReport, substatuses...)
 		}
 	} else {
 		ext.ExtensionLogger.Info("Enable succeeded")
-		reportStatus(ext, status.StatusSuccess, enableCmd, msg)
+		reportStatus(ext, status.StatusSuccess, enableCmd, msg, substatuses...)
 	}
 
 	return msg, runErr
 }
 
+// runEnableCallback calls the enable callback provided by the extension, preferring the one that
+// reports substatuses
+func runEnableCallback(ext *VMExtension) (string, []status.Substatus, error) {
+	if ext.exec.enableWithSubstatusesCallback != nil {
+		return ext.exec.enableWithSubstatusesCallback(ext)
+	}
+
+	msg, err := ext.exec.enableCallback(ext)
+	return msg, nil, err
+}
+
 type disableDependencies interface {
 	writeFile(string, []byte, os.FileMode) error
 	remove(name string) error
@@ -135,6 +157,7 @@ func disable(ext *VMExtension) (string, error) {
 		return msg, fmt.Errorf(msg)
 	}
 	ext.ExtensionLogger.Info("disable called")
+	ext.markHeartbeatStopped("the extension is disabled")
 
 	if ext.exec.supportsDisable {
 		ext.ExtensionLogger.Info("Disabling extension")
@@ -171,7 +194,7 @@ func isDisabled(ext *VMExtension) bool {
 		return false
 	}
 	// We are disabled if the disabled file exists in the config folder
-	disabledFile := path.Join(ext.HandlerEnv.ConfigFolder, disabledFileName)
+	disabledFile := getDisabledFilePath(ext)
 	exists, err := doesFileExistDisableDependency(disabledFile)
 	if err != nil {
 		ext.ExtensionLogger.Error("doesFileExit error detected: %v", err.Error())
@@ -179,8 +202,17 @@ func isDisabled(ext *VMExtension) bool {
 	return exists
 }
 
+// getDisabledFilePath returns the path of the file marking the extension as disabled.
+// Each instance of a multiconfig extension is disabled independently.
+func getDisabledFilePath(ext *VMExtension) string {
+	if ext.ConfigName != "" {
+		return path.Join(ext.HandlerEnv.ConfigFolder, ext.ConfigName+"."+disabledFileName)
+	}
+	return path.Join(ext.HandlerEnv.ConfigFolder, disabledFileName)
+}
+
 func setDisabled(ext *VMExtension, disabled bool) error {
-	disabledFile := path.Join(ext.HandlerEnv.ConfigFolder, disabledFileName)
+	disabledFile := getDisabledFilePath(ext)
 	exists, err := doesFileExistDisableDependency(disabledFile)
 	if err != nil {
 		ext.ExtensionLogger.Error("doesFileExit error detected: %v", err.Error())
@@ -0,0 +1,69 @@
+// Copyright (c) Microsoft Corporation.
+// Licensed under the MIT License.
+package vmextension
+
+import (
+	"os"
+	"time"
+
+	"github.com/Azure/azure-extension-platform/pkg/heartbeat"
+)
+
+// StartHeartbeat starts writing the result of probe to the heartbeat file passed by the guest agent
+// every interval. Long running extensions call this from their enable callback. The heartbeat
+// is stopped when StopHeartbeat is called, or when the extension is disabled or uninstalled, which
+// write a final not ready heartbeat from the process the guest agent runs them in.
+func (ve *VMExtension) StartHeartbeat(interval time.Duration, probe heartbeat.HealthProbe) error {
+	ve.heartbeatMutex.Lock()
+	defer ve.heartbeatMutex.Unlock()
+	ve.stopHeartbeatLocked()
+
+	hw, err := heartbeat.New(ve.ExtensionLogger, ve.HandlerEnv.HeartbeatFile, interval, probe)
+	if err != nil {
+		return err
+	}
+
+	if err := hw.Start(); err != nil {
+		ve.ExtensionLogger.Error("Failed to start heartbeat: %v", err)
+		return err
+	}
+
+	ve.ExtensionLogger.Info("Started heartbeat to %s", ve.HandlerEnv.HeartbeatFile)
+	ve.heartbeatWriter = hw
+	return nil
+}
+
+// StopHeartbeat stops a heartbeat started with StartHeartbeat. It does nothing if no heartbeat is running.
+func (ve *VMExtension) StopHeartbeat() {
+	ve.heartbeatMutex.Lock()
+	defer ve.heartbeatMutex.Unlock()
+	ve.stopHeartbeatLocked()
+}
+
+// markHeartbeatStopped stops the heartbeat of this process, and writes a final not ready heartbeat
+// that also stops the heartbeat of the enable process, which disable and uninstall don't run in.
+// Nothing is written if the extension never wrote a heartbeat.
+func (ve *VMExtension) markHeartbeatStopped(message string) {
+	ve.StopHeartbeat()
+
+	heartbeatFile := ve.HandlerEnv.HeartbeatFile
+	if heartbeatFile == "" {
+		return
+	}
+	if _, err := os.Stat(heartbeatFile); err != nil {
+		return
+	}
+	if err := heartbeat.MarkStopped(heartbeatFile, message); err != nil {
+		ve.ExtensionLogger.Warn("Failed to write the final heartbeat: %v", err)
+	}
+}
+
+func (ve *VMExtension) stopHeartbeatLocked() {
+	if ve.heartbeatWriter == nil {
+		return
+	}
+
+	ve.heartbeatWriter.Stop()
+	ve.heartbeatWriter = nil
+	ve.ExtensionLogger.Info("Stopped heartbeat")
+}
@@ -0,0 +1,103 @@
+// Copyright (c) Microsoft Corporation.
+// Licensed under the MIT License.
+package vmextension
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/Azure/azure-extension-platform/pkg/heartbeat"
+	"github.com/stretchr/testify/require"
+)
+
+func readyProbe() heartbeat.Heartbeat {
+	return heartbeat.Heartbeat{Status: heartbeat.StatusReady, Message: "running"}
+}
+
+func Test_startHeartbeat(t *testing.T) {
+	ext := createTestVMExtension()
+	ext.HandlerEnv.HeartbeatFile = filepath.Join(t.TempDir(), "heartbeat.log")
+
+	err := ext.StartHeartbeat(time.Hour, readyProbe)
+	require.NoError(t, err)
+	require.FileExists(t, ext.HandlerEnv.HeartbeatFile)
+	require.True(t, ext.heartbeatWriter.IsRunning())
+
+	// starting again replaces the running heartbeat
+	previous := ext.heartbeatWriter
+	err = ext.StartHeartbeat(time.Hour, readyProbe)
+	require.NoError(t, err)
+	require.False(t, previous.IsRunning())
+
+	ext.StopHeartbeat()
+	require.Nil(t, ext.heartbeatWriter)
+}
+
+func Test_startHeartbeatNoHeartbeatFile(t *testing.T) {
+	ext := createTestVMExtension()
+	ext.HandlerEnv.HeartbeatFile = ""
+
+	err := ext.StartHeartbeat(time.Hour, readyProbe)
+	require.Error(t, err)
+	require.Nil(t, ext.heartbeatWriter)
+}
+
+func Test_disableStopsHeartbeat(t *testing.T) {
+	ext := createTestVMExtension()
+	ext.exec.supportsDisable = false
+	ext.HandlerEnv.HeartbeatFile = filepath.Join(t.TempDir(), "heartbeat.log")
+	require.NoError(t, ext.StartHeartbeat(time.Hour, readyProbe))
+	hw := ext.heartbeatWriter
+
+	_, err := disable(ext)
+	require.NoError(t, err)
+	require.False(t, hw.IsRunning())
+	require.Nil(t, ext.heartbeatWriter)
+}
+
+func Test_uninstallStopsHeartbeat(t *testing.T) {
+	ext := createTestVMExtension()
+	ext.HandlerEnv.HeartbeatFile = filepath.Join(t.TempDir(), "heartbeat.log")
+	require.NoError(t, ext.StartHeartbeat(time.Hour, readyProbe))
+	hw := ext.heartbeatWriter
+
+	installDependency = &evilInstallDependencies{statErrorToReturn: nil}
+	defer resetDependencies()
+
+	_, err := uninstall(ext)
+	require.NoError(t, err)
+	require.False(t, hw.IsRunning())
+}
+
+func Test_disableStopsHeartbeatOfEnableProcess(t *testing.T) {
+	ext := createTestVMExtension()
+	ext.exec.supportsDisable = false
+	ext.HandlerEnv.HeartbeatFile = filepath.Join(t.TempDir(), "heartbeat.log")
+
+	// the heartbeat written by the enable process, which disable doesn't run in
+	enableProcessWriter, err := heartbeat.New(ext.ExtensionLogger, ext.HandlerEnv.HeartbeatFile, 10*time.Millisecond, readyProbe)
+	require.NoError(t, err)
+	require.NoError(t, enableProcessWriter.Start())
+	defer enableProcessWriter.Stop()
+	require.Nil(t, ext.heartbeatWriter)
+
+	_, err = disable(ext)
+	require.NoError(t, err)
+	require.Eventually(t, func() bool { return !enableProcessWriter.IsRunning() }, 5*time.Second, 5*time.Millisecond)
+	b, err := os.ReadFile(ext.HandlerEnv.HeartbeatFile)
+	require.NoError(t, err)
+	require.Contains(t, string(b), `"notready"`)
+	require.Contains(t, string(b), "the extension is disabled")
+}
+
+func Test_disableWithoutHeartbeatWritesNone(t *testing.T) {
+	ext := createTestVMExtension()
+	ext.exec.supportsDisable = false
+	ext.HandlerEnv.HeartbeatFile = filepath.Join(t.TempDir(), "heartbeat.log")
+
+	_, err := disable(ext)
+	require.NoError(t, err)
+	require.NoFileExists(t, ext.HandlerEnv.HeartbeatFile)
+}
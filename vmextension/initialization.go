@@ -3,7 +3,11 @@
 package vmextension
 
 import (
+	"time"
+
 	"github.com/Azure/azure-extension-platform/pkg/extensionerrors"
+	"github.com/Azure/azure-extension-platform/pkg/extensionevents"
+	"github.com/Azure/azure-extension-platform/pkg/logging"
 	"github.com/Azure/azure-extension-platform/pkg/status"
 )
 
@@ -13,23 +17,41 @@ type CallbackFunc func(ext *VMExtension) error
 // EnableCallbackFunc is used for Enable operation callbacks
 type EnableCallbackFunc func(ext *VMExtension) (string, error)
 
+// EnableWithSubstatusesCallbackFunc is used for Enable operation callbacks that also report substatuses,
+// such as the output of individual scripts. The substatuses are saved with the Enable status
+// whether or not an error is returned.
+type EnableWithSubstatusesCallbackFunc func(ext *VMExtension) (string, []status.Substatus, error)
+
 // InitializationInfo is passed by the extension to specify how the framework should run
 type InitializationInfo struct {
-	Name                  string                        // The name of the extension, without the Linux or Windows suffix
-	Version               string                        // The version of the extension
-	SupportsDisable       bool                          // True if we should automatically disable the extension if Disable is called
-	SupportsResetState    bool                          // True if we should remove all contents of all folder when ResetState is called
-	RequiresSeqNoChange   bool                          // True if Enable will only execute if the sequence number changes
-	InstallExitCode       int                           // Exit code to use for the install case
-	OtherExitCode         int                           // Exit code to use for all other cases
-	EnableCallback        EnableCallbackFunc            // Called for the enable operation
-	DisableCallback       CallbackFunc                  // Called for the Disable operation. Only set this if the extension wants a callback.
-	UpdateCallback        CallbackFunc                  // Called for the Update operation. If nil, then update is not supported.
-	ResetStateCallback    CallbackFunc                  // Called for the ResetState operation. Only set this if the extension wants a callback.
-	InstallCallback       CallbackFunc                  // Called for the Install operation. Only set this if the extension wants a callback.
-	UninstallCallback     CallbackFunc                  // Called for the Uninstall operation. Only set this if the extension wants a callback.
-	CustomStatusFormatter status.StatusMessageFormatter // Provide a function to format the status message. If nil default formatting behavior will be preserved.
-	LogFileNamePattern    string                        // Default format to use for log files. Expected to be format string with one parameter; Eg: "<name_pattern>%v"
+	Name                          string                            // The name of the extension, without the Linux or Windows suffix
+	Version                       string                            // The version of the extension
+	SupportsDisable               bool                              // True if we should automatically disable the extension if Disable is called
+	SupportsResetState            bool                              // True if we should remove all contents of all folder when ResetState is called
+	RequiresSeqNoChange           bool                              // True if Enable will only execute if the sequence number changes
+	InstallExitCode               int                               // Exit code to use for the install case
+	OtherExitCode                 int                               // Exit code to use for all other cases
+	EnableCallback                EnableCallbackFunc                // Called for the enable operation
+	EnableWithSubstatusesCallback EnableWithSubstatusesCallbackFunc // Called for the enable operation instead of EnableCallback if set
+	DisableCallback               CallbackFunc                      // Called for the Disable operation. Only set this if the extension wants a callback.
+	UpdateCallback                CallbackFunc                      // Called for the Update operation. Its error is logged but does not fail the update.
+	UpdateWithContextCallback     UpdateWithContextCallbackFunc     // Called for the Update operation with the versions being updated between. Its error fails the update.
+	Migrations                    *MigrationRegistry                // Migrations run for the Update operation before UpdateWithContextCallback
+	ResetStateCallback            CallbackFunc                      // Called for the ResetState operation. Only set this if the extension wants a callback.
+	InstallCallback               CallbackFunc                      // Called for the Install operation. Only set this if the extension wants a callback.
+	UninstallCallback             CallbackFunc                      // Called for the Uninstall operation. Only set this if the extension wants a callback.
+	CustomStatusFormatter         status.StatusMessageFormatter     // Provide a function to format the status message. If nil default formatting behavior will be preserved.
+	LogFileNamePattern            string                            // Default format to use for log files. Expected to be format string with one parameter; Eg: "<name_pattern>%v"
+	LogRotationPolicy             *logging.RotationPolicy           // How log files are rotated. If nil, logging.DefaultRotationPolicy is used.
+	LogFormatter                  logging.Formatter                 // Format of the log, such as &logging.JSONFormatter{}. If nil, the text format is used.
+	LogLevel                      string                            // Most verbose level logged, such as "verbose" or "debug". If empty, info is used. The logging.LogLevelEnvVar environment variable takes precedence.
+	LogLevelSettingName           string                            // Name of a public setting that overrides LogLevel, such as "logLevel". The environment variable still takes precedence.
+	LogStackTraceLevel            string                            // Most verbose level logged with the call stack, or "none". If empty, only errors have the call stack.
+	SupportsMultiConfig           bool                              // True if the extension is a multiconfig extension. Operations then apply to the instance named by the ConfigExtensionName environment variable
+	EventBufferOptions            *extensionevents.BufferOptions    // If set, events are buffered and written together. They are written when the operation completes and by exithelper.Exiter.
+	DisableLifecycleEvents        bool                              // True if the framework shouldn't write an event when each operation starts and ends
+	ProgressReportInterval        time.Duration                     // Minimum time between progress updates written by ReportProgress. If zero, DefaultProgressReportInterval is used.
+	MaxStatusFileSizeInBytes      int                               // Status messages are truncated to keep the status file within this size. If zero, status.DefaultMaxStatusFileSizeInBytes is used. If negative, there is no limit.
 }
 
 // GetInitializationInfo returns a new InitializationInfo object
@@ -43,19 +65,42 @@ func GetInitializationInfo(name string, version string, requiresSeqNoChange bool
 	}
 
 	return &InitializationInfo{
-		Name:                name,
-		Version:             version,
-		SupportsDisable:     true,
-		SupportsResetState:  true,
-		RequiresSeqNoChange: requiresSeqNoChange,
-		InstallExitCode:     52,
-		OtherExitCode:       3,
-		EnableCallback:      enableCallback,
-		DisableCallback:     nil,
-		UpdateCallback:      nil,
-		ResetStateCallback:  nil,
-		InstallCallback:     nil,
-		UninstallCallback:   nil,
-		LogFileNamePattern:  "",
+		Name:                      name,
+		Version:                   version,
+		SupportsDisable:           true,
+		SupportsResetState:        true,
+		RequiresSeqNoChange:       requiresSeqNoChange,
+		InstallExitCode:           52,
+		OtherExitCode:             3,
+		EnableCallback:            enableCallback,
+		DisableCallback:           nil,
+		UpdateCallback:            nil,
+		UpdateWithContextCallback: nil,
+		Migrations:                nil,
+		ResetStateCallback:        nil,
+		InstallCallback:           nil,
+		UninstallCallback:         nil,
+		LogFileNamePattern:        "",
+		SupportsMultiConfig:       false,
+		ProgressReportInterval:    DefaultProgressReportInterval,
 	}, nil
 }
+
+// GetInitializationInfoWithSubstatuses returns a new InitializationInfo object for an extension
+// whose enable callback reports substatuses
+func GetInitializationInfoWithSubstatuses(name string, version string, requiresSeqNoChange bool, enableCallback EnableWithSubstatusesCallbackFunc) (*InitializationInfo, error) {
+	if enableCallback == nil {
+		return nil, extensionerrors.ErrArgCannotBeNull
+	}
+
+	ii, err := GetInitializationInfo(name, version, requiresSeqNoChange, func(ext *VMExtension) (string, error) {
+		msg, _, err := enableCallback(ext)
+		return msg, err
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	ii.EnableWithSubstatusesCallback = enableCallback
+	return ii, nil
+}
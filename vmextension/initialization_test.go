@@ -23,6 +23,19 @@ func Test_initializationInfoValidate(t *testing.T) {
 	require.Equal(t, extensionerrors.ErrArgCannotBeNull, err)
 }
 
+func Test_initializationInfoWithSubstatusesValidate(t *testing.T) {
+	_, err := GetInitializationInfoWithSubstatuses("", "5.0", true, testEnableWithSubstatusesCallback)
+	require.Equal(t, extensionerrors.ErrArgCannotBeNullOrEmpty, err)
+
+	_, err = GetInitializationInfoWithSubstatuses("yaba", "5.0", true, nil)
+	require.Equal(t, extensionerrors.ErrArgCannotBeNull, err)
+
+	ii, err := GetInitializationInfoWithSubstatuses("yaba", "5.0", true, testEnableWithSubstatusesCallback)
+	require.NoError(t, err)
+	require.NotNil(t, ii.EnableCallback)
+	require.NotNil(t, ii.EnableWithSubstatusesCallback)
+}
+
 func Test_initializationInfoDefaults(t *testing.T) {
 	ii, err := GetInitializationInfo("yaba", "5.0", true, testEnableCallback)
 	require.NoError(t, err, "Error from initialization")
@@ -78,21 +78,11 @@ func removeDirectoryContents(dir string) error {
 	return nil
 }
 
-func update(ext *VMExtension) (string, error) {
-	ext.ExtensionLogger.Info("update called")
-
-	// The only thing we do for update is call the callback if we have one
-	if ext.exec.updateCallback != nil {
-		err := ext.exec.updateCallback(ext)
-		if err != nil {
-			ext.ExtensionLogger.Error("Update failed: %v", err)
-		}
-	}
-
-	return "", nil
-}
-
 func install(ext *VMExtension) (string, error) {
+	// During an update, the Guest Agent reports whether uninstall of the old version failed. The
+	// install callback gets it from GetPreviousVersionResult to clean up after it.
+	getPreviousVersionResult(ext, UninstallOperation)
+
 	// Create the data directory if it doesn't exist
 	exists, err := doesFileExistInstallDependency(ext.HandlerEnv.DataFolder)
 	if err != nil {
@@ -122,6 +112,8 @@ func install(ext *VMExtension) (string, error) {
 }
 
 func uninstall(ext *VMExtension) (string, error) {
+	ext.markHeartbeatStopped("the extension is uninstalled")
+
 	exists, err := doesFileExistInstallDependency(ext.HandlerEnv.DataFolder)
 	if err != nil {
 		return "", err
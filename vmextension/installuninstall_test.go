@@ -75,6 +75,30 @@ func Test_installCallback(t *testing.T) {
 	require.True(t, errorCallbackCalled)
 }
 
+func Test_installCallbackHasPreviousUninstall(t *testing.T) {
+	ext := createTestVMExtension()
+	createDirsForVMExtension(ext)
+	defer cleanupDirsForVMExtension(ext)
+	t.Setenv(string(GuestAgentEnvVarUninstallCmdExitCode), "2")
+
+	var received *PreviousVersionResult
+	ext.exec.installCallback = func(ext *VMExtension) error {
+		var err error
+		received, err = GetPreviousVersionResult(UninstallOperation)
+		return err
+	}
+
+	_, err := install(ext)
+	require.NoError(t, err)
+	require.Equal(t, &PreviousVersionResult{Operation: UninstallOperation, ExitCode: 2}, received)
+
+	// nothing is reported for a fresh install
+	t.Setenv(string(GuestAgentEnvVarUninstallCmdExitCode), "")
+	_, err = install(ext)
+	require.NoError(t, err)
+	require.Nil(t, received)
+}
+
 func Test_uninstallCallback(t *testing.T) {
 	ext := createTestVMExtension()
 	createDirsForVMExtension(ext)
@@ -0,0 +1,73 @@
+// Copyright (c) Microsoft Corporation.
+// Licensed under the MIT License.
+package vmextension
+
+import (
+	"github.com/Azure/azure-extension-platform/pkg/extensionevents"
+)
+
+const (
+	// LifecycleEventTaskName is the task name of the events the framework writes for each operation
+	LifecycleEventTaskName = "ExtensionOperation"
+
+	LifecycleEventStarted   = "started"
+	LifecycleEventSucceeded = "succeeded"
+	LifecycleEventFailed    = "failed"
+)
+
+// Fields of the lifecycle events
+const (
+	LifecycleFieldOperation = "operation"
+	LifecycleFieldSeqNo     = "seqNo"
+	LifecycleFieldResult    = "result"
+	LifecycleFieldExitCode  = "exitCode"
+	LifecycleFieldErrorCode = "errorCode"
+)
+
+// startLifecycleEvent writes the event for the start of the operation and returns the timer that
+// writes the event for its end, or nil if lifecycle events are turned off. Both events have the
+// operation and sequence number.
+func (ve *VMExtension) startLifecycleEvent(operation OperationName, seqNo *uint) *extensionevents.EventTimer {
+	if ve.ExtensionEvents == nil || ve.exec.disableLifecycleEvents || ve.HandlerEnv == nil || ve.HandlerEnv.EventsFolder == "" {
+		return nil
+	}
+
+	fields := extensionevents.EventFields{LifecycleFieldOperation: operation.ToString()}
+	if seqNo != nil {
+		fields[LifecycleFieldSeqNo] = *seqNo
+	}
+	ve.ExtensionEvents.LogEvent(extensionevents.EventLevelInformational, LifecycleEventTaskName, LifecycleEventStarted, fields)
+	return ve.ExtensionEvents.StartTimer(LifecycleEventTaskName, fields)
+}
+
+// flushEvents writes the events buffered by ExtensionEvents, if any
+func (ve *VMExtension) flushEvents() {
+	if ve.ExtensionEvents != nil {
+		ve.ExtensionEvents.Flush()
+	}
+}
+
+// stopLifecycleEvent writes the event for the end of the operation with its duration and result
+func (ve *VMExtension) stopLifecycleEvent(timer *extensionevents.EventTimer, err error, exitCode int) {
+	if timer == nil {
+		return
+	}
+
+	if err == nil {
+		timer.Stop(extensionevents.EventLevelInformational, LifecycleEventSucceeded, extensionevents.EventFields{
+			LifecycleFieldResult:   LifecycleEventSucceeded,
+			LifecycleFieldExitCode: exitCode,
+		})
+		return
+	}
+
+	fields := extensionevents.EventFields{
+		LifecycleFieldResult:            LifecycleEventFailed,
+		LifecycleFieldExitCode:          exitCode,
+		extensionevents.EventFieldError: err,
+	}
+	if errorCode := errorCodeOrDefault(err, 0); errorCode != 0 {
+		fields[LifecycleFieldErrorCode] = errorCode
+	}
+	timer.Stop(extensionevents.EventLevelError, LifecycleEventFailed, fields)
+}
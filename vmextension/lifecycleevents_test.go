@@ -0,0 +1,209 @@
+// Copyright (c) Microsoft Corporation.
+// Licensed under the MIT License.
+package vmextension
+
+import (
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/Azure/azure-extension-platform/pkg/exithelper"
+	"github.com/Azure/azure-extension-platform/pkg/extensionevents"
+	"github.com/stretchr/testify/require"
+)
+
+type lifecycleEvent struct {
+	TaskName   string `json:"TaskName"`
+	EventLevel string `json:"EventLevel"`
+	Fields     map[string]interface{}
+}
+
+func readLifecycleEvents(t *testing.T, eventsFolder string) []lifecycleEvent {
+	fileNames, err := filepath.Glob(filepath.Join(eventsFolder, "*.json"))
+	require.NoError(t, err)
+
+	var events []lifecycleEvent
+	for _, fileName := range fileNames {
+		b, err := os.ReadFile(fileName)
+		require.NoError(t, err)
+		var raw struct {
+			lifecycleEvent
+			Message string `json:"Message"`
+		}
+		require.NoError(t, json.Unmarshal(b, &raw))
+		require.NoError(t, json.Unmarshal([]byte(raw.Message), &raw.lifecycleEvent.Fields))
+		events = append(events, raw.lifecycleEvent)
+	}
+	return events
+}
+
+// doWithEvents runs the enable operation with Do and returns the exit code it used
+func doWithEvents(t *testing.T, ii *InitializationInfo) (exitCode int, eventsFolder string) {
+	return doWithEventsForManager(t, ii, createMockVMExtensionEnvironmentManager())
+}
+
+func doWithEventsForManager(t *testing.T, ii *InitializationInfo, mm *mockGetVMExtensionEnvironmentManager) (exitCode int, eventsFolder string) {
+	he := *mm.he
+	he.EventsFolder = t.TempDir()
+	mm.he = &he
+	ext, err := getVMExtensionInternal(ii, mm)
+	require.NoError(t, err)
+	createDirsForVMExtension(ext)
+	t.Cleanup(func() { cleanupDirsForVMExtension(ext) })
+
+	oldArgs := os.Args
+	defer putBackArgs(oldArgs)
+	os.Args = []string{"dontcare", EnableOperation.ToString()}
+	oldExiter := exithelper.Exiter
+	defer func() { exithelper.Exiter = oldExiter }()
+	eh := &MockExitHelper{}
+	exithelper.Exiter = eh
+
+	ext.Do()
+	return eh.exitCode, he.EventsFolder
+}
+
+func Test_lifecycleEventsSucceeded(t *testing.T) {
+	ii, _ := GetInitializationInfo("yaba", "5.0", false, testEnableCallback)
+	exitCode, eventsFolder := doWithEvents(t, ii)
+	require.Equal(t, 0, exitCode)
+
+	events := readLifecycleEvents(t, eventsFolder)
+	require.Len(t, events, 2)
+	for _, e := range events {
+		require.Equal(t, LifecycleEventTaskName, e.TaskName)
+		require.Equal(t, "Informational", e.EventLevel)
+		require.Equal(t, "enable", e.Fields[LifecycleFieldOperation])
+		require.Equal(t, float64(5), e.Fields[LifecycleFieldSeqNo])
+	}
+	require.Equal(t, LifecycleEventStarted, events[0].Fields["message"])
+	require.Equal(t, LifecycleEventSucceeded, events[1].Fields[LifecycleFieldResult])
+	require.Equal(t, float64(0), events[1].Fields[LifecycleFieldExitCode])
+	require.Contains(t, events[1].Fields, "durationMs")
+}
+
+func Test_lifecycleEventsFailed(t *testing.T) {
+	ii, _ := GetInitializationInfo("yaba", "5.0", false, func(ext *VMExtension) (string, error) {
+		return "", NewErrorWithClarification(42, errors.New("no cupcakes"))
+	})
+	exitCode, eventsFolder := doWithEvents(t, ii)
+	require.Equal(t, 3, exitCode)
+
+	events := readLifecycleEvents(t, eventsFolder)
+	require.Len(t, events, 2)
+	failed := events[1]
+	require.Equal(t, "Error", failed.EventLevel)
+	require.Equal(t, LifecycleEventFailed, failed.Fields[LifecycleFieldResult])
+	require.Equal(t, float64(3), failed.Fields[LifecycleFieldExitCode])
+	require.Equal(t, float64(42), failed.Fields[LifecycleFieldErrorCode])
+	require.Contains(t, failed.Fields["error"], "no cupcakes")
+}
+
+func Test_lifecycleEventsUnchangedSequenceNumber(t *testing.T) {
+	ii, _ := GetInitializationInfo("yaba", "5.0", true, func(ext *VMExtension) (string, error) {
+		t.Fatal("enable ran for an unchanged sequence number")
+		return "", nil
+	})
+	mm := createMockVMExtensionEnvironmentManager()
+	mm.currentSeqNo = mm.seqNo
+	exitCode, eventsFolder := doWithEventsForManager(t, ii, mm)
+	require.Equal(t, 0, exitCode)
+
+	events := readLifecycleEvents(t, eventsFolder)
+	require.Len(t, events, 2, "the started event has a matching end")
+	require.Equal(t, LifecycleEventSucceeded, events[1].Fields[LifecycleFieldResult])
+	require.Equal(t, float64(0), events[1].Fields[LifecycleFieldExitCode])
+}
+
+func Test_lifecycleFieldsArentAddedToOtherEvents(t *testing.T) {
+	ii, _ := GetInitializationInfo("yaba", "5.0", false, func(ext *VMExtension) (string, error) {
+		ext.ExtensionEvents.LogEvent(extensionevents.EventLevelInformational, "download", "done", nil)
+		return "", nil
+	})
+	_, eventsFolder := doWithEvents(t, ii)
+
+	events := readLifecycleEvents(t, eventsFolder)
+	require.Len(t, events, 3)
+	for _, e := range events {
+		if e.TaskName == "download" {
+			require.Equal(t, map[string]interface{}{"message": "done"}, e.Fields)
+		} else {
+			require.Equal(t, "enable", e.Fields[LifecycleFieldOperation])
+		}
+	}
+}
+
+func Test_lifecycleEventsDisabled(t *testing.T) {
+	ii, _ := GetInitializationInfo("yaba", "5.0", false, testEnableCallback)
+	ii.DisableLifecycleEvents = true
+	_, eventsFolder := doWithEvents(t, ii)
+	require.Empty(t, readLifecycleEvents(t, eventsFolder))
+}
+
+func Test_doWritesBufferedEventsBeforeExiting(t *testing.T) {
+	ii, _ := GetInitializationInfo("yaba", "5.0", false, func(ext *VMExtension) (string, error) {
+		return "", errors.New("no cupcakes")
+	})
+	ii.EventBufferOptions = &extensionevents.BufferOptions{FlushInterval: time.Hour}
+
+	exitCode, eventsFolder := doWithEvents(t, ii)
+	require.Equal(t, 3, exitCode)
+	fileNames, err := filepath.Glob(filepath.Join(eventsFolder, "*.json"))
+	require.NoError(t, err)
+	require.Len(t, fileNames, 1, "the buffered events are written before exiting")
+}
+
+func Test_exitHandlersWriteBufferedEvents(t *testing.T) {
+	var fileNames []string
+	ii, _ := GetInitializationInfo("yaba", "5.0", false, func(ext *VMExtension) (string, error) {
+		ext.ExtensionEvents.LogInformationalEvent("enable", "exiting")
+		// ExitHelper runs the exit handlers before it exits the process
+		exithelper.RunExitHandlers()
+		var err error
+		fileNames, err = filepath.Glob(filepath.Join(ext.HandlerEnv.EventsFolder, "*.json"))
+		return "", err
+	})
+	ii.EventBufferOptions = &extensionevents.BufferOptions{FlushInterval: time.Hour}
+
+	exitCode, _ := doWithEvents(t, ii)
+	require.Equal(t, 0, exitCode)
+	require.Len(t, fileNames, 1, "the buffered events are written by the exit handlers")
+}
+
+func Test_bufferedEventsAreWrittenOnPanic(t *testing.T) {
+	ii, _ := GetInitializationInfo("yaba", "5.0", false, func(ext *VMExtension) (string, error) {
+		ext.ExtensionEvents.LogInformationalEvent("enable", "about to panic")
+		panic("no cupcakes")
+	})
+	ii.EventBufferOptions = &extensionevents.BufferOptions{FlushInterval: time.Hour}
+
+	var eventsFolder string
+	require.Panics(t, func() {
+		mm := createMockVMExtensionEnvironmentManager()
+		he := *mm.he
+		he.EventsFolder = t.TempDir()
+		eventsFolder = he.EventsFolder
+		mm.he = &he
+		ext, err := getVMExtensionInternal(ii, mm)
+		require.NoError(t, err)
+		createDirsForVMExtension(ext)
+		defer cleanupDirsForVMExtension(ext)
+		defer ext.ExtensionEvents.DisableBuffering()
+
+		oldArgs := os.Args
+		defer putBackArgs(oldArgs)
+		os.Args = []string{"dontcare", EnableOperation.ToString()}
+		ext.Do()
+	})
+
+	fileNames, err := filepath.Glob(filepath.Join(eventsFolder, "*.json"))
+	require.NoError(t, err)
+	require.Len(t, fileNames, 1, "the buffered events are written together")
+	b, err := os.ReadFile(fileNames[0])
+	require.NoError(t, err)
+	require.Contains(t, string(b), "about to panic")
+	require.Contains(t, string(b), LifecycleEventStarted)
+}
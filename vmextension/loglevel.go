@@ -0,0 +1,75 @@
+// Copyright (c) Microsoft Corporation.
+// Licensed under the MIT License.
+package vmextension
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"github.com/Azure/azure-extension-platform/pkg/logging"
+)
+
+// setLogLevels applies the log levels from the initialization info. The level set by
+// logging.LogLevelEnvVar takes precedence, so it isn't overridden.
+func setLogLevels(el *logging.ExtensionLogger, initInfo *InitializationInfo) error {
+	if initInfo.LogStackTraceLevel != "" {
+		level, err := logging.ParseLevel(initInfo.LogStackTraceLevel)
+		if err != nil {
+			return fmt.Errorf("invalid LogStackTraceLevel: %w", err)
+		}
+		el.SetStackTraceLevel(level)
+	}
+
+	if initInfo.LogLevel != "" {
+		level, err := logging.ParseLevel(initInfo.LogLevel)
+		if err != nil {
+			return fmt.Errorf("invalid LogLevel: %w", err)
+		}
+		if _, isSet, _ := logging.LevelFromEnvironment(); !isSet {
+			el.SetLevel(level)
+		}
+	}
+	return nil
+}
+
+// applyLogLevelSetting sets the log level from the public setting named by LogLevelSettingName,
+// if the extension has one and it is present. Only the public settings of the sequence number are
+// read, and a missing or invalid setting leaves the level as it is.
+func (ve *VMExtension) applyLogLevelSetting(seqNo uint) {
+	if ve.exec.logLevelSettingName == "" {
+		return
+	}
+	if _, isSet, _ := logging.LevelFromEnvironment(); isSet {
+		return
+	}
+
+	publicSettings, err := ve.exec.getPublicSettings(seqNo)
+	if err != nil {
+		ve.ExtensionLogger.Warn("could not read setting %s: %v", ve.exec.logLevelSettingName, err)
+		return
+	}
+	if strings.TrimSpace(publicSettings) == "" {
+		return
+	}
+	var public map[string]interface{}
+	if err := json.Unmarshal([]byte(publicSettings), &public); err != nil {
+		ve.ExtensionLogger.Warn("could not read setting %s: %v", ve.exec.logLevelSettingName, err)
+		return
+	}
+	value, ok := public[ve.exec.logLevelSettingName]
+	if !ok {
+		return
+	}
+	s, ok := value.(string)
+	if !ok {
+		ve.ExtensionLogger.Warn("ignoring setting %s: the log level must be a string", ve.exec.logLevelSettingName)
+		return
+	}
+	level, err := logging.ParseLevel(s)
+	if err != nil {
+		ve.ExtensionLogger.Warn("ignoring setting %s: %v", ve.exec.logLevelSettingName, err)
+		return
+	}
+	ve.ExtensionLogger.SetLevel(level)
+}
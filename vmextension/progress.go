@@ -0,0 +1,134 @@
+// Copyright (c) Microsoft Corporation.
+// Licensed under the MIT License.
+package vmextension
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/Azure/azure-extension-platform/pkg/status"
+)
+
+// DefaultProgressReportInterval is the minimum time between progress updates written to the status file
+const DefaultProgressReportInterval = 5 * time.Second
+
+// UnknownProgress can be passed to ReportProgress when the percentage complete is not known
+const UnknownProgress = -1
+
+var errNoOperationInProgress = fmt.Errorf("progress can only be reported while enable is running")
+
+// progressReporter writes transitioning status updates for the running operation. Updates are
+// written at most once per interval; an update made sooner is held back and written when the
+// interval expires, replacing any update held back before it.
+type progressReporter struct {
+	ext      *VMExtension
+	c        cmd
+	interval time.Duration
+
+	mutex          sync.Mutex
+	lastWrite      time.Time
+	pendingMessage string
+	hasPending     bool
+	timer          *time.Timer
+	stopped        bool
+}
+
+func newProgressReporter(ext *VMExtension, c cmd, interval time.Duration) *progressReporter {
+	if interval <= 0 {
+		interval = DefaultProgressReportInterval
+	}
+
+	return &progressReporter{
+		ext:      ext,
+		c:        c,
+		interval: interval,
+		// the operation has just reported that it is transitioning
+		lastWrite: time.Now(),
+	}
+}
+
+// ReportProgress updates the transitioning status of the enable operation with the percentage
+// complete, from 0 to 100 or UnknownProgress, and a message. It is meant to be called from the
+// enable callback and can be called from multiple goroutines. Updates are rate limited, so only
+// the latest update may be written. An error is returned if enable is not running.
+func (ve *VMExtension) ReportProgress(percentComplete int, message string) error {
+	if ve.progress == nil {
+		return errNoOperationInProgress
+	}
+
+	return ve.progress.report(formatProgressMessage(percentComplete, message))
+}
+
+func formatProgressMessage(percentComplete int, message string) string {
+	if percentComplete < 0 {
+		return message
+	}
+	if percentComplete > 100 {
+		percentComplete = 100
+	}
+
+	progress := fmt.Sprintf("%d%% complete", percentComplete)
+	if message == "" {
+		return progress
+	}
+	return progress + ": " + message
+}
+
+func (pr *progressReporter) report(message string) error {
+	pr.mutex.Lock()
+	defer pr.mutex.Unlock()
+
+	if pr.stopped {
+		return errNoOperationInProgress
+	}
+
+	wait := pr.interval - time.Since(pr.lastWrite)
+	if wait <= 0 {
+		pr.hasPending = false
+		return pr.write(message)
+	}
+
+	pr.pendingMessage = message
+	pr.hasPending = true
+	if pr.timer == nil {
+		pr.timer = time.AfterFunc(wait, pr.flush)
+	}
+	return nil
+}
+
+// flush writes the update held back by the rate limit, if any
+func (pr *progressReporter) flush() {
+	pr.mutex.Lock()
+	defer pr.mutex.Unlock()
+
+	pr.timer = nil
+	if pr.stopped || !pr.hasPending {
+		return
+	}
+
+	pr.hasPending = false
+	if err := pr.write(pr.pendingMessage); err != nil {
+		pr.ext.ExtensionLogger.Warn("Failed to report progress: %v", err)
+	}
+}
+
+// write must be called with the mutex held
+func (pr *progressReporter) write(message string) error {
+	pr.lastWrite = time.Now()
+	return reportStatus(pr.ext, status.StatusTransitioning, pr.c, message)
+}
+
+// stop discards any held back update and rejects further updates. Once stop returns,
+// no progress is written, so the final status of the operation cannot be overwritten.
+func (pr *progressReporter) stop() {
+	pr.mutex.Lock()
+	defer pr.mutex.Unlock()
+
+	pr.stopped = true
+	pr.hasPending = false
+	if pr.timer != nil {
+		pr.timer.Stop()
+		pr.timer = nil
+	}
+}
@@ -0,0 +1,130 @@
+// Copyright (c) Microsoft Corporation.
+// Licensed under the MIT License.
+package vmextension
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/Azure/azure-extension-platform/pkg/status"
+	"github.com/stretchr/testify/require"
+)
+
+func readStatusFileIfExists(ext *VMExtension) (status.StatusReport, error) {
+	seqNo, err := ext.GetRequestedSequenceNumber()
+	if err != nil {
+		return nil, err
+	}
+	b, err := os.ReadFile(path.Join(ext.HandlerEnv.StatusFolder, fmt.Sprintf("%d.status", seqNo)))
+	if err != nil {
+		return nil, err
+	}
+	var report status.StatusReport
+	return report, json.Unmarshal(b, &report)
+}
+
+func Test_formatProgressMessage(t *testing.T) {
+	require.Equal(t, "40% complete: downloading", formatProgressMessage(40, "downloading"))
+	require.Equal(t, "0% complete", formatProgressMessage(0, ""))
+	require.Equal(t, "100% complete: done", formatProgressMessage(150, "done"))
+	require.Equal(t, "downloading", formatProgressMessage(UnknownProgress, "downloading"))
+}
+
+func Test_reportProgressOutsideEnable(t *testing.T) {
+	ext := createTestVMExtension()
+	require.Equal(t, errNoOperationInProgress, ext.ReportProgress(50, "yaba"))
+}
+
+func Test_reportProgressWritesTransitioningStatus(t *testing.T) {
+	mm := createMockVMExtensionEnvironmentManager()
+	ii, _ := GetInitializationInfo("yaba", "5.0", true, testEnableCallback)
+	ii.ProgressReportInterval = 20 * time.Millisecond
+	var reportErr error
+	var report status.StatusReport
+	ii.EnableCallback = func(ext *VMExtension) (string, error) {
+		time.Sleep(ii.ProgressReportInterval)
+		reportErr = ext.ReportProgress(40, "downloading")
+		report = readEnableStatusReport(t, ext)
+		return "done", nil
+	}
+	ext, _ := getVMExtensionInternal(ii, mm)
+	createDirsForVMExtension(ext)
+	defer cleanupDirsForVMExtension(ext)
+
+	_, err := enable(ext)
+	require.NoError(t, err, "enable failed")
+	require.NoError(t, reportErr)
+	require.Equal(t, status.StatusTransitioning, report[0].Status.Status)
+	require.Contains(t, report[0].Status.FormattedMessage.Message, "40% complete: downloading")
+
+	// the final status replaces progress and later progress is rejected
+	report = readEnableStatusReport(t, ext)
+	require.Equal(t, status.StatusSuccess, report[0].Status.Status)
+	require.Equal(t, errNoOperationInProgress, ext.ReportProgress(90, "too late"))
+}
+
+func Test_reportProgressIsRateLimited(t *testing.T) {
+	ext := createTestVMExtension()
+	createDirsForVMExtension(ext)
+	defer cleanupDirsForVMExtension(ext)
+
+	interval := 200 * time.Millisecond
+	ext.progress = newProgressReporter(ext, cmd{nil, EnableOperation, true, 3}, interval)
+	defer ext.progress.stop()
+
+	// updates made within the interval are held back and only the latest one is written
+	require.NoError(t, ext.ReportProgress(10, "first"))
+	require.NoError(t, ext.ReportProgress(20, "second"))
+	require.NoError(t, ext.ReportProgress(30, "third"))
+	_, err := readStatusFileIfExists(ext)
+	require.Error(t, err, "progress written before the interval expired")
+
+	require.Eventually(t, func() bool {
+		report, err := readStatusFileIfExists(ext)
+		return err == nil && report[0].Status.FormattedMessage.Message == ext.statusFormatter("Enable", status.StatusTransitioning, "30% complete: third")
+	}, 5*time.Second, 10*time.Millisecond)
+}
+
+func Test_reportProgressStopDiscardsPending(t *testing.T) {
+	ext := createTestVMExtension()
+	createDirsForVMExtension(ext)
+	defer cleanupDirsForVMExtension(ext)
+
+	ext.progress = newProgressReporter(ext, cmd{nil, EnableOperation, true, 3}, 50*time.Millisecond)
+	require.NoError(t, ext.ReportProgress(10, "held back"))
+	ext.progress.stop()
+
+	time.Sleep(100 * time.Millisecond)
+	_, err := readStatusFileIfExists(ext)
+	require.Error(t, err, "held back progress was written after stop")
+}
+
+func Test_reportProgressConcurrently(t *testing.T) {
+	ext := createTestVMExtension()
+	createDirsForVMExtension(ext)
+	defer cleanupDirsForVMExtension(ext)
+
+	ext.progress = newProgressReporter(ext, cmd{nil, EnableOperation, true, 3}, time.Millisecond)
+	time.Sleep(2 * time.Millisecond)
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			for j := 0; j < 10; j++ {
+				require.NoError(t, ext.ReportProgress(i*10+j, fmt.Sprintf("worker %d", i)))
+			}
+		}(i)
+	}
+	wg.Wait()
+	ext.progress.stop()
+
+	report, err := readStatusFileIfExists(ext)
+	require.NoError(t, err)
+	require.Equal(t, status.StatusTransitioning, report[0].Status.Status)
+}
@@ -0,0 +1,300 @@
+// Copyright (c) Microsoft Corporation.
+// Licensed under the MIT License.
+package vmextension
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/Azure/azure-extension-platform/pkg/semver"
+	"github.com/Azure/azure-extension-platform/pkg/status"
+	"github.com/Azure/azure-extension-platform/pkg/utils"
+)
+
+// UpdateContext describes the update being performed. The Guest Agent runs update for the new
+// version of the extension, before the old version is uninstalled.
+type UpdateContext struct {
+	FromVersionString string         // The version being updated from, as set by the Guest Agent
+	ToVersionString   string         // The version being updated to, as set by the Guest Agent
+	FromVersion       semver.Version // The parsed version being updated from
+	ToVersion         semver.Version // The parsed version being updated to
+	OldDataFolder     string         // The data folder of the old version. On Linux it is shared by all versions.
+	OldConfigFolder   string         // The config folder of the old version, or empty if it can't be determined
+
+	// PreviousDisable is the outcome of disable for the old version, which the Guest Agent runs before
+	// update. It is nil if the Guest Agent didn't report it. If it failed, the old version may still be
+	// running or hold resources, and the update can't assume a clean handoff.
+	PreviousDisable *PreviousVersionResult
+}
+
+// PreviousVersionResult is the outcome of a command the Guest Agent ran for the old version of the
+// extension while updating it
+type PreviousVersionResult struct {
+	Operation OperationName // DisableOperation or UninstallOperation
+	ExitCode  int
+}
+
+// Failed returns true if the command exited with a non zero code
+func (r *PreviousVersionResult) Failed() bool {
+	return r != nil && r.ExitCode != 0
+}
+
+// GetPreviousVersionResult returns the exit code the Guest Agent reported for disable or uninstall of the
+// old version. The exit code of disable is passed to update and the exit code of uninstall to install of
+// the new version, so nil is returned when the Guest Agent didn't set it, such as for a fresh install.
+// Update callbacks get the result of disable as UpdateContext.PreviousDisable; the install callback calls
+// GetPreviousVersionResult(UninstallOperation) to know whether uninstall of the old version failed.
+func GetPreviousVersionResult(operation OperationName) (*PreviousVersionResult, error) {
+	var envVar GuestAgentEnvVar
+	switch operation {
+	case DisableOperation:
+		envVar = GuestAgentEnvVarDisableCmdExitCode
+	case UninstallOperation:
+		envVar = GuestAgentEnvVarUninstallCmdExitCode
+	default:
+		return nil, fmt.Errorf("the Guest Agent doesn't report the exit code of %s", operation)
+	}
+
+	value := os.Getenv(string(envVar))
+	if value == "" {
+		return nil, nil
+	}
+	exitCode, err := strconv.Atoi(strings.TrimSpace(value))
+	if err != nil {
+		return nil, fmt.Errorf("could not parse environment variable %s value '%s' as an exit code", envVar, value)
+	}
+	return &PreviousVersionResult{Operation: operation, ExitCode: exitCode}, nil
+}
+
+// getPreviousVersionResult is GetPreviousVersionResult for the framework, which logs what it found
+// instead of failing the operation over a malformed environment variable
+func getPreviousVersionResult(ext *VMExtension, operation OperationName) *PreviousVersionResult {
+	r, err := GetPreviousVersionResult(operation)
+	if err != nil {
+		ext.ExtensionLogger.Warn("%v", err)
+		return nil
+	}
+	if r.Failed() {
+		ext.ExtensionLogger.Warn("%s of the previous version failed with exit code %d", operation, r.ExitCode)
+	}
+	return r
+}
+
+// UpdateWithContextCallbackFunc is used for Update operation callbacks that need to know what is being updated
+type UpdateWithContextCallbackFunc func(ext *VMExtension, uc *UpdateContext) error
+
+// MigrationFunc moves the data or configuration of the extension to the format of a newer version
+type MigrationFunc func(ext *VMExtension, uc *UpdateContext) error
+
+type migration struct {
+	name string
+	from *semver.Version // nil matches any older version
+	to   semver.Version
+	f    MigrationFunc
+}
+
+// MigrationRegistry holds the migrations run during update
+type MigrationRegistry struct {
+	migrations []migration
+}
+
+// NewMigrationRegistry returns an empty MigrationRegistry
+func NewMigrationRegistry() *MigrationRegistry {
+	return &MigrationRegistry{}
+}
+
+// Register adds a migration that runs when updating from a version in [fromVersion, toVersion) to
+// toVersion or later. An empty fromVersion matches any version older than toVersion. Migrations run
+// in the order of their toVersion, and in the order they were registered for the same toVersion.
+func (mr *MigrationRegistry) Register(name string, fromVersion string, toVersion string, f MigrationFunc) error {
+	if name == "" || f == nil {
+		return fmt.Errorf("migration name and function are required")
+	}
+
+	m := migration{name: name, f: f}
+	var err error
+	if m.to, err = semver.Parse(toVersion); err != nil {
+		return fmt.Errorf("migration '%s': %w", name, err)
+	}
+	if fromVersion != "" {
+		from, err := semver.Parse(fromVersion)
+		if err != nil {
+			return fmt.Errorf("migration '%s': %w", name, err)
+		}
+		if !from.LessThan(m.to) {
+			return fmt.Errorf("migration '%s': version %s is not lower than %s", name, fromVersion, toVersion)
+		}
+		m.from = &from
+	}
+
+	mr.migrations = append(mr.migrations, m)
+	return nil
+}
+
+// applicable returns the migrations needed to update from one version to another, in order
+func (mr *MigrationRegistry) applicable(from, to semver.Version) []migration {
+	var ms []migration
+	for _, m := range mr.migrations {
+		if (m.from == nil || !from.LessThan(*m.from)) && from.LessThan(m.to) && !to.LessThan(m.to) {
+			ms = append(ms, m)
+		}
+	}
+	sort.SliceStable(ms, func(i, j int) bool { return ms[i].to.LessThan(ms[j].to) })
+	return ms
+}
+
+// Applicable returns the names of the migrations needed to update from one version to another, in order
+func (mr *MigrationRegistry) Applicable(from, to semver.Version) []string {
+	var names []string
+	for _, m := range mr.applicable(from, to) {
+		names = append(names, m.name)
+	}
+	return names
+}
+
+// run runs the migrations for the update, stopping at the first one that fails
+func (mr *MigrationRegistry) run(ext *VMExtension, uc *UpdateContext) error {
+	for _, m := range mr.applicable(uc.FromVersion, uc.ToVersion) {
+		ext.ExtensionLogger.Info("running migration '%s' for version %s", m.name, m.to)
+		if err := m.f(ext, uc); err != nil {
+			return fmt.Errorf("migration '%s' failed: %w", m.name, err)
+		}
+		ext.ExtensionLogger.Info("migration '%s' completed", m.name)
+	}
+	return nil
+}
+
+func update(ext *VMExtension) (string, error) {
+	ext.ExtensionLogger.Info("update called")
+
+	previousDisable := getPreviousVersionResult(ext, DisableOperation)
+	if ext.exec.updateWithContextCallback == nil && ext.exec.migrations == nil {
+		// The only thing we do for update is call the callback if we have one. Its error isn't
+		// propagated, which extensions written before UpdateContext existed rely on.
+		callLegacyUpdateCallback(ext)
+		return "", nil
+	}
+
+	uc, err := newUpdateContext(ext)
+	if err != nil {
+		ext.ExtensionLogger.Error("Update failed: %v", err)
+		reportUpdateError(ext, ErrorInvalidUpdateVersion, err)
+		return "", err
+	}
+	uc.PreviousDisable = previousDisable
+	ext.ExtensionLogger.Info("updating from version %s to %s", uc.FromVersion, uc.ToVersion)
+
+	if ext.exec.migrations != nil {
+		if err := ext.exec.migrations.run(ext, uc); err != nil {
+			ext.ExtensionLogger.Error("Update failed: %v", err)
+			reportUpdateError(ext, errorCodeOrDefault(err, ErrorMigrationFailed), err)
+			return "", err
+		}
+	}
+
+	if ext.exec.updateWithContextCallback != nil {
+		if err := ext.exec.updateWithContextCallback(ext, uc); err != nil {
+			ext.ExtensionLogger.Error("Update failed: %v", err)
+			reportUpdateError(ext, errorCodeOrDefault(err, ErrorUpdateFailed), err)
+			return "", err
+		}
+	}
+
+	callLegacyUpdateCallback(ext)
+	ext.ExtensionLogger.Info("updated")
+	return "", nil
+}
+
+func callLegacyUpdateCallback(ext *VMExtension) {
+	if ext.exec.updateCallback != nil {
+		err := ext.exec.updateCallback(ext)
+		if err != nil {
+			ext.ExtensionLogger.Error("Update failed: %v", err)
+		}
+	}
+}
+
+// newUpdateContext reads the versions the Guest Agent is updating between from the environment
+func newUpdateContext(ext *VMExtension) (*UpdateContext, error) {
+	fromVersion, err := GetGuestAgentEnvironmetVariable(GuestAgentEnvVarUpdateFromVersion)
+	if err != nil {
+		return nil, err
+	}
+	toVersion := os.Getenv(string(GuestAgentEnvVarUpdateToVersion))
+	if toVersion == "" {
+		toVersion = ext.Version
+	}
+
+	uc := &UpdateContext{
+		FromVersionString: fromVersion,
+		ToVersionString:   toVersion,
+		OldDataFolder:     utils.GetDataFolder(ext.Name, fromVersion),
+		OldConfigFolder:   replaceVersionInPath(ext.HandlerEnv.ConfigFolder, toVersion, fromVersion),
+	}
+	if uc.FromVersion, err = semver.Parse(fromVersion); err != nil {
+		return nil, fmt.Errorf("could not parse the version being updated from: %w", err)
+	}
+	if uc.ToVersion, err = semver.Parse(toVersion); err != nil {
+		return nil, fmt.Errorf("could not parse the version being updated to: %w", err)
+	}
+	return uc, nil
+}
+
+// replaceVersionInPath returns the path with the deepest directory named after the new version,
+// such as C:\Packages\Plugins\{name}\{version} on Windows or /var/lib/waagent/{name}-{version} on Linux,
+// renamed for the old version. It returns an empty string if there is no such directory.
+func replaceVersionInPath(path, newVersion, oldVersion string) string {
+	if path == "" || newVersion == "" {
+		return ""
+	}
+
+	var tail []string
+	dir := filepath.Clean(path)
+	for {
+		base := filepath.Base(dir)
+		if base == newVersion || strings.HasSuffix(base, "-"+newVersion) {
+			renamed := filepath.Join(filepath.Dir(dir), strings.TrimSuffix(base, newVersion)+oldVersion)
+			return filepath.Join(append([]string{renamed}, tail...)...)
+		}
+
+		parent := filepath.Dir(dir)
+		if parent == dir {
+			return ""
+		}
+		tail = append([]string{base}, tail...)
+		dir = parent
+	}
+}
+
+// errorCodeOrDefault returns the code of an ErrorWithClarification, or the default code for other errors
+func errorCodeOrDefault(err error, defaultCode int) int {
+	var ewcPtr *ErrorWithClarification
+	if errors.As(err, &ewcPtr) && ewcPtr != nil {
+		return ewcPtr.ErrorCode
+	}
+	var ewc ErrorWithClarification
+	if errors.As(err, &ewc) {
+		return ewc.ErrorCode
+	}
+	return defaultCode
+}
+
+// reportUpdateError saves an error status for update. Update doesn't otherwise report status, but
+// a failed update should be visible in place of the status carried over from the old version.
+func reportUpdateError(ext *VMExtension, errorCode int, err error) {
+	seqNo, seqErr := ext.GetRequestedSequenceNumber()
+	if seqErr != nil {
+		ext.ExtensionLogger.Warn("Not reporting the update failure, the sequence number is unknown: %v", seqErr)
+		return
+	}
+
+	s := status.NewError(UpdateOperation.ToStatusName(), status.ErrorClarification{Code: errorCode, Message: err.Error()})
+	if saveErr := saveStatusReport(ext, s, seqNo); saveErr != nil {
+		ext.ExtensionLogger.Error("Failed to save handler status: %v", saveErr)
+	}
+}
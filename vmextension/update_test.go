@@ -0,0 +1,222 @@
+// Copyright (c) Microsoft Corporation.
+// Licensed under the MIT License.
+package vmextension
+
+import (
+	"errors"
+	"path/filepath"
+	"testing"
+
+	"github.com/Azure/azure-extension-platform/pkg/semver"
+	"github.com/Azure/azure-extension-platform/pkg/status"
+	"github.com/stretchr/testify/require"
+)
+
+func setUpdateVersions(t *testing.T, from, to string) {
+	t.Setenv(string(GuestAgentEnvVarUpdateFromVersion), from)
+	t.Setenv(string(GuestAgentEnvVarUpdateToVersion), to)
+}
+
+func Test_migrationRegistryApplicable(t *testing.T) {
+	noop := func(ext *VMExtension, uc *UpdateContext) error { return nil }
+	mr := NewMigrationRegistry()
+	require.NoError(t, mr.Register("to3", "", "3.0", noop))
+	require.NoError(t, mr.Register("to2", "", "2.0", noop))
+	require.NoError(t, mr.Register("from1.5to2", "1.5", "2.0", noop))
+	require.NoError(t, mr.Register("to2.1", "", "2.1.0-rc.1", noop))
+
+	applicable := func(from, to string) []string {
+		return mr.Applicable(semver.MustParse(from), semver.MustParse(to))
+	}
+	require.Equal(t, []string{"to2", "to2.1", "to3"}, applicable("1.0", "3.0"))
+	require.Equal(t, []string{"to2", "from1.5to2"}, applicable("1.5", "2.0.1"))
+	require.Equal(t, []string{"to2.1"}, applicable("2.0", "2.1"))
+	require.Empty(t, applicable("3.0", "3.1"))
+	require.Empty(t, applicable("3.0", "1.0"), "downgrades don't migrate")
+}
+
+func Test_migrationRegistryRegisterInvalid(t *testing.T) {
+	noop := func(ext *VMExtension, uc *UpdateContext) error { return nil }
+	mr := NewMigrationRegistry()
+	require.Error(t, mr.Register("", "", "1.0", noop))
+	require.Error(t, mr.Register("yaba", "", "1.0", nil))
+	require.Error(t, mr.Register("yaba", "", "one", noop))
+	require.Error(t, mr.Register("yaba", "two", "1.0", noop))
+	require.Error(t, mr.Register("yaba", "2.0", "1.0", noop))
+}
+
+func Test_updateWithContext(t *testing.T) {
+	ext := createTestVMExtension()
+	createDirsForVMExtension(ext)
+	defer cleanupDirsForVMExtension(ext)
+	setUpdateVersions(t, "1.0", "2.0")
+
+	var ran []string
+	mr := NewMigrationRegistry()
+	require.NoError(t, mr.Register("second", "", "2.0", func(ext *VMExtension, uc *UpdateContext) error {
+		ran = append(ran, "second")
+		return nil
+	}))
+	require.NoError(t, mr.Register("first", "", "1.5", func(ext *VMExtension, uc *UpdateContext) error {
+		ran = append(ran, "first")
+		return nil
+	}))
+	ext.exec.migrations = mr
+
+	var received *UpdateContext
+	ext.exec.updateWithContextCallback = func(ext *VMExtension, uc *UpdateContext) error {
+		ran = append(ran, "callback")
+		received = uc
+		return nil
+	}
+
+	_, err := update(ext)
+	require.NoError(t, err)
+	require.Equal(t, []string{"first", "second", "callback"}, ran)
+	require.Equal(t, "1.0", received.FromVersionString)
+	require.Equal(t, semver.MustParse("1.0"), received.FromVersion)
+	require.Equal(t, semver.MustParse("2.0"), received.ToVersion)
+	require.NotEmpty(t, received.OldDataFolder)
+}
+
+func Test_updateToVersionDefaultsToExtensionVersion(t *testing.T) {
+	ext := createTestVMExtension()
+	setUpdateVersions(t, "4.0", "")
+
+	uc, err := newUpdateContext(ext)
+	require.NoError(t, err)
+	require.Equal(t, "5.0", uc.ToVersionString)
+}
+
+func Test_updateMigrationFailureReportsError(t *testing.T) {
+	ext := createTestVMExtension()
+	createDirsForVMExtension(ext)
+	defer cleanupDirsForVMExtension(ext)
+	setUpdateVersions(t, "1.0", "2.0")
+
+	callbackCalled := false
+	mr := NewMigrationRegistry()
+	require.NoError(t, mr.Register("broken", "", "2.0", func(ext *VMExtension, uc *UpdateContext) error {
+		return errors.New("disk full")
+	}))
+	ext.exec.migrations = mr
+	ext.exec.updateWithContextCallback = func(ext *VMExtension, uc *UpdateContext) error {
+		callbackCalled = true
+		return nil
+	}
+
+	_, err := update(ext)
+	require.Error(t, err)
+	require.False(t, callbackCalled, "the callback doesn't run after a failed migration")
+
+	report := readEnableStatusReport(t, ext)
+	require.Equal(t, status.StatusError, report[0].Status.Status)
+	require.Equal(t, UpdateOperation.ToStatusName(), report[0].Status.Operation)
+	require.Contains(t, report[0].Status.FormattedMessage.Message, "migration 'broken' failed: disk full")
+	require.Equal(t, ErrorMigrationFailed, report[0].Status.Substatuses[0].Code)
+}
+
+func Test_updateCallbackErrorWithClarification(t *testing.T) {
+	ext := createTestVMExtension()
+	createDirsForVMExtension(ext)
+	defer cleanupDirsForVMExtension(ext)
+	setUpdateVersions(t, "1.0", "2.0")
+
+	ext.exec.updateWithContextCallback = func(ext *VMExtension, uc *UpdateContext) error {
+		return NewErrorWithClarification(42, errors.New("cannot update"))
+	}
+
+	_, err := update(ext)
+	require.Error(t, err)
+	report := readEnableStatusReport(t, ext)
+	require.Equal(t, 42, report[0].Status.Substatuses[0].Code)
+}
+
+func Test_updateInvalidVersions(t *testing.T) {
+	ext := createTestVMExtension()
+	createDirsForVMExtension(ext)
+	defer cleanupDirsForVMExtension(ext)
+	ext.exec.updateWithContextCallback = func(ext *VMExtension, uc *UpdateContext) error { return nil }
+
+	setUpdateVersions(t, "", "2.0")
+	_, err := update(ext)
+	require.Error(t, err)
+
+	setUpdateVersions(t, "yaba", "2.0")
+	_, err = update(ext)
+	require.Error(t, err)
+	report := readEnableStatusReport(t, ext)
+	require.Equal(t, ErrorInvalidUpdateVersion, report[0].Status.Substatuses[0].Code)
+}
+
+func Test_getVMExtensionUpdateFailureExitCode(t *testing.T) {
+	mm := createMockVMExtensionEnvironmentManager()
+	ii, _ := GetInitializationInfo("yaba", "5.0", true, testEnableCallback)
+	ii.OtherExitCode = 7
+	ii.Migrations = NewMigrationRegistry()
+	ext, err := getVMExtensionInternal(ii, mm)
+	require.NoError(t, err)
+	require.Equal(t, 7, ext.exec.cmds[UpdateOperation].failExitCode)
+	require.NotNil(t, ext.exec.migrations)
+}
+
+func Test_replaceVersionInPath(t *testing.T) {
+	require.Equal(t, filepath.Join("/var/lib/waagent", "Microsoft.Yaba-1.0", "config"),
+		replaceVersionInPath(filepath.Join("/var/lib/waagent", "Microsoft.Yaba-2.0", "config"), "2.0", "1.0"))
+	require.Equal(t, filepath.Join("/Packages", "Plugins", "Microsoft.Yaba", "1.0", "RuntimeSettings"),
+		replaceVersionInPath(filepath.Join("/Packages", "Plugins", "Microsoft.Yaba", "2.0", "RuntimeSettings"), "2.0", "1.0"))
+	require.Equal(t, "", replaceVersionInPath(filepath.Join(".", "testdir", "config"), "2.0", "1.0"))
+	require.Equal(t, "", replaceVersionInPath("", "2.0", "1.0"))
+}
+
+func Test_getPreviousVersionResult(t *testing.T) {
+	t.Setenv(string(GuestAgentEnvVarDisableCmdExitCode), "")
+	r, err := GetPreviousVersionResult(DisableOperation)
+	require.NoError(t, err)
+	require.Nil(t, r, "not reported")
+	require.False(t, r.Failed())
+
+	t.Setenv(string(GuestAgentEnvVarDisableCmdExitCode), "0")
+	r, err = GetPreviousVersionResult(DisableOperation)
+	require.NoError(t, err)
+	require.Equal(t, &PreviousVersionResult{Operation: DisableOperation, ExitCode: 0}, r)
+	require.False(t, r.Failed())
+
+	t.Setenv(string(GuestAgentEnvVarUninstallCmdExitCode), "3")
+	r, err = GetPreviousVersionResult(UninstallOperation)
+	require.NoError(t, err)
+	require.True(t, r.Failed())
+	require.Equal(t, 3, r.ExitCode)
+
+	t.Setenv(string(GuestAgentEnvVarUninstallCmdExitCode), "yaba")
+	_, err = GetPreviousVersionResult(UninstallOperation)
+	require.Error(t, err)
+
+	_, err = GetPreviousVersionResult(EnableOperation)
+	require.Error(t, err)
+}
+
+func Test_updateContextHasPreviousDisable(t *testing.T) {
+	ext := createTestVMExtension()
+	createDirsForVMExtension(ext)
+	defer cleanupDirsForVMExtension(ext)
+	setUpdateVersions(t, "1.0", "2.0")
+	t.Setenv(string(GuestAgentEnvVarDisableCmdExitCode), "1")
+
+	var received *UpdateContext
+	ext.exec.updateWithContextCallback = func(ext *VMExtension, uc *UpdateContext) error {
+		received = uc
+		return nil
+	}
+
+	_, err := update(ext)
+	require.NoError(t, err)
+	require.True(t, received.PreviousDisable.Failed())
+	require.Equal(t, 1, received.PreviousDisable.ExitCode)
+
+	// a malformed exit code is logged but doesn't fail the update
+	t.Setenv(string(GuestAgentEnvVarDisableCmdExitCode), "yaba")
+	_, err = update(ext)
+	require.NoError(t, err)
+	require.Nil(t, received.PreviousDisable)
+}
@@ -6,12 +6,15 @@ import (
 	"fmt"
 	"os"
 	"strings"
+	"sync"
+	"time"
 
 	"github.com/Azure/azure-extension-platform/pkg/environmentmanager"
 	"github.com/Azure/azure-extension-platform/pkg/exithelper"
 	"github.com/Azure/azure-extension-platform/pkg/extensionerrors"
 	"github.com/Azure/azure-extension-platform/pkg/extensionevents"
 	"github.com/Azure/azure-extension-platform/pkg/handlerenv"
+	"github.com/Azure/azure-extension-platform/pkg/heartbeat"
 	"github.com/Azure/azure-extension-platform/pkg/logging"
 	"github.com/Azure/azure-extension-platform/pkg/seqno"
 	"github.com/Azure/azure-extension-platform/pkg/settings"
@@ -36,7 +39,8 @@ const (
 	GuestAgentEnvVarUpdateFromVersion    GuestAgentEnvVar = "AZURE_GUEST_AGENT_UPDATING_FROM_VERSION"
 	GuestAgentEnvVarDisableCmdExitCode   GuestAgentEnvVar = "AZURE_GUEST_AGENT_DISABLE_CMD_EXIT_CODE"
 	GuestAgentEnvVarUninstallCmdExitCode GuestAgentEnvVar = "AZURE_GUEST_AGENT_UNINSTALL_CMD_EXIT_CODE"
-	// environment variables that are for multiconfig extensions are excluded
+	// set only for multiconfig extensions, contains the name of the extension instance being operated on
+	GuestAgentEnvVarConfigExtensionName GuestAgentEnvVar = "ConfigExtensionName"
 )
 
 type OperationName string
@@ -90,23 +94,33 @@ type cmd struct {
 
 // executionInfo contains internal information necessary for the extension to execute
 type executionInfo struct {
-	cmds                map[OperationName]cmd                                // Execution commands keyed by operation
-	requiresSeqNoChange bool                                                 // True if Enable will only execute if the sequence number changes
-	supportsDisable     bool                                                 // Whether to run extension agnostic disable code
-	supportsResetState  bool                                                 // Whether to run the extension agnostic ResetState code
-	enableCallback      EnableCallbackFunc                                   // A method provided by the extension for Enable
-	updateCallback      CallbackFunc                                         // A method provided by the extension for Update
-	disableCallback     CallbackFunc                                         // A method provided by the extension for Disable
-	resetStateCallBack  CallbackFunc                                         // A method provided by the extension for ResetState
-	installCallback     CallbackFunc                                         // A method provided by the extension for Update
-	uninstallCallback   CallbackFunc                                         // A method provided by the extension for Uninstall
-	manager             environmentmanager.IGetVMExtensionEnvironmentManager // Used by tests to mock the environment
+	cmds                          map[OperationName]cmd                                           // Execution commands keyed by operation
+	requiresSeqNoChange           bool                                                            // True if Enable will only execute if the sequence number changes
+	supportsDisable               bool                                                            // Whether to run extension agnostic disable code
+	supportsResetState            bool                                                            // Whether to run the extension agnostic ResetState code
+	enableCallback                EnableCallbackFunc                                              // A method provided by the extension for Enable
+	enableWithSubstatusesCallback EnableWithSubstatusesCallbackFunc                               // A method provided by the extension for Enable that reports substatuses. Takes precedence over enableCallback
+	updateCallback                CallbackFunc                                                    // A method provided by the extension for Update
+	updateWithContextCallback     UpdateWithContextCallbackFunc                                   // A method provided by the extension for Update that receives the versions
+	migrations                    *MigrationRegistry                                              // Migrations run for Update
+	disableCallback               CallbackFunc                                                    // A method provided by the extension for Disable
+	resetStateCallBack            CallbackFunc                                                    // A method provided by the extension for ResetState
+	installCallback               CallbackFunc                                                    // A method provided by the extension for Update
+	uninstallCallback             CallbackFunc                                                    // A method provided by the extension for Uninstall
+	manager                       environmentmanager.IGetVMExtensionEnvironmentManager            // Used by tests to mock the environment
+	multiConfigManager            environmentmanager.IGetVMExtensionMultiConfigEnvironmentManager // Set when operating on an instance of a multiconfig extension
+	progressReportInterval        time.Duration                                                   // Minimum time between progress updates
+	maxStatusFileSizeInBytes      int                                                             // Size limit of the status file, or zero or less for none
+	logLevelSettingName           string                                                          // Public setting that sets the log level, if any
+	getPublicSettings             func(seqNo uint) (string, error)                                // Reads the public settings without decrypting the protected settings
+	disableLifecycleEvents        bool                                                            // True if the framework shouldn't write events for operations
 }
 
 // VMExtension is an abstraction for standard extension operations in an OS agnostic manner
 type VMExtension struct {
 	Name                       string                                    // The name of the extension. This will contain 'Windows' or 'Linux'
 	Version                    string                                    // The version of the extension
+	ConfigName                 string                                    // For multiconfig extensions, the name of the extension instance. Empty for handler wide operations
 	GetRequestedSequenceNumber func() (uint, error)                      // Function to get the requested sequence number to run
 	CurrentSequenceNumber      *uint                                     // The last run sequence number, null means no existing sequence number was found
 	HandlerEnv                 *handlerenv.HandlerEnvironment            // Contains information about the folders necessary for the extension
@@ -115,6 +129,9 @@ type VMExtension struct {
 	ExtensionLogger            *logging.ExtensionLogger                  // Automatically logs to the log directory
 	exec                       *executionInfo                            // Internal information necessary for the extension to run
 	statusFormatter            status.StatusMessageFormatter             // Custom status message formatter from initialization info
+	heartbeatMutex             sync.Mutex                                // Guards heartbeatWriter
+	heartbeatWriter            *heartbeat.HeartbeatWriter                // Running heartbeat started by StartHeartbeat, if any
+	progress                   *progressReporter                         // Reports progress while enable is running
 }
 
 type prodGetVMExtensionEnvironmentManager struct {
@@ -144,6 +161,22 @@ func (*prodGetVMExtensionEnvironmentManager) SetSequenceNumberInternal(extension
 	return seqno.SetSequenceNumber(extensionName, extensionVersion, seqNo)
 }
 
+func (*prodGetVMExtensionEnvironmentManager) FindSeqNumForConfig(el logging.ILogger, configFolder, configName string) (uint, error) {
+	return seqno.FindSeqNumForConfig(el, configFolder, configName)
+}
+
+func (em *prodGetVMExtensionEnvironmentManager) GetHandlerSettingsForConfig(el logging.ILogger, he *handlerenv.HandlerEnvironment, configName string) (*settings.HandlerSettings, error) {
+	seqNo, err := em.FindSeqNumForConfig(el, he.ConfigFolder, configName)
+	if err != nil {
+		return nil, err
+	}
+	return settings.GetHandlerSettingsForConfig(el, he, configName, seqNo)
+}
+
+func (*prodGetVMExtensionEnvironmentManager) SetSequenceNumberForConfigInternal(extensionName, extensionVersion, configName string, seqNo uint) error {
+	return seqno.SetSequenceNumberForConfig(extensionName, extensionVersion, configName, seqNo)
+}
+
 func GetGuestAgentEnvironmetVariable(envVarName GuestAgentEnvVar) (string, error) {
 	extensionVersion, isSet := os.LookupEnv(string(envVarName))
 	if !isSet || extensionVersion == "" {
@@ -172,7 +205,7 @@ func getVMExtensionInternal(initInfo *InitializationInfo, manager environmentman
 		return nil, extensionerrors.ErrArgCannotBeNullOrEmpty
 	}
 
-	if initInfo.EnableCallback == nil {
+	if initInfo.EnableCallback == nil && initInfo.EnableWithSubstatusesCallback == nil {
 		return nil, extensionerrors.ErrArgCannotBeNull
 	}
 
@@ -181,16 +214,52 @@ func getVMExtensionInternal(initInfo *InitializationInfo, manager environmentman
 		return nil, err
 	}
 
-	extensionLogger := logging.NewWithName(handlerEnv, initInfo.LogFileNamePattern)
+	rotationPolicy := logging.DefaultRotationPolicy()
+	if initInfo.LogRotationPolicy != nil {
+		rotationPolicy = *initInfo.LogRotationPolicy
+	}
+	extensionLogger := logging.NewWithPolicy(handlerEnv, initInfo.LogFileNamePattern, rotationPolicy)
+	if initInfo.LogFormatter != nil {
+		extensionLogger.SetFormatter(initInfo.LogFormatter)
+	}
+	if err := setLogLevels(extensionLogger, initInfo); err != nil {
+		return nil, err
+	}
 
 	// Create our event manager. This will be disabled if no eventsFolder exists
 	extensionEvents := extensionevents.New(extensionLogger, handlerEnv)
+	if initInfo.EventBufferOptions != nil {
+		extensionEvents.EnableBuffering(*initInfo.EventBufferOptions)
+		// the events are written if a callback exits the process through the exit helper
+		exithelper.AddExitHandler(extensionEvents.Flush)
+	}
+
+	// The Guest Agent only sets the config name for operations on an instance of a multiconfig extension.
+	// Handler wide operations such as install and uninstall behave as they do for single config extensions.
+	var configName string
+	var multiConfigManager environmentmanager.IGetVMExtensionMultiConfigEnvironmentManager
+	if initInfo.SupportsMultiConfig {
+		configName = os.Getenv(string(GuestAgentEnvVarConfigExtensionName))
+		if configName != "" {
+			var supportsMultiConfig bool
+			multiConfigManager, supportsMultiConfig = manager.(environmentmanager.IGetVMExtensionMultiConfigEnvironmentManager)
+			if !supportsMultiConfig {
+				return nil, fmt.Errorf("the environment manager does not support multiconfig extensions")
+			}
+			extensionLogger.Info("operating on multiconfig extension instance '%s'", configName)
+		}
+	}
 
 	// Determine the sequence number requested
 	newSeqNo := func() (uint, error) { return manager.FindSeqNum(extensionLogger, handlerEnv.ConfigFolder) }
+	if multiConfigManager != nil {
+		newSeqNo = func() (uint, error) {
+			return multiConfigManager.FindSeqNumForConfig(extensionLogger, handlerEnv.ConfigFolder, configName)
+		}
+	}
 
 	// Determine the current sequence number
-	retriever := seqno.ProdSequenceNumberRetriever{}
+	retriever := seqno.ProdSequenceNumberRetriever{ConfigName: configName}
 	var currentSeqNo = new(uint)
 	retrievedSequenceNumber, err := manager.GetCurrentSequenceNumber(extensionLogger, &retriever, initInfo.Name, initInfo.Version)
 	if err != nil {
@@ -212,8 +281,8 @@ func getVMExtensionInternal(initInfo *InitializationInfo, manager environmentman
 	var cmdDisable cmd
 	var cmdUpdate cmd
 	var cmdResetState cmd
-	if initInfo.UpdateCallback != nil {
-		cmdUpdate = cmd{update, UpdateOperation, false, 3}
+	if initInfo.UpdateCallback != nil || initInfo.UpdateWithContextCallback != nil || initInfo.Migrations != nil {
+		cmdUpdate = cmd{update, UpdateOperation, false, initInfo.OtherExitCode}
 	} else {
 		cmdUpdate = cmd{noop, UpdateOperation, false, 3}
 	}
@@ -230,7 +299,17 @@ func getVMExtensionInternal(initInfo *InitializationInfo, manager environmentman
 		cmdResetState = cmd{noop, ResetStateOperation, false, 3}
 	}
 
+	publicSettings := func(seqNo uint) (string, error) {
+		if multiConfigManager != nil {
+			return settings.GetPublicSettingsForConfig(extensionLogger, handlerEnv, configName, seqNo)
+		}
+		return settings.GetPublicSettings(extensionLogger, handlerEnv, seqNo)
+	}
+
 	settings := func() (*settings.HandlerSettings, error) {
+		if multiConfigManager != nil {
+			return multiConfigManager.GetHandlerSettingsForConfig(extensionLogger, handlerEnv, configName)
+		}
 		return manager.GetHandlerSettings(extensionLogger, handlerEnv)
 	}
 
@@ -244,6 +323,7 @@ func getVMExtensionInternal(initInfo *InitializationInfo, manager environmentman
 	ext = &VMExtension{
 		Name:                       initInfo.Name,
 		Version:                    initInfo.Version,
+		ConfigName:                 configName,
 		GetRequestedSequenceNumber: newSeqNo,
 		CurrentSequenceNumber:      currentSeqNo,
 		HandlerEnv:                 handlerEnv,
@@ -252,16 +332,25 @@ func getVMExtensionInternal(initInfo *InitializationInfo, manager environmentman
 		ExtensionLogger:            extensionLogger,
 		statusFormatter:            statusFormatter,
 		exec: &executionInfo{
-			manager:             manager,
-			requiresSeqNoChange: initInfo.RequiresSeqNoChange,
-			supportsDisable:     initInfo.SupportsDisable,
-			supportsResetState:  initInfo.SupportsResetState,
-			enableCallback:      initInfo.EnableCallback,
-			disableCallback:     initInfo.DisableCallback,
-			updateCallback:      initInfo.UpdateCallback,
-			resetStateCallBack:  initInfo.ResetStateCallback,
-			installCallback:     initInfo.InstallCallback,
-			uninstallCallback:   initInfo.UninstallCallback,
+			manager:                       manager,
+			multiConfigManager:            multiConfigManager,
+			requiresSeqNoChange:           initInfo.RequiresSeqNoChange,
+			supportsDisable:               initInfo.SupportsDisable,
+			supportsResetState:            initInfo.SupportsResetState,
+			enableCallback:                initInfo.EnableCallback,
+			enableWithSubstatusesCallback: initInfo.EnableWithSubstatusesCallback,
+			disableCallback:               initInfo.DisableCallback,
+			updateCallback:                initInfo.UpdateCallback,
+			updateWithContextCallback:     initInfo.UpdateWithContextCallback,
+			migrations:                    initInfo.Migrations,
+			resetStateCallBack:            initInfo.ResetStateCallback,
+			installCallback:               initInfo.InstallCallback,
+			uninstallCallback:             initInfo.UninstallCallback,
+			progressReportInterval:        initInfo.ProgressReportInterval,
+			maxStatusFileSizeInBytes:      maxStatusFileSize(initInfo.MaxStatusFileSizeInBytes),
+			logLevelSettingName:           initInfo.LogLevelSettingName,
+			getPublicSettings:             publicSettings,
+			disableLifecycleEvents:        initInfo.DisableLifecycleEvents,
 			cmds: map[OperationName]cmd{
 				InstallOperation:    cmdInstall,
 				UninstallOperation:  cmdUninstall,
@@ -282,19 +371,53 @@ func (ve *VMExtension) Do() {
 	// parse command line arguments
 	eh := exithelper.Exiter
 	cmd := ve.parseCmd(os.Args, eh)
+	// buffered events are written when Do returns or the operation panics, and before Do exits
+	defer ve.flushEvents()
+	ve.ExtensionLogger.SetOperation(cmd.operation.ToString())
+	var seqNo *uint
+	if cmd.shouldReportStatus {
+		// only operations that report status act on a sequence number; looking it up for the others
+		// would log an error when there are no settings yet
+		if requested, err := ve.GetRequestedSequenceNumber(); err == nil {
+			ve.ExtensionLogger.SetSequenceNumber(requested)
+			seqNo = &requested
+			ve.applyLogLevelSetting(requested)
+		}
+	}
+	timer := ve.startLifecycleEvent(cmd.operation, seqNo)
 	_, err := cmd.f(ve)
+	unchanged := err == errSequenceNumberUnchanged
+	if unchanged {
+		err = nil
+	}
+	exitCode := 0
 	if err != nil {
+		exitCode = cmd.failExitCode
+	}
+	ve.stopLifecycleEvent(timer, err, exitCode)
+	switch {
+	case unchanged:
+		ve.exit(eh, 0)
+	case err != nil:
 		ve.ExtensionLogger.Error("failed to handle: %v", err)
-		eh.Exit(cmd.failExitCode)
+		ve.exit(eh, cmd.failExitCode)
 	}
 }
 
+// exit writes the buffered events, then exits through the exit helper, which runs the exit handlers
+func (ve *VMExtension) exit(eh exithelper.IExitHelper, exitCode int) {
+	ve.flushEvents()
+	eh.Exit(exitCode)
+}
+
 // reportStatus saves operation status to the status file for the extension
 // handler with the optional given message, if the given cmd requires reporting
 // status.
 //
+// Any substatuses are saved along with the status.
+//
 // If an error occurs reporting the status, it will be logged and returned.
-func reportStatus(ve *VMExtension, t status.StatusType, c cmd, msg string) error {
+func reportStatus(ve *VMExtension, t status.StatusType, c cmd, msg string, substatuses ...status.Substatus) error {
 	if !c.shouldReportStatus {
 		ve.ExtensionLogger.Info("status not reported for operation (by design)")
 		return nil
@@ -305,15 +428,16 @@ func reportStatus(ve *VMExtension, t status.StatusType, c cmd, msg string) error
 		return err
 	}
 
-	s := status.New(t, c.operation.ToStatusName(), ve.statusFormatter(c.operation.ToStatusName(), t, msg))
-	if err := s.Save(ve.HandlerEnv.StatusFolder, requestedSequenceNumber); err != nil {
+	s := status.New(t, c.operation.ToStatusName(), ve.statusFormatter(c.operation.ToStatusName(), t, msg)).
+		AddSubstatuses(substatuses...)
+	if err := saveStatusReport(ve, s, requestedSequenceNumber); err != nil {
 		ve.ExtensionLogger.Error("Failed to save handler status: %v", err)
 		return errors.Wrap(err, "failed to save handler status")
 	}
 	return nil
 }
 
-func reportErrorWithClarification(ve *VMExtension, c cmd, errorCode int, msg string) error {
+func reportErrorWithClarification(ve *VMExtension, c cmd, errorCode int, msg string, substatuses ...status.Substatus) error {
 	if !c.shouldReportStatus {
 		ve.ExtensionLogger.Info("status not reported for operation (by design)")
 		return nil
@@ -324,21 +448,48 @@ func reportErrorWithClarification(ve *VMExtension, c cmd, errorCode int, msg str
 		return err
 	}
 
-	s := status.NewError(c.operation.ToStatusName(), status.ErrorClarification{Code: errorCode, Message: msg})
-	if err := s.Save(ve.HandlerEnv.StatusFolder, requestedSequenceNumber); err != nil {
+	s := status.NewError(c.operation.ToStatusName(), status.ErrorClarification{Code: errorCode, Message: msg}).
+		AddSubstatuses(substatuses...)
+	if err := saveStatusReport(ve, s, requestedSequenceNumber); err != nil {
 		ve.ExtensionLogger.Error("Failed to save handler status: %v", err)
 		return errors.Wrap(err, "failed to save handler status")
 	}
 	return nil
 }
 
+// saveStatusReport writes the status file, which is named {configName}.{seqNo}.status for
+// instances of multiconfig extensions and {seqNo}.status otherwise
+func saveStatusReport(ve *VMExtension, s status.StatusReport, seqNo uint) error {
+	truncatedFields, err := s.SaveWithSizeLimit(ve.HandlerEnv.StatusFolder, ve.ConfigName, seqNo, ve.exec.maxStatusFileSizeInBytes)
+	if len(truncatedFields) > 0 {
+		ve.ExtensionLogger.Warn("Status messages were truncated to fit in %d bytes: %s", ve.exec.maxStatusFileSizeInBytes, strings.Join(truncatedFields, ", "))
+	}
+	return err
+}
+
+// maxStatusFileSize returns the size limit of the status file for InitializationInfo.MaxStatusFileSizeInBytes
+func maxStatusFileSize(maxSizeInBytes int) int {
+	if maxSizeInBytes == 0 {
+		return status.DefaultMaxStatusFileSizeInBytes
+	}
+	return maxSizeInBytes
+}
+
+// setSequenceNumber records the sequence number that is being processed
+func setSequenceNumber(ve *VMExtension, seqNo uint) error {
+	if ve.exec.multiConfigManager != nil {
+		return ve.exec.multiConfigManager.SetSequenceNumberForConfigInternal(ve.Name, ve.Version, ve.ConfigName, seqNo)
+	}
+	return ve.exec.manager.SetSequenceNumberInternal(ve.Name, ve.Version, seqNo)
+}
+
 // parseCmd looks at os.Args and parses the subcommand. If it is invalid,
 // it prints the usage string and an error message and exits with code 0.
 func (ve *VMExtension) parseCmd(args []string, eh exithelper.IExitHelper) cmd {
 	if len(args) != 2 {
 		ve.printUsage(args)
 		fmt.Println("Incorrect usage.")
-		eh.Exit(2)
+		ve.exit(eh, 2)
 		return cmd{}
 	}
 
@@ -348,7 +499,7 @@ func (ve *VMExtension) parseCmd(args []string, eh exithelper.IExitHelper) cmd {
 	if !ok {
 		ve.printUsage(args)
 		fmt.Printf("Incorrect command: %q\n", op)
-		eh.Exit(2)
+		ve.exit(eh, 2)
 	}
 	return cmd
 }
@@ -5,13 +5,16 @@ package vmextension
 import (
 	"encoding/json"
 	"errors"
+	"fmt"
 	"os"
 	"os/exec"
 	"path"
 	"path/filepath"
+	"strings"
 	"testing"
 	"time"
 
+	"github.com/Azure/azure-extension-platform/pkg/environmentmanager"
 	"github.com/Azure/azure-extension-platform/pkg/exithelper"
 	"github.com/Azure/azure-extension-platform/pkg/extensionerrors"
 	"github.com/Azure/azure-extension-platform/pkg/handlerenv"
@@ -42,6 +45,9 @@ type mockGetVMExtensionEnvironmentManager struct {
 	getCurrentSequenceNumberError error
 	getHandlerSettingsError       error
 	setSequenceNumberError        error
+	configSeqNo                   uint
+	lastSetConfigName             string
+	lastSetConfigSeqNo            uint
 }
 
 func (mm *mockGetVMExtensionEnvironmentManager) GetHandlerEnvironment(name string, version string) (he *handlerenv.HandlerEnvironment, _ error) {
@@ -84,6 +90,37 @@ func (mm *mockGetVMExtensionEnvironmentManager) SetSequenceNumberInternal(extens
 	return nil
 }
 
+func (mm *mockGetVMExtensionEnvironmentManager) FindSeqNumForConfig(el logging.ILogger, configFolder, configName string) (uint, error) {
+	if mm.findSeqNumError != nil {
+		return 0, mm.findSeqNumError
+	}
+
+	return mm.configSeqNo, nil
+}
+
+func (mm *mockGetVMExtensionEnvironmentManager) GetHandlerSettingsForConfig(el logging.ILogger, he *handlerenv.HandlerEnvironment, configName string) (hs *settings.HandlerSettings, _ error) {
+	if mm.getHandlerSettingsError != nil {
+		return hs, mm.getHandlerSettingsError
+	}
+
+	return mm.hs, nil
+}
+
+func (mm *mockGetVMExtensionEnvironmentManager) SetSequenceNumberForConfigInternal(extensionName, extensionVersion, configName string, seqNo uint) error {
+	if mm.setSequenceNumberError != nil {
+		return mm.setSequenceNumberError
+	}
+
+	mm.lastSetConfigName = configName
+	mm.lastSetConfigSeqNo = seqNo
+	return nil
+}
+
+// singleConfigEnvironmentManager hides the multiconfig methods of the wrapped manager
+type singleConfigEnvironmentManager struct {
+	environmentmanager.IGetVMExtensionEnvironmentManager
+}
+
 func Test_reportStatusShouldntReport(t *testing.T) {
 	ext := createTestVMExtension()
 	c := cmd{nil, InstallOperation, false, 99}
@@ -243,6 +280,91 @@ func Test_getVMExtensionCannotReadCurrentSeqNo(t *testing.T) {
 	require.Error(t, err)
 }
 
+func Test_getVMExtensionLogFormatter(t *testing.T) {
+	dirs := createTestVMExtension()
+	createDirsForVMExtension(dirs)
+	defer cleanupDirsForVMExtension(dirs)
+
+	mm := createMockVMExtensionEnvironmentManager()
+	ii, _ := GetInitializationInfo("yaba", "5.0", true, testEnableCallback)
+	ii.LogFormatter = &logging.JSONFormatter{}
+	ext, err := getVMExtensionInternal(ii, mm)
+	require.NoError(t, err)
+
+	ext.ExtensionLogger.SetOperation("enable")
+	ext.ExtensionLogger.Info("formatted as json")
+	ext.ExtensionLogger.Close()
+
+	logFiles, err := filepath.Glob(filepath.Join(dirs.HandlerEnv.LogFolder, "log_*"))
+	require.NoError(t, err)
+	require.Len(t, logFiles, 1)
+	b, err := os.ReadFile(logFiles[0])
+	require.NoError(t, err)
+
+	var entry map[string]interface{}
+	lines := strings.Split(strings.TrimSpace(string(b)), "\n")
+	require.NoError(t, json.Unmarshal([]byte(lines[len(lines)-1]), &entry))
+	require.Equal(t, "formatted as json", entry["message"])
+	require.Equal(t, "enable", entry["operation"])
+}
+
+func Test_getVMExtensionLogLevel(t *testing.T) {
+	t.Setenv(logging.LogLevelEnvVar, "")
+	mm := createMockVMExtensionEnvironmentManager()
+	ii, _ := GetInitializationInfo("yaba", "5.0", true, testEnableCallback)
+	ii.LogLevel = "verbose"
+	ii.LogLevelSettingName = "logLevel"
+	ext, err := getVMExtensionInternal(ii, mm)
+	require.NoError(t, err)
+	require.Equal(t, logging.LevelVerbose, ext.ExtensionLogger.Level())
+
+	// the public setting overrides the initialization info, and the protected settings aren't read
+	ext.GetSettings = func() (*settings.HandlerSettings, error) {
+		t.Fatal("the settings were decrypted")
+		return nil, nil
+	}
+	ext.exec.getPublicSettings = func(seqNo uint) (string, error) {
+		require.Equal(t, uint(5), seqNo)
+		return `{"logLevel":"debug"}`, nil
+	}
+	ext.applyLogLevelSetting(5)
+	require.Equal(t, logging.LevelDebug, ext.ExtensionLogger.Level())
+
+	// invalid settings are ignored with a warning
+	logDir := t.TempDir()
+	ext.ExtensionLogger = logging.New(&handlerenv.HandlerEnvironment{LogFolder: logDir})
+	ext.ExtensionLogger.SetLevel(logging.LevelDebug)
+	ext.exec.getPublicSettings = func(uint) (string, error) { return `{"logLevel":"chatty"}`, nil }
+	ext.applyLogLevelSetting(5)
+	require.Equal(t, logging.LevelDebug, ext.ExtensionLogger.Level())
+	ext.ExtensionLogger.Close()
+	logFiles, err := filepath.Glob(filepath.Join(logDir, "*"))
+	require.NoError(t, err)
+	require.Len(t, logFiles, 1)
+	log, err := os.ReadFile(logFiles[0])
+	require.NoError(t, err)
+	require.Contains(t, string(log), "ignoring setting logLevel")
+
+	ii.LogLevel = "chatty"
+	_, err = getVMExtensionInternal(ii, mm)
+	require.Error(t, err)
+}
+
+func Test_getVMExtensionLogLevelFromEnvironment(t *testing.T) {
+	t.Setenv(logging.LogLevelEnvVar, "warning")
+	mm := createMockVMExtensionEnvironmentManager()
+	ii, _ := GetInitializationInfo("yaba", "5.0", true, testEnableCallback)
+	ii.LogLevel = "debug"
+	ii.LogLevelSettingName = "logLevel"
+	ext, err := getVMExtensionInternal(ii, mm)
+	require.NoError(t, err)
+	require.Equal(t, logging.LevelWarning, ext.ExtensionLogger.Level())
+
+	ext.exec.getPublicSettings = func(uint) (string, error) { return `{"logLevel":"debug"}`, nil }
+	ext.applyLogLevelSetting(5)
+	require.Equal(t, logging.LevelWarning, ext.ExtensionLogger.Level(), "the environment variable takes precedence")
+}
+
 func Test_getVMExtensionUpdateSupport(t *testing.T) {
 	// Update disabled
 	mm := createMockVMExtensionEnvironmentManager()
@@ -389,7 +511,8 @@ func Test_enableNoSeqNoChangeButRequired(t *testing.T) {
 		ii.RequiresSeqNoChange = true
 		ext, _ := getVMExtensionInternal(ii, mm)
 
-		enable(ext)
+		os.Args = []string{"dontcare", EnableOperation.ToString()}
+		ext.Do()
 		exithelper.Exiter.Exit(2) // enable above should exit the process cleanly. If it doesn't, fail.
 	}
 
@@ -473,6 +596,108 @@ func Test_enableCallbackSucceeds(t *testing.T) {
 	require.Equal(t, "blah", msg)
 }
 
+func testEnableWithSubstatusesCallback(ext *VMExtension) (string, []status.Substatus, error) {
+	return "scripts ran", []status.Substatus{
+		status.NewSubstatus("StdOut", status.StatusSuccess, 0, "hello"),
+		status.NewSubstatus("StdErr", status.StatusSuccess, 0, ""),
+	}, nil
+}
+
+func testFailEnableWithSubstatusesCallback(ext *VMExtension) (string, []status.Substatus, error) {
+	return "", []status.Substatus{
+		status.NewSubstatus("StdErr", status.StatusError, 0, "script exploded"),
+	}, NewErrorWithClarification(42, extensionerrors.ErrMustRunAsAdmin)
+}
+
+func readEnableStatusReport(t *testing.T, ext *VMExtension) status.StatusReport {
+	seqNo, err := ext.GetRequestedSequenceNumber()
+	require.NoError(t, err)
+	b, err := os.ReadFile(path.Join(ext.HandlerEnv.StatusFolder, fmt.Sprintf("%d.status", seqNo)))
+	require.NoError(t, err, "Could not read status file contents")
+	var report status.StatusReport
+	require.NoError(t, json.Unmarshal(b, &report))
+	require.Equal(t, 1, len(report))
+	return report
+}
+
+func Test_enableCallbackWithSubstatusesSucceeds(t *testing.T) {
+	mm := createMockVMExtensionEnvironmentManager()
+	ii, err := GetInitializationInfoWithSubstatuses("yaba", "5.0", true, testEnableWithSubstatusesCallback)
+	require.NoError(t, err)
+	ext, _ := getVMExtensionInternal(ii, mm)
+	createDirsForVMExtension(ext)
+	defer cleanupDirsForVMExtension(ext)
+
+	msg, err := enable(ext)
+	require.NoError(t, err, "enable failed")
+	require.Equal(t, "scripts ran", msg)
+
+	report := readEnableStatusReport(t, ext)
+	require.Equal(t, status.StatusSuccess, report[0].Status.Status)
+	substatuses := report[0].Status.Substatuses
+	require.Equal(t, 2, len(substatuses))
+	require.Equal(t, "StdOut", substatuses[0].Name)
+	require.Equal(t, "hello", substatuses[0].FormattedMessage.Message)
+	require.Equal(t, "StdErr", substatuses[1].Name)
+}
+
+func Test_enableStatusFileSizeLimit(t *testing.T) {
+	mm := createMockVMExtensionEnvironmentManager()
+	ii, err := GetInitializationInfo("yaba", "5.0", true, func(ext *VMExtension) (string, error) {
+		return strings.Repeat("x", 10000), nil
+	})
+	require.NoError(t, err)
+	ii.MaxStatusFileSizeInBytes = 2048
+	ext, _ := getVMExtensionInternal(ii, mm)
+	createDirsForVMExtension(ext)
+	defer cleanupDirsForVMExtension(ext)
+
+	_, err = enable(ext)
+	require.NoError(t, err, "enable failed")
+	info, err := os.Stat(path.Join(ext.HandlerEnv.StatusFolder, "5.status"))
+	require.NoError(t, err)
+	require.LessOrEqual(t, info.Size(), int64(2048))
+
+	// the default limit applies when the option isn't set
+	ii.MaxStatusFileSizeInBytes = 0
+	ext, _ = getVMExtensionInternal(ii, mm)
+	require.Equal(t, status.DefaultMaxStatusFileSizeInBytes, ext.exec.maxStatusFileSizeInBytes)
+}
+
+func Test_enableCallbackWithSubstatusesErrorClarification(t *testing.T) {
+	mm := createMockVMExtensionEnvironmentManager()
+	ii, err := GetInitializationInfoWithSubstatuses("yaba", "5.0", true, testFailEnableWithSubstatusesCallback)
+	require.NoError(t, err)
+	ext, _ := getVMExtensionInternal(ii, mm)
+	createDirsForVMExtension(ext)
+	defer cleanupDirsForVMExtension(ext)
+
+	_, err = enable(ext)
+	require.Error(t, err)
+
+	report := readEnableStatusReport(t, ext)
+	require.Equal(t, status.StatusError, report[0].Status.Status)
+	substatuses := report[0].Status.Substatuses
+	require.Equal(t, 2, len(substatuses))
+	require.Equal(t, status.ErrorClarificationSubStatusName, substatuses[0].Name)
+	require.Equal(t, 42, substatuses[0].Code)
+	require.Equal(t, "StdErr", substatuses[1].Name)
+	require.Equal(t, "script exploded", substatuses[1].FormattedMessage.Message)
+}
+
+func Test_enableWithSubstatusesCallbackTakesPrecedence(t *testing.T) {
+	mm := createMockVMExtensionEnvironmentManager()
+	ii, _ := GetInitializationInfo("yaba", "5.0", true, testEnableCallback)
+	ii.EnableWithSubstatusesCallback = testEnableWithSubstatusesCallback
+	ext, _ := getVMExtensionInternal(ii, mm)
+	createDirsForVMExtension(ext)
+	defer cleanupDirsForVMExtension(ext)
+
+	msg, err := enable(ext)
+	require.NoError(t, err, "enable failed")
+	require.Equal(t, "scripts ran", msg)
+}
+
 func Test_doFailToWriteSequenceNumber(t *testing.T) {
 	mm := createMockVMExtensionEnvironmentManager()
 	mm.setSequenceNumberError = extensionerrors.ErrMustRunAsAdmin
@@ -526,6 +751,84 @@ func Test_doCommandSucceeds(t *testing.T) {
 	ext.Do()
 }
 
+func Test_getVMExtensionMultiConfig(t *testing.T) {
+	t.Setenv(string(GuestAgentEnvVarConfigExtensionName), "chipmunk")
+	mm := createMockVMExtensionEnvironmentManager()
+	mm.configSeqNo = 11
+	ii, _ := GetInitializationInfo("yaba", "5.0", true, testEnableCallback)
+	ii.SupportsMultiConfig = true
+
+	ext, err := getVMExtensionInternal(ii, mm)
+	require.NoError(t, err, "getVMExtensionInternal failed")
+	require.Equal(t, "chipmunk", ext.ConfigName)
+	seqNo, err := ext.GetRequestedSequenceNumber()
+	require.NoError(t, err)
+	require.Equal(t, uint(11), seqNo)
+
+	createDirsForVMExtension(ext)
+	defer cleanupDirsForVMExtension(ext)
+
+	_, err = enable(ext)
+	require.NoError(t, err, "enable failed")
+	require.Equal(t, "chipmunk", mm.lastSetConfigName)
+	require.Equal(t, uint(11), mm.lastSetConfigSeqNo)
+	_, err = os.Stat(path.Join(ext.HandlerEnv.StatusFolder, "chipmunk.11.status"))
+	require.NoError(t, err, "config status file doesn't exist")
+	_, err = os.Stat(path.Join(ext.HandlerEnv.StatusFolder, "11.status"))
+	require.True(t, os.IsNotExist(err), "handler status file should not be written")
+}
+
+func Test_getVMExtensionMultiConfigHandlerWideOperation(t *testing.T) {
+	os.Unsetenv(string(GuestAgentEnvVarConfigExtensionName))
+	mm := createMockVMExtensionEnvironmentManager()
+	mm.configSeqNo = 11
+	ii, _ := GetInitializationInfo("yaba", "5.0", true, testEnableCallback)
+	ii.SupportsMultiConfig = true
+
+	ext, err := getVMExtensionInternal(ii, mm)
+	require.NoError(t, err, "getVMExtensionInternal failed")
+	require.Empty(t, ext.ConfigName)
+	seqNo, err := ext.GetRequestedSequenceNumber()
+	require.NoError(t, err)
+	require.Equal(t, mm.seqNo, seqNo)
+}
+
+func Test_getVMExtensionMultiConfigNotEnabled(t *testing.T) {
+	t.Setenv(string(GuestAgentEnvVarConfigExtensionName), "chipmunk")
+	mm := createMockVMExtensionEnvironmentManager()
+	ii, _ := GetInitializationInfo("yaba", "5.0", true, testEnableCallback)
+
+	ext, err := getVMExtensionInternal(ii, mm)
+	require.NoError(t, err, "getVMExtensionInternal failed")
+	require.Empty(t, ext.ConfigName)
+}
+
+func Test_getVMExtensionMultiConfigUnsupportedManager(t *testing.T) {
+	t.Setenv(string(GuestAgentEnvVarConfigExtensionName), "chipmunk")
+	mm := &singleConfigEnvironmentManager{createMockVMExtensionEnvironmentManager()}
+	ii, _ := GetInitializationInfo("yaba", "5.0", true, testEnableCallback)
+	ii.SupportsMultiConfig = true
+
+	_, err := getVMExtensionInternal(ii, mm)
+	require.Error(t, err)
+}
+
+func Test_disableMultiConfigInstance(t *testing.T) {
+	ext := createTestVMExtension()
+	ext.ConfigName = "chipmunk"
+	createDirsForVMExtension(ext)
+	defer cleanupDirsForVMExtension(ext)
+
+	require.NoError(t, setDisabled(ext, true), "Disabling failed")
+	require.True(t, isDisabled(ext))
+	_, err := os.Stat(path.Join(ext.HandlerEnv.ConfigFolder, "chipmunk."+disabledFileName))
+	require.NoError(t, err, "config disabled file doesn't exist")
+
+	// other instances are not affected
+	ext.ConfigName = "squirrel"
+	require.False(t, isDisabled(ext))
+}
+
 func Test_validHandlerEnvironment(t *testing.T) {
 	hes := `[{	
 		"version": 1.0, 	  
@@ -682,6 +985,8 @@ func createTestVMExtension() *VMExtension {
 			disableCallback:     testDisableCallbackNormal,
 			updateCallback:      nil,
 			cmds:                map[OperationName]cmd{DisableOperation: disableCommand},
+
+			maxStatusFileSizeInBytes: status.DefaultMaxStatusFileSizeInBytes,
 		},
 		statusFormatter: status.StatusMsg,
 	}